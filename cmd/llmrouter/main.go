@@ -2,24 +2,49 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/howard-nolan/llmrouter/internal/cache"
 	"github.com/howard-nolan/llmrouter/internal/config"
 	"github.com/howard-nolan/llmrouter/internal/embedder"
+	"github.com/howard-nolan/llmrouter/internal/feedback"
 	"github.com/howard-nolan/llmrouter/internal/metrics"
 	"github.com/howard-nolan/llmrouter/internal/provider"
 	"github.com/howard-nolan/llmrouter/internal/router"
 	"github.com/howard-nolan/llmrouter/internal/server"
+	"github.com/howard-nolan/llmrouter/internal/tokens"
 )
 
+// defaultShutdownTimeout is how long in-flight requests get to finish on
+// shutdown when server.shutdown_timeout isn't set.
+const defaultShutdownTimeout = 30 * time.Second
+
 func main() {
+	if err := run(); err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+	log.Printf("llmrouter stopped")
+}
+
+// run wires up the gateway, serves until SIGTERM or SIGINT, and shuts down
+// gracefully (see shutdown). Resources are released by defers, in reverse
+// order of creation: everything that uses a resource is gone before the
+// resource is closed — the feedback sink, then the classifier, then the
+// cache, then the embedder. The config is checked before anything is
+// opened, and a startup failure after that returns, so whatever was opened
+// is still closed.
+func run() error {
 	cfg, err := config.Load("config.yaml")
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
 	// Build the provider registry: a map from model name → Provider.
@@ -61,7 +86,7 @@ func main() {
 	for name, provCfg := range cfg.Providers {
 		factory, ok := constructors[name]
 		if !ok {
-			log.Fatalf("unknown provider in config: %q", name)
+			return fmt.Errorf("unknown provider in config: %q", name)
 		}
 
 		p := factory(provCfg.APIKey, provCfg.BaseURL)
@@ -72,51 +97,207 @@ func main() {
 		}
 	}
 
-	// Create the embedder from the ONNX model and tokenizer paths in config.
-	// This loads the ONNX Runtime shared library, initializes the inference
+	// Check the rest of the config before opening anything, so a typo
+	// fails fast without a half-started gateway to tear down.
+	if cfg.Routing.ClassifierBackend != "" && cfg.Routing.ClassifierBackend != "onnx" && cfg.Routing.ClassifierBackend != "gbt" {
+		return fmt.Errorf("unknown classifier backend %q (want onnx or gbt)", cfg.Routing.ClassifierBackend)
+	}
+
+	if !server.ValidCoalesce(cfg.Cache.Coalesce) {
+		return fmt.Errorf("unknown cache.coalesce %q (want off, exact, or semantic)", cfg.Cache.Coalesce)
+	}
+
+	if !router.ValidTruncation(cfg.Routing.Context.Truncation) {
+		return fmt.Errorf("unknown context truncation %q (want none or drop_oldest)", cfg.Routing.Context.Truncation)
+	}
+
+	if err := router.ValidateRules(cfg.Routing.Rules); err != nil {
+		return fmt.Errorf("invalid routing config: %w", err)
+	}
+	for _, rule := range cfg.Routing.Rules {
+		if _, ok := models[rule.Model]; rule.Model != "" && !ok {
+			return fmt.Errorf("routing rule %q: model %q is not registered", rule.Name, rule.Model)
+		}
+	}
+
+	// Experiments can force a model or route to a percentage of traffic;
+	// catch typos at startup rather than on the first assigned request.
+	for _, exp := range cfg.Routing.Experiments {
+		if exp.Name == "" || exp.Name == router.NoExperiment {
+			return fmt.Errorf("routing experiment needs a name other than %q", router.NoExperiment)
+		}
+		if exp.Percent < 0 || exp.Percent > 100 {
+			return fmt.Errorf("routing experiment %q: percent must be between 0 and 100", exp.Name)
+		}
+		if _, ok := models[exp.Model]; exp.Model != "" && !ok {
+			return fmt.Errorf("routing experiment %q: model %q is not registered", exp.Name, exp.Model)
+		}
+		log.Printf("routing experiment %q: %.1f%% of auto traffic (shadow=%t)", exp.Name, exp.Percent, exp.Shadow)
+	}
+
+	// Create the embedder for the configured backend. The ONNX backend
+	// loads the ONNX Runtime shared library, initializes the inference
 	// session, and loads the HuggingFace tokenizer — all at startup, so
 	// request-time embedding is just a function call (no loading overhead).
-	emb, err := embedder.New(
-		cfg.Embedding.ModelPath,
-		cfg.Embedding.TokenizerPath,
-		cfg.Embedding.LibraryPath,
-		cfg.Embedding.Dimension,
-	)
+	// Remote backends share the provider HTTP client.
+	emb, closeEmbedder, err := newEmbedder(cfg.Embedding, httpClient)
+	if err != nil {
+		return fmt.Errorf("failed to create embedder: %w", err)
+	}
+	defer closeEmbedder()
+
+	// Coalesce concurrent Embed calls into batched inference. Requests
+	// arriving within batch_window share one [B, 128] ONNX run instead of
+	// each paying for its own. Deferred after closeEmbedder so it runs first
+	// (defers are LIFO) — the batcher must stop before the session goes away.
+	var requestEmbedder server.Embedder = emb
+	if cfg.Embedding.MaxBatchSize > 1 {
+		batcher := embedder.NewBatcher(emb, cfg.Embedding.MaxBatchSize, cfg.Embedding.BatchWindow)
+		defer batcher.Close()
+		requestEmbedder = batcher
+	}
+
+	// Remember recent prompt embeddings. Exact repeats skip the model (and
+	// the batching window) entirely, and the handler uses the LRU hit as
+	// the signal to read the cache entry by key instead of scanning.
+	if cfg.Embedding.CacheSize > 0 {
+		requestEmbedder = embedder.NewCachingEmbedder(requestEmbedder, cfg.Embedding.CacheSize)
+	}
+
+	// Namespace cache entries by the embedding model's fingerprint, so a
+	// model change never compares new vectors against old ones.
+	cfg.Cache.Fingerprint, err = embeddingFingerprint(cfg.Embedding)
 	if err != nil {
-		log.Fatalf("failed to create embedder: %v", err)
+		return fmt.Errorf("failed to fingerprint embedding model: %w", err)
 	}
-	defer emb.Close()
+	log.Printf("embedding fingerprint %s", cfg.Cache.Fingerprint)
 
-	// Create the Redis-backed semantic cache. NewRedisCache parses the
-	// Redis URL, creates a connection pool, and pings to verify connectivity.
-	c, err := cache.NewRedisCache(cfg.Cache)
+	// Create the Redis-backed semantic cache behind a circuit breaker.
+	// OpenRedisCache only parses the URL; the breaker pings, and if Redis
+	// is down the gateway starts without the cache and reconnects in the
+	// background. The same happens if Redis goes away later.
+	rc, err := cache.OpenRedisCache(cfg.Cache)
 	if err != nil {
-		log.Fatalf("failed to create cache: %v", err)
+		return fmt.Errorf("failed to create cache: %w", err)
 	}
+	c := cache.NewBreaker(rc, cfg.Cache)
 	defer c.Close()
 
+	// Re-embed entries left over from a previous embedding model in the
+	// background. They're already invisible to lookups; this just keeps
+	// the cache warm across a model change. Cancelled on exit.
+	if cfg.Cache.MigrateOnStart {
+		migrateCtx, cancelMigrate := context.WithCancel(context.Background())
+		migrated := make(chan struct{})
+		defer func() {
+			cancelMigrate()
+			<-migrated // it writes to the cache, so it must stop before c.Close
+		}()
+		go func() {
+			defer close(migrated)
+			res, err := rc.Migrate(migrateCtx, emb)
+			if err != nil {
+				log.Printf("cache migration stopped: %v", err)
+			}
+			if res.Migrated+res.Skipped+res.Failed > 0 {
+				log.Printf("cache migration: %d migrated, %d skipped (no prompt), %d failed",
+					res.Migrated, res.Skipped, res.Failed)
+			}
+		}()
+	}
+
 	metrics.RegisterCacheEntries(func() float64 {
 		return float64(c.Stats().Entries)
 	})
 
-	// Create the complexity classifier from the ONNX model exported by
-	// training/export_onnx.py. This must happen after embedder.New()
-	// because the embedder initializes the ONNX Runtime environment
-	// (one per process), and the classifier reuses it.
-	classifier, err := router.NewONNXClassifier(
-		cfg.Routing.ClassifierModelPath,
-		cfg.Embedding.Dimension,
-	)
-	if err != nil {
-		log.Fatalf("failed to create classifier: %v", err)
+	// Create the complexity classifier from the model exported by
+	// training/export_onnx.py. The onnx backend must be created after
+	// embedder.New() because the embedder initializes the ONNX Runtime
+	// environment (one per process), and the classifier reuses it. The
+	// gbt backend evaluates the same trees in pure Go and has no such
+	// ordering dependency, though it still needs the onnx embedder's
+	// vectors (see below).
+	//
+	// The classifier sits in a Registry so a retrained model can be swapped
+	// in (and rolled back) without a restart; the Registry also picks up
+	// the model's tuned threshold from its metadata sidecar.
+	//
+	// The classifier was trained on the local model's embeddings, so it
+	// only makes sense with the onnx embedding backend, whichever
+	// classifier backend evaluates it. With a remote embedding backend (the
+	// only kind in a noonnx build) the router runs without one: cheapest
+	// and quality work, auto errors.
+	var classifier router.Classifier
+	if isONNXBackend(cfg.Embedding.Backend) {
+		load := func(path string) (router.Classifier, error) {
+			return router.NewONNXClassifier(path, cfg.Embedding.Dimension)
+		}
+		if cfg.Routing.ClassifierBackend == "gbt" {
+			load = func(path string) (router.Classifier, error) {
+				return router.NewGBTClassifier(path, cfg.Embedding.Dimension)
+			}
+		}
+		registry, err := router.NewRegistry(
+			cfg.Routing.ClassifierModelPath,
+			cfg.Routing.ComplexityThreshold,
+			load,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create classifier: %w", err)
+		}
+		defer registry.Close()
+		current, _ := registry.Info()
+		log.Printf("complexity classifier %s (threshold %.3f)", current.Version, current.Threshold)
+
+		if cfg.Routing.ClassifierWatchInterval > 0 {
+			watchCtx, stopWatch := context.WithCancel(context.Background())
+			watched := make(chan struct{})
+			defer func() {
+				stopWatch()
+				<-watched // a reload in progress must finish before registry.Close
+			}()
+			go func() {
+				defer close(watched)
+				registry.Watch(watchCtx, cfg.Routing.ClassifierWatchInterval)
+			}()
+		}
+		classifier = registry
+	} else {
+		log.Printf("embedding backend %q: complexity classifier disabled (it expects the onnx model's embeddings)", cfg.Embedding.Backend)
+	}
+
+	// The cost strategy picks from every model with a quality score, so
+	// drop scores for models no provider serves — routing to one would
+	// fail the request.
+	for model := range cfg.Routing.QualityScores {
+		if _, ok := models[model]; !ok {
+			log.Printf("quality score for unregistered model %q ignored", model)
+			delete(cfg.Routing.QualityScores, model)
+		}
 	}
-	defer classifier.Close()
 
 	// Create the model router for "auto" routing. With the classifier
-	// plugged in, all three strategies work: auto, cheapest, quality.
-	mr := router.New(cfg.Routing, classifier)
+	// plugged in, all four strategies work: auto, cheapest, quality, cost.
+	mr := router.New(cfg.Routing, cfg.Costs, classifier)
+
+	// Count tokens with each provider's counting API where it has one,
+	// and calibrate the local estimate routing uses from real usage.
+	mr.SetTokenCounter(tokens.NewCounter(models))
+
+	// Collect client feedback on served responses as classifier training
+	// data. The redis sink shares the cache's Redis unless configured
+	// otherwise.
+	var fb *feedback.Collector
+	if cfg.Feedback.Enabled {
+		sink, err := feedback.NewSink(cfg.Feedback, cfg.Cache.RedisURL)
+		if err != nil {
+			return fmt.Errorf("failed to create feedback sink: %w", err)
+		}
+		fb = feedback.NewCollector(cfg.Feedback, sink)
+		defer fb.Close()
+	}
 
-	srv := server.New(cfg, models, emb, c, mr)
+	srv := server.New(cfg, models, requestEmbedder, c, mr, fb)
 
 	httpServer := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
@@ -125,9 +306,109 @@ func main() {
 		WriteTimeout: cfg.Server.WriteTimeout,
 	}
 
-	log.Printf("llmrouter listening on :%d", cfg.Server.Port)
+	// Serve until SIGTERM (a deploy or scale-down) or SIGINT (Ctrl-C).
+	// ListenAndServe returns ErrServerClosed once Shutdown starts; any
+	// other error means the server couldn't start or died.
+	stopCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	if err := httpServer.ListenAndServe(); err != nil {
-		log.Fatalf("server error: %v", err)
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("llmrouter listening on :%d", cfg.Server.Port)
+		if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-stopCtx.Done():
+		stop() // a second signal kills the process immediately
+	}
+
+	shutdown(srv, httpServer, cfg.Server)
+	return nil
+}
+
+// shutdown drains the server before the deferred teardown in run: /health
+// reports not-ready for the drain delay so load balancers stop sending
+// traffic, then the listener closes and in-flight requests — including
+// streams — get until the shutdown timeout to finish. Connections still
+// open at the deadline are cut. The cache writes they left running get a
+// shutdown timeout of their own; shadow experiment calls are cancelled by
+// Drain rather than waited for.
+func shutdown(srv *server.Server, httpServer *http.Server, cfg config.ServerConfig) {
+	log.Printf("shutting down: draining for %s", cfg.DrainDelay)
+	srv.Drain()
+	time.Sleep(cfg.DrainDelay)
+
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: in-flight requests didn't finish in %s, closing connections: %v", timeout, err)
+		httpServer.Close()
+	}
+
+	// Requests cut off above may have left work behind, so Wait doesn't
+	// share their deadline.
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), timeout)
+	defer waitCancel()
+	if err := srv.Wait(waitCtx); err != nil {
+		log.Printf("shutdown: background work (cache writes, shadow calls) didn't finish in %s: %v", timeout, err)
+	}
+}
+
+// isONNXBackend reports whether backend names the in-process ONNX embedder
+// (the default when unset).
+func isONNXBackend(backend string) bool {
+	return backend == "" || backend == "onnx"
+}
+
+// embeddingFingerprint identifies the configured embedding model's vector
+// space (see embedder.ONNXFingerprint).
+func embeddingFingerprint(cfg config.EmbeddingConfig) (string, error) {
+	if isONNXBackend(cfg.Backend) {
+		return embedder.ONNXFingerprint(cfg.ModelPath, cfg.Dimension, embedder.LongPromptMode(cfg.LongPromptMode))
+	}
+	return embedder.RemoteFingerprint(cfg.Backend, cfg.ModelName, cfg.Dimension), nil
+}
+
+// newEmbedder builds the Embedder for cfg.Backend and returns it with its
+// cleanup function. Remote backends are probed once so a wrong URL, key,
+// or dimension fails at startup instead of on the first request.
+func newEmbedder(cfg config.EmbeddingConfig, client *http.Client) (embedder.Embedder, func() error, error) {
+	var emb embedder.Embedder
+	switch {
+	case isONNXBackend(cfg.Backend):
+		onnx, err := embedder.New(
+			cfg.ModelPath,
+			cfg.TokenizerPath,
+			cfg.LibraryPath,
+			cfg.Dimension,
+			embedder.LongPromptMode(cfg.LongPromptMode),
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		return onnx, onnx.Close, nil
+
+	case cfg.Backend == "openai":
+		emb = embedder.NewOpenAIEmbedder(cfg.APIKey, cfg.BaseURL, cfg.ModelName, cfg.Dimension, client)
+
+	case cfg.Backend == "gemini":
+		emb = embedder.NewGeminiEmbedder(cfg.APIKey, cfg.BaseURL, cfg.ModelName, cfg.Dimension, client)
+
+	default:
+		return nil, nil, fmt.Errorf("unknown embedding backend %q (must be onnx, openai, or gemini)", cfg.Backend)
+	}
+
+	if _, err := emb.Embed("llmrouter startup check"); err != nil {
+		return nil, nil, fmt.Errorf("probing %s embedding backend: %w", cfg.Backend, err)
 	}
+	return emb, func() error { return nil }, nil
 }
@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// ErrUnavailable is returned by a Breaker's calls while Redis is down.
+var ErrUnavailable = errors.New("cache unavailable: redis is down")
+
+// Breaker defaults, for zero CacheConfig fields.
+const (
+	defaultBreakerFailures   = 3
+	defaultReconnectInterval = 5 * time.Second
+
+	// pingTimeout bounds the startup and reconnect pings.
+	pingTimeout = 2 * time.Second
+)
+
+// Breaker is a circuit breaker in front of a Cache. After BreakerFailures
+// consecutive failed calls it opens: every call fails fast with
+// ErrUnavailable instead of waiting on a dead connection, and a background
+// loop pings Redis every ReconnectInterval, closing the breaker when it
+// answers. The handler checks Available to skip the cache entirely while
+// it's open.
+//
+// Ping always goes to Redis, open or not, so readiness checks report the
+// connection's real state.
+type Breaker struct {
+	next      Cache
+	failures  int
+	reconnect time.Duration
+
+	available atomic.Bool
+	streak    atomic.Int64 // consecutive failures while closed
+	probing   atomic.Bool  // a reconnect loop is running
+
+	// lastStats is the last Stats read while Redis was up, served while
+	// it's down so /cache/stats and the entries gauge don't block on it.
+	lastStats atomic.Pointer[CacheStats]
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewBreaker wraps next in a circuit breaker configured by cfg. It pings
+// Redis once: if that fails, the breaker starts open — the gateway runs
+// without the cache until the reconnect loop gets through.
+func NewBreaker(next Cache, cfg CacheConfig) *Breaker {
+	b := &Breaker{
+		next:      next,
+		failures:  cfg.BreakerFailures,
+		reconnect: cfg.ReconnectInterval,
+		done:      make(chan struct{}),
+	}
+	if b.failures <= 0 {
+		b.failures = defaultBreakerFailures
+	}
+	if b.reconnect <= 0 {
+		b.reconnect = defaultReconnectInterval
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := next.Ping(ctx); err != nil {
+		log.Printf("cache: redis unavailable, starting with the cache disabled: %v", err)
+		b.open()
+	} else {
+		b.available.Store(true)
+		metrics.CacheAvailable.Set(1)
+	}
+	return b
+}
+
+// Available reports whether the breaker is closed — Redis is reachable
+// as far as it knows.
+func (b *Breaker) Available() bool {
+	return b.available.Load()
+}
+
+// open takes the cache offline and starts the reconnect loop, unless one
+// is already running.
+func (b *Breaker) open() {
+	b.available.Store(false)
+	metrics.CacheAvailable.Set(0)
+	if !b.probing.CompareAndSwap(false, true) {
+		return
+	}
+	b.wg.Go(b.reconnectLoop)
+}
+
+// reconnectLoop pings Redis until it answers, then closes the breaker.
+func (b *Breaker) reconnectLoop() {
+	ticker := time.NewTicker(b.reconnect)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err := b.next.Ping(ctx)
+		cancel()
+		if err == nil {
+			// Clear probing before closing the breaker, so a failure
+			// right after can start a new loop.
+			b.streak.Store(0)
+			b.probing.Store(false)
+			b.available.Store(true)
+			metrics.CacheAvailable.Set(1)
+			log.Printf("cache: redis reachable again, cache re-enabled")
+			return
+		}
+	}
+}
+
+// record counts the outcome of a call to next, opening the breaker after
+// enough consecutive failures. A caller hanging up isn't Redis's fault.
+func (b *Breaker) record(err error) {
+	if err == nil {
+		b.streak.Store(0)
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if b.streak.Add(1) >= int64(b.failures) && b.available.Load() {
+		log.Printf("cache: %d consecutive redis failures, disabling the cache until it reconnects: %v", b.failures, err)
+		b.open()
+	}
+}
+
+// Lookup implements Cache.
+func (b *Breaker) Lookup(ctx context.Context, embedding []float32, model string) (*CacheResult, error) {
+	if !b.Available() {
+		return nil, ErrUnavailable
+	}
+	result, err := b.next.Lookup(ctx, embedding, model)
+	b.record(err)
+	return result, err
+}
+
+// LookupExact implements Cache.
+func (b *Breaker) LookupExact(ctx context.Context, embedding []float32, model string) (*CacheResult, error) {
+	if !b.Available() {
+		return nil, ErrUnavailable
+	}
+	result, err := b.next.LookupExact(ctx, embedding, model)
+	b.record(err)
+	return result, err
+}
+
+// Nearest implements Cache.
+func (b *Breaker) Nearest(ctx context.Context, embedding []float32, model string) (*CacheCandidate, error) {
+	if !b.Available() {
+		return nil, ErrUnavailable
+	}
+	candidate, err := b.next.Nearest(ctx, embedding, model)
+	b.record(err)
+	return candidate, err
+}
+
+// Store implements Cache.
+func (b *Breaker) Store(ctx context.Context, prompt string, embedding []float32, model string, response *provider.ChatResponse) error {
+	if !b.Available() {
+		return ErrUnavailable
+	}
+	err := b.next.Store(ctx, prompt, embedding, model, response)
+	b.record(err)
+	return err
+}
+
+// Stats implements Cache. While Redis is down it returns the last stats
+// read while it was up.
+func (b *Breaker) Stats() CacheStats {
+	if !b.Available() {
+		if last := b.lastStats.Load(); last != nil {
+			return *last
+		}
+		return CacheStats{}
+	}
+	stats := b.next.Stats()
+	b.lastStats.Store(&stats)
+	return stats
+}
+
+// Flush implements Cache.
+func (b *Breaker) Flush(ctx context.Context) error {
+	if !b.Available() {
+		return ErrUnavailable
+	}
+	err := b.next.Flush(ctx)
+	b.record(err)
+	return err
+}
+
+// Ping implements Cache. It always reaches Redis and doesn't affect the
+// breaker.
+func (b *Breaker) Ping(ctx context.Context) error {
+	return b.next.Ping(ctx)
+}
+
+// Close stops the reconnect loop and closes the wrapped cache.
+func (b *Breaker) Close() error {
+	close(b.done)
+	b.wg.Wait()
+	return b.next.Close()
+}
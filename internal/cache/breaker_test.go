@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupBreaker returns a Breaker over a RedisCache at addr that trips
+// after two failures and retries every 10ms.
+func setupBreaker(t *testing.T, addr string) *Breaker {
+	t.Helper()
+	cfg := CacheConfig{
+		RedisURL:            "redis://" + addr + "?max_retries=-1",
+		SimilarityThreshold: 0.92,
+		TTL:                 time.Hour,
+		MaxEntries:          100,
+		BreakerFailures:     2,
+		ReconnectInterval:   10 * time.Millisecond,
+	}
+	rc, err := OpenRedisCache(cfg)
+	require.NoError(t, err)
+	b := NewBreaker(rc, cfg)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestBreaker_StartsWithoutRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+
+	b := setupBreaker(t, addr)
+	assert.False(t, b.Available())
+
+	_, err := b.Lookup(context.Background(), normalizedVec(1), "m")
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.ErrorIs(t, b.Store(context.Background(), "p", normalizedVec(1), "m", fakeResponse("r")), ErrUnavailable)
+
+	// Redis comes up: the reconnect loop finds it.
+	require.NoError(t, mr.Restart())
+	require.Eventually(t, b.Available, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, b.Store(context.Background(), "p", normalizedVec(1), "m", fakeResponse("r")))
+}
+
+func TestBreaker_TripsAndRecovers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b := setupBreaker(t, mr.Addr())
+	ctx := context.Background()
+	require.True(t, b.Available())
+	require.NoError(t, b.Store(ctx, "p", normalizedVec(1), "m", fakeResponse("cached")))
+	assert.EqualValues(t, 1, b.Stats().Entries)
+
+	mr.Close()
+
+	// A cancelled request isn't a Redis failure.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	for range 3 {
+		b.Lookup(cancelled, normalizedVec(1), "m")
+	}
+	assert.True(t, b.Available())
+
+	// Two real failures open the breaker; after that calls fail fast.
+	for range 2 {
+		_, err := b.Lookup(ctx, normalizedVec(1), "m")
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, ErrUnavailable))
+	}
+	assert.False(t, b.Available())
+	_, err := b.Lookup(ctx, normalizedVec(1), "m")
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.EqualValues(t, 1, b.Stats().Entries, "stats from before the outage")
+	assert.Error(t, b.Ping(ctx))
+
+	require.NoError(t, mr.Restart())
+	require.Eventually(t, b.Available, 2*time.Second, 10*time.Millisecond)
+	result, err := b.Lookup(ctx, normalizedVec(1), "m")
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	assert.Equal(t, "cached", result.Response.Content)
+}
@@ -22,10 +22,26 @@ type Cache interface {
 	// nil if no match is found (nil, nil = miss).
 	Lookup(ctx context.Context, embedding []float32, model string) (*CacheResult, error)
 
+	// LookupExact fetches the entry stored under exactly this embedding
+	// and model, without a similarity scan. Entry keys are derived from
+	// the embedding bytes, so this is a single key read. Used as a fast
+	// path when the caller knows the prompt is an exact repeat (and so
+	// has a bit-identical embedding). Returns nil, nil if there's no such
+	// entry; misses are not counted in Stats, since the caller falls back
+	// to Lookup.
+	LookupExact(ctx context.Context, embedding []float32, model string) (*CacheResult, error)
+
+	// Nearest returns the entry in the model's partition most similar to
+	// the embedding, whether or not it would be a hit, for explaining
+	// cache decisions. Read-only: stats and hit counts are untouched.
+	// Returns nil, nil if the partition is empty.
+	Nearest(ctx context.Context, embedding []float32, model string) (*CacheCandidate, error)
+
 	// Store saves an LLM response keyed by its prompt embedding, scoped
 	// to the specified model. Called after a cache miss once the provider
-	// returns a successful response.
-	Store(ctx context.Context, embedding []float32, model string, response *provider.ChatResponse) error
+	// returns a successful response. The prompt text is stored with the
+	// entry so it can be re-embedded if the embedding model changes.
+	Store(ctx context.Context, prompt string, embedding []float32, model string, response *provider.ChatResponse) error
 
 	// Stats returns current cache metrics (hits, misses, entry count).
 	// Uses in-memory atomic counters, so no Redis call is needed.
@@ -34,11 +50,39 @@ type Cache interface {
 	// Flush deletes all cached entries. Powers the /cache/flush admin endpoint.
 	Flush(ctx context.Context) error
 
+	// Ping round-trips to the backing store, for readiness checks.
+	Ping(ctx context.Context) error
+
 	// Close releases resources (Redis connection pool). Call during
 	// graceful shutdown to avoid leaking TCP connections.
 	Close() error
 }
 
+// Policies for prompts whose embedding doesn't cover all of their text
+// (CacheConfig.TruncatedPolicy).
+const (
+	TruncatedAllow   = "allow"   // treat like any other prompt (the default)
+	TruncatedTighten = "tighten" // require TruncatedSimilarityThreshold instead of SimilarityThreshold
+	TruncatedRefuse  = "refuse"  // never look up or store
+)
+
+// truncatedKey is the context key for WithTruncatedPrompt.
+type truncatedKey struct{}
+
+// WithTruncatedPrompt marks ctx as belonging to a request whose prompt
+// embedding is lossy — the embedder dropped some of the prompt's tokens.
+// Long prompts that share a preamble can embed identically, so Lookup,
+// LookupExact, and Store apply CacheConfig.TruncatedPolicy to them.
+func WithTruncatedPrompt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, truncatedKey{}, true)
+}
+
+// isTruncatedPrompt reports whether ctx was marked by WithTruncatedPrompt.
+func isTruncatedPrompt(ctx context.Context) bool {
+	truncated, _ := ctx.Value(truncatedKey{}).(bool)
+	return truncated
+}
+
 // CacheResult wraps a cached response with metadata. Returned by Lookup
 // on a cache hit — the handler uses Similarity for the debug header and
 // Key for logging.
@@ -48,6 +92,18 @@ type CacheResult struct {
 	Key        string  // Redis key for this entry
 }
 
+// CacheCandidate is the closest cached entry to a prompt, returned by
+// Nearest. Hit reports whether Lookup would serve it: Similarity is at
+// least Threshold (the threshold that applies to this request) and the
+// truncated-prompt policy allows caching.
+type CacheCandidate struct {
+	Key        string  `json:"key"`
+	Prompt     string  `json:"prompt,omitempty"` // the cached entry's prompt, if stored
+	Similarity float64 `json:"similarity"`
+	Threshold  float64 `json:"threshold"`
+	Hit        bool    `json:"hit"`
+}
+
 // CacheStats holds cache performance metrics. Fields are int64 because
 // they're updated atomically from concurrent goroutines — atomic ops
 // in Go require int64, not int.
@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// migrateBatchSize is how many prompts Migrate re-embeds per EmbedBatch call.
+const migrateBatchSize = 32
+
+// BatchEmbedder computes embeddings for Migrate. embedder.Embedder
+// satisfies it; it's defined here so the cache package doesn't depend on
+// the embedder package (and its CGo).
+type BatchEmbedder interface {
+	EmbedBatch(texts []string) ([][]float32, error)
+}
+
+// MigrationResult summarizes a Migrate run.
+type MigrationResult struct {
+	Migrated int // re-embedded and rewritten under the current fingerprint
+	Skipped  int // stale, but stored without a prompt, so can't be re-embedded
+	Failed   int // embedding or Redis errors; left in place
+}
+
+// staleEntry is an entry from another fingerprint namespace, queued for
+// re-embedding.
+type staleEntry struct {
+	key       string
+	prompt    string
+	model     string
+	response  string
+	createdAt time.Time
+}
+
+// Migrate re-embeds every entry stored under a different embedding
+// fingerprint with emb, rewrites it under the current fingerprint, and
+// deletes the original. Responses, creation times, and remaining TTLs
+// carry over, so migrated entries evict and expire as they would have.
+//
+// Stale entries are already invisible to Lookup (they live in another
+// namespace), so this is purely about not throwing away a warm cache when
+// the embedding model changes. Entries stored before prompts were kept
+// can't be re-embedded; they're skipped and age out via TTL and eviction.
+//
+// Safe to run while serving: it only touches stale entries. Stops early
+// if ctx is cancelled.
+func (rc *RedisCache) Migrate(ctx context.Context, emb BatchEmbedder) (MigrationResult, error) {
+	var res MigrationResult
+
+	keys, err := rc.client.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil {
+		return res, fmt.Errorf("reading cache index: %w", err)
+	}
+
+	var pending []staleEntry
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		fields, err := rc.client.HMGet(ctx, key, "fingerprint", "prompt", "model", "response", "created_at").Result()
+		if err != nil {
+			res.Failed++
+			continue
+		}
+		if fields[3] == nil {
+			continue // expired between ZRange and HMGet
+		}
+		if fingerprint, _ := fields[0].(string); fingerprint == rc.cfg.Fingerprint {
+			continue
+		}
+		prompt, _ := fields[1].(string)
+		if prompt == "" {
+			res.Skipped++
+			continue
+		}
+
+		model, _ := fields[2].(string)
+		response, _ := fields[3].(string)
+		createdAtStr, _ := fields[4].(string)
+		createdAt, _ := strconv.ParseInt(createdAtStr, 10, 64)
+
+		pending = append(pending, staleEntry{
+			key:       key,
+			prompt:    prompt,
+			model:     model,
+			response:  response,
+			createdAt: time.Unix(createdAt, 0),
+		})
+		if len(pending) == migrateBatchSize {
+			rc.migrateBatch(ctx, emb, pending, &res)
+			pending = pending[:0]
+		}
+	}
+	if len(pending) > 0 {
+		rc.migrateBatch(ctx, emb, pending, &res)
+	}
+
+	return res, ctx.Err()
+}
+
+// migrateBatch re-embeds one batch of stale entries and swaps each for a
+// current-fingerprint copy. A failed embedding call fails the whole batch;
+// Redis errors fail only the affected entry.
+func (rc *RedisCache) migrateBatch(ctx context.Context, emb BatchEmbedder, batch []staleEntry, res *MigrationResult) {
+	prompts := make([]string, len(batch))
+	for i, e := range batch {
+		prompts[i] = e.prompt
+	}
+
+	vecs, err := emb.EmbedBatch(prompts)
+	if err != nil {
+		res.Failed += len(batch)
+		return
+	}
+
+	for i, e := range batch {
+		// Carry over the remaining lifetime. -2 means the entry expired
+		// while we were embedding; -1 (no expiry) shouldn't happen, but
+		// gets the configured TTL.
+		ttl, err := rc.client.PTTL(ctx, e.key).Result()
+		if err != nil {
+			res.Failed++
+			continue
+		}
+		if ttl == -2 {
+			continue
+		}
+		if ttl < 0 {
+			ttl = rc.cfg.TTL
+		}
+
+		if err := rc.writeEntry(ctx, e.prompt, vecs[i], e.model, []byte(e.response), e.createdAt, ttl); err != nil {
+			res.Failed++
+			continue
+		}
+		rc.client.ZRem(ctx, indexKey, e.key)
+		rc.deleteEntry(ctx, e.key)
+		res.Migrated++
+	}
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupFingerprintedCaches returns two RedisCaches on the same Redis with
+// different embedding fingerprints — the before and after of a model change.
+func setupFingerprintedCaches(t *testing.T) (oldCache, newCache *RedisCache, mr *miniredis.Miniredis) {
+	t.Helper()
+
+	mr = miniredis.RunT(t)
+	open := func(fingerprint string) *RedisCache {
+		rc, err := NewRedisCache(CacheConfig{
+			RedisURL:            "redis://" + mr.Addr(),
+			SimilarityThreshold: 0.92,
+			TTL:                 1 * time.Hour,
+			MaxEntries:          100,
+			Fingerprint:         fingerprint,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { rc.Close() })
+		return rc
+	}
+	return open("onnx-old"), open("onnx-new"), mr
+}
+
+// stubEmbedder returns vec for every input, or err.
+type stubEmbedder struct {
+	vec []float32
+	err error
+}
+
+func (s stubEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	out := make([][]float32, len(texts))
+	for i := range out {
+		out[i] = s.vec
+	}
+	return out, nil
+}
+
+func TestFingerprint_IsolatesLookups(t *testing.T) {
+	oldCache, newCache, _ := setupFingerprintedCaches(t)
+	ctx := context.Background()
+
+	embedding := normalizedVec(1.0)
+	require.NoError(t, oldCache.Store(ctx, "hello", embedding, "test-model", fakeResponse("old model")))
+
+	// Same vector, same chat model — but a different embedding model, so
+	// neither lookup path may compare against the old entry.
+	result, err := newCache.Lookup(ctx, embedding, "test-model")
+	require.NoError(t, err)
+	assert.Nil(t, result, "expected miss across fingerprints")
+
+	result, err = newCache.LookupExact(ctx, embedding, "test-model")
+	require.NoError(t, err)
+	assert.Nil(t, result, "expected exact miss across fingerprints")
+
+	result, err = oldCache.Lookup(ctx, embedding, "test-model")
+	require.NoError(t, err)
+	assert.NotNil(t, result, "expected hit within the same fingerprint")
+}
+
+func TestMigrate_ReembedsStaleEntries(t *testing.T) {
+	oldCache, newCache, mr := setupFingerprintedCaches(t)
+	ctx := context.Background()
+
+	require.NoError(t, oldCache.Store(ctx, "hello", normalizedVec(1.0), "test-model", fakeResponse("migrated")))
+	mr.FastForward(10 * time.Minute)
+
+	newVec := make([]float32, 384)
+	newVec[5] = 1.0
+
+	res, err := newCache.Migrate(ctx, stubEmbedder{vec: newVec})
+	require.NoError(t, err)
+	assert.Equal(t, MigrationResult{Migrated: 1}, res)
+
+	// The entry is now found under the new fingerprint with the new vector...
+	result, err := newCache.Lookup(ctx, newVec, "test-model")
+	require.NoError(t, err)
+	require.NotNil(t, result, "expected migrated entry to be found")
+	assert.Equal(t, "migrated", result.Response.Content)
+
+	// ...keeps its remaining TTL rather than getting a fresh hour...
+	assert.LessOrEqual(t, mr.TTL(result.Key), 50*time.Minute)
+
+	// ...and the old copy is gone.
+	assert.Equal(t, int64(1), newCache.Stats().Entries)
+	result, err = oldCache.Lookup(ctx, normalizedVec(1.0), "test-model")
+	require.NoError(t, err)
+	assert.Nil(t, result, "expected old entry to be removed")
+
+	// A second run has nothing to do.
+	res, err = newCache.Migrate(ctx, stubEmbedder{vec: newVec})
+	require.NoError(t, err)
+	assert.Equal(t, MigrationResult{}, res)
+}
+
+func TestMigrate_SkipsEntriesWithoutPrompt(t *testing.T) {
+	oldCache, newCache, _ := setupFingerprintedCaches(t)
+	ctx := context.Background()
+
+	require.NoError(t, oldCache.Store(ctx, "", normalizedVec(1.0), "test-model", fakeResponse("no prompt")))
+
+	res, err := newCache.Migrate(ctx, stubEmbedder{vec: normalizedVec(1.0)})
+	require.NoError(t, err)
+	assert.Equal(t, MigrationResult{Skipped: 1}, res)
+}
+
+func TestMigrate_EmbedErrorLeavesEntries(t *testing.T) {
+	oldCache, newCache, _ := setupFingerprintedCaches(t)
+	ctx := context.Background()
+
+	require.NoError(t, oldCache.Store(ctx, "hello", normalizedVec(1.0), "test-model", fakeResponse("kept")))
+
+	res, err := newCache.Migrate(ctx, stubEmbedder{err: errors.New("model unavailable")})
+	require.NoError(t, err)
+	assert.Equal(t, MigrationResult{Failed: 1}, res)
+
+	result, err := oldCache.Lookup(ctx, normalizedVec(1.0), "test-model")
+	require.NoError(t, err)
+	assert.NotNil(t, result, "expected entry left in place after a failed migration")
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"sync/atomic"
@@ -30,6 +31,39 @@ type CacheConfig struct {
 	SimilarityThreshold float64       `koanf:"similarity_threshold"` // minimum cosine similarity for a cache hit (e.g. 0.92)
 	TTL                 time.Duration `koanf:"ttl"`                   // how long entries live before Redis auto-deletes them
 	MaxEntries          int           `koanf:"max_entries"`           // max cached entries — triggers eviction when full
+
+	// How to treat prompts flagged by WithTruncatedPrompt: "allow"
+	// (default), "tighten", or "refuse". Tightening only helps when the
+	// embedder keeps some of the tail (head_tail or chunk mode) — in
+	// truncate mode, prompts sharing a preamble embed identically, so
+	// use "refuse".
+	TruncatedPolicy              string  `koanf:"truncated_policy"`
+	TruncatedSimilarityThreshold float64 `koanf:"truncated_similarity_threshold"` // threshold for truncated prompts under "tighten" (e.g. 0.98)
+
+	// Circuit breaker (see Breaker): this many consecutive Redis failures
+	// take the cache offline, and while it's offline Redis is pinged every
+	// ReconnectInterval until it answers. Zero means the defaults.
+	BreakerFailures   int           `koanf:"breaker_failures"`
+	ReconnectInterval time.Duration `koanf:"reconnect_interval"`
+
+	// Request coalescing (applied by the server): "off" (default),
+	// "exact", or "semantic". A miss whose prompt matches one already on
+	// its way to the provider for the same model — an identical embedding,
+	// or for "semantic" one within SimilarityThreshold — waits up to
+	// CoalesceMaxWait for that response instead of making its own call.
+	Coalesce        string        `koanf:"coalesce"`
+	CoalesceMaxWait time.Duration `koanf:"coalesce_max_wait"`
+
+	// MigrateOnStart re-embeds entries stored under another embedding
+	// fingerprint in the background at startup (see Migrate).
+	MigrateOnStart bool `koanf:"migrate_on_start"`
+
+	// Fingerprint identifies the embedding model whose vectors this cache
+	// holds (embedder.ONNXFingerprint / RemoteFingerprint). Set by main
+	// from the embedder, not from config. Entries are namespaced by it, so
+	// Lookup never compares vectors from different models. Empty means
+	// no namespace (the pre-fingerprint key layout).
+	Fingerprint string `koanf:"-"`
 }
 
 // RedisCache implements the Cache interface using Redis for storage and
@@ -47,19 +81,29 @@ type RedisCache struct {
 
 // NewRedisCache creates a RedisCache and verifies the Redis connection.
 func NewRedisCache(cfg CacheConfig) (*RedisCache, error) {
-	opts, err := redis.ParseURL(cfg.RedisURL)
+	rc, err := OpenRedisCache(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("parsing redis URL: %w", err)
+		return nil, err
 	}
 
-	client := redis.NewClient(opts)
-
-	if err := client.Ping(context.Background()).Err(); err != nil {
+	if err := rc.client.Ping(context.Background()).Err(); err != nil {
+		rc.Close()
 		return nil, fmt.Errorf("connecting to redis: %w", err)
 	}
+	return rc, nil
+}
+
+// OpenRedisCache creates a RedisCache without contacting Redis: the
+// client connects on first use, so this only fails on a malformed URL.
+// Wrap it in a Breaker to start up (and keep serving) while Redis is down.
+func OpenRedisCache(cfg CacheConfig) (*RedisCache, error) {
+	opts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing redis URL: %w", err)
+	}
 
 	return &RedisCache{
-		client: client,
+		client: redis.NewClient(opts),
 		cfg:    cfg,
 	}, nil
 }
@@ -88,24 +132,32 @@ func bytesToEmbedding(data []byte) []float32 {
 }
 
 // embeddingKey returns the Redis key for a cache entry by SHA-256 hashing
-// the embedding bytes concatenated with the model name. Including the model
-// ensures that the same prompt sent to different models gets separate cache
-// entries (different Redis keys) rather than overwriting each other.
+// the embedding bytes concatenated with the model name and embedding
+// fingerprint. Including the model ensures that the same prompt sent to
+// different models gets separate cache entries (different Redis keys)
+// rather than overwriting each other.
 //
-// The model bytes only affect the key — they don't touch the stored embedding
-// vector, so the cosine similarity search is unaffected.
-func embeddingKey(embedding []float32, model string) string {
+// The model and fingerprint bytes only affect the key — they don't touch
+// the stored embedding vector, so the cosine similarity search is
+// unaffected. An empty fingerprint yields the pre-fingerprint key.
+func embeddingKey(embedding []float32, model, fingerprint string) string {
 	embBytes := embeddingToBytes(embedding)
 	combined := append(embBytes, []byte(model)...)
+	combined = append(combined, []byte(fingerprint)...)
 	hash := sha256.Sum256(combined)
 	return keyPrefix + hex.EncodeToString(hash[:])
 }
 
-// modelIndexKey returns the Redis key for a model-scoped sorted set index.
-// Lookup uses this to scan only entries for the requested model, preventing
-// cross-model cache hits.
-func modelIndexKey(model string) string {
-	return indexKey + ":" + model
+// modelIndexKey returns the Redis key for a model-scoped sorted set index
+// within an embedding fingerprint's namespace. Lookup uses this to scan
+// only entries for the requested model whose vectors came from the current
+// embedding model, preventing cross-model cache hits and comparisons
+// between incompatible vectors.
+func modelIndexKey(fingerprint, model string) string {
+	if fingerprint == "" {
+		return indexKey + ":" + model
+	}
+	return indexKey + ":" + fingerprint + ":" + model
 }
 
 // ---------------------------------------------------------------------------
@@ -115,15 +167,39 @@ func modelIndexKey(model string) string {
 // Store saves an LLM response keyed by its prompt embedding. Uses a Redis
 // pipeline to batch the hash write, TTL set, and index update into one
 // round-trip. Evicts the oldest entry if we're at MaxEntries.
-func (rc *RedisCache) Store(ctx context.Context, embedding []float32, model string, response *provider.ChatResponse) error {
-	key := embeddingKey(embedding, model)
+func (rc *RedisCache) Store(ctx context.Context, prompt string, embedding []float32, model string, response *provider.ChatResponse) error {
+	if rc.refuses(ctx) {
+		return nil
+	}
 
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		return fmt.Errorf("marshaling response: %w", err)
 	}
 
-	now := time.Now()
+	if err := rc.writeEntry(ctx, prompt, embedding, model, responseJSON, time.Now(), rc.cfg.TTL); err != nil {
+		return err
+	}
+
+	// Evict oldest entries if over the limit.
+	if rc.cfg.MaxEntries > 0 {
+		count, err := rc.client.ZCard(ctx, indexKey).Result()
+		if err != nil {
+			return fmt.Errorf("checking entry count: %w", err)
+		}
+		if int(count) > rc.cfg.MaxEntries {
+			rc.evictOldest(ctx, int(count)-rc.cfg.MaxEntries)
+		}
+	}
+
+	return nil
+}
+
+// writeEntry writes one entry under the current fingerprint. createdAt
+// orders it in the indexes (eviction is oldest-first); ttl is its
+// lifetime from now. The prompt is kept so Migrate can re-embed it.
+func (rc *RedisCache) writeEntry(ctx context.Context, prompt string, embedding []float32, model string, responseJSON []byte, createdAt time.Time, ttl time.Duration) error {
+	key := embeddingKey(embedding, model, rc.cfg.Fingerprint)
 	embBytes := embeddingToBytes(embedding)
 
 	// Pipeline: batch commands into 1 network round-trip.
@@ -134,36 +210,26 @@ func (rc *RedisCache) Store(ctx context.Context, embedding []float32, model stri
 	// the entry from the correct model-scoped index.
 	pipe := rc.client.Pipeline()
 	pipe.HSet(ctx, key, map[string]interface{}{
-		"embedding":  embBytes,
-		"response":   responseJSON,
-		"model":      model,
-		"created_at": now.Unix(),
-		"hit_count":  0,
+		"embedding":   embBytes,
+		"response":    responseJSON,
+		"model":       model,
+		"prompt":      prompt,
+		"fingerprint": rc.cfg.Fingerprint,
+		"created_at":  createdAt.Unix(),
+		"hit_count":   0,
 	})
-	pipe.Expire(ctx, key, rc.cfg.TTL)
+	pipe.Expire(ctx, key, ttl)
 	pipe.ZAdd(ctx, indexKey, redis.Z{
-		Score:  float64(now.UnixMilli()),
+		Score:  float64(createdAt.UnixMilli()),
 		Member: key,
 	})
-	pipe.ZAdd(ctx, modelIndexKey(model), redis.Z{
-		Score:  float64(now.UnixMilli()),
+	pipe.ZAdd(ctx, modelIndexKey(rc.cfg.Fingerprint, model), redis.Z{
+		Score:  float64(createdAt.UnixMilli()),
 		Member: key,
 	})
 	if _, err := pipe.Exec(ctx); err != nil {
 		return fmt.Errorf("storing cache entry: %w", err)
 	}
-
-	// Evict oldest entries if over the limit.
-	if rc.cfg.MaxEntries > 0 {
-		count, err := rc.client.ZCard(ctx, indexKey).Result()
-		if err != nil {
-			return fmt.Errorf("checking entry count: %w", err)
-		}
-		if int(count) > rc.cfg.MaxEntries {
-			rc.evictOldest(ctx, int(count)-rc.cfg.MaxEntries)
-		}
-	}
-
 	return nil
 }
 
@@ -180,14 +246,25 @@ func (rc *RedisCache) evictOldest(ctx context.Context, n int) {
 	for _, entry := range entries {
 		key := entry.Member.(string)
 
-		// Read the model field so we can remove from the model-scoped index.
-		model, err := rc.client.HGet(ctx, key, "model").Result()
-		if err == nil && model != "" {
-			rc.client.ZRem(ctx, modelIndexKey(model), key)
-		}
+		rc.deleteEntry(ctx, key)
+	}
+}
 
-		rc.client.Del(ctx, key)
+// deleteEntry removes an entry and its model-scoped index membership. It
+// reads the "model" and "fingerprint" fields to find the right index, so
+// it works for entries from any fingerprint namespace. The caller removes
+// the key from the global index.
+func (rc *RedisCache) deleteEntry(ctx context.Context, key string) {
+	fields, err := rc.client.HMGet(ctx, key, "model", "fingerprint").Result()
+	if err == nil {
+		model, _ := fields[0].(string)
+		fingerprint, _ := fields[1].(string)
+		if model != "" {
+			rc.client.ZRem(ctx, modelIndexKey(fingerprint, model), key)
+		}
 	}
+
+	rc.client.Del(ctx, key)
 }
 
 // ---------------------------------------------------------------------------
@@ -201,23 +278,54 @@ func (rc *RedisCache) evictOldest(ctx context.Context, n int) {
 // match (avoids deserializing every cached response). Second pass fetches
 // the full response only for the winner.
 func (rc *RedisCache) Lookup(ctx context.Context, embedding []float32, model string) (*CacheResult, error) {
-	// Get cache keys from the model-scoped index. This ensures we only
-	// compare against entries stored for the same model, preventing
-	// cross-model cache hits.
-	keys, err := rc.client.ZRange(ctx, modelIndexKey(model), 0, -1).Result()
+	if rc.refuses(ctx) {
+		atomic.AddInt64(&rc.misses, 1)
+		return nil, nil
+	}
+
+	bestKey, bestSim, err := rc.bestMatch(ctx, embedding, model)
 	if err != nil {
-		return nil, fmt.Errorf("reading cache index: %w", err)
+		return nil, err
 	}
 
-	if len(keys) == 0 {
+	// Check if the best match clears the threshold.
+	if bestKey == "" || bestSim < rc.threshold(ctx) {
 		atomic.AddInt64(&rc.misses, 1)
 		return nil, nil
 	}
 
-	// Pass 1: fetch embeddings and find the best cosine similarity match.
-	var bestKey string
-	var bestSim float64
+	// Pass 2: fetch the full response for the winning entry.
+	response, err := rc.fetchResponse(ctx, bestKey)
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		atomic.AddInt64(&rc.misses, 1)
+		return nil, nil
+	}
+
+	rc.recordHit(bestSim)
 
+	return &CacheResult{
+		Response:   response,
+		Similarity: bestSim,
+		Key:        bestKey,
+	}, nil
+}
+
+// bestMatch scans the model's partition for the entry with the highest
+// cosine similarity to embedding. Returns an empty key if the partition is
+// empty (or every entry has a non-positive similarity).
+func (rc *RedisCache) bestMatch(ctx context.Context, embedding []float32, model string) (bestKey string, bestSim float64, err error) {
+	// Get cache keys from the model-scoped index. This ensures we only
+	// compare against entries stored for the same model, preventing
+	// cross-model cache hits.
+	keys, err := rc.client.ZRange(ctx, modelIndexKey(rc.cfg.Fingerprint, model), 0, -1).Result()
+	if err != nil {
+		return "", 0, fmt.Errorf("reading cache index: %w", err)
+	}
+
+	// Pass 1: fetch embeddings and find the best cosine similarity match.
 	for _, key := range keys {
 		embBytes, err := rc.client.HGet(ctx, key, "embedding").Bytes()
 		if err != nil {
@@ -234,20 +342,79 @@ func (rc *RedisCache) Lookup(ctx context.Context, embedding []float32, model str
 			bestKey = key
 		}
 	}
+	return bestKey, bestSim, nil
+}
 
-	// Check if the best match clears the threshold.
-	if bestSim < rc.cfg.SimilarityThreshold {
-		atomic.AddInt64(&rc.misses, 1)
+// Nearest reports the closest entry and whether Lookup would serve it,
+// without counting a hit or miss.
+func (rc *RedisCache) Nearest(ctx context.Context, embedding []float32, model string) (*CacheCandidate, error) {
+	key, sim, err := rc.bestMatch(ctx, embedding, model)
+	if err != nil || key == "" {
+		return nil, err
+	}
+	prompt, err := rc.client.HGet(ctx, key, "prompt").Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("reading cached prompt: %w", err)
+	}
+	threshold := rc.threshold(ctx)
+	return &CacheCandidate{
+		Key:        key,
+		Prompt:     prompt,
+		Similarity: sim,
+		Threshold:  threshold,
+		Hit:        sim >= threshold && !rc.refuses(ctx),
+	}, nil
+}
+
+// LookupExact reads the entry whose key is derived from exactly this
+// embedding and model. No scan — one HMGET. Similarity is 1.0 by
+// construction.
+func (rc *RedisCache) LookupExact(ctx context.Context, embedding []float32, model string) (*CacheResult, error) {
+	if rc.refuses(ctx) {
 		return nil, nil
 	}
 
-	// Pass 2: fetch the full response for the winning entry.
-	result, err := rc.client.HMGet(ctx, bestKey, "response", "hit_count").Result()
+	key := embeddingKey(embedding, model, rc.cfg.Fingerprint)
+
+	response, err := rc.fetchResponse(ctx, key)
+	if err != nil || response == nil {
+		return nil, err
+	}
+
+	rc.recordHit(1.0)
+
+	return &CacheResult{
+		Response:   response,
+		Similarity: 1.0,
+		Key:        key,
+	}, nil
+}
+
+// refuses reports whether the truncated-prompt policy rules out caching
+// for this request.
+func (rc *RedisCache) refuses(ctx context.Context) bool {
+	return rc.cfg.TruncatedPolicy == TruncatedRefuse && isTruncatedPrompt(ctx)
+}
+
+// threshold returns the similarity a match must reach for this request:
+// the tightened threshold for truncated prompts under "tighten", otherwise
+// SimilarityThreshold.
+func (rc *RedisCache) threshold(ctx context.Context) float64 {
+	if rc.cfg.TruncatedPolicy == TruncatedTighten && isTruncatedPrompt(ctx) {
+		return max(rc.cfg.SimilarityThreshold, rc.cfg.TruncatedSimilarityThreshold)
+	}
+	return rc.cfg.SimilarityThreshold
+}
+
+// fetchResponse reads and decodes the response stored under key, and bumps
+// the entry's hit_count. Returns nil, nil if the entry is gone (expired or
+// evicted).
+func (rc *RedisCache) fetchResponse(ctx context.Context, key string) (*provider.ChatResponse, error) {
+	result, err := rc.client.HMGet(ctx, key, "response", "hit_count").Result()
 	if err != nil {
 		return nil, fmt.Errorf("fetching cached response: %w", err)
 	}
 	if result[0] == nil {
-		atomic.AddInt64(&rc.misses, 1)
 		return nil, nil
 	}
 
@@ -257,7 +424,13 @@ func (rc *RedisCache) Lookup(ctx context.Context, embedding []float32, model str
 	}
 
 	// Increment hit count on the entry (fire-and-forget).
-	rc.client.HIncrBy(ctx, bestKey, "hit_count", 1)
+	rc.client.HIncrBy(ctx, key, "hit_count", 1)
+
+	return &response, nil
+}
+
+// recordHit updates the hit stats for a lookup that returned an entry.
+func (rc *RedisCache) recordHit(similarity float64) {
 
 	// Update stats atomically.
 	atomic.AddInt64(&rc.hits, 1)
@@ -266,19 +439,13 @@ func (rc *RedisCache) Lookup(ctx context.Context, embedding []float32, model str
 	// addition here — slight imprecision under heavy concurrency is
 	// acceptable for a stats gauge.
 	rc.similaritySum = int64(math.Float64bits(
-		math.Float64frombits(uint64(atomic.LoadInt64(&rc.similaritySum))) + bestSim,
+		math.Float64frombits(uint64(atomic.LoadInt64(&rc.similaritySum))) + similarity,
 	))
 	_ = newHitCount
-
-	return &CacheResult{
-		Response:   &response,
-		Similarity: bestSim,
-		Key:        bestKey,
-	}, nil
 }
 
 // ---------------------------------------------------------------------------
-// Group 5: Stats, Flush, Close
+// Group 5: Stats, Flush, Ping, Close
 // ---------------------------------------------------------------------------
 
 // Stats returns current cache performance metrics.
@@ -347,6 +514,11 @@ func (rc *RedisCache) Flush(ctx context.Context) error {
 	return nil
 }
 
+// Ping sends a Redis PING.
+func (rc *RedisCache) Ping(ctx context.Context) error {
+	return rc.client.Ping(ctx).Err()
+}
+
 // Close releases the Redis connection pool.
 func (rc *RedisCache) Close() error {
 	return rc.client.Close()
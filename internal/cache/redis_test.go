@@ -78,7 +78,7 @@ func TestStoreAndLookup_IdenticalEmbedding(t *testing.T) {
 	resp := fakeResponse("Hello, world!")
 
 	// Store a response.
-	err := rc.Store(ctx, embedding, "test-model", resp)
+	err := rc.Store(ctx, "prompt", embedding, "test-model", resp)
 	require.NoError(t, err)
 
 	// Look up with the exact same embedding and model — should be a hit.
@@ -102,7 +102,7 @@ func TestLookup_DissimilarEmbedding(t *testing.T) {
 	ctx := context.Background()
 
 	// Store with one vector direction.
-	err := rc.Store(ctx, normalizedVec(1.0), "test-model", fakeResponse("cached response"))
+	err := rc.Store(ctx, "prompt", normalizedVec(1.0), "test-model", fakeResponse("cached response"))
 	require.NoError(t, err)
 
 	// Look up with a vector pointing in a completely different dimension.
@@ -121,6 +121,37 @@ func TestLookup_DissimilarEmbedding(t *testing.T) {
 	assert.Equal(t, int64(1), stats.Misses)
 }
 
+func TestNearest(t *testing.T) {
+	rc := setupCache(t, 100)
+	ctx := context.Background()
+
+	candidate, err := rc.Nearest(ctx, normalizedVec(1.0), "test-model")
+	require.NoError(t, err)
+	assert.Nil(t, candidate, "empty partition has no candidate")
+
+	require.NoError(t, rc.Store(ctx, "what is go?", normalizedVec(1.0), "test-model", fakeResponse("a language")))
+
+	// A near miss: similarity 0.8, below the 0.92 threshold.
+	near := make([]float32, 384)
+	near[0], near[1] = 0.8, 0.6
+	candidate, err = rc.Nearest(ctx, near, "test-model")
+	require.NoError(t, err)
+	require.NotNil(t, candidate)
+	assert.Equal(t, "what is go?", candidate.Prompt)
+	assert.InDelta(t, 0.8, candidate.Similarity, 0.001)
+	assert.Equal(t, 0.92, candidate.Threshold)
+	assert.False(t, candidate.Hit)
+
+	candidate, err = rc.Nearest(ctx, normalizedVec(1.0), "test-model")
+	require.NoError(t, err)
+	assert.True(t, candidate.Hit)
+
+	// Read-only: no hits or misses counted.
+	stats := rc.Stats()
+	assert.Equal(t, int64(0), stats.Hits)
+	assert.Equal(t, int64(0), stats.Misses)
+}
+
 func TestEviction_MaxEntries(t *testing.T) {
 	rc := setupCache(t, 2)
 	ctx := context.Background()
@@ -133,13 +164,13 @@ func TestEviction_MaxEntries(t *testing.T) {
 	vec3 := make([]float32, 384)
 	vec3[2] = 1.0
 
-	require.NoError(t, rc.Store(ctx, vec1, "test-model", fakeResponse("first")))
+	require.NoError(t, rc.Store(ctx, "prompt", vec1, "test-model", fakeResponse("first")))
 	// Small sleep to ensure distinct timestamps in the sorted set, so
 	// eviction order is deterministic (lowest score = oldest = evicted first).
 	time.Sleep(2 * time.Millisecond)
-	require.NoError(t, rc.Store(ctx, vec2, "test-model", fakeResponse("second")))
+	require.NoError(t, rc.Store(ctx, "prompt", vec2, "test-model", fakeResponse("second")))
 	time.Sleep(2 * time.Millisecond)
-	require.NoError(t, rc.Store(ctx, vec3, "test-model", fakeResponse("third")))
+	require.NoError(t, rc.Store(ctx, "prompt", vec3, "test-model", fakeResponse("third")))
 
 	// With MaxEntries=2, the oldest (vec1/"first") should have been evicted.
 	stats := rc.Stats()
@@ -173,8 +204,8 @@ func TestFlush_ResetsEverything(t *testing.T) {
 	vec1[0] = 1.0
 	vec2 := make([]float32, 384)
 	vec2[1] = 1.0
-	require.NoError(t, rc.Store(ctx, vec1, "test-model", fakeResponse("one")))
-	require.NoError(t, rc.Store(ctx, vec2, "test-model", fakeResponse("two")))
+	require.NoError(t, rc.Store(ctx, "prompt", vec1, "test-model", fakeResponse("one")))
+	require.NoError(t, rc.Store(ctx, "prompt", vec2, "test-model", fakeResponse("two")))
 
 	result, err := rc.Lookup(ctx, vec1, "test-model")
 	require.NoError(t, err)
@@ -209,7 +240,7 @@ func TestLookup_CrossModelIsolation(t *testing.T) {
 	embedding := normalizedVec(1.0)
 
 	// Store a response under model A.
-	err := rc.Store(ctx, embedding, "model-a", fakeResponse("response from model A"))
+	err := rc.Store(ctx, "prompt", embedding, "model-a", fakeResponse("response from model A"))
 	require.NoError(t, err)
 
 	// Look up with the exact same embedding but a different model — should miss.
@@ -223,3 +254,91 @@ func TestLookup_CrossModelIsolation(t *testing.T) {
 	require.NotNil(t, result, "expected cache hit for same model and embedding")
 	assert.Equal(t, "response from model A", result.Response.Content)
 }
+
+func TestLookupExact(t *testing.T) {
+	rc := setupCache(t, 100)
+	ctx := context.Background()
+
+	embedding := normalizedVec(1.0)
+	require.NoError(t, rc.Store(ctx, "prompt", embedding, "model-a", fakeResponse("exact")))
+
+	result, err := rc.LookupExact(ctx, embedding, "model-a")
+	require.NoError(t, err)
+	require.NotNil(t, result, "expected exact hit for the stored embedding")
+	assert.Equal(t, "exact", result.Response.Content)
+	assert.Equal(t, 1.0, result.Similarity)
+
+	// A near-identical embedding is a semantic hit but not an exact one.
+	near := make([]float32, 384)
+	near[0], near[1] = 0.999, 0.0447
+	result, err = rc.LookupExact(ctx, near, "model-a")
+	require.NoError(t, err)
+	assert.Nil(t, result, "expected exact miss for a different embedding")
+
+	// Same embedding under another model is a miss too.
+	result, err = rc.LookupExact(ctx, embedding, "model-b")
+	require.NoError(t, err)
+	assert.Nil(t, result, "expected exact miss for a different model")
+
+	// Exact misses aren't counted; the caller falls back to Lookup.
+	stats := rc.Stats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(0), stats.Misses)
+}
+
+func TestTruncatedPolicy_Refuse(t *testing.T) {
+	rc := setupCache(t, 100)
+	rc.cfg.TruncatedPolicy = TruncatedRefuse
+	ctx := context.Background()
+	truncCtx := WithTruncatedPrompt(ctx)
+
+	embedding := normalizedVec(1.0)
+
+	// Truncated prompts are never stored...
+	require.NoError(t, rc.Store(truncCtx, "prompt", embedding, "test-model", fakeResponse("truncated")))
+	result, err := rc.Lookup(ctx, embedding, "test-model")
+	require.NoError(t, err)
+	assert.Nil(t, result, "expected truncated prompt not to be stored")
+
+	// ...and never served, even on an identical embedding.
+	require.NoError(t, rc.Store(ctx, "prompt", embedding, "test-model", fakeResponse("full")))
+	result, err = rc.Lookup(truncCtx, embedding, "test-model")
+	require.NoError(t, err)
+	assert.Nil(t, result, "expected lookup refused for truncated prompt")
+	result, err = rc.LookupExact(truncCtx, embedding, "test-model")
+	require.NoError(t, err)
+	assert.Nil(t, result, "expected exact lookup refused for truncated prompt")
+}
+
+func TestTruncatedPolicy_Tighten(t *testing.T) {
+	rc := setupCache(t, 100)
+	rc.cfg.TruncatedPolicy = TruncatedTighten
+	rc.cfg.TruncatedSimilarityThreshold = 0.99
+	ctx := context.Background()
+
+	require.NoError(t, rc.Store(ctx, "prompt", normalizedVec(1.0), "test-model", fakeResponse("stored")))
+
+	// ~0.95 similar: clears the normal 0.92 threshold but not 0.99.
+	near := make([]float32, 384)
+	near[0], near[1] = 0.95, float32(math.Sqrt(1-0.95*0.95))
+
+	result, err := rc.Lookup(ctx, near, "test-model")
+	require.NoError(t, err)
+	assert.NotNil(t, result, "expected hit at the normal threshold")
+
+	result, err = rc.Lookup(WithTruncatedPrompt(ctx), near, "test-model")
+	require.NoError(t, err)
+	assert.Nil(t, result, "expected miss at the tightened threshold")
+}
+
+func TestPing(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc, err := NewRedisCache(CacheConfig{RedisURL: "redis://" + mr.Addr(), SimilarityThreshold: 0.92})
+	require.NoError(t, err)
+	t.Cleanup(func() { rc.Close() })
+
+	assert.NoError(t, rc.Ping(context.Background()))
+
+	mr.Close()
+	assert.Error(t, rc.Ping(context.Background()))
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/howard-nolan/llmrouter/internal/cache"
+	"github.com/howard-nolan/llmrouter/internal/feedback"
 	"github.com/joho/godotenv"
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/env"
@@ -23,6 +24,7 @@ type Config struct {
 	Embedding EmbeddingConfig           `koanf:"embedding"`
 	Routing   RoutingConfig             `koanf:"routing"`
 	Costs     map[string]ModelCost      `koanf:"costs"`
+	Feedback  feedback.FeedbackConfig   `koanf:"feedback"`
 }
 
 // ModelCost holds per-model token pricing. Prices are in USD per million
@@ -33,33 +35,187 @@ type ModelCost struct {
 }
 
 // RoutingConfig controls how "model": "auto" requests are routed.
+//
+// QualityScores and EstimatedOutputTokens drive the "cost" strategy, which
+// ignores provider tiers and considers every model with both a quality
+// score (0–1) and a Costs entry. EstimatedOutputTokens is the typical
+// completion length; the strategy scales it by prompt complexity.
+//
+// ClassifierBackend picks how ClassifierModelPath is evaluated: "onnx"
+// (the default; ONNX Runtime on the .onnx model) or "gbt" (pure Go, on the
+// .gbt.json tree export written alongside it).
+//
+// ClassifierWatchInterval, if set, polls ClassifierModelPath (and its
+// .json metadata sidecar) and hot-reloads the classifier when either
+// changes. Zero disables the watch; POST /classifier/reload still works.
 type RoutingConfig struct {
-	DefaultStrategy     string                          `koanf:"default_strategy"`
-	DefaultProvider     string                          `koanf:"default_provider"`
-	ComplexityThreshold float64                         `koanf:"complexity_threshold"`
-	ClassifierModelPath string                          `koanf:"classifier_model_path"`
+	DefaultStrategy         string                           `koanf:"default_strategy"`
+	DefaultProvider         string                           `koanf:"default_provider"`
+	ComplexityThreshold     float64                          `koanf:"complexity_threshold"`
+	ClassifierModelPath     string                           `koanf:"classifier_model_path"`
+	ClassifierBackend       string                           `koanf:"classifier_backend"`
+	ClassifierWatchInterval time.Duration                    `koanf:"classifier_watch_interval"`
+	Providers               map[string]RoutingProviderConfig `koanf:"providers"`
+	QualityScores           map[string]float64               `koanf:"quality_scores"`
+	EstimatedOutputTokens   int                              `koanf:"estimated_output_tokens"`
+	Experiments             []ExperimentConfig               `koanf:"experiments"`
+	Rules                   []RoutingRule                    `koanf:"rules"`
+	Budget                  BudgetConfig                     `koanf:"budget"`
+	Context                 ContextConfig                    `koanf:"context"`
+}
+
+// BudgetConfig caps what one request may cost, in USD. A request's cap is
+// its X-Max-Cost header, else its API key's entry in Keys, else
+// DefaultMaxCost; zero means no cap. The router checks a pre-flight
+// estimate against the cap and downgrades to a cheaper model that fits,
+// or rejects the request if none does.
+type BudgetConfig struct {
+	DefaultMaxCost float64     `koanf:"default_max_cost"`
+	Keys           []KeyBudget `koanf:"keys"`
+}
+
+// ContextConfig bounds requests by their model's context window. Windows
+// maps model names to their context window in tokens; a request's size is
+// its estimated prompt tokens plus max_tokens, and models without an entry
+// take any size. An "auto" request too large for the routed model moves
+// to a larger-context model when one is available. Anything still too
+// large is cut down by Truncation — "drop_oldest" drops the oldest turns,
+// keeping system messages and the latest message — or, with "none" (the
+// default), rejected with a 400.
+type ContextConfig struct {
+	Windows    map[string]int `koanf:"windows"`
+	Truncation string         `koanf:"truncation"`
+}
+
+// KeyBudget is the default cap for requests made with one API key.
+type KeyBudget struct {
+	APIKey  string  `koanf:"api_key"`
+	MaxCost float64 `koanf:"max_cost"`
+}
+
+// RoutingRule overrides routing for matching "auto" requests before the
+// classifier runs. Rules are tried in order and the first match wins.
+//
+// Every condition that's set must hold: PromptRegex matches any message's
+// content; MinMessages and MinTokens (estimated at ~4 characters per
+// token) are lower bounds; APIKeys and Tenants list accepted callers (the
+// bearer token or x-api-key, and the X-Tenant header); Headers requires
+// each header to have exactly the given value. A rule with no conditions
+// matches everything.
+//
+// The action is Model (route straight to it), or Provider and/or Tier:
+// Provider replaces X-Provider, and Tier picks a 1-based tier of that
+// provider counting from the cheapest, or from the top if negative (-1 is
+// the top tier). Provider alone still runs the normal strategy.
+type RoutingRule struct {
+	Name        string            `koanf:"name"`
+	PromptRegex string            `koanf:"prompt_regex"`
+	MinMessages int               `koanf:"min_messages"`
+	MinTokens   int               `koanf:"min_tokens"`
+	APIKeys     []string          `koanf:"api_keys"`
+	Tenants     []string          `koanf:"tenants"`
+	Headers     map[string]string `koanf:"headers"`
+	Model       string            `koanf:"model"`
+	Provider    string            `koanf:"provider"`
+	Tier        int               `koanf:"tier"`
+}
+
+// ExperimentConfig defines a routing experiment: Percent of "auto" traffic
+// (0–100) is routed by a treatment arm — this RoutingConfig with the
+// experiment's non-empty fields layered on top — instead of the base config.
+// Another Percent (as much as fits under 100) is routed by the base config
+// as the experiment's control arm; the rest of the traffic is left to
+// later experiments.
+//
+// StickyBy picks what assignment hashes: "api_key" (default; the client's
+// bearer token or x-api-key, so a client sees consistent behavior) or
+// "request" (the request body, so identical requests land in the same arm).
+// Requests without an API key fall back to "request".
+//
+// With Shadow, assigned requests are still served by the control arm; the
+// treatment arm's choice gets an asynchronous copy of the request whose
+// response is discarded. Model forces the treatment arm to one model,
+// bypassing its strategy.
+type ExperimentConfig struct {
+	Name                string                           `koanf:"name"`
+	Percent             float64                          `koanf:"percent"`
+	StickyBy            string                           `koanf:"sticky_by"`
+	Shadow              bool                             `koanf:"shadow"`
+	DefaultStrategy     string                           `koanf:"default_strategy"`
+	ComplexityThreshold float64                          `koanf:"complexity_threshold"`
 	Providers           map[string]RoutingProviderConfig `koanf:"providers"`
+	Model               string                           `koanf:"model"`
 }
 
-// RoutingProviderConfig defines the cheap and quality model for a provider.
+// RoutingProviderConfig defines the models "auto" routing can pick for a
+// provider. Tiers is an ordered list from cheapest to most capable, each
+// owning the complexity scores from its MinScore up to the next tier's. If
+// Tiers is empty, CheapModel and QualityModel form a two-tier list split at
+// RoutingConfig.ComplexityThreshold.
 type RoutingProviderConfig struct {
-	CheapModel   string `koanf:"cheap_model"`
-	QualityModel string `koanf:"quality_model"`
+	CheapModel   string        `koanf:"cheap_model"`
+	QualityModel string        `koanf:"quality_model"`
+	Tiers        []RoutingTier `koanf:"tiers"`
+}
+
+// RoutingTier is one model in a provider's tier list. MinScore is the
+// lowest complexity score routed to it; the first tier also takes anything
+// below its MinScore.
+type RoutingTier struct {
+	Model    string  `koanf:"model"`
+	MinScore float64 `koanf:"min_score"`
 }
 
-// EmbeddingConfig holds paths and settings for the ONNX embedding model.
+// EmbeddingConfig holds paths and settings for the embedding model.
+// ModelName is the public name reported by POST /v1/embeddings; requests
+// naming any other model are rejected.
+//
+// Backend picks the implementation: "onnx" (default, in-process), "openai"
+// (any OpenAI-compatible /embeddings endpoint), or "gemini". Remote
+// backends send ModelName upstream and use BaseURL and APIKey; ModelPath,
+// TokenizerPath, LibraryPath, and LongPromptMode apply only to onnx.
+// Dimension must match what the backend returns.
+//
+// MaxBatchSize and BatchWindow control the micro-batcher that coalesces
+// concurrent Embed calls into one inference. MaxBatchSize <= 1 disables it.
+//
+// LongPromptMode is how prompts longer than the model's token window are
+// embedded: "truncate" (default), "head_tail", or "chunk".
+//
+// CacheSize is how many prompt embeddings the in-process LRU keeps; exact
+// repeats are served from it without running the model. 0 disables it.
 type EmbeddingConfig struct {
-	ModelPath     string `koanf:"model_path"`
-	TokenizerPath string `koanf:"tokenizer_path"`
-	LibraryPath   string `koanf:"library_path"`
-	Dimension     int    `koanf:"dimension"`
+	Backend        string        `koanf:"backend"`
+	BaseURL        string        `koanf:"base_url"`
+	APIKey         string        `koanf:"api_key"`
+	ModelName      string        `koanf:"model_name"`
+	ModelPath      string        `koanf:"model_path"`
+	TokenizerPath  string        `koanf:"tokenizer_path"`
+	LibraryPath    string        `koanf:"library_path"`
+	Dimension      int           `koanf:"dimension"`
+	MaxBatchSize   int           `koanf:"max_batch_size"`
+	BatchWindow    time.Duration `koanf:"batch_window"`
+	CacheSize      int           `koanf:"cache_size"`
+	LongPromptMode string        `koanf:"long_prompt_mode"`
 }
 
 // ServerConfig holds HTTP server settings.
+//
+// On SIGTERM the server reports not-ready on /health for DrainDelay, so
+// load balancers stop routing to it, then stops accepting connections and
+// gives in-flight requests (and the cache writes they leave running) up to
+// ShutdownTimeout to finish before the rest is torn down.
 type ServerConfig struct {
-	Port         int           `koanf:"port"`
-	ReadTimeout  time.Duration `koanf:"read_timeout"`
-	WriteTimeout time.Duration `koanf:"write_timeout"`
+	Port            int           `koanf:"port"`
+	ReadTimeout     time.Duration `koanf:"read_timeout"`
+	WriteTimeout    time.Duration `koanf:"write_timeout"`
+	DrainDelay      time.Duration `koanf:"drain_delay"`
+	ShutdownTimeout time.Duration `koanf:"shutdown_timeout"`
+
+	// AdminToken guards the admin endpoints (/classifier/reload and
+	// /classifier/rollback), which must send it as a bearer token. Empty
+	// disables them.
+	AdminToken string `koanf:"admin_token"`
 }
 
 // ProviderConfig holds the settings for a single LLM provider.
@@ -111,12 +267,23 @@ func Load(path string) (*Config, error) {
 	// koanf doesn't do this automatically, so we handle it ourselves
 	// using os.Getenv to look up the actual environment variable value.
 	for name, p := range cfg.Providers {
-		if strings.HasPrefix(p.APIKey, "${") && strings.HasSuffix(p.APIKey, "}") {
-			envVar := p.APIKey[2 : len(p.APIKey)-1] // strip ${ and }
-			p.APIKey = os.Getenv(envVar)
-			cfg.Providers[name] = p // write back into the map
-		}
+		p.APIKey = expandEnv(p.APIKey)
+		cfg.Providers[name] = p // write back into the map
+	}
+	cfg.Embedding.APIKey = expandEnv(cfg.Embedding.APIKey)
+	cfg.Server.AdminToken = expandEnv(cfg.Server.AdminToken)
+	for i := range cfg.Routing.Budget.Keys {
+		cfg.Routing.Budget.Keys[i].APIKey = expandEnv(cfg.Routing.Budget.Keys[i].APIKey)
 	}
 
 	return &cfg, nil
 }
+
+// expandEnv replaces a whole-value ${VAR_NAME} placeholder with the
+// environment variable's value. Anything else is returned unchanged.
+func expandEnv(value string) string {
+	if strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}") {
+		return os.Getenv(value[2 : len(value)-1]) // strip ${ and }
+	}
+	return value
+}
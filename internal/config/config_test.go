@@ -21,6 +21,8 @@ server:
   port: 9090
   read_timeout: 10s
   write_timeout: 60s
+  drain_delay: 2s
+  shutdown_timeout: 45s
 
 providers:
   google:
@@ -47,6 +49,8 @@ providers:
 	assert.Equal(t, 9090, cfg.Server.Port)
 	assert.Equal(t, 10*time.Second, cfg.Server.ReadTimeout)
 	assert.Equal(t, 60*time.Second, cfg.Server.WriteTimeout)
+	assert.Equal(t, 2*time.Second, cfg.Server.DrainDelay)
+	assert.Equal(t, 45*time.Second, cfg.Server.ShutdownTimeout)
 
 	// Assert provider config values.
 	google, ok := cfg.Providers["google"]
@@ -78,3 +82,28 @@ server:
 
 	assert.Equal(t, 3000, cfg.Server.Port)
 }
+
+func TestLoadEmbeddingBackend(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	yamlContent := `
+embedding:
+  backend: openai
+  base_url: https://api.openai.com/v1
+  api_key: ${TEST_EMBEDDING_KEY}
+  model_name: text-embedding-3-small
+  dimension: 1536
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(yamlContent), 0644))
+	t.Setenv("TEST_EMBEDDING_KEY", "sk-embed")
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, "openai", cfg.Embedding.Backend)
+	assert.Equal(t, "https://api.openai.com/v1", cfg.Embedding.BaseURL)
+	assert.Equal(t, "sk-embed", cfg.Embedding.APIKey)
+	assert.Equal(t, "text-embedding-3-small", cfg.Embedding.ModelName)
+	assert.Equal(t, 1536, cfg.Embedding.Dimension)
+}
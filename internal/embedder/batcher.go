@@ -0,0 +1,159 @@
+package embedder
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBatcherClosed is returned by Batcher.Embed after Close has been called.
+var ErrBatcherClosed = errors.New("embedder: batcher is closed")
+
+// Batcher coalesces concurrent Embed calls into batched EmbedBatch calls on
+// the wrapped Embedder. Under concurrent load every request would otherwise
+// pay for its own ONNX session run; with the batcher, requests that arrive
+// within a short window share one [B, seqLen] inference.
+//
+// The first request to arrive opens a batch and starts the window timer.
+// The batch is flushed when the window expires or it reaches maxBatch,
+// whichever comes first. Inference runs on the dispatcher goroutine, so
+// requests arriving during a flush queue up and form the next batch —
+// batches naturally grow as load increases.
+//
+// Batcher satisfies Embedder, so it drops in wherever an *ONNXEmbedder
+// would be used.
+type Batcher struct {
+	next     Embedder
+	maxBatch int
+	window   time.Duration
+
+	requests  chan embedRequest
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// embedRequest is one pending Embed call. The dispatcher answers on result
+// (buffered, so it never blocks on a caller that has given up).
+type embedRequest struct {
+	text   string
+	result chan embedResult
+}
+
+type embedResult struct {
+	vec []float32
+	err error
+}
+
+// NewBatcher wraps next with a micro-batching queue and starts the
+// dispatcher goroutine. maxBatch caps the batch dimension of each
+// inference; window is how long the first request in a batch waits for
+// company. Call Close to stop the dispatcher — it does not close next.
+func NewBatcher(next Embedder, maxBatch int, window time.Duration) *Batcher {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	b := &Batcher{
+		next:     next,
+		maxBatch: maxBatch,
+		window:   window,
+		requests: make(chan embedRequest),
+		done:     make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.run()
+	return b
+}
+
+// Embed queues text for the next batch and blocks until its vector is ready.
+func (b *Batcher) Embed(text string) ([]float32, error) {
+	req := embedRequest{text: text, result: make(chan embedResult, 1)}
+
+	select {
+	case b.requests <- req:
+	case <-b.done:
+		return nil, ErrBatcherClosed
+	}
+
+	res := <-req.result
+	return res.vec, res.err
+}
+
+// EmbedBatch passes straight through to the wrapped Embedder — the caller
+// has already formed a batch, so there's nothing to coalesce.
+func (b *Batcher) EmbedBatch(texts []string) ([][]float32, error) {
+	return b.next.EmbedBatch(texts)
+}
+
+// Truncated forwards to the wrapped Embedder if it's a TruncationReporter.
+func (b *Batcher) Truncated(text string) bool {
+	return truncated(b.next, text)
+}
+
+// Probe embeds a fixed text through the wrapped Embedder directly, without
+// waiting out a batching window.
+func (b *Batcher) Probe() ([]float32, error) {
+	return probe(b.next)
+}
+
+// Close stops the dispatcher and waits for any in-progress batch to
+// finish. Embed calls made after Close return ErrBatcherClosed. Safe to
+// call more than once.
+func (b *Batcher) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	b.wg.Wait()
+	return nil
+}
+
+// run is the dispatcher loop: wait for a first request, collect more until
+// the window closes or the batch is full, then flush.
+func (b *Batcher) run() {
+	defer b.wg.Done()
+
+	for {
+		var first embedRequest
+		select {
+		case first = <-b.requests:
+		case <-b.done:
+			return
+		}
+
+		batch := []embedRequest{first}
+		timer := time.NewTimer(b.window)
+
+	collect:
+		for len(batch) < b.maxBatch {
+			select {
+			case req := <-b.requests:
+				batch = append(batch, req)
+			case <-timer.C:
+				break collect
+			case <-b.done:
+				// Still flush what we have — those callers are already
+				// committed and waiting on their result channels.
+				break collect
+			}
+		}
+		timer.Stop()
+
+		b.flush(batch)
+	}
+}
+
+// flush runs one EmbedBatch call for the whole batch and fans the results
+// back out. An error fails every request in the batch.
+func (b *Batcher) flush(batch []embedRequest) {
+	texts := make([]string, len(batch))
+	for i, req := range batch {
+		texts[i] = req.text
+	}
+
+	vecs, err := b.next.EmbedBatch(texts)
+	for i, req := range batch {
+		if err != nil {
+			req.result <- embedResult{err: err}
+			continue
+		}
+		req.result <- embedResult{vec: vecs[i]}
+	}
+}
@@ -0,0 +1,126 @@
+package embedder
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEmbedder is an Embedder that returns a one-element vector encoding
+// the input's length, and records the size of every EmbedBatch call.
+type fakeEmbedder struct {
+	mu      sync.Mutex
+	batches []int
+	err     error
+}
+
+func (f *fakeEmbedder) Embed(text string) ([]float32, error) {
+	vecs, err := f.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+func (f *fakeEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	f.mu.Lock()
+	f.batches = append(f.batches, len(texts))
+	f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		out[i] = []float32{float32(len(t))}
+	}
+	return out, nil
+}
+
+func TestBatcher_CoalescesConcurrentCalls(t *testing.T) {
+	fake := &fakeEmbedder{}
+	// A generous window so all goroutines land in the same batch even on
+	// a slow CI machine.
+	b := NewBatcher(fake, 8, 200*time.Millisecond)
+	t.Cleanup(func() { b.Close() })
+
+	var wg sync.WaitGroup
+	results := make([][]float32, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			vec, err := b.Embed(fmt.Sprintf("%*s", i+1, "x"))
+			if err != nil {
+				t.Errorf("Embed(%d) error: %v", i, err)
+				return
+			}
+			results[i] = vec
+		}(i)
+	}
+	wg.Wait()
+
+	// Hitting maxBatch flushes immediately, so 8 callers → one batch of 8.
+	if len(fake.batches) != 1 || fake.batches[0] != 8 {
+		t.Errorf("batches = %v, want [8]", fake.batches)
+	}
+
+	// Each caller gets the vector for its own input, not a neighbor's.
+	for i, vec := range results {
+		if len(vec) != 1 || vec[0] != float32(i+1) {
+			t.Errorf("caller %d got %v, want [%d]", i, vec, i+1)
+		}
+	}
+}
+
+func TestBatcher_WindowFlushesPartialBatch(t *testing.T) {
+	fake := &fakeEmbedder{}
+	b := NewBatcher(fake, 32, time.Millisecond)
+	t.Cleanup(func() { b.Close() })
+
+	// A lone request must not wait for the batch to fill.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := b.Embed("solo"); err != nil {
+			t.Errorf("Embed error: %v", err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Embed did not return after the batch window expired")
+	}
+
+	if len(fake.batches) != 1 || fake.batches[0] != 1 {
+		t.Errorf("batches = %v, want [1]", fake.batches)
+	}
+}
+
+func TestBatcher_ErrorFailsWholeBatch(t *testing.T) {
+	fake := &fakeEmbedder{err: errors.New("inference failed")}
+	b := NewBatcher(fake, 4, time.Millisecond)
+	t.Cleanup(func() { b.Close() })
+
+	if _, err := b.Embed("x"); err == nil || err.Error() != "inference failed" {
+		t.Errorf("Embed error = %v, want %q", err, "inference failed")
+	}
+}
+
+func TestBatcher_EmbedAfterClose(t *testing.T) {
+	b := NewBatcher(&fakeEmbedder{}, 4, time.Millisecond)
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	if _, err := b.Embed("x"); !errors.Is(err, ErrBatcherClosed) {
+		t.Errorf("Embed after Close error = %v, want ErrBatcherClosed", err)
+	}
+
+	// Close is idempotent.
+	if err := b.Close(); err != nil {
+		t.Errorf("second Close error: %v", err)
+	}
+}
@@ -1,146 +1,223 @@
-// Package embedder wraps the ONNX embedding model for prompt vectorization.
+// Package embedder computes prompt embeddings — in-process with the ONNX
+// model by default, or through a remote OpenAI-compatible or Gemini
+// embeddings endpoint.
+//
+// The ONNX backend needs CGo (ONNX Runtime and the Rust tokenizers). Build
+// with -tags noonnx for a CGo-free binary that supports only the remote
+// backends.
 package embedder
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-
-	"github.com/daulet/tokenizers"
-	ort "github.com/yalue/onnxruntime_go"
+	"io"
+	"math"
+	"os"
 )
 
 // Embedder is the interface for computing text embeddings. Consumers depend
 // on this interface so they can swap implementations (e.g., mock in tests).
 type Embedder interface {
 	Embed(text string) ([]float32, error)
+	EmbedBatch(texts []string) ([][]float32, error)
 }
 
-// ONNXEmbedder tokenizes text and runs it through an ONNX embedding model to
-// produce a fixed-size vector. Used for semantic cache lookups and complexity
-// classification. Satisfies the Embedder interface.
-type ONNXEmbedder struct {
-	tokenizer *tokenizers.Tokenizer
-	session   *ort.DynamicAdvancedSession
-	dimension int
+// TruncationReporter is implemented by embedders that can tell whether a
+// prompt's embedding covers all of its text. The handler uses it to flag
+// lossy embeddings to the cache (cache.WithTruncatedPrompt).
+type TruncationReporter interface {
+	Truncated(text string) bool
 }
 
-// New creates an Embedder by loading the tokenizer and ONNX model. The
-// libraryPath must point to the ONNX Runtime shared library
-// (libonnxruntime.dylib on macOS, libonnxruntime.so on Linux).
-func New(modelPath, tokenizerPath, libraryPath string, dimension int) (*ONNXEmbedder, error) {
-	// Tell the Go wrapper where to find the ONNX Runtime C++ library.
-	// This must happen before InitializeEnvironment.
-	ort.SetSharedLibraryPath(libraryPath)
+// Prober is implemented by wrappers that put something between callers and
+// the model — a cache, a batching window. Probe embeds a fixed text straight
+// through the model, so a readiness check exercises the model itself.
+type Prober interface {
+	Probe() ([]float32, error)
+}
 
-	// Load the C++ runtime into the process. This is a global, one-time
-	// operation — calling it twice returns an error.
-	if err := ort.InitializeEnvironment(); err != nil {
-		return nil, fmt.Errorf("initializing ONNX environment: %w", err)
-	}
+// probeText is the text Probe embeds.
+const probeText = "llmrouter readiness check"
+
+// LongPromptMode selects what EmbedBatch does with prompts longer than the
+// model's token window (128 tokens for all-MiniLM-L6-v2).
+type LongPromptMode string
+
+const (
+	// LongPromptTruncate keeps the first window of tokens and drops the
+	// rest — the model's native behavior, and the default. Two long
+	// prompts that share a preamble get identical embeddings.
+	LongPromptTruncate LongPromptMode = "truncate"
+
+	// LongPromptHeadTail keeps the first and last half-windows and drops
+	// the middle. One inference row per prompt, and prompts that differ
+	// only at the end no longer collide.
+	LongPromptHeadTail LongPromptMode = "head_tail"
+
+	// LongPromptChunk splits the prompt into consecutive windows, embeds
+	// each as its own row, and mean-pools the results (weighted by token
+	// count) into one normalized vector. Costs one row per window, capped
+	// at maxChunks.
+	LongPromptChunk LongPromptMode = "chunk"
+)
 
-	// Load the HuggingFace tokenizer from its JSON config. This uses the
-	// Rust tokenizers crate via CGo — same tokenization as Python, but
-	// compiled natively.
-	tk, err := tokenizers.FromFile(tokenizerPath)
+// defaultWindow is the token window used when tokenizer.json doesn't
+// declare a truncation max_length.
+const defaultWindow = 128
+
+// maxChunks caps the rows LongPromptChunk spends on one prompt (~1,000
+// tokens at a 128 window). Beyond that, the middle chunks are dropped and
+// the final chunk is always kept — the end of a prompt is where otherwise
+// similar prompts tend to differ.
+const maxChunks = 8
+
+// ONNXFingerprint identifies the vector space of an ONNX embedding model:
+// a hash of the model file's bytes, the output dimension, and the
+// long-prompt mode, which decides what a long prompt's vector is made of.
+// Vectors from embedders with different fingerprints aren't comparable, so
+// the cache namespaces its entries by it. The default mode ("" or
+// truncate) hashes as it did before modes existed, keeping caches written
+// then. Hashing a ~90MB model takes a fraction of a second, once at
+// startup.
+func ONNXFingerprint(modelPath string, dimension int, mode LongPromptMode) (string, error) {
+	f, err := os.Open(modelPath)
 	if err != nil {
-		return nil, fmt.Errorf("loading tokenizer from %s: %w", tokenizerPath, err)
-	}
-
-	// Create the ONNX inference session. We use DynamicAdvancedSession
-	// because we want to supply tensors at run time rather than at session
-	// creation.
-	//
-	// This ONNX model has two outputs:
-	//   - token_embeddings: [1, seqLen, dim] per-token hidden states
-	//   - sentence_embedding: [1, dim] mean-pooled + L2-normalized vector
-	//
-	// We request only sentence_embedding — the model's built-in pooling
-	// layer handles mean pooling and normalization, matching the output of
-	// Python sentence-transformers exactly.
-	session, err := ort.NewDynamicAdvancedSession(
-		modelPath,
-		[]string{"input_ids", "attention_mask"},
-		[]string{"sentence_embedding"},
-		nil,
-	)
-	if err != nil {
-		tk.Close()
-		return nil, fmt.Errorf("creating ONNX session from %s: %w", modelPath, err)
+		return "", fmt.Errorf("opening embedding model: %w", err)
 	}
+	defer f.Close()
 
-	return &ONNXEmbedder{
-		tokenizer: tk,
-		session:   session,
-		dimension: dimension,
-	}, nil
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing embedding model: %w", err)
+	}
+	fmt.Fprintf(h, "\x00dim=%d", dimension)
+	if mode != "" && mode != LongPromptTruncate {
+		fmt.Fprintf(h, "\x00long_prompt_mode=%s", mode)
+	}
+	return "onnx-" + hex.EncodeToString(h.Sum(nil))[:16], nil
 }
 
-// Embed converts text into a fixed-size vector by tokenizing, running ONNX
-// inference, and returning the model's sentence embedding output (mean-pooled
-// + L2-normalized).
-func (e *ONNXEmbedder) Embed(text string) ([]float32, error) {
-	// Step 1: Tokenize with attention mask. The tokenizer.json has padding
-	// (to 128) and truncation built in, so Encode returns exactly
-	// maxSeqLen tokens. We use EncodeWithOptions to also get the attention
-	// mask, which is 1 for real tokens and 0 for padding — the model
-	// needs this to ignore pad positions during pooling.
-	enc := e.tokenizer.EncodeWithOptions(text, true,
-		tokenizers.WithReturnAttentionMask(),
-	)
-	if len(enc.IDs) == 0 {
-		return nil, fmt.Errorf("tokenizer produced no tokens for input")
-	}
-
-	// Step 2: Convert from uint32 → int64. The tokenizer returns uint32
-	// but ONNX models expect int64 tensors.
-	seqLen := len(enc.IDs)
-	inputIDs := make([]int64, seqLen)
-	attentionMask := make([]int64, seqLen)
-	for i := 0; i < seqLen; i++ {
-		inputIDs[i] = int64(enc.IDs[i])
-		attentionMask[i] = int64(enc.AttentionMask[i])
-	}
-
-	// Step 3: Create ONNX input tensors. Shape [1, seqLen] means batch
-	// size 1. The tokenizer has already padded/truncated to 128.
-	inputIDsTensor, err := ort.NewTensor(ort.Shape{1, int64(seqLen)}, inputIDs)
-	if err != nil {
-		return nil, fmt.Errorf("creating input_ids tensor: %w", err)
+// RemoteFingerprint is ONNXFingerprint for remote backends, where there's
+// no file to hash: the backend, upstream model name, and dimension.
+func RemoteFingerprint(backend, model string, dimension int) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%s\x00dim=%d", backend, model, dimension))
+	return backend + "-" + hex.EncodeToString(sum[:])[:16]
+}
+
+// untruncatedTokenizer rewrites a tokenizer.json with its truncation and
+// padding sections nulled out, and returns the truncation max_length as
+// the window (defaultWindow if none is declared).
+func untruncatedTokenizer(raw []byte) ([]byte, int, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, 0, err
 	}
-	defer inputIDsTensor.Destroy()
 
-	attentionMaskTensor, err := ort.NewTensor(ort.Shape{1, int64(seqLen)}, attentionMask)
-	if err != nil {
-		return nil, fmt.Errorf("creating attention_mask tensor: %w", err)
+	window := defaultWindow
+	var trunc struct {
+		MaxLength int `json:"max_length"`
+	}
+	if t, ok := doc["truncation"]; ok && json.Unmarshal(t, &trunc) == nil && trunc.MaxLength > 0 {
+		window = trunc.MaxLength
 	}
-	defer attentionMaskTensor.Destroy()
 
-	// Step 4: Create the output tensor. Shape [1, dimension] — the model's
-	// sentence_embedding output is already mean-pooled and normalized.
-	outputTensor, err := ort.NewEmptyTensor[float32](ort.Shape{1, int64(e.dimension)})
+	doc["truncation"] = json.RawMessage("null")
+	doc["padding"] = json.RawMessage("null")
+	out, err := json.Marshal(doc)
 	if err != nil {
-		return nil, fmt.Errorf("creating output tensor: %w", err)
+		return nil, 0, err
 	}
-	defer outputTensor.Destroy()
+	return out, window, nil
+}
 
-	// Step 5: Run inference.
-	err = e.session.Run(
-		[]ort.Value{inputIDsTensor, attentionMaskTensor},
-		[]ort.Value{outputTensor},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("running ONNX inference: %w", err)
+// windows cuts a full token sequence into the row(s) to run through the
+// model, each at most window tokens. special marks the tokens the
+// tokenizer added ([CLS], [SEP]); every row keeps the leading and trailing
+// ones so it looks like a normal single-sentence input.
+func windows(ids, special []uint32, window int, mode LongPromptMode) [][]uint32 {
+	if len(ids) <= window {
+		return [][]uint32{ids}
+	}
+
+	lead, trail := 0, len(ids)
+	for lead < trail && special[lead] == 1 {
+		lead++
+	}
+	for trail > lead && special[trail-1] == 1 {
+		trail--
+	}
+	prefix, body, suffix := ids[:lead], ids[lead:trail], ids[trail:]
+	budget := window - len(prefix) - len(suffix)
+	if budget <= 0 {
+		return [][]uint32{ids[:window]}
+	}
+
+	wrap := func(parts ...[]uint32) []uint32 {
+		row := make([]uint32, 0, window)
+		row = append(row, prefix...)
+		for _, p := range parts {
+			row = append(row, p...)
+		}
+		return append(row, suffix...)
 	}
 
-	// The output data is [1, dimension] — copy just the embedding.
-	data := outputTensor.GetData()
-	result := make([]float32, e.dimension)
-	copy(result, data[:e.dimension])
-	return result, nil
+	switch mode {
+	case LongPromptHeadTail:
+		head := (budget + 1) / 2
+		tail := budget - head
+		return [][]uint32{wrap(body[:head], body[len(body)-tail:])}
+
+	case LongPromptChunk:
+		var chunks [][]uint32
+		for start := 0; start < len(body); start += budget {
+			chunks = append(chunks, body[start:min(start+budget, len(body))])
+		}
+		if len(chunks) > maxChunks {
+			chunks = append(chunks[:maxChunks-1], chunks[len(chunks)-1])
+		}
+		rows := make([][]uint32, len(chunks))
+		for i, c := range chunks {
+			rows[i] = wrap(c)
+		}
+		return rows
+
+	default:
+		return [][]uint32{wrap(body[:budget])}
+	}
 }
 
-// Close releases the tokenizer, ONNX session, and ONNX Runtime environment.
-func (e *ONNXEmbedder) Close() error {
-	e.session.Destroy()
-	e.tokenizer.Close()
-	return ort.DestroyEnvironment()
+// truncated asks e whether text's embedding is lossy, for wrappers that
+// forward TruncationReporter. Embedders that can't tell report false.
+func truncated(e Embedder, text string) bool {
+	if tr, ok := e.(TruncationReporter); ok {
+		return tr.Truncated(text)
+	}
+	return false
+}
+
+// probe embeds probeText through e's model, for wrappers that forward
+// Prober. Anything that isn't a wrapper embeds it directly.
+func probe(e Embedder) ([]float32, error) {
+	if p, ok := e.(Prober); ok {
+		return p.Probe()
+	}
+	return e.Embed(probeText)
+}
+
+// normalize scales vec to unit L2 length in place, so dot product equals
+// cosine similarity just like the model's own output.
+func normalize(vec []float32) {
+	var sum float64
+	for _, v := range vec {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return
+	}
+	inv := float32(1 / math.Sqrt(sum))
+	for i := range vec {
+		vec[i] *= inv
+	}
 }
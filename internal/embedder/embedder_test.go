@@ -1,121 +1,177 @@
 package embedder
 
 import (
-	"math"
+	"os"
 	"path/filepath"
-	"runtime"
+	"strings"
 	"testing"
 )
 
-// projectRoot returns the absolute path to the repo root by walking up from
-// this test file's location.
-func projectRoot(t *testing.T) string {
-	t.Helper()
-	// This file lives at internal/embedder/embedder_test.go, so the repo
-	// root is two directories up.
-	_, thisFile, _, ok := runtime.Caller(0)
-	if !ok {
-		t.Fatal("could not determine test file path")
-	}
-	return filepath.Join(filepath.Dir(thisFile), "..", "..")
+// seq builds a fake encoding of n content tokens (IDs 1..n) wrapped in
+// [CLS]=101 and [SEP]=102, with the matching special tokens mask.
+func seq(n int) (ids, special []uint32) {
+	ids = append(ids, 101)
+	special = append(special, 1)
+	for i := 1; i <= n; i++ {
+		ids = append(ids, uint32(i))
+		special = append(special, 0)
+	}
+	return append(ids, 102), append(special, 1)
 }
 
-func setupEmbedder(t *testing.T) *ONNXEmbedder {
-	t.Helper()
-	root := projectRoot(t)
-
-	emb, err := New(
-		filepath.Join(root, "models", "model.onnx"),
-		filepath.Join(root, "models", "tokenizer.json"),
-		filepath.Join(root, "lib", "libonnxruntime.dylib"),
-		384,
-	)
-	if err != nil {
-		t.Fatalf("failed to create embedder: %v", err)
+func TestWindows_ShortPromptUnchanged(t *testing.T) {
+	ids, special := seq(5)
+	for _, mode := range []LongPromptMode{LongPromptTruncate, LongPromptHeadTail, LongPromptChunk} {
+		rows := windows(ids, special, 10, mode)
+		if len(rows) != 1 || len(rows[0]) != len(ids) {
+			t.Errorf("%s: got %v, want the input unchanged", mode, rows)
+		}
 	}
-	t.Cleanup(func() { emb.Close() })
-	return emb
 }
 
-func TestEmbed_MatchesPythonReference(t *testing.T) {
-	emb := setupEmbedder(t)
-
-	got, err := emb.Embed("What is the weather today?")
-	if err != nil {
-		t.Fatalf("Embed() error: %v", err)
+func TestWindows_Truncate(t *testing.T) {
+	ids, special := seq(20)
+	rows := windows(ids, special, 10, LongPromptTruncate)
+	want := []uint32{101, 1, 2, 3, 4, 5, 6, 7, 8, 102}
+	if len(rows) != 1 || !equalIDs(rows[0], want) {
+		t.Errorf("got %v, want [%v]", rows, want)
 	}
+}
 
-	if len(got) != 384 {
-		t.Fatalf("expected 384-dim vector, got %d", len(got))
+func TestWindows_HeadTail(t *testing.T) {
+	ids, special := seq(20)
+	rows := windows(ids, special, 10, LongPromptHeadTail)
+	want := []uint32{101, 1, 2, 3, 4, 17, 18, 19, 20, 102}
+	if len(rows) != 1 || !equalIDs(rows[0], want) {
+		t.Errorf("got %v, want [%v]", rows, want)
 	}
+}
 
-	// Reference values from running the same ONNX model in Python with
-	// onnxruntime, using the sentence_embedding output.
-	expected := []float32{-0.03579939, 0.09944275, 0.07859868, 0.06265699, -0.01527093}
-	tolerance := float32(1e-4)
-
-	for i, want := range expected {
-		if diff := float32(math.Abs(float64(got[i] - want))); diff > tolerance {
-			t.Errorf("dimension %d: got %f, want %f (diff %f)", i, got[i], want, diff)
+func TestWindows_Chunk(t *testing.T) {
+	ids, special := seq(20)
+	rows := windows(ids, special, 10, LongPromptChunk)
+	want := [][]uint32{
+		{101, 1, 2, 3, 4, 5, 6, 7, 8, 102},
+		{101, 9, 10, 11, 12, 13, 14, 15, 16, 102},
+		{101, 17, 18, 19, 20, 102},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i := range want {
+		if !equalIDs(rows[i], want[i]) {
+			t.Errorf("row %d = %v, want %v", i, rows[i], want[i])
 		}
 	}
 }
 
-func TestEmbed_IdenticalInputsProduceIdenticalOutputs(t *testing.T) {
-	emb := setupEmbedder(t)
+func TestWindows_ChunkCapKeepsTail(t *testing.T) {
+	// 100 content tokens at 8 per window is 13 chunks; the cap keeps the
+	// first maxChunks-1 and the last.
+	ids, special := seq(100)
+	rows := windows(ids, special, 10, LongPromptChunk)
+	if len(rows) != maxChunks {
+		t.Fatalf("got %d rows, want %d", len(rows), maxChunks)
+	}
+	last := rows[len(rows)-1]
+	if last[len(last)-2] != 100 {
+		t.Errorf("last row = %v, want it to end with the final token", last)
+	}
+}
 
-	a, err := emb.Embed("Tell me about Go programming")
+func TestUntruncatedTokenizer(t *testing.T) {
+	raw := []byte(`{"version":"1.0","truncation":{"max_length":256,"strategy":"LongestFirst"},"padding":{"strategy":{"Fixed":256}},"model":{}}`)
+	out, window, err := untruncatedTokenizer(raw)
 	if err != nil {
-		t.Fatalf("first Embed() error: %v", err)
+		t.Fatalf("untruncatedTokenizer error: %v", err)
+	}
+	if window != 256 {
+		t.Errorf("window = %d, want 256", window)
+	}
+	if !strings.Contains(string(out), `"truncation":null`) || !strings.Contains(string(out), `"padding":null`) {
+		t.Errorf("truncation and padding not cleared: %s", out)
 	}
 
-	b, err := emb.Embed("Tell me about Go programming")
+	_, window, err = untruncatedTokenizer([]byte(`{"truncation":null}`))
 	if err != nil {
-		t.Fatalf("second Embed() error: %v", err)
+		t.Fatalf("untruncatedTokenizer error: %v", err)
 	}
+	if window != defaultWindow {
+		t.Errorf("window = %d, want default %d", window, defaultWindow)
+	}
+}
 
+func equalIDs(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
 	for i := range a {
 		if a[i] != b[i] {
-			t.Fatalf("dimension %d differs: %f vs %f", i, a[i], b[i])
+			return false
 		}
 	}
+	return true
 }
 
-func TestEmbed_DifferentInputsProduceDifferentOutputs(t *testing.T) {
-	emb := setupEmbedder(t)
-
-	a, err := emb.Embed("What is the weather today?")
-	if err != nil {
-		t.Fatalf("first Embed() error: %v", err)
+func TestONNXFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
 	}
-
-	b, err := emb.Embed("How do I cook pasta?")
-	if err != nil {
-		t.Fatalf("second Embed() error: %v", err)
+	a := write("a.onnx", "model weights A")
+	b := write("b.onnx", "model weights B")
+	aCopy := write("a-copy.onnx", "model weights A")
+
+	fpMode := func(path string, dim int, mode LongPromptMode) string {
+		t.Helper()
+		got, err := ONNXFingerprint(path, dim, mode)
+		if err != nil {
+			t.Fatalf("ONNXFingerprint(%s) error: %v", path, err)
+		}
+		return got
+	}
+	fp := func(path string, dim int) string {
+		t.Helper()
+		return fpMode(path, dim, "")
 	}
 
-	// At least some dimensions should differ meaningfully.
-	diffs := 0
-	for i := range a {
-		if math.Abs(float64(a[i]-b[i])) > 0.01 {
-			diffs++
-		}
+	if fp(a, 384) != fp(aCopy, 384) {
+		t.Error("same bytes at different paths should fingerprint the same")
+	}
+	if fp(a, 384) == fp(b, 384) {
+		t.Error("different model files should fingerprint differently")
+	}
+	if fp(a, 384) == fp(a, 768) {
+		t.Error("different dimensions should fingerprint differently")
+	}
+	if fp(a, 384) != fpMode(a, 384, LongPromptTruncate) {
+		t.Error("an unset long prompt mode is truncate")
 	}
-	if diffs == 0 {
-		t.Error("different inputs produced identical embeddings")
+	if fp(a, 384) == fpMode(a, 384, LongPromptHeadTail) || fp(a, 384) == fpMode(a, 384, LongPromptChunk) ||
+		fpMode(a, 384, LongPromptHeadTail) == fpMode(a, 384, LongPromptChunk) {
+		t.Error("different long prompt modes should fingerprint differently")
+	}
+	if _, err := ONNXFingerprint(filepath.Join(dir, "missing.onnx"), 384, ""); err == nil {
+		t.Error("expected an error for a missing model file")
 	}
 }
 
-func TestEmbed_EmptyInput(t *testing.T) {
-	emb := setupEmbedder(t)
-
-	// Even empty string should produce tokens ([CLS] + [SEP]) and a valid
-	// embedding, not an error.
-	got, err := emb.Embed("")
-	if err != nil {
-		t.Fatalf("Embed(\"\") error: %v", err)
+func TestRemoteFingerprint(t *testing.T) {
+	base := RemoteFingerprint("openai", "text-embedding-3-small", 1536)
+	if base != RemoteFingerprint("openai", "text-embedding-3-small", 1536) {
+		t.Error("fingerprint should be deterministic")
 	}
-	if len(got) != 384 {
-		t.Fatalf("expected 384-dim vector, got %d", len(got))
+	for _, other := range []string{
+		RemoteFingerprint("gemini", "text-embedding-3-small", 1536),
+		RemoteFingerprint("openai", "text-embedding-3-large", 1536),
+		RemoteFingerprint("openai", "text-embedding-3-small", 512),
+	} {
+		if other == base {
+			t.Errorf("fingerprint %s should differ from %s", other, base)
+		}
 	}
 }
@@ -0,0 +1,106 @@
+package embedder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// geminiMaxInputs is the most requests Gemini accepts in one
+// batchEmbedContents call.
+const geminiMaxInputs = 100
+
+// GeminiEmbedder embeds text through Gemini's batchEmbedContents endpoint.
+// Satisfies the Embedder interface.
+type GeminiEmbedder struct {
+	apiKey    string // sent as a query parameter, like GoogleProvider
+	baseURL   string // e.g. "https://generativelanguage.googleapis.com/v1beta"
+	model     string // e.g. "gemini-embedding-001"
+	dimension int
+	client    *http.Client
+}
+
+// NewGeminiEmbedder creates a GeminiEmbedder. dimension is sent as
+// outputDimensionality, so models that support truncated output (like
+// gemini-embedding-001) return vectors of exactly that size; responses of
+// any other size are rejected.
+func NewGeminiEmbedder(apiKey, baseURL, model string, dimension int, client *http.Client) *GeminiEmbedder {
+	return &GeminiEmbedder{
+		apiKey:    apiKey,
+		baseURL:   baseURL,
+		model:     model,
+		dimension: dimension,
+		client:    client,
+	}
+}
+
+type geminiBatchEmbedRequest struct {
+	Requests []geminiEmbedRequest `json:"requests"`
+}
+
+type geminiEmbedRequest struct {
+	Model                string             `json:"model"` // "models/{model}"
+	Content              geminiEmbedContent `json:"content"`
+	OutputDimensionality int                `json:"outputDimensionality,omitempty"`
+}
+
+type geminiEmbedContent struct {
+	Parts []geminiEmbedPart `json:"parts"`
+}
+
+type geminiEmbedPart struct {
+	Text string `json:"text"`
+}
+
+type geminiBatchEmbedResponse struct {
+	Embeddings []struct {
+		Values []float32 `json:"values"`
+	} `json:"embeddings"`
+}
+
+// Embed embeds a single text. It's a batch of one through EmbedBatch.
+func (g *GeminiEmbedder) Embed(text string) ([]float32, error) {
+	vecs, err := g.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+// EmbedBatch embeds texts in as few calls as the API allows. Results are
+// returned in input order.
+func (g *GeminiEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	url := fmt.Sprintf("%s/models/%s:batchEmbedContents?key=%s", g.baseURL, g.model, g.apiKey)
+
+	out := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += geminiMaxInputs {
+		part := texts[start:min(start+geminiMaxInputs, len(texts))]
+
+		body := geminiBatchEmbedRequest{Requests: make([]geminiEmbedRequest, len(part))}
+		for i, text := range part {
+			body.Requests[i] = geminiEmbedRequest{
+				Model:                "models/" + g.model,
+				Content:              geminiEmbedContent{Parts: []geminiEmbedPart{{Text: text}}},
+				OutputDimensionality: g.dimension,
+			}
+		}
+
+		var resp geminiBatchEmbedResponse
+		err := callRemote(len(part), func(ctx context.Context) error {
+			return postJSON(ctx, g.client, "google", url, nil, body, &resp)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		vecs := make([][]float32, len(resp.Embeddings))
+		for i, e := range resp.Embeddings {
+			vecs[i] = e.Values
+		}
+		if err := checkVectors("google", vecs, len(part), g.dimension); err != nil {
+			return nil, err
+		}
+		out = append(out, vecs...)
+	}
+	return out, nil
+}
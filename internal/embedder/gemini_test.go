@@ -0,0 +1,58 @@
+package embedder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGeminiEmbedder_EmbedBatch(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/models/gemini-embedding-001:batchEmbedContents" || r.URL.Query().Get("key") != "g-key" {
+			http.NotFound(w, r)
+			return
+		}
+		var req geminiBatchEmbedRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		type values struct {
+			Values []float32 `json:"values"`
+		}
+		var embs []values
+		for _, sub := range req.Requests {
+			if sub.Model != "models/gemini-embedding-001" || sub.OutputDimensionality != 3 {
+				http.Error(w, "bad sub-request", http.StatusBadRequest)
+				return
+			}
+			embs = append(embs, values{Values: []float32{0, float32(len(sub.Content.Parts[0].Text)), 0}})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"embeddings": embs})
+	}))
+	t.Cleanup(srv.Close)
+
+	e := NewGeminiEmbedder("g-key", srv.URL, "gemini-embedding-001", 3, srv.Client())
+
+	// 150 inputs is two calls at 100 per batch.
+	texts := make([]string, 150)
+	for i := range texts {
+		texts[i] = "hi"
+	}
+	vecs, err := e.EmbedBatch(texts)
+	if err != nil {
+		t.Fatalf("EmbedBatch error: %v", err)
+	}
+	if len(vecs) != 150 {
+		t.Fatalf("got %d vectors, want 150", len(vecs))
+	}
+	if calls != 2 {
+		t.Errorf("made %d calls, want 2", calls)
+	}
+	if vecs[149][1] != 1 {
+		t.Errorf("vecs[149] = %v, want unit vector [0 1 0]", vecs[149])
+	}
+}
@@ -0,0 +1,182 @@
+package embedder
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"strings"
+	"sync"
+
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+)
+
+// CachingEmbedder is an in-process LRU in front of another Embedder. Exact
+// repeats of a prompt are common (the benchmark corpus is full of them), and
+// re-tokenizing and re-running ONNX for text we embedded a moment ago is
+// pure waste.
+//
+// Entries are keyed by a SHA-256 of the normalized prompt text (see
+// normalizePrompt), so whitespace-only differences share an entry. The
+// stored vector is the one computed for the FIRST text seen with that key.
+// That's deliberate: it means every exact repeat gets a bit-identical
+// embedding, which the handler relies on for the exact-match cache fast
+// path (cache.LookupExact).
+//
+// Returned vectors are shared with the cache — callers must not modify them.
+type CachingEmbedder struct {
+	next     Embedder
+	capacity int
+
+	mu    sync.Mutex
+	ll    *list.List // front = most recently used
+	items map[[sha256.Size]byte]*list.Element
+}
+
+// lruEntry is the value stored in each list element.
+type lruEntry struct {
+	key [sha256.Size]byte
+	vec []float32
+}
+
+// NewCachingEmbedder wraps next with an LRU holding up to capacity prompt
+// embeddings. At 384 dims each entry is ~1.5KB, so 10,000 entries is ~15MB.
+func NewCachingEmbedder(next Embedder, capacity int) *CachingEmbedder {
+	return &CachingEmbedder{
+		next:     next,
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// normalizePrompt collapses runs of whitespace to a single space and trims
+// the ends. Case is preserved — for code and proper nouns it can change
+// what the right answer is.
+func normalizePrompt(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// promptKey is the LRU key for text.
+func promptKey(text string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(normalizePrompt(text)))
+}
+
+// Embed returns the embedding for text, from the LRU if possible.
+func (c *CachingEmbedder) Embed(text string) ([]float32, error) {
+	vec, _, err := c.EmbedCached(text)
+	return vec, err
+}
+
+// EmbedCached is Embed that also reports whether the vector came from the
+// LRU. hit=true means this exact (normalized) prompt was embedded before,
+// so the returned vector is bit-identical to the earlier one.
+func (c *CachingEmbedder) EmbedCached(text string) (vec []float32, hit bool, err error) {
+	key := promptKey(text)
+	if vec, ok := c.get(key); ok {
+		metrics.EmbeddingCacheLookups.WithLabelValues("hit").Inc()
+		return vec, true, nil
+	}
+	metrics.EmbeddingCacheLookups.WithLabelValues("miss").Inc()
+
+	vec, err = c.next.Embed(text)
+	if err != nil {
+		return nil, false, err
+	}
+	return c.add(key, vec), false, nil
+}
+
+// Peek is Embed without side effects on the LRU: a cached vector is
+// returned without counting a lookup or refreshing its recency, and a
+// missing one is embedded by the wrapped Embedder but not stored. For
+// dry runs (POST /v1/route/explain) that mustn't skew the cache.
+func (c *CachingEmbedder) Peek(text string) ([]float32, error) {
+	c.mu.Lock()
+	el, ok := c.items[promptKey(text)]
+	c.mu.Unlock()
+	if ok {
+		return el.Value.(*lruEntry).vec, nil
+	}
+	return c.next.Embed(text)
+}
+
+// EmbedBatch serves what it can from the LRU and embeds the rest in a
+// single EmbedBatch call on the wrapped Embedder.
+func (c *CachingEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	keys := make([][sha256.Size]byte, len(texts))
+
+	var missTexts []string
+	var missIdx []int
+	for i, text := range texts {
+		keys[i] = promptKey(text)
+		if vec, ok := c.get(keys[i]); ok {
+			metrics.EmbeddingCacheLookups.WithLabelValues("hit").Inc()
+			out[i] = vec
+			continue
+		}
+		metrics.EmbeddingCacheLookups.WithLabelValues("miss").Inc()
+		missTexts = append(missTexts, text)
+		missIdx = append(missIdx, i)
+	}
+
+	if len(missTexts) == 0 {
+		return out, nil
+	}
+
+	vecs, err := c.next.EmbedBatch(missTexts)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missIdx {
+		out[i] = c.add(keys[i], vecs[j])
+	}
+	return out, nil
+}
+
+// Truncated forwards to the wrapped Embedder if it's a TruncationReporter.
+func (c *CachingEmbedder) Truncated(text string) bool {
+	return truncated(c.next, text)
+}
+
+// Probe embeds a fixed text through the wrapped Embedder, skipping the LRU
+// — a cached vector would say nothing about the model.
+func (c *CachingEmbedder) Probe() ([]float32, error) {
+	return probe(c.next)
+}
+
+// get returns the cached vector for key and marks it most recently used.
+func (c *CachingEmbedder) get(key [sha256.Size]byte) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruEntry).vec, true
+}
+
+// add inserts vec under key, evicting the least recently used entry if
+// the LRU is full. If another goroutine stored the same key first, its
+// vector wins and is returned — keeping repeats bit-identical.
+func (c *CachingEmbedder) add(key [sha256.Size]byte, vec []float32) []float32 {
+	if c.capacity <= 0 {
+		return vec
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*lruEntry).vec
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, vec: vec})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+	return vec
+}
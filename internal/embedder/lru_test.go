@@ -0,0 +1,147 @@
+package embedder
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCachingEmbedder_ExactRepeatIsHit(t *testing.T) {
+	fake := &fakeEmbedder{}
+	c := NewCachingEmbedder(fake, 10)
+
+	first, hit, err := c.EmbedCached("hello   world")
+	if err != nil {
+		t.Fatalf("EmbedCached error: %v", err)
+	}
+	if hit {
+		t.Fatal("first call reported a hit")
+	}
+
+	// Whitespace-only differences normalize to the same key, and the
+	// repeat gets the vector computed for the first text.
+	second, hit, err := c.EmbedCached("  hello world\n")
+	if err != nil {
+		t.Fatalf("EmbedCached error: %v", err)
+	}
+	if !hit {
+		t.Fatal("repeat call reported a miss")
+	}
+	if &first[0] != &second[0] {
+		t.Error("repeat returned a different vector, want the cached one")
+	}
+	if len(fake.batches) != 1 {
+		t.Errorf("wrapped embedder called %d times, want 1", len(fake.batches))
+	}
+
+	// Case is significant.
+	if _, hit, _ := c.EmbedCached("Hello world"); hit {
+		t.Error("case-different prompt reported a hit")
+	}
+}
+
+func TestCachingEmbedder_EvictsLeastRecentlyUsed(t *testing.T) {
+	fake := &fakeEmbedder{}
+	c := NewCachingEmbedder(fake, 2)
+
+	c.Embed("a")
+	c.Embed("b")
+	c.Embed("a") // a is now most recently used
+	c.Embed("c") // evicts b
+
+	if _, hit, _ := c.EmbedCached("a"); !hit {
+		t.Error("a was evicted, want it kept")
+	}
+	if _, hit, _ := c.EmbedCached("b"); hit {
+		t.Error("b was kept, want it evicted")
+	}
+}
+
+func TestCachingEmbedder_BatchEmbedsOnlyMisses(t *testing.T) {
+	fake := &fakeEmbedder{}
+	c := NewCachingEmbedder(fake, 10)
+
+	c.Embed("x")
+
+	vecs, err := c.EmbedBatch([]string{"x", "yy", "zzz"})
+	if err != nil {
+		t.Fatalf("EmbedBatch error: %v", err)
+	}
+	for i, want := range []float32{1, 2, 3} {
+		if vecs[i][0] != want {
+			t.Errorf("vecs[%d] = %v, want [%v]", i, vecs[i], want)
+		}
+	}
+	if got := fake.batches[len(fake.batches)-1]; got != 2 {
+		t.Errorf("last batch size = %d, want 2 (only the misses)", got)
+	}
+}
+
+func TestCachingEmbedder_ZeroCapacityDisables(t *testing.T) {
+	fake := &fakeEmbedder{}
+	c := NewCachingEmbedder(fake, 0)
+
+	c.Embed("a")
+	if _, hit, _ := c.EmbedCached("a"); hit {
+		t.Error("zero-capacity cache reported a hit")
+	}
+}
+
+func TestCachingEmbedder_ProbeBypassesLRU(t *testing.T) {
+	fake := &fakeEmbedder{}
+	b := NewBatcher(fake, 8, time.Hour)
+	defer b.Close()
+	c := NewCachingEmbedder(b, 10)
+
+	// Through both wrappers, every probe reaches the model — without
+	// waiting out the hour-long batching window.
+	for range 2 {
+		vec, err := c.Probe()
+		if err != nil {
+			t.Fatalf("Probe error: %v", err)
+		}
+		if len(vec) != 1 {
+			t.Fatalf("Probe returned %d dims, want 1", len(vec))
+		}
+	}
+	if len(fake.batches) != 2 {
+		t.Errorf("wrapped embedder called %d times, want 2", len(fake.batches))
+	}
+
+	fake.err = errors.New("session gone")
+	if _, err := c.Probe(); err == nil {
+		t.Error("Probe succeeded with a failing model")
+	}
+}
+
+func TestCachingEmbedder_PeekLeavesLRUAlone(t *testing.T) {
+	fake := &fakeEmbedder{}
+	c := NewCachingEmbedder(fake, 2)
+
+	// A miss is embedded but not stored.
+	if _, err := c.Peek("a"); err != nil {
+		t.Fatalf("Peek error: %v", err)
+	}
+	if _, hit, _ := c.EmbedCached("a"); hit {
+		t.Error("Peek stored its vector")
+	}
+
+	// A hit is served from the LRU but doesn't refresh the entry: "a"
+	// is still the oldest, and the next prompt evicts it.
+	cached, _, _ := c.EmbedCached("a")
+	c.EmbedCached("b")
+	vec, err := c.Peek("a")
+	if err != nil {
+		t.Fatalf("Peek error: %v", err)
+	}
+	if &vec[0] != &cached[0] {
+		t.Error("Peek returned a different vector, want the cached one")
+	}
+	if len(fake.batches) != 3 {
+		t.Errorf("wrapped embedder called %d times, want 3", len(fake.batches))
+	}
+	c.EmbedCached("c")
+	if _, hit, _ := c.EmbedCached("a"); hit {
+		t.Error("Peek refreshed the entry")
+	}
+}
@@ -0,0 +1,262 @@
+//go:build !noonnx
+
+package embedder
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/daulet/tokenizers"
+	ort "github.com/yalue/onnxruntime_go"
+
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+)
+
+// ONNXEmbedder tokenizes text and runs it through an ONNX embedding model to
+// produce a fixed-size vector. Used for semantic cache lookups and complexity
+// classification. Satisfies the Embedder interface.
+type ONNXEmbedder struct {
+	tokenizer *tokenizers.Tokenizer
+	session   *ort.DynamicAdvancedSession
+	dimension int
+	window    int // max tokens per inference row, special tokens included
+	mode      LongPromptMode
+}
+
+// New creates an Embedder by loading the tokenizer and ONNX model. The
+// libraryPath must point to the ONNX Runtime shared library
+// (libonnxruntime.dylib on macOS, libonnxruntime.so on Linux). mode picks
+// the long-prompt handling; "" means LongPromptTruncate.
+func New(modelPath, tokenizerPath, libraryPath string, dimension int, mode LongPromptMode) (*ONNXEmbedder, error) {
+	switch mode {
+	case "":
+		mode = LongPromptTruncate
+	case LongPromptTruncate, LongPromptHeadTail, LongPromptChunk:
+	default:
+		return nil, fmt.Errorf("unknown long prompt mode %q (must be truncate, head_tail, or chunk)", mode)
+	}
+
+	// Read the tokenizer config ourselves so we can switch off its
+	// built-in truncation and padding. We need the full token sequence to
+	// detect truncation and to build head+tail or chunked windows; the
+	// window length is taken from the truncation settings we removed.
+	raw, err := os.ReadFile(tokenizerPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading tokenizer from %s: %w", tokenizerPath, err)
+	}
+	raw, window, err := untruncatedTokenizer(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing tokenizer from %s: %w", tokenizerPath, err)
+	}
+
+	// Tell the Go wrapper where to find the ONNX Runtime C++ library.
+	// This must happen before InitializeEnvironment.
+	ort.SetSharedLibraryPath(libraryPath)
+
+	// Load the C++ runtime into the process. This is a global, one-time
+	// operation — calling it twice returns an error.
+	if err := ort.InitializeEnvironment(); err != nil {
+		return nil, fmt.Errorf("initializing ONNX environment: %w", err)
+	}
+
+	// Load the HuggingFace tokenizer from its JSON config. This uses the
+	// Rust tokenizers crate via CGo — same tokenization as Python, but
+	// compiled natively.
+	tk, err := tokenizers.FromBytes(raw)
+	if err != nil {
+		return nil, fmt.Errorf("loading tokenizer from %s: %w", tokenizerPath, err)
+	}
+
+	// Create the ONNX inference session. We use DynamicAdvancedSession
+	// because we want to supply tensors at run time rather than at session
+	// creation.
+	//
+	// This ONNX model has two outputs:
+	//   - token_embeddings: [1, seqLen, dim] per-token hidden states
+	//   - sentence_embedding: [1, dim] mean-pooled + L2-normalized vector
+	//
+	// We request only sentence_embedding — the model's built-in pooling
+	// layer handles mean pooling and normalization, matching the output of
+	// Python sentence-transformers exactly.
+	session, err := ort.NewDynamicAdvancedSession(
+		modelPath,
+		[]string{"input_ids", "attention_mask"},
+		[]string{"sentence_embedding"},
+		nil,
+	)
+	if err != nil {
+		tk.Close()
+		return nil, fmt.Errorf("creating ONNX session from %s: %w", modelPath, err)
+	}
+
+	return &ONNXEmbedder{
+		tokenizer: tk,
+		session:   session,
+		dimension: dimension,
+		window:    window,
+		mode:      mode,
+	}, nil
+}
+
+// Embed converts text into a fixed-size vector by tokenizing, running ONNX
+// inference, and returning the model's sentence embedding output (mean-pooled
+// + L2-normalized). It's a batch of one through EmbedBatch.
+func (e *ONNXEmbedder) Embed(text string) ([]float32, error) {
+	vecs, err := e.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+// EmbedBatch embeds several texts in ONE ONNX inference call with batch
+// dimension len(texts), instead of one session run per text. The model's
+// cost is dominated by per-run overhead at our sequence length, so a batch
+// of 32 costs far less than 32 separate Embed calls. Results are returned
+// in input order.
+//
+// Prompts longer than the window are handled according to the long-prompt
+// mode. In chunk mode a prompt contributes several rows to the batch, which
+// are pooled back into one vector after inference.
+func (e *ONNXEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	// Step 1: Tokenize each text into one or more rows of token IDs. The
+	// tokenizer's own truncation and padding are off (see New), so rows
+	// come back at their natural length and we pad to the longest below.
+	// owner[r] is the input index row r belongs to; weight[r] is how many
+	// of the prompt's tokens the row carries, for chunk pooling.
+	var rows [][]uint32
+	var owner []int
+	var weight []float32
+	seqLen := 0
+	for i, text := range texts {
+		enc := e.encode(text)
+		if len(enc.IDs) == 0 {
+			return nil, fmt.Errorf("tokenizer produced no tokens for input %d", i)
+		}
+		if len(enc.IDs) > e.window {
+			metrics.EmbeddingTruncatedPrompts.WithLabelValues(string(e.mode)).Inc()
+		}
+		for _, row := range windows(enc.IDs, enc.SpecialTokensMask, e.window, e.mode) {
+			rows = append(rows, row)
+			owner = append(owner, i)
+			weight = append(weight, float32(len(row)))
+			seqLen = max(seqLen, len(row))
+		}
+	}
+
+	// Step 2: Flatten into row-major [batch, seqLen] int64 buffers. The
+	// tokenizer returns uint32 but ONNX models expect int64 tensors. The
+	// attention mask is 1 for real tokens and 0 for padding — the model
+	// needs this to ignore pad positions during pooling. Rows shorter than
+	// seqLen stay zero — pad token ID 0 with mask 0.
+	batch := len(rows)
+	inputIDs := make([]int64, batch*seqLen)
+	attentionMask := make([]int64, batch*seqLen)
+	for b, ids := range rows {
+		row := b * seqLen
+		for i, id := range ids {
+			inputIDs[row+i] = int64(id)
+			attentionMask[row+i] = 1
+		}
+	}
+
+	// Step 3: Create ONNX input tensors with shape [batch, seqLen].
+	shape := ort.Shape{int64(batch), int64(seqLen)}
+	inputIDsTensor, err := ort.NewTensor(shape, inputIDs)
+	if err != nil {
+		return nil, fmt.Errorf("creating input_ids tensor: %w", err)
+	}
+	defer inputIDsTensor.Destroy()
+
+	attentionMaskTensor, err := ort.NewTensor(shape, attentionMask)
+	if err != nil {
+		return nil, fmt.Errorf("creating attention_mask tensor: %w", err)
+	}
+	defer attentionMaskTensor.Destroy()
+
+	// Step 4: Create the output tensor. Shape [batch, dimension] — the
+	// model's sentence_embedding output is already mean-pooled and
+	// normalized per row.
+	outputTensor, err := ort.NewEmptyTensor[float32](ort.Shape{int64(batch), int64(e.dimension)})
+	if err != nil {
+		return nil, fmt.Errorf("creating output tensor: %w", err)
+	}
+	defer outputTensor.Destroy()
+
+	// Step 5: Run inference.
+	inferStart := time.Now()
+	err = e.session.Run(
+		[]ort.Value{inputIDsTensor, attentionMaskTensor},
+		[]ort.Value{outputTensor},
+	)
+	metrics.EmbeddingInferenceDuration.Observe(time.Since(inferStart).Seconds())
+	metrics.EmbeddingBatchSize.Observe(float64(batch))
+	if err != nil {
+		return nil, fmt.Errorf("running ONNX inference: %w", err)
+	}
+
+	// Step 6: Pool rows back into one vector per input. Single-row inputs
+	// (the common case) are copied as-is — the model already normalized
+	// them. Copy out of the tensor's buffer either way; it's freed by the
+	// deferred Destroy.
+	data := outputTensor.GetData()
+	rowCount := make([]int, len(texts))
+	for _, i := range owner {
+		rowCount[i]++
+	}
+	result := make([][]float32, len(texts))
+	for b, i := range owner {
+		out := data[b*e.dimension : (b+1)*e.dimension]
+		if rowCount[i] == 1 {
+			result[i] = make([]float32, e.dimension)
+			copy(result[i], out)
+			continue
+		}
+		if result[i] == nil {
+			result[i] = make([]float32, e.dimension)
+		}
+		for d, v := range out {
+			result[i][d] += v * weight[b]
+		}
+	}
+	for i, vec := range result {
+		if rowCount[i] > 1 {
+			normalize(vec)
+		}
+	}
+	return result, nil
+}
+
+// encode tokenizes text with the model's special tokens ([CLS] ... [SEP])
+// and a mask marking which tokens are special.
+func (e *ONNXEmbedder) encode(text string) tokenizers.Encoding {
+	return e.tokenizer.EncodeWithOptions(text, true,
+		tokenizers.WithReturnSpecialTokensMask(),
+	)
+}
+
+// Truncated reports whether some of text's tokens don't contribute to its
+// embedding: any prompt over the window in truncate and head_tail modes,
+// and prompts over maxChunks windows in chunk mode. It tokenizes text
+// again, which is cheap next to inference.
+func (e *ONNXEmbedder) Truncated(text string) bool {
+	n := len(e.encode(text).IDs)
+	if e.mode == LongPromptChunk {
+		// Specials are repeated per chunk, so this slightly overstates
+		// the capacity; close enough for a cache-policy flag.
+		return n > maxChunks*e.window
+	}
+	return n > e.window
+}
+
+// Close releases the tokenizer, ONNX session, and ONNX Runtime environment.
+func (e *ONNXEmbedder) Close() error {
+	e.session.Destroy()
+	e.tokenizer.Close()
+	return ort.DestroyEnvironment()
+}
@@ -0,0 +1,27 @@
+//go:build noonnx
+
+package embedder
+
+import "errors"
+
+// ErrONNXUnavailable is returned by New in binaries built with -tags noonnx.
+var ErrONNXUnavailable = errors.New("embedder: built without ONNX support (noonnx); use a remote backend")
+
+// ONNXEmbedder is a placeholder in noonnx builds so callers compile
+// unchanged. New never returns one.
+type ONNXEmbedder struct{}
+
+// New always fails in noonnx builds.
+func New(modelPath, tokenizerPath, libraryPath string, dimension int, mode LongPromptMode) (*ONNXEmbedder, error) {
+	return nil, ErrONNXUnavailable
+}
+
+func (e *ONNXEmbedder) Embed(text string) ([]float32, error) { return nil, ErrONNXUnavailable }
+
+func (e *ONNXEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	return nil, ErrONNXUnavailable
+}
+
+func (e *ONNXEmbedder) Truncated(text string) bool { return false }
+
+func (e *ONNXEmbedder) Close() error { return nil }
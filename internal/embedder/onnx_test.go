@@ -0,0 +1,194 @@
+//go:build !noonnx
+
+package embedder
+
+import (
+	"math"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// projectRoot returns the absolute path to the repo root by walking up from
+// this test file's location.
+func projectRoot(t *testing.T) string {
+	t.Helper()
+	// This file lives at internal/embedder/onnx_test.go, so the repo
+	// root is two directories up.
+	_, thisFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("could not determine test file path")
+	}
+	return filepath.Join(filepath.Dir(thisFile), "..", "..")
+}
+
+func setupEmbedder(t *testing.T) *ONNXEmbedder {
+	t.Helper()
+	root := projectRoot(t)
+
+	emb, err := New(
+		filepath.Join(root, "models", "model.onnx"),
+		filepath.Join(root, "models", "tokenizer.json"),
+		filepath.Join(root, "lib", "libonnxruntime.dylib"),
+		384,
+		LongPromptTruncate,
+	)
+	if err != nil {
+		t.Fatalf("failed to create embedder: %v", err)
+	}
+	t.Cleanup(func() { emb.Close() })
+	return emb
+}
+
+func TestEmbed_MatchesPythonReference(t *testing.T) {
+	emb := setupEmbedder(t)
+
+	got, err := emb.Embed("What is the weather today?")
+	if err != nil {
+		t.Fatalf("Embed() error: %v", err)
+	}
+
+	if len(got) != 384 {
+		t.Fatalf("expected 384-dim vector, got %d", len(got))
+	}
+
+	// Reference values from running the same ONNX model in Python with
+	// onnxruntime, using the sentence_embedding output.
+	expected := []float32{-0.03579939, 0.09944275, 0.07859868, 0.06265699, -0.01527093}
+	tolerance := float32(1e-4)
+
+	for i, want := range expected {
+		if diff := float32(math.Abs(float64(got[i] - want))); diff > tolerance {
+			t.Errorf("dimension %d: got %f, want %f (diff %f)", i, got[i], want, diff)
+		}
+	}
+}
+
+func TestEmbed_IdenticalInputsProduceIdenticalOutputs(t *testing.T) {
+	emb := setupEmbedder(t)
+
+	a, err := emb.Embed("Tell me about Go programming")
+	if err != nil {
+		t.Fatalf("first Embed() error: %v", err)
+	}
+
+	b, err := emb.Embed("Tell me about Go programming")
+	if err != nil {
+		t.Fatalf("second Embed() error: %v", err)
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("dimension %d differs: %f vs %f", i, a[i], b[i])
+		}
+	}
+}
+
+func TestEmbed_DifferentInputsProduceDifferentOutputs(t *testing.T) {
+	emb := setupEmbedder(t)
+
+	a, err := emb.Embed("What is the weather today?")
+	if err != nil {
+		t.Fatalf("first Embed() error: %v", err)
+	}
+
+	b, err := emb.Embed("How do I cook pasta?")
+	if err != nil {
+		t.Fatalf("second Embed() error: %v", err)
+	}
+
+	// At least some dimensions should differ meaningfully.
+	diffs := 0
+	for i := range a {
+		if math.Abs(float64(a[i]-b[i])) > 0.01 {
+			diffs++
+		}
+	}
+	if diffs == 0 {
+		t.Error("different inputs produced identical embeddings")
+	}
+}
+
+func TestEmbed_EmptyInput(t *testing.T) {
+	emb := setupEmbedder(t)
+
+	// Even empty string should produce tokens ([CLS] + [SEP]) and a valid
+	// embedding, not an error.
+	got, err := emb.Embed("")
+	if err != nil {
+		t.Fatalf("Embed(\"\") error: %v", err)
+	}
+	if len(got) != 384 {
+		t.Fatalf("expected 384-dim vector, got %d", len(got))
+	}
+}
+
+func TestEmbedBatch_MatchesSingleEmbed(t *testing.T) {
+	emb := setupEmbedder(t)
+
+	texts := []string{
+		"What is the weather today?",
+		"How do I cook pasta?",
+		"",
+	}
+
+	batch, err := emb.EmbedBatch(texts)
+	if err != nil {
+		t.Fatalf("EmbedBatch() error: %v", err)
+	}
+	if len(batch) != len(texts) {
+		t.Fatalf("expected %d vectors, got %d", len(texts), len(batch))
+	}
+
+	// Each row of the batched output must match embedding the same text
+	// on its own — batching is a throughput optimization, not a change in
+	// semantics.
+	tolerance := 1e-5
+	for i, text := range texts {
+		single, err := emb.Embed(text)
+		if err != nil {
+			t.Fatalf("Embed(%q) error: %v", text, err)
+		}
+		for d := range single {
+			if diff := math.Abs(float64(batch[i][d] - single[d])); diff > tolerance {
+				t.Fatalf("input %d dimension %d: batch %f vs single %f", i, d, batch[i][d], single[d])
+			}
+		}
+	}
+}
+
+func TestEmbed_HeadTailSeparatesSharedPreamble(t *testing.T) {
+	root := projectRoot(t)
+	emb, err := New(
+		filepath.Join(root, "models", "model.onnx"),
+		filepath.Join(root, "models", "tokenizer.json"),
+		filepath.Join(root, "lib", "libonnxruntime.dylib"),
+		384,
+		LongPromptHeadTail,
+	)
+	if err != nil {
+		t.Fatalf("failed to create embedder: %v", err)
+	}
+	t.Cleanup(func() { emb.Close() })
+
+	preamble := strings.Repeat("You are a helpful assistant that answers questions about the codebase. ", 20)
+	a := preamble + "How do I configure the Redis connection?"
+	b := preamble + "Write a poem about autumn leaves."
+
+	if !emb.Truncated(a) {
+		t.Fatal("expected the long prompt to be reported as truncated")
+	}
+
+	vecs, err := emb.EmbedBatch([]string{a, b})
+	if err != nil {
+		t.Fatalf("EmbedBatch() error: %v", err)
+	}
+	var dot float64
+	for d := range vecs[0] {
+		dot += float64(vecs[0][d] * vecs[1][d])
+	}
+	if dot > 0.99 {
+		t.Errorf("similarity %f: head_tail embeddings of prompts with different endings should differ", dot)
+	}
+}
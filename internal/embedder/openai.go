@@ -0,0 +1,89 @@
+package embedder
+
+import (
+	"context"
+	"net/http"
+	"sort"
+)
+
+// openAIMaxInputs is the most inputs OpenAI accepts in one embeddings call.
+const openAIMaxInputs = 2048
+
+// OpenAIEmbedder embeds text through an OpenAI-compatible POST /embeddings
+// endpoint — OpenAI itself, or any server speaking the same API (vLLM,
+// Ollama, LiteLLM, TEI). Satisfies the Embedder interface.
+type OpenAIEmbedder struct {
+	apiKey    string
+	baseURL   string // e.g. "https://api.openai.com/v1"
+	model     string // e.g. "text-embedding-3-small"
+	dimension int
+	client    *http.Client
+}
+
+// NewOpenAIEmbedder creates an OpenAIEmbedder. dimension is the vector
+// size the rest of the gateway expects; responses of any other size are
+// rejected. apiKey may be empty for local servers that don't check it.
+func NewOpenAIEmbedder(apiKey, baseURL, model string, dimension int, client *http.Client) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		apiKey:    apiKey,
+		baseURL:   baseURL,
+		model:     model,
+		dimension: dimension,
+		client:    client,
+	}
+}
+
+type openAIEmbeddingsRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type openAIEmbeddingsResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed embeds a single text. It's a batch of one through EmbedBatch.
+func (o *OpenAIEmbedder) Embed(text string) ([]float32, error) {
+	vecs, err := o.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+// EmbedBatch embeds texts in as few calls as the API allows. Results are
+// returned in input order.
+func (o *OpenAIEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	out := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += openAIMaxInputs {
+		part := texts[start:min(start+openAIMaxInputs, len(texts))]
+
+		var resp openAIEmbeddingsResponse
+		err := callRemote(len(part), func(ctx context.Context) error {
+			header := http.Header{}
+			if o.apiKey != "" {
+				header.Set("Authorization", "Bearer "+o.apiKey)
+			}
+			return postJSON(ctx, o.client, "openai", o.baseURL+"/embeddings", header,
+				openAIEmbeddingsRequest{Model: o.model, Input: part}, &resp)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The API returns an index per item; don't assume the order.
+		sort.Slice(resp.Data, func(i, j int) bool { return resp.Data[i].Index < resp.Data[j].Index })
+		vecs := make([][]float32, len(resp.Data))
+		for i, d := range resp.Data {
+			vecs[i] = d.Embedding
+		}
+		if err := checkVectors("openai", vecs, len(part), o.dimension); err != nil {
+			return nil, err
+		}
+		out = append(out, vecs...)
+	}
+	return out, nil
+}
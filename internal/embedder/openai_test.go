@@ -0,0 +1,107 @@
+package embedder
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// newOpenAITestServer serves POST /embeddings, answering every input with
+// a vector of dim elements encoding its length, in reverse order to check
+// the client sorts by index.
+func newOpenAITestServer(t *testing.T, dim int) (*httptest.Server, *http.Request) {
+	t.Helper()
+	var last http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = *r.Clone(r.Context())
+		if r.URL.Path != "/embeddings" {
+			http.NotFound(w, r)
+			return
+		}
+		var req openAIEmbeddingsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		var data []item
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			vec := make([]float32, dim)
+			vec[0] = float32(len(req.Input[i]))
+			data = append(data, item{Index: i, Embedding: vec})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": data})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &last
+}
+
+func TestOpenAIEmbedder_EmbedBatch(t *testing.T) {
+	srv, last := newOpenAITestServer(t, 4)
+	e := NewOpenAIEmbedder("sk-test", srv.URL, "text-embedding-3-small", 4, srv.Client())
+
+	vecs, err := e.EmbedBatch([]string{"a", "bbb"})
+	if err != nil {
+		t.Fatalf("EmbedBatch error: %v", err)
+	}
+	if len(vecs) != 2 {
+		t.Fatalf("got %d vectors, want 2", len(vecs))
+	}
+	// Returned in input order and normalized to unit length.
+	for i, vec := range vecs {
+		if vec[0] != 1 {
+			t.Errorf("vecs[%d] = %v, want unit vector [1 0 0 0]", i, vec)
+		}
+	}
+	if got := last.Header.Get("Authorization"); got != "Bearer sk-test" {
+		t.Errorf("Authorization = %q, want Bearer sk-test", got)
+	}
+}
+
+func TestOpenAIEmbedder_DimensionMismatch(t *testing.T) {
+	srv, _ := newOpenAITestServer(t, 1536)
+	e := NewOpenAIEmbedder("", srv.URL, "text-embedding-3-small", 384, srv.Client())
+
+	_, err := e.Embed("hello")
+	if err == nil || !strings.Contains(err.Error(), "embedding.dimension is 384") {
+		t.Fatalf("got error %v, want a dimension mismatch", err)
+	}
+}
+
+func TestOpenAIEmbedder_UpstreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"message":"bad key"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	e := NewOpenAIEmbedder("wrong", srv.URL, "m", 4, srv.Client())
+
+	_, err := e.Embed("hello")
+	var provErr *provider.ProviderError
+	if !errors.As(err, &provErr) || provErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got error %v, want a 401 ProviderError", err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	vec := []float32{3, 4}
+	normalize(vec)
+	if math.Abs(float64(vec[0])-0.6) > 1e-6 || math.Abs(float64(vec[1])-0.8) > 1e-6 {
+		t.Errorf("normalize([3 4]) = %v, want [0.6 0.8]", vec)
+	}
+
+	zero := []float32{0, 0}
+	normalize(zero)
+	if zero[0] != 0 || zero[1] != 0 {
+		t.Errorf("normalize([0 0]) = %v, want it unchanged", zero)
+	}
+}
@@ -0,0 +1,91 @@
+package embedder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// remoteTimeout bounds one remote embeddings call, retries included. The
+// embedding sits in front of every cache lookup, so a slow upstream must
+// fail fast — the handler then serves the request without the cache.
+const remoteTimeout = 10 * time.Second
+
+// remoteMaxAttempts is how many times a remote call is tried on a
+// retryable error (429, 5xx).
+const remoteMaxAttempts = 2
+
+// postJSON marshals body, POSTs it to url with the given headers, and
+// decodes a 200 response into out. Non-200 responses become a
+// *provider.ProviderError attributed to providerName, so provider.Retry
+// can tell transient failures from permanent ones.
+func postJSON(ctx context.Context, client *http.Client, providerName, url string, header http.Header, body, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshaling request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	for k, vals := range header {
+		for _, v := range vals {
+			httpReq.Header.Add(k, v)
+		}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("sending request to %s: %w", providerName, err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return provider.NewProviderError(providerName, httpResp)
+	}
+
+	if err := json.NewDecoder(httpResp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s response: %w", providerName, err)
+	}
+	return nil
+}
+
+// callRemote runs fn under remoteTimeout with retries, and records its
+// duration and batch size alongside the ONNX inference metrics.
+func callRemote(batch int, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := provider.Retry(ctx, remoteMaxAttempts, func() error { return fn(ctx) })
+	metrics.EmbeddingInferenceDuration.Observe(time.Since(start).Seconds())
+	metrics.EmbeddingBatchSize.Observe(float64(batch))
+	return err
+}
+
+// checkVectors validates a remote response: one vector per input, each of
+// the configured dimension. A dimension mismatch means the config and the
+// upstream model disagree — every cache entry and classifier input depends
+// on it, so it's an error rather than something to paper over. Vectors are
+// L2-normalized in place, since not every API guarantees unit length and
+// the cache relies on dot product equaling cosine similarity.
+func checkVectors(providerName string, vecs [][]float32, inputs, dimension int) error {
+	if len(vecs) != inputs {
+		return fmt.Errorf("%s returned %d embeddings for %d inputs", providerName, len(vecs), inputs)
+	}
+	for i, vec := range vecs {
+		if len(vec) != dimension {
+			return fmt.Errorf("%s returned a %d-dim embedding, but embedding.dimension is %d", providerName, len(vec), dimension)
+		}
+		normalize(vecs[i])
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Package features turns a chat request into the inputs a complexity
+// classifier scores: the prompt embedding plus a handful of request-level
+// signals the embedding can't see — conversation length, system prompt
+// size, the requested max_tokens, and whether there's code in it.
+//
+// Training extracts the same features in training/features.py. Both sides
+// follow training/feature_schema.json, which names each request feature,
+// its order in the model input, and how its raw value is transformed; a
+// test here keeps the Go side in step with it.
+package features
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// Features describes one request. Lengths are in characters (Unicode code
+// points, as Python's len counts them). The JSON form is the raw request
+// features, untransformed, keyed by their schema names.
+type Features struct {
+	Embedding []float32 `json:"-"` // of the last user message
+
+	MessageCount      int  `json:"message_count"`
+	UserTurns         int  `json:"user_turns"`
+	SystemPromptChars int  `json:"system_prompt_chars"`
+	LastUserChars     int  `json:"last_user_chars"`
+	ConversationChars int  `json:"conversation_chars"`
+	MaxTokens         int  `json:"max_tokens"` // 0 when the request doesn't set it
+	HasCodeFence      bool `json:"has_code_fence"`
+}
+
+// RequestNames are the request features in the order they follow the
+// embedding in a model input. Append only: a model's input layout is fixed
+// when it's trained.
+var RequestNames = []string{
+	"message_count",
+	"user_turns",
+	"system_prompt_chars",
+	"last_user_chars",
+	"conversation_chars",
+	"max_tokens",
+	"has_code_fence",
+}
+
+// Extract builds the features for req, whose last user message embeds to
+// embedding. req may be nil, leaving only the embedding.
+func Extract(embedding []float32, req *provider.ChatRequest) Features {
+	f := Features{Embedding: embedding}
+	if req == nil {
+		return f
+	}
+
+	f.MessageCount = len(req.Messages)
+	f.MaxTokens = req.MaxTokens
+	for _, m := range req.Messages {
+		n := utf8.RuneCountInString(m.Content)
+		f.ConversationChars += n
+		switch m.Role {
+		case "system":
+			f.SystemPromptChars += n
+		case "user":
+			f.UserTurns++
+			f.LastUserChars = n
+		}
+		if strings.Contains(m.Content, "```") {
+			f.HasCodeFence = true
+		}
+	}
+	return f
+}
+
+// Request returns the transformed request features in RequestNames order:
+// counts and lengths as log1p, has_code_fence as 0 or 1.
+func (f Features) Request() []float32 {
+	fence := 0.0
+	if f.HasCodeFence {
+		fence = 1
+	}
+	return []float32{
+		log1p(f.MessageCount),
+		log1p(f.UserTurns),
+		log1p(f.SystemPromptChars),
+		log1p(f.LastUserChars),
+		log1p(f.ConversationChars),
+		log1p(f.MaxTokens),
+		float32(fence),
+	}
+}
+
+func log1p(n int) float32 {
+	return float32(math.Log1p(float64(max(n, 0))))
+}
+
+// Layout is how a model's input vector is assembled: the embedding alone,
+// or the embedding followed by the request features. Those are the two
+// layouts training produces.
+type Layout struct {
+	EmbeddingDim int
+	Request      bool
+}
+
+// LayoutFor returns the layout of a model that takes width inputs, for
+// embeddings of embeddingDim.
+func LayoutFor(embeddingDim, width int) (Layout, error) {
+	switch width {
+	case embeddingDim:
+		return Layout{EmbeddingDim: embeddingDim}, nil
+	case embeddingDim + len(RequestNames):
+		return Layout{EmbeddingDim: embeddingDim, Request: true}, nil
+	}
+	return Layout{}, fmt.Errorf(
+		"model takes %d inputs; want %d (embedding) or %d (embedding + request features)",
+		width, embeddingDim, embeddingDim+len(RequestNames),
+	)
+}
+
+// Width returns the number of model inputs.
+func (l Layout) Width() int {
+	if l.Request {
+		return l.EmbeddingDim + len(RequestNames)
+	}
+	return l.EmbeddingDim
+}
+
+// Vector lays f out as model input.
+func (l Layout) Vector(f Features) ([]float32, error) {
+	if len(f.Embedding) != l.EmbeddingDim {
+		return nil, fmt.Errorf(
+			"classifier: expected embedding of dimension %d, got %d",
+			l.EmbeddingDim, len(f.Embedding),
+		)
+	}
+	if !l.Request {
+		return f.Embedding, nil
+	}
+	return append(slices.Clip(f.Embedding), f.Request()...), nil
+}
@@ -0,0 +1,103 @@
+package features
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSchema keeps RequestNames in step with the schema training reads.
+func TestSchema(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "training", "feature_schema.json"))
+	require.NoError(t, err)
+	var schema struct {
+		RequestFeatures []struct {
+			Name      string `json:"name"`
+			Transform string `json:"transform"`
+		} `json:"request_features"`
+	}
+	require.NoError(t, json.Unmarshal(data, &schema))
+
+	var names []string
+	for _, f := range schema.RequestFeatures {
+		names = append(names, f.Name)
+		want := "log1p"
+		if f.Name == "has_code_fence" {
+			want = "bool"
+		}
+		assert.Equal(t, want, f.Transform, f.Name)
+	}
+	assert.Equal(t, RequestNames, names)
+
+	// The JSON form of Features carries exactly the schema's raw values.
+	raw, err := json.Marshal(Features{Embedding: []float32{1}})
+	require.NoError(t, err)
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(raw, &fields))
+	assert.Len(t, fields, len(RequestNames))
+	for _, name := range RequestNames {
+		assert.Contains(t, fields, name)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	req := &provider.ChatRequest{
+		MaxTokens: 256,
+		Messages: []provider.Message{
+			{Role: "system", Content: "be brief"},
+			{Role: "user", Content: "hi ```x``` é"},
+		},
+	}
+	f := Extract([]float32{0.5}, req)
+	assert.Equal(t, Features{
+		Embedding:         []float32{0.5},
+		MessageCount:      2,
+		UserTurns:         1,
+		SystemPromptChars: 8,
+		LastUserChars:     12,
+		ConversationChars: 20,
+		MaxTokens:         256,
+		HasCodeFence:      true,
+	}, f)
+
+	// Values from training/features.py's request_vector on the same request.
+	want := []float64{
+		1.0986122886681096, 0.6931471805599453, 2.1972245773362196,
+		2.5649493574615367, 3.044522437723423, 5.54907608489522, 1.0,
+	}
+	got := f.Request()
+	require.Len(t, got, len(want))
+	for i := range want {
+		assert.InDelta(t, want[i], got[i], 1e-6, RequestNames[i])
+	}
+
+	assert.Equal(t, Features{Embedding: []float32{0.5}}, Extract([]float32{0.5}, nil))
+}
+
+func TestLayout(t *testing.T) {
+	f := Features{Embedding: []float32{0.1, 0.2, 0.3}, MessageCount: 1}
+
+	l, err := LayoutFor(3, 3)
+	require.NoError(t, err)
+	v, err := l.Vector(f)
+	require.NoError(t, err)
+	assert.Equal(t, f.Embedding, v)
+
+	l, err = LayoutFor(3, 3+len(RequestNames))
+	require.NoError(t, err)
+	assert.Equal(t, 3+len(RequestNames), l.Width())
+	v, err = l.Vector(f)
+	require.NoError(t, err)
+	assert.Equal(t, append([]float32{0.1, 0.2, 0.3}, f.Request()...), v)
+	assert.Len(t, f.Embedding, 3, "embedding isn't appended to in place")
+
+	_, err = LayoutFor(3, 5)
+	assert.Error(t, err)
+	_, err = l.Vector(Features{Embedding: []float32{0.1}})
+	assert.Error(t, err, "wrong embedding dimension")
+}
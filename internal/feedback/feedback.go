@@ -0,0 +1,236 @@
+// Package feedback collects client feedback on gateway responses as
+// training data for the complexity classifier.
+//
+// The gateway remembers what it knew about each response it served — the
+// prompt, its embedding, the complexity score, and the model it routed to —
+// for a while after serving it. When the client reports feedback on a
+// response ID, that record is written out with the feedback attached, to
+// an append-only JSONL file or a Redis stream.
+package feedback
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/features"
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+)
+
+// Sink names for FeedbackConfig.Sink.
+const (
+	SinkJSONL = "jsonl"
+	SinkRedis = "redis"
+)
+
+// Source is the "source" of every record, marking it as real traffic
+// rather than one of the public datasets collect_dataset.py samples.
+const Source = "feedback"
+
+// Defaults for unset FeedbackConfig fields.
+const (
+	defaultPendingTTL = 24 * time.Hour
+	defaultMaxPending = 10_000
+)
+
+// FeedbackConfig controls feedback collection. Disabled unless Enabled.
+type FeedbackConfig struct {
+	Enabled  bool   `koanf:"enabled"`
+	Sink     string `koanf:"sink"`      // "jsonl" (default) or "redis"
+	Path     string `koanf:"path"`      // jsonl: file records are appended to
+	Stream   string `koanf:"stream"`    // redis: stream key records are XADDed to
+	RedisURL string `koanf:"redis_url"` // redis: defaults to cache.redis_url
+
+	// How long after serving a response feedback on it is accepted, and
+	// how many served responses are remembered at once (oldest dropped
+	// first). Defaults: 24h and 10,000.
+	PendingTTL time.Duration `koanf:"pending_ttl"`
+	MaxPending int           `koanf:"max_pending"`
+}
+
+// Ratings for Feedback.Rating.
+const (
+	RatingUp   = "up"
+	RatingDown = "down"
+)
+
+// Feedback is what a client reports about a response: a thumbs up/down, a
+// score from 0 (bad) to 1 (good), or both.
+type Feedback struct {
+	Rating  string   `json:"rating,omitempty"`
+	Score   *float64 `json:"score,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+}
+
+// Validate reports whether f carries a usable signal.
+func (f Feedback) Validate() error {
+	if f.Rating == "" && f.Score == nil {
+		return errors.New("feedback needs a rating or a score")
+	}
+	if f.Rating != "" && f.Rating != RatingUp && f.Rating != RatingDown {
+		return fmt.Errorf("rating must be %q or %q, got %q", RatingUp, RatingDown, f.Rating)
+	}
+	if f.Score != nil && (*f.Score < 0 || *f.Score > 1) {
+		return fmt.Errorf("score must be between 0 and 1, got %g", *f.Score)
+	}
+	return nil
+}
+
+// Record is one line of training data: a served response and the
+// feedback on it. Prompt and Source match the prompts.jsonl entries
+// training/collect_dataset.py reads, so a feedback file can be fed
+// straight back through the labeling pipeline; the rest lets a model be
+// retrained on the gateway's own embeddings and routing decisions.
+type Record struct {
+	Prompt string `json:"prompt"`
+	Source string `json:"source"`
+
+	ResponseID           string    `json:"response_id"`
+	Model                string    `json:"routed_model"`
+	Strategy             string    `json:"strategy,omitempty"`         // routing strategy; empty for pinned models
+	ComplexityScore      *float64  `json:"complexity_score,omitempty"` // nil without a classifier
+	CacheHit             bool      `json:"cache_hit"`
+	Embedding            []float32 `json:"embedding,omitempty"`
+	EmbeddingFingerprint string    `json:"embedding_fingerprint,omitempty"`
+	ServedAt             time.Time `json:"served_at"`
+
+	// Features are the raw request features (see training/features.py),
+	// so a model using them can be retrained on whole conversations.
+	Features *features.Features `json:"features,omitempty"`
+
+	Feedback   *Feedback `json:"feedback,omitempty"`
+	FeedbackAt time.Time `json:"feedback_at,omitzero"`
+}
+
+// Sink persists feedback records. Implementations must be safe for
+// concurrent use.
+type Sink interface {
+	Write(ctx context.Context, rec Record) error
+	Close() error
+}
+
+// ErrUnknownResponse is returned by Submit for response IDs the collector
+// never served, or served longer ago than PendingTTL.
+var ErrUnknownResponse = errors.New("unknown or expired response ID")
+
+// pending is a served response awaiting feedback.
+type pending struct {
+	rec     Record
+	expires time.Time
+}
+
+// Collector remembers served responses and writes them to a Sink when
+// feedback arrives. Memory is bounded by MaxPending: responses are kept
+// in serving order, so the oldest are dropped first. Pending responses
+// live in this process only — behind a load balancer, feedback must reach
+// the instance that served the response.
+type Collector struct {
+	sink Sink
+	ttl  time.Duration
+	max  int
+
+	mu    sync.Mutex
+	order *list.List               // of *pending, oldest first
+	byID  map[string]*list.Element // response ID → element in order
+}
+
+// NewCollector creates a Collector writing to sink.
+func NewCollector(cfg FeedbackConfig, sink Sink) *Collector {
+	ttl := cfg.PendingTTL
+	if ttl <= 0 {
+		ttl = defaultPendingTTL
+	}
+	maxPending := cfg.MaxPending
+	if maxPending <= 0 {
+		maxPending = defaultMaxPending
+	}
+	return &Collector{
+		sink:  sink,
+		ttl:   ttl,
+		max:   maxPending,
+		order: list.New(),
+		byID:  make(map[string]*list.Element),
+	}
+}
+
+// Track remembers a served response so feedback can be attached to it. A
+// response ID served again (a cache hit replays the original ID) replaces
+// the earlier record. Records without a response ID are ignored.
+func (c *Collector) Track(rec Record) {
+	if rec.ResponseID == "" {
+		return
+	}
+	rec.Source = Source
+	if rec.ServedAt.IsZero() {
+		rec.ServedAt = time.Now()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.byID[rec.ResponseID]; ok {
+		c.order.Remove(el)
+	}
+	for c.order.Len() >= c.max {
+		c.evict(c.order.Front())
+	}
+	p := &pending{rec: rec, expires: rec.ServedAt.Add(c.ttl)}
+	c.byID[rec.ResponseID] = c.order.PushBack(p)
+}
+
+// evict drops el. Caller holds mu.
+func (c *Collector) evict(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.byID, el.Value.(*pending).rec.ResponseID)
+}
+
+// Submit attaches fb to the response and writes the record to the sink.
+// Feedback can be submitted more than once for a response — each
+// submission is written, and consumers should keep the latest.
+func (c *Collector) Submit(ctx context.Context, responseID string, fb Feedback) error {
+	if err := fb.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	el, ok := c.byID[responseID]
+	var rec Record
+	if ok {
+		p := el.Value.(*pending)
+		if time.Now().After(p.expires) {
+			c.evict(el)
+			ok = false
+		}
+		rec = p.rec
+	}
+	c.mu.Unlock()
+	if !ok {
+		return ErrUnknownResponse
+	}
+
+	rec.Feedback = &fb
+	rec.FeedbackAt = time.Now()
+	if err := c.sink.Write(ctx, rec); err != nil {
+		metrics.FeedbackSubmissions.WithLabelValues("error").Inc()
+		return fmt.Errorf("writing feedback: %w", err)
+	}
+	metrics.FeedbackSubmissions.WithLabelValues(feedbackLabel(fb)).Inc()
+	return nil
+}
+
+// feedbackLabel is fb's metrics label: the rating, or "score" if it only
+// has a score.
+func feedbackLabel(fb Feedback) string {
+	if fb.Rating != "" {
+		return fb.Rating
+	}
+	return "score"
+}
+
+// Close closes the sink.
+func (c *Collector) Close() error {
+	return c.sink.Close()
+}
@@ -0,0 +1,154 @@
+package feedback
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySink records what it's given.
+type memorySink struct {
+	records []Record
+	err     error
+}
+
+func (m *memorySink) Write(_ context.Context, rec Record) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.records = append(m.records, rec)
+	return nil
+}
+
+func (m *memorySink) Close() error { return nil }
+
+func up() Feedback { return Feedback{Rating: RatingUp} }
+
+func TestFeedback_Validate(t *testing.T) {
+	half, over := 0.5, 1.5
+	assert.NoError(t, Feedback{Rating: RatingDown}.Validate())
+	assert.NoError(t, Feedback{Score: &half}.Validate())
+	assert.Error(t, Feedback{}.Validate(), "no signal")
+	assert.Error(t, Feedback{Rating: "meh"}.Validate())
+	assert.Error(t, Feedback{Score: &over}.Validate())
+}
+
+func TestCollector_SubmitWritesTrackedRecord(t *testing.T) {
+	sink := &memorySink{}
+	c := NewCollector(FeedbackConfig{}, sink)
+
+	score := 0.7
+	c.Track(Record{
+		Prompt:          "explain monads",
+		ResponseID:      "resp-1",
+		Model:           "claude-sonnet-4-5-20250929",
+		Strategy:        "auto",
+		ComplexityScore: &score,
+		Embedding:       []float32{0.1, 0.2},
+	})
+
+	require.NoError(t, c.Submit(context.Background(), "resp-1", Feedback{Rating: RatingDown, Comment: "too vague"}))
+	require.Len(t, sink.records, 1)
+	rec := sink.records[0]
+	assert.Equal(t, "explain monads", rec.Prompt)
+	assert.Equal(t, Source, rec.Source)
+	assert.Equal(t, "claude-sonnet-4-5-20250929", rec.Model)
+	assert.Equal(t, 0.7, *rec.ComplexityScore)
+	assert.Equal(t, RatingDown, rec.Feedback.Rating)
+	assert.False(t, rec.FeedbackAt.IsZero())
+
+	// Feedback can be revised; each submission is written.
+	require.NoError(t, c.Submit(context.Background(), "resp-1", up()))
+	assert.Len(t, sink.records, 2)
+}
+
+func TestCollector_UnknownAndExpired(t *testing.T) {
+	c := NewCollector(FeedbackConfig{PendingTTL: time.Minute}, &memorySink{})
+
+	assert.ErrorIs(t, c.Submit(context.Background(), "never-served", up()), ErrUnknownResponse)
+
+	c.Track(Record{ResponseID: "old", ServedAt: time.Now().Add(-2 * time.Minute)})
+	assert.ErrorIs(t, c.Submit(context.Background(), "old", up()), ErrUnknownResponse)
+}
+
+func TestCollector_EvictsOldest(t *testing.T) {
+	c := NewCollector(FeedbackConfig{MaxPending: 2}, &memorySink{})
+	c.Track(Record{ResponseID: "a"})
+	c.Track(Record{ResponseID: "b"})
+	c.Track(Record{ResponseID: "a"}) // re-served: now the newest
+	c.Track(Record{ResponseID: "c"})
+
+	assert.ErrorIs(t, c.Submit(context.Background(), "b", up()), ErrUnknownResponse)
+	assert.NoError(t, c.Submit(context.Background(), "a", up()))
+	assert.NoError(t, c.Submit(context.Background(), "c", up()))
+}
+
+func TestCollector_SinkError(t *testing.T) {
+	c := NewCollector(FeedbackConfig{}, &memorySink{err: errors.New("disk full")})
+	c.Track(Record{ResponseID: "a"})
+
+	err := c.Submit(context.Background(), "a", up())
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnknownResponse)
+}
+
+func TestJSONLSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.jsonl")
+	sink, err := NewSink(FeedbackConfig{Path: path}, "")
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Write(context.Background(), Record{Prompt: "first", Source: Source, ResponseID: "a"}))
+	require.NoError(t, sink.Write(context.Background(), Record{Prompt: "second", Source: Source, ResponseID: "b"}))
+	require.NoError(t, sink.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Each line is a prompts.jsonl-style entry with the extra fields.
+	var prompts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		assert.Equal(t, Source, entry["source"])
+		prompts = append(prompts, entry["prompt"].(string))
+	}
+	assert.Equal(t, []string{"first", "second"}, prompts)
+}
+
+func TestRedisStreamSink(t *testing.T) {
+	mr := miniredis.RunT(t)
+	sink, err := NewSink(FeedbackConfig{Sink: SinkRedis, Stream: "llmrouter:feedback"}, "redis://"+mr.Addr())
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Write(context.Background(), Record{Prompt: "hi", Source: Source, ResponseID: "a"}))
+
+	entries, err := mr.Stream("llmrouter:feedback")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, []string{"record"}, entries[0].Values[:1])
+
+	var rec Record
+	require.NoError(t, json.Unmarshal([]byte(entries[0].Values[1]), &rec))
+	assert.Equal(t, "a", rec.ResponseID)
+}
+
+func TestNewSink_Invalid(t *testing.T) {
+	_, err := NewSink(FeedbackConfig{Sink: "kafka"}, "")
+	assert.Error(t, err)
+	_, err = NewSink(FeedbackConfig{Sink: SinkJSONL}, "")
+	assert.Error(t, err, "jsonl needs a path")
+	_, err = NewSink(FeedbackConfig{Sink: SinkRedis}, "redis://localhost:6379")
+	assert.Error(t, err, "redis needs a stream")
+}
@@ -0,0 +1,108 @@
+package feedback
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewSink creates the Sink cfg.Sink names. defaultRedisURL is used by the
+// redis sink when cfg.RedisURL is empty.
+func NewSink(cfg FeedbackConfig, defaultRedisURL string) (Sink, error) {
+	switch cfg.Sink {
+	case "", SinkJSONL:
+		return NewJSONLSink(cfg.Path)
+	case SinkRedis:
+		url := cfg.RedisURL
+		if url == "" {
+			url = defaultRedisURL
+		}
+		return NewRedisStreamSink(url, cfg.Stream)
+	default:
+		return nil, fmt.Errorf("unknown feedback sink %q (want %q or %q)", cfg.Sink, SinkJSONL, SinkRedis)
+	}
+}
+
+// JSONLSink appends each record as a line of JSON to a file.
+type JSONLSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewJSONLSink opens path for appending, creating it if needed.
+func NewJSONLSink(path string) (*JSONLSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("feedback jsonl sink needs a path")
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening feedback file: %w", err)
+	}
+	return &JSONLSink{f: f}, nil
+}
+
+// Write appends rec. Each record is written with a single write call, so
+// lines from concurrent writers never interleave.
+func (s *JSONLSink) Write(_ context.Context, rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(line)
+	return err
+}
+
+// Close closes the file.
+func (s *JSONLSink) Close() error {
+	return s.f.Close()
+}
+
+// RedisStreamSink adds each record to a Redis stream, as a JSON string in
+// the entry's "record" field — the same line a JSONLSink would write, so
+// a consumer can dump the stream to a JSONL file unchanged.
+type RedisStreamSink struct {
+	client *redis.Client
+	stream string
+}
+
+// NewRedisStreamSink connects to redisURL and verifies the connection.
+func NewRedisStreamSink(redisURL, stream string) (*RedisStreamSink, error) {
+	if stream == "" {
+		return nil, fmt.Errorf("feedback redis sink needs a stream name")
+	}
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connecting to redis: %w", err)
+	}
+	return &RedisStreamSink{client: client, stream: stream}, nil
+}
+
+// Write adds rec to the stream.
+func (s *RedisStreamSink) Write(ctx context.Context, rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		Values: map[string]any{"record": data},
+	}).Err()
+}
+
+// Close releases the Redis connection pool.
+func (s *RedisStreamSink) Close() error {
+	return s.client.Close()
+}
@@ -12,12 +12,41 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// Outcomes for BudgetChecks.
+const (
+	BudgetWithin     = "within"
+	BudgetDowngraded = "downgraded"
+	BudgetRejected   = "rejected"
+)
+
+// Outcomes for ContextOverflows.
+const (
+	ContextRerouted  = "rerouted"
+	ContextTruncated = "truncated"
+	ContextRejected  = "rejected"
+)
+
 // Cache status values attached to Requests and related metrics.
 const (
 	CacheHit      = "HIT"
 	CacheMiss     = "MISS"
 	CacheSkip     = "SKIP"
 	CacheOnlyMiss = "ONLY_MISS"
+
+	// CacheUnavailable: Redis was down (the cache breaker open), so the
+	// cache was skipped.
+	CacheUnavailable = "UNAVAILABLE"
+
+	// CacheCoalesced: a miss served from the response of a matching
+	// request that was already calling the provider.
+	CacheCoalesced = "COALESCED"
+)
+
+// Results for Coalesced.
+const (
+	CoalesceJoined       = "joined"
+	CoalesceTimeout      = "timeout"
+	CoalesceLeaderFailed = "leader_failed"
 )
 
 // Provider error type values — capped to a small enum to keep cardinality
@@ -77,6 +106,18 @@ var (
 		Buckets: []float64{16, 64, 256, 1024, 4096, 16384, 65536},
 	})
 
+	// labels: model, source (provider|estimate)
+	TokenCounts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_token_counts_total",
+		Help: "Pre-flight token counts served by POST /v1/tokenize, by whether the provider's counting API or the local estimate answered.",
+	}, []string{"model", "source"})
+
+	// labels: model
+	CharsPerToken = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "llmrouter_chars_per_token",
+		Help: "Calibrated characters per input token used by the local token estimate. Models without a series use 4.",
+	}, []string{"model"})
+
 	// labels: provider, model
 	CostUSD = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "llmrouter_cost_usd_total",
@@ -96,12 +137,45 @@ var (
 		Help: "Cumulative USD cost avoided by serving responses from cache.",
 	}, []string{"provider", "model"})
 
-	// labels: provider — estimated savings from auto-routing to the cheap model.
+	// labels: provider, model — of the leader's response that served a
+	// coalesced request.
+	CostSavedByCoalescing = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_cost_saved_by_coalescing_usd_total",
+		Help: "Cumulative USD cost avoided by serving cache misses from a matching request's in-flight provider call.",
+	}, []string{"provider", "model"})
+
+	// labels: provider — estimated savings from auto-routing below the top tier.
 	CostSavedByRouting = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "llmrouter_cost_saved_by_routing_usd_total",
-		Help: "Cumulative estimated USD cost avoided by routing to the cheap model. Estimator: (prompt_tokens × quality_input_price + completion_tokens × quality_output_price) − actual_cost.",
+		Help: "Cumulative estimated USD cost avoided by routing below the provider's top tier. Estimator: (prompt_tokens × top_tier_input_price + completion_tokens × top_tier_output_price) − actual_cost.",
 	}, []string{"provider"})
 
+	// labels: provider, model — the pre-flight estimate for each provider
+	// call with a priced model, to compare against CostUSD.
+	EstimatedCostUSD = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_estimated_cost_usd_total",
+		Help: "Cumulative pre-flight USD cost estimates (prompt tokens + max_tokens at list price) of provider calls.",
+	}, []string{"provider", "model"})
+
+	// labels: model
+	CostEstimateRatio = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "llmrouter_cost_estimate_ratio",
+		Help:    "Actual over pre-flight estimated USD cost per provider call. Below 1 means the estimate was conservative.",
+		Buckets: []float64{.05, .1, .25, .5, .75, 1, 1.5, 2, 4},
+	}, []string{"model"})
+
+	// labels: outcome (within|downgraded|rejected)
+	BudgetChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_budget_checks_total",
+		Help: "Requests with a cost cap (X-Max-Cost or a per-key default), by whether the estimate fit, the model was downgraded to fit, or the request was rejected.",
+	}, []string{"outcome"})
+
+	// labels: outcome (rerouted|truncated|rejected)
+	ContextOverflows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_context_overflows_total",
+		Help: "Requests too large for their model's context window, by whether they were rerouted to a larger-context model, truncated, or rejected.",
+	}, []string{"outcome"})
+
 	// labels: result (hit|miss)
 	CacheSimilarity = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "llmrouter_cache_similarity_score",
@@ -109,24 +183,111 @@ var (
 		Buckets: []float64{.9, .92, .94, .96, .98, .99, 1.0},
 	}, []string{"result"})
 
+	CacheAvailable = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "llmrouter_cache_available",
+		Help: "1 while the semantic cache's Redis is reachable, 0 while it's down and requests skip the cache.",
+	})
+
+	// labels: result (joined|timeout|leader_failed)
+	Coalesced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_coalesced_requests_total",
+		Help: "Cache misses that found a matching request already calling the provider, by whether its response served them (joined) or they called the provider themselves because it took too long (timeout) or failed (leader_failed).",
+	}, []string{"result"})
+
 	// labels: strategy, selected_model
 	RoutingDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "llmrouter_routing_decisions_total",
 		Help: "Routing decisions for auto-model requests, by strategy and resulting model.",
 	}, []string{"strategy", "selected_model"})
 
+	// labels: rule
+	RoutingRuleMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_routing_rule_matches_total",
+		Help: "Auto-routing requests overridden by a routing rule, by the rule that fired.",
+	}, []string{"rule"})
+
+	// labels: experiment, arm, model — requests served (or shadowed) under
+	// a routing experiment. Unassigned traffic is experiment="none",
+	// arm="control" — the shared baseline every treatment compares against.
+	ExperimentRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_experiment_requests_total",
+		Help: "Requests by routing experiment and arm, with the model the arm chose. Shadow copies count under their arm.",
+	}, []string{"experiment", "arm", "model"})
+
+	// labels: experiment, arm
+	ExperimentCostUSD = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_experiment_cost_usd_total",
+		Help: "Cumulative USD provider cost by routing experiment and arm, including shadow copies.",
+	}, []string{"experiment", "arm"})
+
+	// labels: experiment, arm
+	ExperimentRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "llmrouter_experiment_request_duration_seconds",
+		Help:    "End-to-end request duration by routing experiment and arm. Shadow copies are timed from their provider call.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2, 5, 10, 15, 20, 30, 60},
+	}, []string{"experiment", "arm"})
+
+	// labels: experiment, arm
+	ExperimentErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_experiment_errors_total",
+		Help: "Failed provider calls by routing experiment and arm, including shadow copies.",
+	}, []string{"experiment", "arm"})
+
 	EmbeddingDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "llmrouter_embedding_duration_seconds",
-		Help:    "Duration of the ONNX embedding inference step.",
+		Help:    "Per-request duration of the embedding step, including any micro-batching wait.",
 		Buckets: []float64{.001, .005, .01, .025, .05, .1},
 	})
 
+	EmbeddingInferenceDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "llmrouter_embedding_inference_duration_seconds",
+		Help:    "Duration of one embedding model call (one batch): an ONNX inference or a remote embeddings request.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	})
+
+	EmbeddingBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "llmrouter_embedding_batch_size",
+		Help:    "Number of rows (texts, or chunks of long texts) embedded per model call.",
+		Buckets: []float64{1, 2, 4, 8, 16, 32, 64, 128},
+	})
+
+	// labels: mode (truncate|head_tail|chunk)
+	EmbeddingTruncatedPrompts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_embedding_truncated_prompts_total",
+		Help: "Prompts longer than the embedding model's token window, by the long-prompt mode that handled them.",
+	}, []string{"mode"})
+
+	// labels: result (hit|miss)
+	EmbeddingCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_embedding_cache_lookups_total",
+		Help: "Lookups in the in-process prompt embedding LRU, by result.",
+	}, []string{"result"})
+
 	ClassificationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "llmrouter_classification_duration_seconds",
 		Help:    "Duration of the complexity classifier inference step.",
 		Buckets: []float64{.0005, .001, .005, .01, .025},
 	})
 
+	// labels: version, threshold — always exactly one series, valued 1,
+	// naming the live complexity classifier.
+	ClassifierInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "llmrouter_classifier_info",
+		Help: "The live complexity classifier's version and decision threshold. Always 1.",
+	}, []string{"version", "threshold"})
+
+	// labels: result (reloaded|rolled_back|error)
+	ClassifierReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_classifier_reloads_total",
+		Help: "Complexity classifier swaps at runtime, by result.",
+	}, []string{"result"})
+
+	// labels: result (up|down|score|error)
+	FeedbackSubmissions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llmrouter_feedback_total",
+		Help: "Feedback submissions recorded via POST /v1/feedback, by rating (score if only a score was given) or error if it couldn't be written.",
+	}, []string{"result"})
+
 	ComplexityScore = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "llmrouter_complexity_score",
 		Help:    "Distribution of complexity classifier scores for auto-routed requests.",
@@ -53,7 +53,7 @@ func (a *AnthropicProvider) Name() string {
 type anthropicRequest struct {
 	Model     string             `json:"model"`
 	MaxTokens int                `json:"max_tokens"`
-	System    string             `json:"system,omitempty"`
+	System    anthropicText      `json:"system,omitempty"`
 	Messages  []anthropicMessage `json:"messages"`
 	Stream    bool               `json:"stream,omitempty"`
 }
@@ -62,8 +62,8 @@ type anthropicRequest struct {
 // Unlike Gemini's nested parts structure, Anthropic uses a flat
 // role + content shape — same as OpenAI's format.
 type anthropicMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string        `json:"role"`
+	Content anthropicText `json:"content"`
 }
 
 // --- Response types ---
@@ -77,6 +77,8 @@ type anthropicMessage struct {
 //   - "stop_reason" instead of "finishReason"
 type anthropicResponse struct {
 	ID         string                  `json:"id"`
+	Type       string                  `json:"type,omitempty"` // always "message"; only set on ingress responses
+	Role       string                  `json:"role,omitempty"` // always "assistant"; only set on ingress responses
 	Content    []anthropicContentBlock `json:"content"`
 	Model      string                  `json:"model"`
 	StopReason string                  `json:"stop_reason"`
@@ -129,15 +131,28 @@ type anthropicStreamEvent struct {
 	Message *anthropicEventMessage `json:"message,omitempty"` // present on message_start
 	Delta   *anthropicEventDelta  `json:"delta,omitempty"`   // present on content_block_delta AND message_delta
 	Usage   *anthropicUsage       `json:"usage,omitempty"`   // present on message_delta (output tokens)
+
+	// The fields below are only written by the ingress encoder
+	// (anthropic_ingress.go); the outbound adapter never reads them.
+	Index        *int                   `json:"index,omitempty"`         // content_block_* events
+	ContentBlock *anthropicContentBlock `json:"content_block,omitempty"` // content_block_start
+	Error        *anthropicErrorBody    `json:"error,omitempty"`         // error
 }
 
 // anthropicEventMessage is the "message" object inside a message_start event.
 // It carries the response metadata: ID, model, and the input token count.
 // Output tokens are 0 here because the model hasn't generated anything yet.
+//
+// Type, Role, and Content are only populated by the ingress encoder — the
+// Anthropic SDKs expect them on message_start, but the outbound adapter
+// doesn't need them.
 type anthropicEventMessage struct {
-	ID    string         `json:"id"`
-	Model string         `json:"model"`
-	Usage anthropicUsage `json:"usage"` // input_tokens populated, output_tokens = 0
+	ID      string                  `json:"id"`
+	Type    string                  `json:"type,omitempty"`
+	Role    string                  `json:"role,omitempty"`
+	Content []anthropicContentBlock `json:"content"`
+	Model   string                  `json:"model"`
+	Usage   anthropicUsage          `json:"usage"` // input_tokens populated, output_tokens = 0
 }
 
 // anthropicEventDelta carries different data depending on the event type:
@@ -192,13 +207,13 @@ func toAnthropicRequest(req *ChatRequest) *anthropicRequest {
 		// just like our unified format (unlike Gemini which uses "model").
 		ar.Messages = append(ar.Messages, anthropicMessage{
 			Role:    msg.Role,
-			Content: msg.Content,
+			Content: anthropicText(msg.Content),
 		})
 	}
 
 	// Join multiple system messages with newlines into one string.
 	if len(systemParts) > 0 {
-		ar.System = strings.Join(systemParts, "\n")
+		ar.System = anthropicText(strings.Join(systemParts, "\n"))
 	}
 
 	// Set max_tokens — use the caller's value if provided, otherwise default.
@@ -493,3 +508,61 @@ func (a *AnthropicProvider) ChatCompletionStream(ctx context.Context, req *ChatR
 
 	return ch, nil
 }
+
+// ---------------------------------------------------------------------------
+// Token counting: CountTokens
+// ---------------------------------------------------------------------------
+
+// anthropicCountRequest is the request body for /v1/messages/count_tokens:
+// the same model, system, and messages as a completion, without
+// max_tokens or stream.
+type anthropicCountRequest struct {
+	Model    string             `json:"model"`
+	System   anthropicText      `json:"system,omitempty"`
+	Messages []anthropicMessage `json:"messages"`
+}
+
+// anthropicCountResponse is the response from /v1/messages/count_tokens.
+type anthropicCountResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
+// CountTokens asks Anthropic's /v1/messages/count_tokens endpoint how many
+// input tokens req would use. It's free and doesn't run the model, but it
+// is a network round trip, so it isn't used on the request path.
+func (a *AnthropicProvider) CountTokens(ctx context.Context, req *ChatRequest) (int, error) {
+	ar := toAnthropicRequest(req)
+	body, err := json.Marshal(anthropicCountRequest{
+		Model:    ar.Model,
+		System:   ar.System,
+		Messages: ar.Messages,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/messages/count_tokens", a.baseURL)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", a.apiKey)
+	httpReq.Header.Set("anthropic-version", anthropicAPIVersion)
+
+	httpResp, err := a.client.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("sending request to anthropic: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return 0, NewProviderError("anthropic", httpResp)
+	}
+
+	var countResp anthropicCountResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&countResp); err != nil {
+		return 0, fmt.Errorf("decoding anthropic response: %w", err)
+	}
+	return countResp.InputTokens, nil
+}
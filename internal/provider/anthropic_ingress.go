@@ -0,0 +1,253 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Inbound translation: Anthropic Messages API → unified types
+// ---------------------------------------------------------------------------
+//
+// anthropic.go translates our unified types INTO Anthropic's format so we
+// can call their API. This file runs the same structs in the opposite
+// direction so the gateway can ACCEPT Anthropic-shaped requests on
+// POST /v1/messages and answer in Anthropic's response/SSE format. That
+// lets clients built on the Anthropic SDK point at llmrouter unchanged,
+// and still be routed to any provider (including Gemini).
+
+// anthropicText is a message or system body. Anthropic accepts either a
+// plain string or an array of content blocks ([{"type":"text","text":...}])
+// for both fields; the SDKs send whichever the caller used. We only support
+// text, so block arrays are flattened by concatenating their text blocks.
+//
+// It's a named string type so the outbound adapter still marshals it as a
+// plain JSON string — only decoding needs the custom logic.
+type anthropicText string
+
+// UnmarshalJSON accepts either a JSON string or an array of content blocks.
+func (t *anthropicText) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = anthropicText(s)
+		return nil
+	}
+
+	var blocks []anthropicContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return fmt.Errorf("content must be a string or an array of content blocks")
+	}
+
+	var sb strings.Builder
+	for _, b := range blocks {
+		if b.Type != "text" {
+			return fmt.Errorf("unsupported content block type %q (only \"text\" is supported)", b.Type)
+		}
+		sb.WriteString(b.Text)
+	}
+	*t = anthropicText(sb.String())
+	return nil
+}
+
+// anthropicErrorBody is the "error" object in Anthropic's error responses
+// and in the "error" SSE event.
+type anthropicErrorBody struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// anthropicStopReason is reported on every response we emit. ChatResponse
+// doesn't carry the upstream finish reason, and the only way our streams
+// end successfully is the model finishing its turn.
+const anthropicStopReason = "end_turn"
+
+// DecodeAnthropicRequest reads an Anthropic Messages API request body and
+// translates it into a unified ChatRequest. The top-level "system" field
+// becomes a leading system message — the inverse of toAnthropicRequest —
+// so every provider adapter sees the same shape no matter which ingress
+// endpoint the request arrived on.
+func DecodeAnthropicRequest(r io.Reader) (*ChatRequest, error) {
+	var ar anthropicRequest
+	if err := json.NewDecoder(r).Decode(&ar); err != nil {
+		return nil, err
+	}
+
+	req := &ChatRequest{
+		Model:     ar.Model,
+		Stream:    ar.Stream,
+		MaxTokens: ar.MaxTokens,
+	}
+
+	if ar.System != "" {
+		req.Messages = append(req.Messages, Message{
+			Role:    "system",
+			Content: string(ar.System),
+		})
+	}
+
+	for _, msg := range ar.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return nil, fmt.Errorf("invalid message role %q (must be \"user\" or \"assistant\")", msg.Role)
+		}
+		req.Messages = append(req.Messages, Message{
+			Role:    msg.Role,
+			Content: string(msg.Content),
+		})
+	}
+
+	return req, nil
+}
+
+// EncodeAnthropicResponse writes a unified ChatResponse to w as an
+// Anthropic Messages API response body.
+func EncodeAnthropicResponse(w io.Writer, resp *ChatResponse) error {
+	return json.NewEncoder(w).Encode(anthropicResponse{
+		ID:         resp.ID,
+		Type:       "message",
+		Role:       "assistant",
+		Content:    []anthropicContentBlock{{Type: "text", Text: resp.Content}},
+		Model:      resp.Model,
+		StopReason: anthropicStopReason,
+		Usage: anthropicUsage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+		},
+	})
+}
+
+// EncodeAnthropicError writes an Anthropic-format error body:
+//
+//	{"type": "error", "error": {"type": "rate_limit_error", "message": "..."}}
+func EncodeAnthropicError(w io.Writer, status int, message string) error {
+	return json.NewEncoder(w).Encode(struct {
+		Type  string             `json:"type"`
+		Error anthropicErrorBody `json:"error"`
+	}{
+		Type:  "error",
+		Error: anthropicErrorBody{Type: anthropicErrorType(status), Message: message},
+	})
+}
+
+// anthropicErrorType maps an HTTP status to the error "type" string
+// Anthropic uses for it. The SDKs switch on this to raise typed exceptions.
+func anthropicErrorType(status int) string {
+	switch status {
+	case 400:
+		return "invalid_request_error"
+	case 401:
+		return "authentication_error"
+	case 403:
+		return "permission_error"
+	case 404:
+		return "not_found_error"
+	case 413:
+		return "request_too_large"
+	case 429:
+		return "rate_limit_error"
+	case 529:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Streaming: StreamChunk → Anthropic named SSE events
+// ---------------------------------------------------------------------------
+
+// AnthropicEvent is one named server-sent event in Anthropic's streaming
+// format. The stream package handles the SSE framing and flushing; this
+// package only knows which events to emit and what their payloads look like.
+type AnthropicEvent struct {
+	Name string // SSE "event:" field, e.g. "content_block_delta"
+	Data any    // JSON-serializable payload for the "data:" field
+}
+
+// AnthropicStreamEncoder converts a sequence of unified StreamChunks into
+// Anthropic's named events. It's stateful because Anthropic's stream has a
+// preamble (message_start + content_block_start) that must be sent exactly
+// once before the first text delta — the reverse of how the outbound
+// adapter collects metadata across events.
+//
+// The zero value is ready to use. One encoder per response stream.
+type AnthropicStreamEncoder struct {
+	started bool
+}
+
+// Encode returns the events to emit for one chunk, in order. The final
+// (Done) chunk closes the content block and the message.
+func (e *AnthropicStreamEncoder) Encode(chunk StreamChunk) []AnthropicEvent {
+	var events []AnthropicEvent
+	index := 0
+
+	if !e.started {
+		e.started = true
+		events = append(events,
+			AnthropicEvent{Name: "message_start", Data: anthropicStreamEvent{
+				Type: "message_start",
+				Message: &anthropicEventMessage{
+					ID:      chunk.ID,
+					Type:    "message",
+					Role:    "assistant",
+					Content: []anthropicContentBlock{},
+					Model:   chunk.Model,
+				},
+			}},
+			AnthropicEvent{Name: "content_block_start", Data: anthropicStreamEvent{
+				Type:         "content_block_start",
+				Index:        &index,
+				ContentBlock: &anthropicContentBlock{Type: "text"},
+			}},
+		)
+	}
+
+	if chunk.Delta != "" {
+		events = append(events, AnthropicEvent{Name: "content_block_delta", Data: anthropicStreamEvent{
+			Type:  "content_block_delta",
+			Index: &index,
+			Delta: &anthropicEventDelta{Type: "text_delta", Text: chunk.Delta},
+		}})
+	}
+
+	if chunk.Done {
+		// Our chunks only carry usage on the final chunk, so input tokens
+		// go on message_delta rather than message_start. The SDKs merge
+		// usage from both events, so totals still come out right.
+		var usage anthropicUsage
+		if chunk.Usage != nil {
+			usage = anthropicUsage{
+				InputTokens:  chunk.Usage.PromptTokens,
+				OutputTokens: chunk.Usage.CompletionTokens,
+			}
+		}
+		events = append(events,
+			AnthropicEvent{Name: "content_block_stop", Data: anthropicStreamEvent{
+				Type:  "content_block_stop",
+				Index: &index,
+			}},
+			AnthropicEvent{Name: "message_delta", Data: anthropicStreamEvent{
+				Type:  "message_delta",
+				Delta: &anthropicEventDelta{StopReason: anthropicStopReason},
+				Usage: &usage,
+			}},
+			AnthropicEvent{Name: "message_stop", Data: anthropicStreamEvent{
+				Type: "message_stop",
+			}},
+		)
+	}
+
+	return events
+}
+
+// AnthropicErrorEvent builds the "error" event sent when a stream fails
+// after headers are already on the wire. The message is generic: the
+// underlying error, which can carry the upstream provider's internals, is
+// for the log.
+func AnthropicErrorEvent() AnthropicEvent {
+	return AnthropicEvent{Name: "error", Data: anthropicStreamEvent{
+		Type:  "error",
+		Error: &anthropicErrorBody{Type: "api_error", Message: "upstream provider stream failed"},
+	}}
+}
@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------------
+// DecodeAnthropicRequest
+// ---------------------------------------------------------------------------
+
+func TestDecodeAnthropicRequest_StringContent(t *testing.T) {
+	body := `{
+		"model": "auto",
+		"max_tokens": 256,
+		"system": "You are terse.",
+		"stream": true,
+		"messages": [
+			{"role": "user", "content": "Hi"},
+			{"role": "assistant", "content": "Hello."},
+			{"role": "user", "content": "What is Go?"}
+		]
+	}`
+
+	req, err := DecodeAnthropicRequest(strings.NewReader(body))
+	require.NoError(t, err)
+
+	assert.Equal(t, "auto", req.Model)
+	assert.Equal(t, 256, req.MaxTokens)
+	assert.True(t, req.Stream)
+
+	// The top-level system field becomes a leading system message —
+	// the inverse of toAnthropicRequest.
+	require.Len(t, req.Messages, 4)
+	assert.Equal(t, Message{Role: "system", Content: "You are terse."}, req.Messages[0])
+	assert.Equal(t, Message{Role: "user", Content: "What is Go?"}, req.Messages[3])
+}
+
+func TestDecodeAnthropicRequest_ContentBlocks(t *testing.T) {
+	// The SDKs send content as an array of blocks when the caller does.
+	body := `{
+		"model": "claude-haiku-4-5-20251001",
+		"max_tokens": 64,
+		"system": [{"type": "text", "text": "Be brief."}],
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "Hello, "},
+				{"type": "text", "text": "world"}
+			]}
+		]
+	}`
+
+	req, err := DecodeAnthropicRequest(strings.NewReader(body))
+	require.NoError(t, err)
+
+	require.Len(t, req.Messages, 2)
+	assert.Equal(t, "Be brief.", req.Messages[0].Content)
+	assert.Equal(t, "Hello, world", req.Messages[1].Content)
+}
+
+func TestDecodeAnthropicRequest_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{
+			name:    "image_block",
+			body:    `{"model":"m","messages":[{"role":"user","content":[{"type":"image"}]}]}`,
+			wantErr: "image",
+		},
+		{
+			name:    "system_role_in_messages",
+			body:    `{"model":"m","messages":[{"role":"system","content":"x"}]}`,
+			wantErr: "role",
+		},
+		{
+			name:    "malformed_json",
+			body:    `{"model":`,
+			wantErr: "EOF",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeAnthropicRequest(strings.NewReader(tt.body))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// EncodeAnthropicResponse / EncodeAnthropicError
+// ---------------------------------------------------------------------------
+
+func TestEncodeAnthropicResponse(t *testing.T) {
+	var buf bytes.Buffer
+	err := EncodeAnthropicResponse(&buf, &ChatResponse{
+		ID:      "resp-1",
+		Model:   "gemini-2.0-flash",
+		Content: "Paris.",
+		Usage:   Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+	})
+	require.NoError(t, err)
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+
+	assert.Equal(t, "message", got["type"])
+	assert.Equal(t, "assistant", got["role"])
+	assert.Equal(t, "gemini-2.0-flash", got["model"])
+	assert.Equal(t, "end_turn", got["stop_reason"])
+	assert.Equal(t, []any{map[string]any{"type": "text", "text": "Paris."}}, got["content"])
+	assert.Equal(t, map[string]any{"input_tokens": 12.0, "output_tokens": 3.0}, got["usage"])
+}
+
+func TestEncodeAnthropicError(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, EncodeAnthropicError(&buf, 429, "slow down"))
+
+	assert.JSONEq(t,
+		`{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`,
+		buf.String(),
+	)
+}
+
+// ---------------------------------------------------------------------------
+// AnthropicStreamEncoder
+// ---------------------------------------------------------------------------
+
+func TestAnthropicStreamEncoder(t *testing.T) {
+	var enc AnthropicStreamEncoder
+
+	var names []string
+	var events []AnthropicEvent
+	for _, chunk := range []StreamChunk{
+		{ID: "msg_1", Model: "m", Delta: "Hello"},
+		{ID: "msg_1", Model: "m", Delta: " world"},
+		{ID: "msg_1", Model: "m", Done: true, Usage: &Usage{PromptTokens: 5, CompletionTokens: 2}},
+	} {
+		for _, e := range enc.Encode(chunk) {
+			names = append(names, e.Name)
+			events = append(events, e)
+		}
+	}
+
+	// The preamble is sent once, before the first delta.
+	assert.Equal(t, []string{
+		"message_start",
+		"content_block_start",
+		"content_block_delta",
+		"content_block_delta",
+		"content_block_stop",
+		"message_delta",
+		"message_stop",
+	}, names)
+
+	start, err := json.Marshal(events[0].Data)
+	require.NoError(t, err)
+	assert.Contains(t, string(start), `"content":[]`)
+	assert.Contains(t, string(start), `"id":"msg_1"`)
+
+	delta, err := json.Marshal(events[2].Data)
+	require.NoError(t, err)
+	assert.JSONEq(t,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		string(delta),
+	)
+
+	msgDelta, err := json.Marshal(events[5].Data)
+	require.NoError(t, err)
+	assert.JSONEq(t,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"input_tokens":5,"output_tokens":2}}`,
+		string(msgDelta),
+	)
+}
@@ -158,3 +158,19 @@ func TestAnthropicChatCompletion_HTTPErrors(t *testing.T) {
 		})
 	}
 }
+
+// ---------------------------------------------------------------------------
+// Token counting
+// ---------------------------------------------------------------------------
+
+func TestAnthropicCountTokens(t *testing.T) {
+	p := newAnthropicTestProvider(t, "anthropic_count_tokens")
+	var _ TokenCounter = p
+
+	req := simpleAnthropicRequest("What is the capital of France?")
+	req.Messages = append([]Message{{Role: "system", Content: "Answer in one word."}}, req.Messages...)
+
+	n, err := p.CountTokens(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 21, n)
+}
@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -33,6 +34,12 @@ type ProviderError struct {
 	// Message is the human-readable error detail from the provider's response body.
 	Message string
 
+	// Structured reports whether Message is an error message the body
+	// spelled out, as opposed to raw text from a body that wasn't a
+	// provider error (a proxy's HTML page, a stack trace). Only a
+	// structured message is fit to pass on to a client.
+	Structured bool
+
 	// Retryable indicates whether this error is worth retrying. True for
 	// transient failures (429 rate limit, 5xx server errors), false for
 	// permanent failures (401 bad key, 400 bad request).
@@ -84,32 +91,67 @@ func NewProviderError(providerName string, httpResp *http.Response) *ProviderErr
 	bodyBytes, err := io.ReadAll(io.LimitReader(httpResp.Body, 4096))
 
 	// Default message if we can't read the body.
-	message := "unknown error"
-
+	message, structured := "unknown error", false
 	if err == nil && len(bodyBytes) > 0 {
-		// Try to parse as JSON for a cleaner message.
-		// Many provider error responses look like:
-		//   {"error": {"message": "Rate limit exceeded", "type": "rate_limit_error"}}
-		//
-		// We try to extract a useful string from the JSON. If it's not
-		// valid JSON, we fall back to the raw body text.
-		var parsed map[string]any
-		if json.Unmarshal(bodyBytes, &parsed) == nil {
-			message = fmt.Sprintf("%v", parsed)
-		} else {
-			message = string(bodyBytes)
-		}
+		message, structured = parseErrorBody(bodyBytes)
 	}
 
 	return &ProviderError{
 		StatusCode: httpResp.StatusCode,
 		Provider:   providerName,
 		Message:    message,
+		Structured: structured,
 		Retryable:  isRetryable(httpResp.StatusCode),
 		RetryAfter: parseRetryAfter(httpResp.Header.Get("Retry-After")),
 	}
 }
 
+// parseErrorBody extracts the message from a provider's error body.
+// Anthropic, Gemini, and OpenAI-compatible APIs all nest it under "error":
+//
+//	{"error": {"message": "Rate limit exceeded", "type": "rate_limit_error"}}
+//	{"error": {"code": 400, "message": "...", "status": "INVALID_ARGUMENT"}}
+//
+// Some gateways in front of them send "error" as a plain string, or a
+// top-level "message". Those are structured. Other JSON gives "unknown
+// error" rather than a dump of the body; a body that isn't JSON is
+// returned as text for the log, unstructured.
+func parseErrorBody(body []byte) (message string, structured bool) {
+	var parsed struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	if json.Unmarshal(body, &parsed) != nil {
+		return strings.TrimSpace(string(body)), false
+	}
+
+	var detail struct {
+		Message string `json:"message"`
+	}
+	var text string
+	switch {
+	case json.Unmarshal(parsed.Error, &detail) == nil && detail.Message != "":
+		return detail.Message, true
+	case json.Unmarshal(parsed.Error, &text) == nil && text != "":
+		return text, true
+	case parsed.Message != "":
+		return parsed.Message, true
+	}
+	return "unknown error", false
+}
+
+// ClientError reports whether the provider rejected the request itself —
+// its content, not the gateway's credentials or the provider's health:
+// 400 (bad request), 413 (too large), or 422 (unprocessable). Sending the
+// same request again won't help, and the client is the one to fix it.
+func (e *ProviderError) ClientError() bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
 // isRetryable determines whether an HTTP status code represents a transient
 // failure that's worth retrying.
 //
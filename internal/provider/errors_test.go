@@ -77,6 +77,7 @@ func TestNewProviderError_JSONBody(t *testing.T) {
 	assert.Equal(t, "anthropic", err.Provider)
 	assert.True(t, err.Retryable)
 	assert.Contains(t, err.Message, "Rate limit exceeded")
+	assert.True(t, err.Structured)
 }
 
 func TestNewProviderError_PlainTextBody(t *testing.T) {
@@ -92,6 +93,7 @@ func TestNewProviderError_PlainTextBody(t *testing.T) {
 	assert.Equal(t, "google", err.Provider)
 	assert.True(t, err.Retryable)
 	assert.Equal(t, "Service Unavailable", err.Message)
+	assert.False(t, err.Structured)
 }
 
 func TestNewProviderError_RetryAfterHeader(t *testing.T) {
@@ -119,6 +121,36 @@ func TestNewProviderError_NonRetryable(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, err.StatusCode)
 }
 
+func TestParseErrorBody(t *testing.T) {
+	tests := []struct {
+		name, body     string
+		wantMessage    string
+		wantStructured bool
+	}{
+		{"anthropic", `{"type": "error", "error": {"type": "invalid_request_error", "message": "max_tokens: must be positive"}}`,
+			"max_tokens: must be positive", true},
+		{"gemini", `{"error": {"code": 400, "message": "Invalid role: robot", "status": "INVALID_ARGUMENT"}}`,
+			"Invalid role: robot", true},
+		{"string error", `{"error": "invalid api key"}`, "invalid api key", true},
+		{"top-level message", `{"message": "Forbidden"}`, "Forbidden", true},
+		{"other JSON isn't dumped", `{"trace": "internal/handler.go:42", "node": "10.0.0.7"}`, "unknown error", false},
+		{"plain text", "  upstream connect error\n", "upstream connect error", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, structured := parseErrorBody([]byte(tt.body))
+			assert.Equal(t, tt.wantMessage, message)
+			assert.Equal(t, tt.wantStructured, structured)
+		})
+	}
+}
+
+func TestProviderError_ClientError(t *testing.T) {
+	for status, want := range map[int]bool{400: true, 413: true, 422: true, 401: false, 403: false, 404: false, 429: false, 500: false} {
+		assert.Equal(t, want, (&ProviderError{StatusCode: status}).ClientError(), "status %d", status)
+	}
+}
+
 func TestProviderError_ErrorString(t *testing.T) {
 	err := &ProviderError{
 		StatusCode: 429,
@@ -465,3 +465,67 @@ func (g *GoogleProvider) ChatCompletionStream(ctx context.Context, req *ChatRequ
 	// will do: for chunk := range ch { /* process each chunk */ }
 	return ch, nil
 }
+
+// ---------------------------------------------------------------------------
+// Token counting: CountTokens
+// ---------------------------------------------------------------------------
+
+// geminiCountRequest is the request body for countTokens. Bare "contents"
+// can't carry a system instruction, so the whole generateContent request
+// is nested instead; it must name its model.
+type geminiCountRequest struct {
+	GenerateContentRequest geminiCountContentRequest `json:"generateContentRequest"`
+}
+
+// geminiCountContentRequest is a geminiRequest with the model it targets.
+type geminiCountContentRequest struct {
+	Model string `json:"model"` // "models/{model}"
+	*geminiRequest
+}
+
+// geminiCountResponse is the response from countTokens.
+type geminiCountResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
+// CountTokens asks Gemini's countTokens endpoint how many input tokens req
+// would use. Like Anthropic's, it doesn't run the model but is a network
+// round trip.
+func (g *GoogleProvider) CountTokens(ctx context.Context, req *ChatRequest) (int, error) {
+	gr := toGeminiRequest(req)
+	gr.GenerationConfig = nil // counts input only
+	body, err := json.Marshal(geminiCountRequest{
+		GenerateContentRequest: geminiCountContentRequest{
+			Model:         "models/" + req.Model,
+			geminiRequest: gr,
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/models/%s:countTokens?key=%s",
+		g.baseURL, req.Model, g.apiKey,
+	)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := g.client.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("sending request to gemini: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return 0, NewProviderError("google", httpResp)
+	}
+
+	var countResp geminiCountResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&countResp); err != nil {
+		return 0, fmt.Errorf("decoding gemini response: %w", err)
+	}
+	return countResp.TotalTokens, nil
+}
@@ -2,6 +2,7 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"testing"
 
@@ -200,3 +201,41 @@ func TestGoogleChatCompletion_MalformedResponse(t *testing.T) {
 	// The error message should mention the decoding failure.
 	assert.Contains(t, err.Error(), "decoding gemini response")
 }
+
+// ---------------------------------------------------------------------------
+// Token counting
+// ---------------------------------------------------------------------------
+
+func TestGoogleCountTokens(t *testing.T) {
+	p := newGoogleTestProvider(t, "google_count_tokens")
+	var _ TokenCounter = p
+
+	req := simpleGoogleRequest("What is the capital of France?")
+	req.Messages = append([]Message{{Role: "system", Content: "Answer in one word."}}, req.Messages...)
+	req.MaxTokens = 100 // an output setting; countTokens must not get it
+
+	n, err := p.CountTokens(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 14, n)
+}
+
+func TestGeminiCountRequest_JSON(t *testing.T) {
+	// The cassette matcher ignores bodies, so check the nesting here: the
+	// generateContent request's fields sit beside "model", not under it.
+	gr := toGeminiRequest(&ChatRequest{
+		Model: "gemini-2.0-flash",
+		Messages: []Message{
+			{Role: "system", Content: "Answer in one word."},
+			{Role: "user", Content: "Hi"},
+		},
+	})
+	body, err := json.Marshal(geminiCountRequest{
+		GenerateContentRequest: geminiCountContentRequest{Model: "models/gemini-2.0-flash", geminiRequest: gr},
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"generateContentRequest": {
+		"model": "models/gemini-2.0-flash",
+		"contents": [{"role": "user", "parts": [{"text": "Hi"}]}],
+		"systemInstruction": {"parts": [{"text": "Answer in one word."}]}
+	}}`, string(body))
+}
@@ -37,6 +37,16 @@ type Provider interface {
 	ChatCompletionStream(ctx context.Context, req *ChatRequest) (<-chan StreamChunk, error)
 }
 
+// TokenCounter is an optional capability for providers whose API can count
+// a request's input tokens without running it (Anthropic's count_tokens,
+// Gemini's countTokens). Callers check for it with a type assertion and
+// fall back to a local estimate when a provider doesn't implement it.
+type TokenCounter interface {
+	// CountTokens returns the number of input tokens req would use on
+	// req.Model — the prompt_tokens a completion would report.
+	CountTokens(ctx context.Context, req *ChatRequest) (int, error)
+}
+
 // ---------------------------------------------------------------------------
 // Unified request types
 // ---------------------------------------------------------------------------
@@ -0,0 +1,194 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// BudgetError is returned when a request's pre-flight estimate exceeds its
+// cost cap on every model the router may use. Model is the cheapest of
+// them, and EstimateUSD its estimate.
+type BudgetError struct {
+	Model       string
+	EstimateUSD float64
+	MaxCostUSD  float64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("estimated cost $%.6f on %s exceeds the request's cost cap of $%.6f",
+		e.EstimateUSD, e.Model, e.MaxCostUSD)
+}
+
+// OverBudget returns the estimate and the cap it exceeded, so callers can
+// recognize the error without importing this package.
+func (e *BudgetError) OverBudget() (estimate, maxCost float64) {
+	return e.EstimateUSD, e.MaxCostUSD
+}
+
+// maxCost returns the request's cost cap: requested (its X-Max-Cost,
+// which the server parses and validates) if set, else its API key's entry
+// in Budget.Keys, else Budget.DefaultMaxCost. Zero means no cap.
+func (rt *Router) maxCost(header http.Header, requested float64) float64 {
+	if requested > 0 {
+		return requested
+	}
+	if key := apiKey(header); key != "" {
+		for _, kb := range rt.cfg.Budget.Keys {
+			if kb.APIKey == key {
+				return kb.MaxCost
+			}
+		}
+	}
+	return rt.cfg.Budget.DefaultMaxCost
+}
+
+// EstimateCost is the pre-flight estimate of req's cost on model: its
+// prompt tokens plus max_tokens, at the model's price. Without max_tokens
+// the output is assumed to be the typical completion length
+// (EstimatedOutputTokens). ok=false if the model has no price.
+func (rt *Router) EstimateCost(model string, req *provider.ChatRequest) (cost float64, ok bool) {
+	outputTokens := rt.cfg.EstimatedOutputTokens
+	if outputTokens <= 0 {
+		outputTokens = defaultOutputTokens
+	}
+	var messages []provider.Message
+	if req != nil {
+		messages = req.Messages
+		if req.MaxTokens > 0 {
+			outputTokens = req.MaxTokens
+		}
+	}
+	return rt.estimateCost(model, rt.promptTokens(model, messages), outputTokens)
+}
+
+// CheckBudget holds a request for a pinned model to its cost cap (see
+// maxCost for header and maxCost). There's nothing to downgrade to, so
+// it returns a *BudgetError if the estimate exceeds the cap. Models
+// without a price always pass.
+func (rt *Router) CheckBudget(model string, req *provider.ChatRequest, header http.Header, maxCost float64) error {
+	limit := rt.maxCost(header, maxCost)
+	if limit <= 0 {
+		return nil
+	}
+	if est, ok := rt.EstimateCost(model, req); ok && est > limit {
+		metrics.BudgetChecks.WithLabelValues(metrics.BudgetRejected).Inc()
+		return &BudgetError{Model: model, EstimateUSD: est, MaxCostUSD: limit}
+	}
+	metrics.BudgetChecks.WithLabelValues(metrics.BudgetWithin).Inc()
+	return nil
+}
+
+// FitBudget holds the decision Decide makes for the same arguments to the
+// request's cost cap (see maxCost for header and maxCost), for a request
+// the cache couldn't serve. The decision is made again without being
+// counted; only the budget check is. It returns the model to call instead
+// and why — the same as Decide's within the cap, else a downgrade — or a
+// *BudgetError if nothing fits. model is empty if the request has no cap.
+func (rt *Router) FitBudget(embedding []float32, req *provider.ChatRequest, header http.Header, strategy string, providerName string, latencyBudget time.Duration, experimentName string, maxCost float64) (model, reason string, err error) {
+	limit := rt.maxCost(header, maxCost)
+	if limit <= 0 {
+		return "", "", nil
+	}
+	d, err := rt.decide(embedding, req, header, strategy, providerName, latencyBudget, experimentName, false)
+	if err != nil {
+		return "", "", err
+	}
+	if err := rt.fitBudget(&d, req, limit, true); err != nil {
+		return "", "", err
+	}
+	return d.Model, d.Reason, nil
+}
+
+// fitBudget holds d to limit, the request's cost cap. If d's model is
+// estimated over it, d is downgraded to the best model that fits (see
+// downgrades) and whose context window the request fits; if none does,
+// fitBudget returns a *BudgetError. Models without a price can't be
+// estimated, so they pass as chosen but aren't downgraded to.
+func (rt *Router) fitBudget(d *Explanation, req *provider.ChatRequest, limit float64, record bool) error {
+	if limit <= 0 {
+		return nil
+	}
+	d.MaxCostUSD = &limit
+
+	outcome := metrics.BudgetWithin
+	defer func() {
+		if record {
+			metrics.BudgetChecks.WithLabelValues(outcome).Inc()
+		}
+	}()
+
+	est, ok := rt.EstimateCost(d.Model, req)
+	if !ok {
+		return nil
+	}
+	if est <= limit {
+		d.EstimatedCostUSD = &est
+		return nil
+	}
+
+	// The arm that decided owns the tier lists.
+	decider := rt
+	if e := rt.experiment(d.Experiment); e != nil {
+		decider = e.arm
+	}
+	cheapest, cheapestEst := d.Model, est
+	for _, m := range decider.downgrades(*d) {
+		mEst, ok := rt.EstimateCost(m, req)
+		if !ok || !rt.fitsContext(m, req) {
+			continue
+		}
+		if mEst <= limit {
+			d.Reason += fmt.Sprintf("; over cost cap on %s ($%.6f > $%.6f), downgraded to %s ($%.6f)",
+				d.Model, est, limit, m, mEst)
+			d.DowngradedFrom, d.Model, d.EstimatedCostUSD = d.Model, m, &mEst
+			outcome = metrics.BudgetDowngraded
+			return nil
+		}
+		if mEst < cheapestEst {
+			cheapest, cheapestEst = m, mEst
+		}
+	}
+	outcome = metrics.BudgetRejected
+	return &BudgetError{Model: cheapest, EstimateUSD: cheapestEst, MaxCostUSD: limit}
+}
+
+// downgrades lists the models d could fall back to, best first: the lower
+// tiers of d's provider, highest first, or for the cost strategy every
+// other scored model, highest quality first. Models a rule or experiment
+// forced have no fallbacks.
+func (rt *Router) downgrades(d Explanation) []string {
+	var models []string
+	switch {
+	case d.Strategy == "rule" || d.Strategy == "experiment":
+		return nil
+	case d.Strategy == "cost":
+		for name := range rt.cfg.QualityScores {
+			if name != d.Model {
+				models = append(models, name)
+			}
+		}
+		sort.Slice(models, func(i, j int) bool {
+			qi, qj := rt.cfg.QualityScores[models[i]], rt.cfg.QualityScores[models[j]]
+			if qi != qj {
+				return qi > qj
+			}
+			return models[i] < models[j]
+		})
+	case d.Provider != "":
+		tiers := rt.tiers[d.Provider]
+		for i := len(tiers) - 1; i >= 0; i-- {
+			if tiers[i].Model == d.Model {
+				for j := i - 1; j >= 0; j-- {
+					models = append(models, tiers[j].Model)
+				}
+				break
+			}
+		}
+	}
+	return models
+}
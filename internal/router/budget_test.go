@@ -0,0 +1,136 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/howard-nolan/llmrouter/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateCost(t *testing.T) {
+	cfg, costs := costConfig()
+	rt := New(cfg, costs, nil)
+
+	req := promptOf(4000)
+	req.MaxTokens = 1000
+	est, ok := rt.EstimateCost("claude-sonnet-4-5-20250929", req)
+	require.True(t, ok)
+	assert.InDelta(t, 0.018, est, 1e-12)
+
+	// Without max_tokens, the typical completion length is assumed.
+	req.MaxTokens = 0
+	est, ok = rt.EstimateCost("claude-haiku-4-5-20251001", req)
+	require.True(t, ok)
+	assert.InDelta(t, 0.006, est, 1e-12) // 1,000 output tokens, from costConfig
+
+	_, ok = rt.EstimateCost("unpriced-model", req)
+	assert.False(t, ok)
+}
+
+// The FitBudget tests send 1,000 prompt tokens with max_tokens 1,000,
+// estimated (at costConfig prices) at $0.018 on sonnet, $0.01125 on
+// gemini-2.5-pro, $0.006 on haiku, and $0.0005 on gemini-2.0-flash.
+func TestFitBudget_DowngradesTier(t *testing.T) {
+	cfg, costs := costConfig()
+	rt := New(cfg, costs, &mockClassifier{score: 0.8})
+	req := promptOf(4000)
+	req.MaxTokens = 1000
+
+	// Decide ignores the cap: a cache hit would serve the request for free.
+	model, _, err := rt.Decide(dummyEmbedding, req, nil, "auto", "anthropic", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "claude-sonnet-4-5-20250929", model)
+
+	model, reason, err := rt.FitBudget(dummyEmbedding, req, nil, "auto", "anthropic", 0, "", 0.01)
+	require.NoError(t, err)
+	assert.Equal(t, "claude-haiku-4-5-20251001", model)
+	assert.Contains(t, reason, "downgraded to claude-haiku-4-5-20251001")
+
+	// Within the cap, nothing changes.
+	model, _, err = rt.FitBudget(dummyEmbedding, req, nil, "auto", "anthropic", 0, "", 0.02)
+	require.NoError(t, err)
+	assert.Equal(t, "claude-sonnet-4-5-20250929", model)
+
+	// Without a cap, there's nothing to fit.
+	model, _, err = rt.FitBudget(dummyEmbedding, req, nil, "auto", "anthropic", 0, "", 0)
+	require.NoError(t, err)
+	assert.Empty(t, model)
+}
+
+func TestFitBudget_RejectsWhenNothingFits(t *testing.T) {
+	cfg, costs := costConfig()
+	rt := New(cfg, costs, &mockClassifier{score: 0.8})
+	req := promptOf(4000)
+	req.MaxTokens = 1000
+
+	_, _, err := rt.FitBudget(dummyEmbedding, req, nil, "auto", "anthropic", 0, "", 0.001)
+	var be *BudgetError
+	require.True(t, errors.As(err, &be))
+	assert.Equal(t, "claude-haiku-4-5-20251001", be.Model, "reports the cheapest option")
+	assert.InDelta(t, 0.006, be.EstimateUSD, 1e-12)
+	assert.Equal(t, 0.001, be.MaxCostUSD)
+}
+
+func TestFitBudget_CostStrategy(t *testing.T) {
+	cfg, costs := costConfig()
+	rt := New(cfg, costs, &mockClassifier{score: 0.99})
+	req := promptOf(4000)
+	req.MaxTokens = 1000
+
+	// Sonnet is the cost strategy's pick; gemini-2.5-pro is the best
+	// model under the cap.
+	model, _, err := rt.FitBudget(dummyEmbedding, req, nil, "cost", "", 0, "", 0.012)
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.5-pro", model)
+}
+
+func TestFitBudget_ForcedModelIsNotDowngraded(t *testing.T) {
+	cfg, costs := costConfig()
+	cfg.Rules = []config.RoutingRule{{Name: "vip", Model: "claude-sonnet-4-5-20250929"}}
+	rt := New(cfg, costs, &mockClassifier{score: 0.1})
+	req := promptOf(4000)
+	req.MaxTokens = 1000
+
+	_, _, err := rt.FitBudget(dummyEmbedding, req, nil, "auto", "anthropic", 0, "", 0.01)
+	var be *BudgetError
+	require.True(t, errors.As(err, &be))
+	assert.Equal(t, "claude-sonnet-4-5-20250929", be.Model)
+}
+
+func TestMaxCost(t *testing.T) {
+	cfg, costs := costConfig()
+	cfg.Budget = config.BudgetConfig{
+		DefaultMaxCost: 0.05,
+		Keys:           []config.KeyBudget{{APIKey: "team-a", MaxCost: 0.01}},
+	}
+	rt := New(cfg, costs, nil)
+
+	assert.Equal(t, 0.05, rt.maxCost(nil, 0))
+	assert.Equal(t, 0.01, rt.maxCost(http.Header{"Authorization": {"Bearer team-a"}}, 0))
+	assert.Equal(t, 0.05, rt.maxCost(http.Header{"Authorization": {"Bearer team-b"}}, 0))
+
+	// The request's own cap overrides the key's default.
+	h := http.Header{"Authorization": {"Bearer team-a"}}
+	assert.Equal(t, 0.2, rt.maxCost(h, 0.2))
+}
+
+func TestCheckBudget(t *testing.T) {
+	cfg, costs := costConfig()
+	rt := New(cfg, costs, nil)
+	req := promptOf(4000)
+	req.MaxTokens = 1000
+
+	assert.NoError(t, rt.CheckBudget("claude-sonnet-4-5-20250929", req, nil, 0), "no cap")
+	assert.NoError(t, rt.CheckBudget("claude-haiku-4-5-20251001", req, nil, 0.01))
+	assert.NoError(t, rt.CheckBudget("unpriced-model", req, nil, 0.01))
+
+	err := rt.CheckBudget("claude-sonnet-4-5-20250929", req, nil, 0.01)
+	var be *BudgetError
+	require.True(t, errors.As(err, &be))
+	estimate, limit := be.OverBudget()
+	assert.InDelta(t, 0.018, estimate, 1e-12)
+	assert.Equal(t, 0.01, limit)
+}
@@ -1,9 +1,12 @@
+//go:build !noonnx
+
 package router
 
 import (
 	"fmt"
 	"time"
 
+	"github.com/howard-nolan/llmrouter/internal/features"
 	"github.com/howard-nolan/llmrouter/internal/metrics"
 	ort "github.com/yalue/onnxruntime_go"
 )
@@ -15,21 +18,35 @@ import (
 // (the embedder does this in main.go via ort.InitializeEnvironment). ONNX
 // Runtime only allows one environment per process — creating a second panics.
 type ONNXClassifier struct {
-	session  *ort.DynamicAdvancedSession
-	inputDim int
+	session *ort.DynamicAdvancedSession
+	layout  features.Layout
 }
 
 // NewONNXClassifier loads the ONNX complexity classifier model and returns
 // a ready-to-use classifier. modelPath points to the .onnx file exported
-// by training/export_onnx.py.
+// by training/export_onnx.py; embeddingDim is the embedding dimension.
 //
 // The ONNX model has:
-//   - Input:  "float_input"   — shape [1, inputDim], float32 embedding vector
+//   - Input:  "float_input"   — shape [1, width], float32 model input: the
+//     embedding, followed by the request features if the model was
+//     trained with them (width says which; see features.LayoutFor)
 //   - Output: "probabilities" — shape [1, 2], float32 class probabilities
 //
 // We only request "probabilities" (not "label") because the router needs
 // the continuous score, not a binary decision.
-func NewONNXClassifier(modelPath string, inputDim int) (*ONNXClassifier, error) {
+func NewONNXClassifier(modelPath string, embeddingDim int) (*ONNXClassifier, error) {
+	inputs, _, err := ort.GetInputOutputInfo(modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading classifier inputs from %s: %w", modelPath, err)
+	}
+	if len(inputs) != 1 || len(inputs[0].Dimensions) != 2 {
+		return nil, fmt.Errorf("classifier %s: want one [1, width] input", modelPath)
+	}
+	layout, err := features.LayoutFor(embeddingDim, int(inputs[0].Dimensions[1]))
+	if err != nil {
+		return nil, fmt.Errorf("classifier %s: %w", modelPath, err)
+	}
+
 	session, err := ort.NewDynamicAdvancedSession(
 		modelPath,
 		[]string{"float_input"},
@@ -41,31 +58,29 @@ func NewONNXClassifier(modelPath string, inputDim int) (*ONNXClassifier, error)
 	}
 
 	return &ONNXClassifier{
-		session:  session,
-		inputDim: inputDim,
+		session: session,
+		layout:  layout,
 	}, nil
 }
 
 // Classify returns a complexity score between 0 (simple) and 1 (complex)
-// for the given embedding vector. The router compares this score against
+// for the given request features. The router compares this score against
 // its configured threshold to decide cheap vs. quality model.
-func (c *ONNXClassifier) Classify(embedding []float32) (float64, error) {
+func (c *ONNXClassifier) Classify(f features.Features) (float64, error) {
 	start := time.Now()
 	defer func() {
 		metrics.ClassificationDuration.Observe(time.Since(start).Seconds())
 	}()
 
-	if len(embedding) != c.inputDim {
-		return 0, fmt.Errorf(
-			"classifier: expected embedding of dimension %d, got %d",
-			c.inputDim, len(embedding),
-		)
+	input, err := c.layout.Vector(f)
+	if err != nil {
+		return 0, err
 	}
 
-	// Create the input tensor: shape [1, inputDim] (batch of one).
+	// Create the input tensor: shape [1, width] (batch of one).
 	inputTensor, err := ort.NewTensor(
-		ort.Shape{1, int64(c.inputDim)},
-		embedding,
+		ort.Shape{1, int64(c.layout.Width())},
+		input,
 	)
 	if err != nil {
 		return 0, fmt.Errorf("creating classifier input tensor: %w", err)
@@ -91,9 +106,7 @@ func (c *ONNXClassifier) Classify(embedding []float32) (float64, error) {
 
 	// Read the probability of class 1 (needs-expensive) as the complexity score.
 	probs := outputTensor.GetData()
-	score := float64(probs[1])
-	metrics.ComplexityScore.Observe(score)
-	return score, nil
+	return float64(probs[1]), nil
 }
 
 // Close releases the ONNX session. Does NOT destroy the ONNX Runtime
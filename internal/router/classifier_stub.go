@@ -0,0 +1,28 @@
+//go:build noonnx
+
+package router
+
+import (
+	"errors"
+
+	"github.com/howard-nolan/llmrouter/internal/features"
+)
+
+// ErrONNXUnavailable is returned by NewONNXClassifier in binaries built with
+// -tags noonnx.
+var ErrONNXUnavailable = errors.New("router: built without ONNX support (noonnx)")
+
+// ONNXClassifier is a placeholder in noonnx builds so callers compile
+// unchanged. NewONNXClassifier never returns one.
+type ONNXClassifier struct{}
+
+// NewONNXClassifier always fails in noonnx builds.
+func NewONNXClassifier(modelPath string, embeddingDim int) (*ONNXClassifier, error) {
+	return nil, ErrONNXUnavailable
+}
+
+func (c *ONNXClassifier) Classify(f features.Features) (float64, error) {
+	return 0, ErrONNXUnavailable
+}
+
+func (c *ONNXClassifier) Close() {}
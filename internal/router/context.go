@@ -0,0 +1,190 @@
+package router
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// Truncation strategies for ContextConfig.Truncation.
+const (
+	TruncateNone       = "none"
+	TruncateDropOldest = "drop_oldest"
+)
+
+// ContextError is returned when a request is larger than its model's
+// context window and can't be made to fit.
+type ContextError struct {
+	Model  string
+	Tokens int // estimated prompt tokens plus max_tokens
+	Window int
+}
+
+func (e *ContextError) Error() string {
+	return fmt.Sprintf("request is an estimated %d tokens (prompt plus max_tokens), over the %d-token context window of %s",
+		e.Tokens, e.Window, e.Model)
+}
+
+// ContextOverflow returns the request's size and the window it exceeded,
+// so callers can recognize the error without importing this package.
+func (e *ContextError) ContextOverflow() (tokens, window int) {
+	return e.Tokens, e.Window
+}
+
+// ValidTruncation reports whether s names a truncation strategy. Empty
+// means TruncateNone.
+func ValidTruncation(s string) bool {
+	return s == "" || s == TruncateNone || s == TruncateDropOldest
+}
+
+// requestTokens is the context req takes up on model: its estimated
+// prompt plus the output it reserves with max_tokens.
+func (rt *Router) requestTokens(model string, messages []provider.Message, maxTokens int) int {
+	return rt.promptTokens(model, messages) + maxTokens
+}
+
+// fitsContext reports whether req fits model's context window. Models
+// without a configured window take anything.
+func (rt *Router) fitsContext(model string, req *provider.ChatRequest) bool {
+	window, ok := rt.cfg.Context.Windows[model]
+	if !ok || req == nil {
+		return true
+	}
+	return rt.requestTokens(model, req.Messages, req.MaxTokens) <= window
+}
+
+// fitContext moves d to a larger-context model (see upgrades) if the
+// request doesn't fit d's. If none fits either, d is left alone: the
+// request is truncated or rejected when it's sent (FitContext).
+func (rt *Router) fitContext(d *Explanation, req *provider.ChatRequest, record bool) {
+	if rt.fitsContext(d.Model, req) {
+		return
+	}
+
+	// The arm that decided owns the tier lists.
+	decider := rt
+	if e := rt.experiment(d.Experiment); e != nil {
+		decider = e.arm
+	}
+	for _, m := range decider.upgrades(*d) {
+		if _, ok := rt.cfg.Context.Windows[m]; ok && rt.fitsContext(m, req) {
+			d.Reason += fmt.Sprintf("; over the %d-token context window of %s, rerouted to %s",
+				rt.cfg.Context.Windows[d.Model], d.Model, m)
+			d.ReroutedFrom, d.Model = d.Model, m
+			if record {
+				metrics.ContextOverflows.WithLabelValues(metrics.ContextRerouted).Inc()
+			}
+			return
+		}
+	}
+}
+
+// upgrades lists the models d could move to for a larger context window,
+// first choice first: the higher tiers of d's provider, cheapest first,
+// then the other providers' tiers; or for the cost strategy every scored
+// model at least as good as d's, lowest quality first. Models a rule or
+// experiment forced have no alternatives. Only models with a configured
+// window are ever chosen.
+func (rt *Router) upgrades(d Explanation) []string {
+	var models []string
+	switch {
+	case d.Strategy == "rule" || d.Strategy == "experiment":
+		return nil
+	case d.Strategy == "cost":
+		floor := rt.cfg.QualityScores[d.Model]
+		for name, q := range rt.cfg.QualityScores {
+			if name != d.Model && q >= floor {
+				models = append(models, name)
+			}
+		}
+		sort.Slice(models, func(i, j int) bool {
+			qi, qj := rt.cfg.QualityScores[models[i]], rt.cfg.QualityScores[models[j]]
+			if qi != qj {
+				return qi < qj
+			}
+			return models[i] < models[j]
+		})
+	case d.Provider != "":
+		tiers := rt.tiers[d.Provider]
+		for i, t := range tiers {
+			if t.Model == d.Model {
+				for _, above := range tiers[i+1:] {
+					models = append(models, above.Model)
+				}
+				break
+			}
+		}
+		others := make([]string, 0, len(rt.tiers))
+		for name := range rt.tiers {
+			if name != d.Provider {
+				others = append(others, name)
+			}
+		}
+		slices.Sort(others)
+		for _, name := range others {
+			for _, t := range rt.tiers[name] {
+				models = append(models, t.Model)
+			}
+		}
+	}
+	return models
+}
+
+// FitContext makes req fit model's context window before it's sent. It
+// returns req itself if it already fits. Otherwise, with the drop_oldest
+// truncation strategy, it returns a copy without the oldest turns —
+// system messages and the latest message are always kept — and how many
+// messages were dropped; if that still doesn't fit, or truncation is off,
+// it returns a *ContextError.
+func (rt *Router) FitContext(model string, req *provider.ChatRequest) (*provider.ChatRequest, int, error) {
+	window, ok := rt.cfg.Context.Windows[model]
+	if !ok {
+		return req, 0, nil
+	}
+	tokens := rt.requestTokens(model, req.Messages, req.MaxTokens)
+	if tokens <= window {
+		return req, 0, nil
+	}
+	reject := func(tokens int) (*provider.ChatRequest, int, error) {
+		metrics.ContextOverflows.WithLabelValues(metrics.ContextRejected).Inc()
+		return nil, 0, &ContextError{Model: model, Tokens: tokens, Window: window}
+	}
+	if rt.cfg.Context.Truncation != TruncateDropOldest || len(req.Messages) == 0 {
+		return reject(tokens)
+	}
+
+	// Drop turns from the front of the conversation, skipping system
+	// messages, until the rest fits. A conversation can't open with the
+	// assistant, so a turn's reply goes with it.
+	last := len(req.Messages) - 1
+	dropped := make([]bool, len(req.Messages))
+	kept := slices.Clone(req.Messages)
+	for i := 0; i < last && tokens > window; i++ {
+		if req.Messages[i].Role == "system" {
+			continue
+		}
+		dropped[i] = true
+		for i+1 < last && req.Messages[i+1].Role == "assistant" {
+			i++
+			dropped[i] = true
+		}
+		kept = kept[:0]
+		for j, m := range req.Messages {
+			if !dropped[j] {
+				kept = append(kept, m)
+			}
+		}
+		tokens = rt.requestTokens(model, kept, req.MaxTokens)
+	}
+	if tokens > window {
+		return reject(tokens)
+	}
+
+	metrics.ContextOverflows.WithLabelValues(metrics.ContextTruncated).Inc()
+	truncated := *req
+	truncated.Messages = kept
+	return &truncated, len(req.Messages) - len(kept), nil
+}
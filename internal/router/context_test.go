@@ -0,0 +1,155 @@
+package router
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/howard-nolan/llmrouter/internal/config"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// windowed returns testConfig with small context windows: 1,000 tokens on
+// the Anthropic models, 2,000 on gemini-2.0-flash, and 10,000 on
+// gemini-2.5-pro.
+func windowed(truncation string) config.RoutingConfig {
+	cfg := testConfig()
+	cfg.Context = config.ContextConfig{
+		Windows: map[string]int{
+			"claude-haiku-4-5-20251001":  1000,
+			"claude-sonnet-4-5-20250929": 1000,
+			"gemini-2.0-flash":           2000,
+			"gemini-2.5-pro":             10000,
+		},
+		Truncation: truncation,
+	}
+	return cfg
+}
+
+// turns returns a conversation of n user/assistant turns of size
+// characters each, after a system prompt, ending with a user message.
+func turns(n, size int) *provider.ChatRequest {
+	req := &provider.ChatRequest{Messages: []provider.Message{{Role: "system", Content: "be brief"}}}
+	for i := range n {
+		req.Messages = append(req.Messages,
+			provider.Message{Role: "user", Content: strings.Repeat("u", size-1) + string(rune('0'+i))},
+			provider.Message{Role: "assistant", Content: strings.Repeat("a", size)},
+		)
+	}
+	req.Messages = append(req.Messages, provider.Message{Role: "user", Content: "and now?"})
+	return req
+}
+
+func TestDecide_ContextReroutes(t *testing.T) {
+	rt := New(windowed(""), nil, &mockClassifier{score: 0.1})
+
+	// 1,000 tokens fits haiku.
+	model, _, err := rt.Decide(dummyEmbedding, promptOf(4000), nil, "auto", "anthropic", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "claude-haiku-4-5-20251001", model)
+
+	// 1,500 tokens doesn't fit either Anthropic tier; Google's cheapest
+	// tier with room is next.
+	model, reason, err := rt.Decide(dummyEmbedding, promptOf(6000), nil, "auto", "anthropic", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.0-flash", model)
+	assert.Contains(t, reason, "rerouted to gemini-2.0-flash")
+
+	// max_tokens counts against the window too.
+	req := promptOf(6000)
+	req.MaxTokens = 1000
+	model, _, err = rt.Decide(dummyEmbedding, req, nil, "auto", "anthropic", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.5-pro", model)
+
+	// Nothing fits: the routed model stands, for FitContext to deal with.
+	model, _, err = rt.Decide(dummyEmbedding, promptOf(80000), nil, "auto", "anthropic", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "claude-haiku-4-5-20251001", model)
+}
+
+func TestDecide_ContextCostStrategy(t *testing.T) {
+	cfg, costs := costConfig()
+	cfg.Context = windowed("").Context
+	rt := New(cfg, costs, &mockClassifier{score: 0.2})
+
+	// Flash is the cost pick but too small; pro is the lowest-quality
+	// model at least as good that fits.
+	model, _, err := rt.Decide(dummyEmbedding, promptOf(12000), nil, "cost", "", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.5-pro", model)
+}
+
+func TestDecide_ContextForcedModelIsNotRerouted(t *testing.T) {
+	cfg := windowed("")
+	cfg.Rules = []config.RoutingRule{{Name: "vip", Model: "claude-sonnet-4-5-20250929"}}
+	rt := New(cfg, nil, &mockClassifier{score: 0.1})
+
+	model, _, err := rt.Decide(dummyEmbedding, promptOf(6000), nil, "auto", "anthropic", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "claude-sonnet-4-5-20250929", model)
+}
+
+func TestFitBudget_DowngradeMustFitContext(t *testing.T) {
+	cfg, costs := costConfig()
+	cfg.Context = config.ContextConfig{Windows: map[string]int{"claude-haiku-4-5-20251001": 1000}}
+	rt := New(cfg, costs, &mockClassifier{score: 0.8})
+	req := promptOf(6000)
+	req.MaxTokens = 1000
+
+	// Haiku would fit the cap but not the request.
+	_, _, err := rt.FitBudget(dummyEmbedding, req, nil, "auto", "anthropic", 0, "", 0.01)
+	var be *BudgetError
+	require.True(t, errors.As(err, &be))
+	assert.Equal(t, "claude-sonnet-4-5-20250929", be.Model)
+}
+
+func TestFitContext(t *testing.T) {
+	t.Run("fits", func(t *testing.T) {
+		rt := New(windowed(""), nil, nil)
+		req := promptOf(4000)
+		got, dropped, err := rt.FitContext("claude-haiku-4-5-20251001", req)
+		require.NoError(t, err)
+		assert.Same(t, req, got)
+		assert.Zero(t, dropped)
+
+		got, _, err = rt.FitContext("unlisted-model", promptOf(1<<20))
+		require.NoError(t, err)
+		assert.NotNil(t, got)
+	})
+
+	t.Run("rejects without truncation", func(t *testing.T) {
+		rt := New(windowed(TruncateNone), nil, nil)
+		_, _, err := rt.FitContext("claude-haiku-4-5-20251001", turns(3, 2000))
+		var ce *ContextError
+		require.True(t, errors.As(err, &ce))
+		tokens, window := ce.ContextOverflow()
+		assert.Equal(t, 1000, window)
+		assert.Equal(t, 3004, tokens)
+	})
+
+	t.Run("drops oldest turns", func(t *testing.T) {
+		rt := New(windowed(TruncateDropOldest), nil, nil)
+		req := turns(3, 1800) // ~2,700 tokens, ~900 a turn: two turns must go
+		got, dropped, err := rt.FitContext("claude-haiku-4-5-20251001", req)
+		require.NoError(t, err)
+		assert.Equal(t, 4, dropped)
+		require.Len(t, got.Messages, 4)
+		assert.Equal(t, "system", got.Messages[0].Role)
+		assert.True(t, strings.HasSuffix(got.Messages[1].Content, "2"), "keeps the newest turn")
+		assert.Equal(t, "assistant", got.Messages[2].Role)
+		assert.Equal(t, "and now?", got.Messages[3].Content)
+		assert.Len(t, req.Messages, 8, "the original request is untouched")
+	})
+
+	t.Run("rejects when the latest message alone is too big", func(t *testing.T) {
+		rt := New(windowed(TruncateDropOldest), nil, nil)
+		req := turns(1, 100)
+		req.Messages = append(req.Messages, provider.Message{Role: "user", Content: strings.Repeat("x", 8000)})
+		_, _, err := rt.FitContext("claude-haiku-4-5-20251001", req)
+		var ce *ContextError
+		assert.True(t, errors.As(err, &ce))
+	})
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// defaultOutputTokens is the typical completion length assumed when
+// RoutingConfig.EstimatedOutputTokens is unset.
+const defaultOutputTokens = 500
+
+// costCandidate is one model considered by the cost strategy.
+type costCandidate struct {
+	model   string
+	quality float64
+	cost    float64 // estimated USD for this request
+}
+
+// cheapestClearing implements the cost strategy: among every model with a
+// quality score and a price, pick the cheapest whose quality is at least
+// the prompt's complexity score. If nothing clears the bar, pick the
+// highest-quality model — the prompt is harder than anything configured.
+//
+// Output length is estimated as EstimatedOutputTokens scaled by complexity,
+// from half at score 0 to one and a half times at score 1: hard prompts
+// tend to get long answers, and output tokens dominate most price sheets.
+// Input is estimated per model, since tokenizers differ.
+func (rt *Router) cheapestClearing(score float64, messages []provider.Message) (model, reason string, err error) {
+	outputTokens := rt.estimateOutputTokens(score)
+
+	var candidates []costCandidate
+	for name, quality := range rt.cfg.QualityScores {
+		cost, ok := rt.estimateCost(name, rt.promptTokens(name, messages), outputTokens)
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, costCandidate{
+			model:   name,
+			quality: quality,
+			cost:    cost,
+		})
+	}
+	if len(candidates) == 0 {
+		return "", "", fmt.Errorf("cost routing requires models with both a quality score and a price")
+	}
+
+	// Cheapest first; among equal prices prefer higher quality, then name
+	// so the choice is deterministic.
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.cost != b.cost {
+			return a.cost < b.cost
+		}
+		if a.quality != b.quality {
+			return a.quality > b.quality
+		}
+		return a.model < b.model
+	})
+
+	var chosen *costCandidate
+	var skipped []string
+	for i := range candidates {
+		if candidates[i].quality >= score {
+			chosen = &candidates[i]
+			break
+		}
+		skipped = append(skipped, fmt.Sprintf("%s(q=%.2f)", candidates[i].model, candidates[i].quality))
+	}
+
+	reason = fmt.Sprintf("cost: score=%.3f est_tokens=%d+%d", score, rt.promptTokens("", messages), outputTokens)
+	if chosen == nil {
+		best := &candidates[0]
+		for i := range candidates {
+			if candidates[i].quality > best.quality {
+				best = &candidates[i]
+			}
+		}
+		return best.model, fmt.Sprintf("%s; no model clears the bar, chose highest quality %s(q=%.2f, $%.6f)",
+			reason, best.model, best.quality, best.cost), nil
+	}
+
+	reason = fmt.Sprintf("%s; chose %s(q=%.2f, $%.6f)", reason, chosen.model, chosen.quality, chosen.cost)
+	if len(skipped) > 0 {
+		reason += "; cheaper below bar: " + strings.Join(skipped, ", ")
+	}
+	return chosen.model, reason, nil
+}
+
+// estimateOutputTokens estimates a completion's length for a prompt of the
+// given complexity score.
+func (rt *Router) estimateOutputTokens(score float64) int {
+	outputTokens := rt.cfg.EstimatedOutputTokens
+	if outputTokens <= 0 {
+		outputTokens = defaultOutputTokens
+	}
+	return int(float64(outputTokens) * (0.5 + score))
+}
+
+// estimateCost prices a request to model in USD. ok=false if the model
+// has no price.
+func (rt *Router) estimateCost(model string, promptTokens, outputTokens int) (cost float64, ok bool) {
+	mc, ok := rt.costs[model]
+	if !ok {
+		return 0, false
+	}
+	return (float64(promptTokens)*mc.InputPerMillion +
+		float64(outputTokens)*mc.OutputPerMillion) / 1_000_000, true
+}
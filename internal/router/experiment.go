@@ -0,0 +1,105 @@
+package router
+
+import (
+	"hash/fnv"
+	"maps"
+	"net/http"
+
+	"github.com/howard-nolan/llmrouter/internal/config"
+)
+
+// Experiment labels for traffic not assigned to any experiment, and the
+// two arms of an experiment.
+const (
+	NoExperiment = "none"
+	ArmControl   = "control"
+	ArmTreatment = "treatment"
+)
+
+// experiment is a configured routing experiment and the Router that
+// implements its treatment arm.
+type experiment struct {
+	cfg config.ExperimentConfig
+	arm *Router
+}
+
+// newExperiment builds the treatment arm for exp on top of the base
+// routing config. The arm shares the base router's price table,
+// classifier, and latency estimates — only the routing policy differs.
+func newExperiment(base *Router, exp config.ExperimentConfig) *experiment {
+	cfg := base.cfg
+	cfg.Experiments = nil
+	if exp.DefaultStrategy != "" {
+		cfg.DefaultStrategy = exp.DefaultStrategy
+	}
+	if exp.ComplexityThreshold > 0 {
+		cfg.ComplexityThreshold = exp.ComplexityThreshold
+	}
+	if len(exp.Providers) > 0 {
+		cfg.Providers = maps.Clone(cfg.Providers)
+		maps.Copy(cfg.Providers, exp.Providers)
+	}
+
+	arm := New(cfg, base.costs, base.classifier)
+	arm.latency = base.latency
+	arm.tokens = base.tokens
+	arm.isArm = true
+	arm.fixedThreshold = exp.ComplexityThreshold > 0
+	return &experiment{cfg: exp, arm: arm}
+}
+
+// Assign places a request in an experiment arm. An A/B experiment treats
+// the requests hashing into its first Percent of buckets and holds the
+// next Percent (as much as fits under 100) out as its control arm, so the
+// two are compared on samples of equal size; a shadow experiment's share
+// is served by control with a copy sent to treatment. Experiments are
+// tried in config order and the first whose share the request hashes into
+// wins; each hashes independently (salted by name), so one experiment's
+// assignment doesn't skew another's. header is the client's request
+// headers, for its API key; requestKey identifies the request body.
+//
+// Returns the experiment name and arm. shadow=true means the request is
+// served by the control arm and the treatment arm gets a background copy.
+// Requests outside every experiment are NoExperiment/ArmControl; with no
+// experiments configured, Assign returns empty strings.
+func (rt *Router) Assign(header http.Header, requestKey string) (experiment, arm string, shadow bool) {
+	if len(rt.experiments) == 0 {
+		return "", "", false
+	}
+	clientKey := apiKey(header)
+	for _, e := range rt.experiments {
+		key := requestKey
+		if e.cfg.StickyBy != "request" && clientKey != "" {
+			key = clientKey
+		}
+		switch b := bucket(e.cfg.Name, key); {
+		case b < e.cfg.Percent && e.cfg.Shadow:
+			return e.cfg.Name, ArmControl, true
+		case b < e.cfg.Percent:
+			return e.cfg.Name, ArmTreatment, false
+		case b < 2*e.cfg.Percent && !e.cfg.Shadow:
+			return e.cfg.Name, ArmControl, false
+		}
+	}
+	return NoExperiment, ArmControl, false
+}
+
+// bucket hashes key into [0, 100) with 0.01 resolution, salted by the
+// experiment name.
+func bucket(name, key string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return float64(h.Sum64()%10_000) / 100
+}
+
+// experiment returns the named experiment, or nil.
+func (rt *Router) experiment(name string) *experiment {
+	for _, e := range rt.experiments {
+		if e.cfg.Name == name {
+			return e
+		}
+	}
+	return nil
+}
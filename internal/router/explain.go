@@ -0,0 +1,147 @@
+package router
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// Explanation is a routing decision with the inputs behind it, for
+// debugging why a prompt went where it did.
+type Explanation struct {
+	Model      string `json:"model"`
+	Reason     string `json:"reason"`
+	Strategy   string `json:"strategy"`             // "rule" or "experiment" when one forced the model
+	Provider   string `json:"provider,omitempty"`   // tier list used; empty when the choice crossed providers
+	Experiment string `json:"experiment,omitempty"` // treatment arm that made the decision
+	Rule       string `json:"rule,omitempty"`       // routing rule that matched
+
+	// ComplexityScore is the classifier's score for the prompt, and
+	// Threshold the cheap/quality split in effect (the live classifier's
+	// own, or the configured one). Nil without a classifier.
+	ComplexityScore *float64 `json:"complexity_score,omitempty"`
+	Threshold       *float64 `json:"threshold,omitempty"`
+
+	// MaxCostUSD is the request's cost cap and EstimatedCostUSD the
+	// pre-flight estimate on the chosen model (see EstimateCost); both nil
+	// without a cap. DowngradedFrom is the model the strategy chose before
+	// the cap pushed it to a cheaper one, and OverBudget is set if no model
+	// fits the cap. Either applies only to a cache miss.
+	MaxCostUSD       *float64 `json:"max_cost_usd,omitempty"`
+	EstimatedCostUSD *float64 `json:"estimated_cost_usd,omitempty"`
+	DowngradedFrom   string   `json:"downgraded_from,omitempty"`
+	OverBudget       bool     `json:"over_budget,omitempty"`
+
+	// ReroutedFrom is the model the strategy chose before the request
+	// turned out too large for its context window.
+	ReroutedFrom string `json:"rerouted_from,omitempty"`
+
+	// Candidates are the models the strategy chose among, with this
+	// request's estimated cost on each.
+	Candidates []CandidateCost `json:"candidates"`
+}
+
+// CandidateCost is one model's estimated cost for a request. Output
+// tokens are estimated as the cost strategy does, from the complexity
+// score. EstimatedCostUSD is nil if the model has no price.
+type CandidateCost struct {
+	Model            string   `json:"model"`
+	InputTokens      int      `json:"input_tokens"`
+	OutputTokens     int      `json:"output_tokens"`
+	EstimatedCostUSD *float64 `json:"estimated_cost_usd,omitempty"`
+	Chosen           bool     `json:"chosen"`
+}
+
+// Explain makes the same decision Decide would, without counting it in any
+// metric, and describes it. Returns the chosen model, which the cache is
+// searched under, and an *Explanation (typed any so consumers needn't
+// import this package) of the model a cache miss would call: the decision
+// held to the request's cost cap as FitBudget would hold it (maxCost as
+// there).
+func (rt *Router) Explain(embedding []float32, req *provider.ChatRequest, header http.Header, strategy string, providerName string, latencyBudget time.Duration, experimentName string, maxCost float64) (model string, explanation any, err error) {
+	d, err := rt.decide(embedding, req, header, strategy, providerName, latencyBudget, experimentName, false)
+	if err != nil {
+		return "", nil, err
+	}
+	model = d.Model
+	if err := rt.fitBudget(&d, req, rt.maxCost(header, maxCost), false); err != nil {
+		d.OverBudget = true
+	}
+
+	// The arm that decided owns the tier lists and threshold.
+	decider := rt
+	if e := rt.experiment(d.Experiment); e != nil {
+		decider = e.arm
+	}
+
+	// Report the score even for strategies that don't route on it.
+	if d.ComplexityScore == nil && decider.classifier != nil {
+		if score, threshold, err := decider.classify(embedding, req, false); err == nil {
+			d.ComplexityScore, d.Threshold = &score, &threshold
+		}
+	}
+
+	var messages []provider.Message
+	if req != nil {
+		messages = req.Messages
+	}
+	d.Candidates = decider.candidateCosts(d, messages)
+	return model, &d, nil
+}
+
+// candidateCosts prices the models d's strategy chose among: every scored
+// model for the cost strategy, the provider's tiers for tier strategies,
+// or just the chosen model when it was forced.
+func (rt *Router) candidateCosts(d Explanation, messages []provider.Message) []CandidateCost {
+	var models []string
+	switch {
+	case d.Strategy == "cost":
+		for name := range rt.cfg.QualityScores {
+			models = append(models, name)
+		}
+	case d.Provider != "":
+		for _, t := range rt.tiers[d.Provider] {
+			models = append(models, t.Model)
+		}
+	}
+	if !slices.Contains(models, d.Model) {
+		models = append(models, d.Model)
+	}
+
+	// Without a score, assume a middling prompt: the typical output length.
+	score := 0.5
+	if d.ComplexityScore != nil {
+		score = *d.ComplexityScore
+	}
+	outputTokens := rt.estimateOutputTokens(score)
+
+	out := make([]CandidateCost, 0, len(models))
+	for _, m := range models {
+		promptTokens := rt.promptTokens(m, messages)
+		c := CandidateCost{
+			Model:        m,
+			InputTokens:  promptTokens,
+			OutputTokens: outputTokens,
+			Chosen:       m == d.Model,
+		}
+		if cost, ok := rt.estimateCost(m, promptTokens, outputTokens); ok {
+			c.EstimatedCostUSD = &cost
+		}
+		out = append(out, c)
+	}
+	// Cheapest first, unpriced last, then by name.
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].EstimatedCostUSD, out[j].EstimatedCostUSD
+		switch {
+		case a != nil && b != nil && *a != *b:
+			return *a < *b
+		case (a == nil) != (b == nil):
+			return a != nil
+		}
+		return out[i].Model < out[j].Model
+	})
+	return out
+}
@@ -0,0 +1,109 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/howard-nolan/llmrouter/internal/config"
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+)
+
+func TestExplain_AutoStrategy(t *testing.T) {
+	cfg, costs := costConfig()
+	rt := New(cfg, costs, &mockClassifier{score: 0.8})
+
+	decisions := testutil.ToFloat64(metrics.RoutingDecisions.WithLabelValues("auto", "claude-sonnet-4-5-20250929"))
+	scores := testutil.CollectAndCount(metrics.ComplexityScore)
+
+	model, explanation, err := rt.Explain(dummyEmbedding, promptOf(800), nil, "", "", 0, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "claude-sonnet-4-5-20250929", model)
+
+	e := explanation.(*Explanation)
+	assert.Equal(t, "auto", e.Strategy)
+	assert.Equal(t, "anthropic", e.Provider)
+	assert.Equal(t, 0.8, *e.ComplexityScore)
+	assert.Equal(t, 0.6, *e.Threshold)
+
+	// Both anthropic tiers, cheapest first: 200 input tokens, and 1000
+	// output tokens scaled by (0.5 + 0.8).
+	require.Len(t, e.Candidates, 2)
+	assert.Equal(t, "claude-haiku-4-5-20251001", e.Candidates[0].Model)
+	assert.False(t, e.Candidates[0].Chosen)
+	assert.Equal(t, 200, e.Candidates[1].InputTokens)
+	assert.Equal(t, 1300, e.Candidates[1].OutputTokens)
+	assert.InDelta(t, (200*3.00+1300*15.00)/1e6, *e.Candidates[1].EstimatedCostUSD, 1e-12)
+	assert.True(t, e.Candidates[1].Chosen)
+
+	// A dry run: nothing counted.
+	assert.Equal(t, decisions, testutil.ToFloat64(metrics.RoutingDecisions.WithLabelValues("auto", "claude-sonnet-4-5-20250929")))
+	assert.Equal(t, scores, testutil.CollectAndCount(metrics.ComplexityScore))
+}
+
+func TestExplain_CostStrategyListsEveryScoredModel(t *testing.T) {
+	cfg, costs := costConfig()
+	rt := New(cfg, costs, &mockClassifier{score: 0.5})
+
+	model, explanation, err := rt.Explain(dummyEmbedding, promptOf(800), nil, "cost", "", 0, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "claude-haiku-4-5-20251001", model)
+
+	e := explanation.(*Explanation)
+	assert.Empty(t, e.Provider, "cost routing crosses providers")
+	require.Len(t, e.Candidates, 5)
+	assert.Equal(t, "gemini-2.0-flash", e.Candidates[0].Model)
+	assert.Equal(t, "unpriced-model", e.Candidates[4].Model, "unpriced last")
+	assert.Nil(t, e.Candidates[4].EstimatedCostUSD)
+}
+
+func TestExplain_ScoreReportedForUnclassifiedStrategies(t *testing.T) {
+	rt := New(testConfig(), nil, &mockClassifier{score: 0.3})
+
+	_, explanation, err := rt.Explain(dummyEmbedding, nil, nil, "quality", "google", 0, "", 0)
+	require.NoError(t, err)
+	e := explanation.(*Explanation)
+	assert.Equal(t, "gemini-2.5-pro", e.Model)
+	assert.Equal(t, 0.3, *e.ComplexityScore)
+}
+
+func TestExplain_RuleForcedModel(t *testing.T) {
+	cfg := rulesConfig(config.RoutingRule{Name: "vip", Headers: map[string]string{"X-Plan": "vip"}, Model: "gemini-2.5-pro"})
+	rt := New(cfg, nil, &mockClassifier{score: 0.1})
+
+	matches := testutil.ToFloat64(metrics.RoutingRuleMatches.WithLabelValues("vip"))
+	_, explanation, err := rt.Explain(dummyEmbedding, nil, http.Header{"X-Plan": {"vip"}}, "", "", 0, "", 0)
+	require.NoError(t, err)
+
+	e := explanation.(*Explanation)
+	assert.Equal(t, "vip", e.Rule)
+	assert.Equal(t, "rule", e.Strategy)
+	require.Len(t, e.Candidates, 1)
+	assert.Equal(t, "gemini-2.5-pro", e.Candidates[0].Model)
+	assert.Equal(t, matches, testutil.ToFloat64(metrics.RoutingRuleMatches.WithLabelValues("vip")))
+}
+
+func TestExplain_CostCapDescribesTheMiss(t *testing.T) {
+	cfg, costs := costConfig()
+	rt := New(cfg, costs, &mockClassifier{score: 0.8})
+	req := promptOf(4000)
+	req.MaxTokens = 1000
+
+	// The cache is searched under sonnet; a miss would call haiku.
+	model, explanation, err := rt.Explain(dummyEmbedding, req, nil, "auto", "anthropic", 0, "", 0.01)
+	require.NoError(t, err)
+	assert.Equal(t, "claude-sonnet-4-5-20250929", model)
+	e := explanation.(*Explanation)
+	assert.Equal(t, "claude-haiku-4-5-20251001", e.Model)
+	assert.Equal(t, "claude-sonnet-4-5-20250929", e.DowngradedFrom)
+	assert.False(t, e.OverBudget)
+
+	// Nothing fits: still explained, since a cache hit would be served.
+	model, explanation, err = rt.Explain(dummyEmbedding, req, nil, "auto", "anthropic", 0, "", 0.001)
+	require.NoError(t, err)
+	assert.Equal(t, "claude-sonnet-4-5-20250929", model)
+	assert.True(t, explanation.(*Explanation).OverBudget)
+}
@@ -0,0 +1,142 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/features"
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+)
+
+// gbtFormat identifies the JSON tree export written by
+// training/export_onnx.py.
+const gbtFormat = "sklearn-gbt"
+
+// GBTClassifier scores prompt complexity by evaluating the gradient-boosted
+// tree ensemble directly, from the JSON export training/export_onnx.py
+// writes next to the ONNX model. It gives the same scores as ONNXClassifier
+// without an ONNX Runtime session of its own, so it can be created at any
+// point in startup. Its inputs are still the local ONNX embedding model's
+// vectors, so the gateway only uses it with the onnx embedding backend —
+// never in a noonnx build, which has no local embedder.
+type GBTClassifier struct {
+	layout    features.Layout
+	initScore float64
+	trees     [][]gbtNode
+}
+
+// gbtNode is one node of a regression tree. Leaves have left < 0; their
+// value is already scaled by the learning rate.
+type gbtNode struct {
+	feature     int32
+	threshold   float32
+	left, right int32
+	value       float64
+}
+
+// gbtExport is the JSON layout of the tree export. Each tree is sklearn's
+// tree_ arrays, one entry per node; children are node indices, -1 for
+// none.
+type gbtExport struct {
+	Format       string  `json:"format"`
+	NFeatures    int     `json:"n_features"`
+	LearningRate float64 `json:"learning_rate"`
+	InitScore    float64 `json:"init_score"`
+	Trees        []struct {
+		Feature   []int32   `json:"feature"`
+		Threshold []float64 `json:"threshold"`
+		Left      []int32   `json:"left"`
+		Right     []int32   `json:"right"`
+		Value     []float64 `json:"value"`
+	} `json:"trees"`
+}
+
+// NewGBTClassifier loads the tree export at path. embeddingDim is the
+// embedding dimension; the export's feature count says whether the model
+// also takes request features.
+func NewGBTClassifier(path string, embeddingDim int) (*GBTClassifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading classifier trees: %w", err)
+	}
+	var export gbtExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("parsing classifier trees %s: %w", path, err)
+	}
+	if export.Format != gbtFormat {
+		return nil, fmt.Errorf("classifier trees %s: format %q, want %q", path, export.Format, gbtFormat)
+	}
+	layout, err := features.LayoutFor(embeddingDim, export.NFeatures)
+	if err != nil {
+		return nil, fmt.Errorf("classifier trees %s: %w", path, err)
+	}
+
+	c := &GBTClassifier{
+		layout:    layout,
+		initScore: export.InitScore,
+		trees:     make([][]gbtNode, len(export.Trees)),
+	}
+	for i, t := range export.Trees {
+		n := len(t.Feature)
+		if n == 0 || len(t.Threshold) != n || len(t.Left) != n || len(t.Right) != n || len(t.Value) != n {
+			return nil, fmt.Errorf("classifier trees %s: tree %d has mismatched node arrays", path, i)
+		}
+		nodes := make([]gbtNode, n)
+		for j := range nodes {
+			node := gbtNode{left: t.Left[j], right: t.Right[j]}
+			if node.left < 0 {
+				node.value = export.LearningRate * t.Value[j]
+			} else {
+				// Children come after their parent, so evaluation can't
+				// loop; thresholds are compared as float32, as ONNX does.
+				if node.left <= int32(j) || int(node.left) >= n || node.right <= int32(j) || int(node.right) >= n {
+					return nil, fmt.Errorf("classifier trees %s: tree %d node %d has invalid children", path, i, j)
+				}
+				if t.Feature[j] < 0 || int(t.Feature[j]) >= export.NFeatures {
+					return nil, fmt.Errorf("classifier trees %s: tree %d node %d splits on feature %d", path, i, j, t.Feature[j])
+				}
+				node.feature = t.Feature[j]
+				node.threshold = float32(t.Threshold[j])
+			}
+			nodes[j] = node
+		}
+		c.trees[i] = nodes
+	}
+	return c, nil
+}
+
+// Classify returns a complexity score between 0 (simple) and 1 (complex)
+// for the given request features.
+func (c *GBTClassifier) Classify(f features.Features) (float64, error) {
+	start := time.Now()
+	defer func() {
+		metrics.ClassificationDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	x, err := c.layout.Vector(f)
+	if err != nil {
+		return 0, err
+	}
+	return c.score(x), nil
+}
+
+// score evaluates the ensemble on a model input: the sigmoid of the prior
+// plus every tree's leaf value.
+func (c *GBTClassifier) score(x []float32) float64 {
+	raw := c.initScore
+	for _, nodes := range c.trees {
+		i := int32(0)
+		for nodes[i].left >= 0 {
+			if x[nodes[i].feature] <= nodes[i].threshold {
+				i = nodes[i].left
+			} else {
+				i = nodes[i].right
+			}
+		}
+		raw += nodes[i].value
+	}
+	return 1 / (1 + math.Exp(-raw))
+}
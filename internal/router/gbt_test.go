@@ -0,0 +1,86 @@
+package router
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/howard-nolan/llmrouter/internal/features"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGBTClassifier_Fixture(t *testing.T) {
+	c, err := NewGBTClassifier(filepath.Join("testdata", "gbt_small.json"), 4)
+	require.NoError(t, err)
+
+	// Expected scores are sigmoid(-0.5 + 0.1 * leaf sum), worked by hand.
+	tests := []struct {
+		name      string
+		embedding []float32
+		want      float64
+	}{
+		// Exactly on every threshold goes left. float32(0.1) is above
+		// 0.1, so this only holds if thresholds are compared as float32.
+		{"on thresholds", []float32{0.1, 0.25, -0.5, 0}, 0.3728522336868044},
+		{"above thresholds", []float32{0.2, 0.3, 0, 0}, 0.4700359482354282},
+		{"zeros", []float32{0, 0, 0, 0}, 0.34978145142617295},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, err := c.Classify(features.Features{Embedding: tt.embedding})
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want, score, 1e-12)
+		})
+	}
+
+	_, err = c.Classify(features.Features{Embedding: []float32{0, 0, 0}})
+	assert.Error(t, err, "wrong dimension")
+}
+
+func TestNewGBTClassifier_Invalid(t *testing.T) {
+	_, err := NewGBTClassifier(filepath.Join("testdata", "gbt_small.json"), 384)
+	assert.Error(t, err, "dimension mismatch")
+
+	tests := map[string]string{
+		"format":     `{"format": "xgboost", "n_features": 2, "trees": []}`,
+		"mismatched": `{"format": "sklearn-gbt", "n_features": 2, "trees": [{"feature": [0], "threshold": [], "left": [-1], "right": [-1], "value": [1]}]}`,
+		"cycle":      `{"format": "sklearn-gbt", "n_features": 2, "trees": [{"feature": [0], "threshold": [0], "left": [0], "right": [0], "value": [1]}]}`,
+		"feature":    `{"format": "sklearn-gbt", "n_features": 2, "trees": [{"feature": [5, -2, -2], "threshold": [0, -2, -2], "left": [1, -1, -1], "right": [2, -1, -1], "value": [0, 1, 2]}]}`,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "trees.json")
+			require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+			_, err := NewGBTClassifier(path, 2)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestGBTClassifier_Golden checks the Go evaluation of a small export
+// against the scores ONNX gives for it. The fixture comes from
+// training/export_golden_fixture.py (ONNX Runtime), or its stand-in
+// training/golden_reference.py; "scored_by" says which.
+func TestGBTClassifier_Golden(t *testing.T) {
+	treesPath := filepath.Join("testdata", "gbt_golden.trees.json")
+	goldenPath := filepath.Join("testdata", "gbt_golden.json")
+	data, err := os.ReadFile(goldenPath)
+	require.NoError(t, err)
+	var golden struct {
+		EmbeddingDim int         `json:"embedding_dim"`
+		Inputs       [][]float32 `json:"inputs"` // model inputs, request features included
+		Scores       []float64   `json:"scores"`
+	}
+	require.NoError(t, json.Unmarshal(data, &golden))
+	require.Len(t, golden.Scores, len(golden.Inputs))
+	require.NotEmpty(t, golden.Inputs)
+
+	c, err := NewGBTClassifier(treesPath, golden.EmbeddingDim)
+	require.NoError(t, err)
+	for i, input := range golden.Inputs {
+		require.Len(t, input, c.layout.Width())
+		assert.InDelta(t, golden.Scores[i], c.score(input), 1e-5, "sample %d", i)
+	}
+}
@@ -0,0 +1,156 @@
+package router
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/config"
+)
+
+const (
+	// latencyAlpha is the EWMA weight of each new observation. At 0.1 the
+	// estimate mostly reflects the last ~20 requests to a model — quick
+	// enough to notice a provider slowing down, slow enough that one
+	// outlier doesn't flip routing.
+	latencyAlpha = 0.1
+
+	// latencyMinSamples is how many TTFT observations a model needs before
+	// its estimate is trusted. Until then the latency strategy treats it
+	// as fast, so unmeasured models get traffic and become measured.
+	latencyMinSamples = 5
+
+	// z90 is the standard normal 90th-percentile z-score. p90 TTFT is
+	// estimated as mean + z90·stddev.
+	z90 = 1.2816
+
+	// maxErrorRate caps the error-rate penalty so a model that's failing
+	// everything still has a finite (if huge) effective latency.
+	maxErrorRate = 0.9
+)
+
+// latencyStats is the rolling estimate for one model. Mean and variance
+// are exponentially weighted (West's incremental EWMA variance), in
+// seconds; errorRate is the EWMA of failures (1) and successes (0).
+type latencyStats struct {
+	samples   int
+	mean      float64
+	variance  float64
+	errorRate float64
+}
+
+// p90 estimates the model's 90th-percentile TTFT.
+func (s latencyStats) p90() time.Duration {
+	return time.Duration((s.mean + z90*math.Sqrt(s.variance)) * float64(time.Second))
+}
+
+// effective is p90 inflated by the error rate: with failure probability e,
+// a request takes on average 1/(1-e) attempts to succeed.
+func (s latencyStats) effective() time.Duration {
+	return time.Duration(float64(s.p90()) / (1 - min(s.errorRate, maxErrorRate)))
+}
+
+// latencyTracker keeps latencyStats per model, fed from the handler as
+// streams report their first token and requests finish.
+type latencyTracker struct {
+	mu    sync.Mutex
+	stats map[string]*latencyStats
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{stats: make(map[string]*latencyStats)}
+}
+
+// entry returns model's stats, creating them if needed. Caller holds mu.
+func (lt *latencyTracker) entry(model string) *latencyStats {
+	s, ok := lt.stats[model]
+	if !ok {
+		s = &latencyStats{}
+		lt.stats[model] = s
+	}
+	return s
+}
+
+func (lt *latencyTracker) observeTTFT(model string, ttft time.Duration) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	s := lt.entry(model)
+	x := ttft.Seconds()
+	if s.samples == 0 {
+		s.mean = x
+	} else {
+		diff := x - s.mean
+		incr := latencyAlpha * diff
+		s.mean += incr
+		s.variance = (1 - latencyAlpha) * (s.variance + diff*incr)
+	}
+	s.samples++
+}
+
+func (lt *latencyTracker) observeOutcome(model string, failed bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	x := 0.0
+	if failed {
+		x = 1
+	}
+	s := lt.entry(model)
+	s.errorRate += latencyAlpha * (x - s.errorRate)
+}
+
+// get returns a copy of model's stats, and whether there are enough TTFT
+// samples to trust them.
+func (lt *latencyTracker) get(model string) (latencyStats, bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	s, ok := lt.stats[model]
+	if !ok {
+		return latencyStats{}, false
+	}
+	return *s, s.samples >= latencyMinSamples
+}
+
+// pickByLatency implements the latency strategy over a provider's tiers.
+//
+// With no budget it picks the tier with the lowest effective p90 TTFT.
+// With a budget (SLO mode) it picks the cheapest tier whose effective p90
+// is within it, falling back to the fastest if none is. Unmeasured tiers
+// count as fast in both modes, so they get traffic until they're measured.
+func (rt *Router) pickByLatency(tiers []config.RoutingTier, providerName string, budget time.Duration) (model, reason string) {
+	describe := func(model string) string {
+		s, trusted := rt.latency.get(model)
+		if !trusted {
+			return model + "(unmeasured)"
+		}
+		return fmt.Sprintf("%s(p90=%s, err=%.0f%%)", model, s.p90().Round(time.Millisecond), s.errorRate*100)
+	}
+
+	// effective latency per tier, 0 for unmeasured.
+	eff := make([]time.Duration, len(tiers))
+	fastest := 0
+	for i, t := range tiers {
+		if s, trusted := rt.latency.get(t.Model); trusted {
+			eff[i] = s.effective()
+		}
+		if eff[i] < eff[fastest] {
+			fastest = i
+		}
+	}
+
+	if budget <= 0 {
+		model = tiers[fastest].Model
+		return model, fmt.Sprintf("latency: fastest %s tier %s", providerName, describe(model))
+	}
+
+	for i, t := range tiers {
+		if eff[i] <= budget {
+			return t.Model, fmt.Sprintf("latency: budget=%s, cheapest %s tier within it %s", budget, providerName, describe(t.Model))
+		}
+	}
+	model = tiers[fastest].Model
+	return model, fmt.Sprintf("latency: budget=%s, no %s tier within it, chose fastest %s", budget, providerName, describe(model))
+}
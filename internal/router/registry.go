@@ -0,0 +1,321 @@
+package router
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/features"
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+)
+
+// retireDelay is how long a classifier pushed out of the rollback slot
+// stays open before Close, so requests that grabbed it just before the
+// swap can finish classifying.
+const retireDelay = time.Minute
+
+// ClassifierLoader opens the classifier stored at path — e.g. a closure
+// over NewONNXClassifier with the embedding dimension.
+type ClassifierLoader func(path string) (Classifier, error)
+
+// ThresholdedClassifier is a Classifier that carries its own decision
+// threshold, swapped together with the model (see Registry). The router
+// reads both in one call so a reload can't pair a new model with an old
+// threshold.
+type ThresholdedClassifier interface {
+	Classifier
+	ClassifyWithThreshold(f features.Features) (score, threshold float64, err error)
+}
+
+// ClassifierInfo describes a loaded classifier, for /health and the admin
+// endpoints. Version comes from the metadata sidecar (see Registry), or is
+// a hash of the model file if the sidecar doesn't set one.
+type ClassifierInfo struct {
+	Version   string         `json:"version"`
+	Path      string         `json:"path"`
+	Threshold float64        `json:"threshold"`
+	LoadedAt  time.Time      `json:"loaded_at"`
+	Metadata  map[string]any `json:"metadata,omitempty"`
+}
+
+// loadedClassifier is one generation of the registry: a classifier and the
+// threshold and metadata it was loaded with.
+type loadedClassifier struct {
+	classifier Classifier
+	info       ClassifierInfo
+	modTime    time.Time // newest of the model and sidecar mtimes when loaded, for Watch
+}
+
+// Registry holds the live complexity classifier and lets it be replaced at
+// runtime — by Reload (admin endpoint) or Watch (file changes) — and put
+// back with Rollback. Swaps are atomic: in-flight requests finish on the
+// classifier they started with.
+//
+// Each model file may have a JSON sidecar with the same name and a .json
+// extension (models/complexity_classifier.json, written by
+// training/export_onnx.py). Its "threshold" replaces the configured
+// ComplexityThreshold while that model is live, "version" names it, and
+// everything else is reported as metadata.
+//
+// Registry implements ThresholdedClassifier, so the Router uses it like
+// any other classifier.
+type Registry struct {
+	path             string
+	defaultThreshold float64
+	load             ClassifierLoader
+
+	mu   sync.Mutex // serializes Reload, Rollback, and Close
+	cur  atomic.Pointer[loadedClassifier]
+	prev *loadedClassifier // rollback target; nil until the first reload
+
+	// retiring holds classifiers pushed out of the rollback slot, each
+	// with the timer that closes it after retireDelay. Close stops the
+	// timers and closes them itself.
+	retiring map[*loadedClassifier]*time.Timer
+}
+
+// NewRegistry loads the classifier at path. defaultThreshold applies when
+// the model has no sidecar threshold.
+func NewRegistry(path string, defaultThreshold float64, load ClassifierLoader) (*Registry, error) {
+	r := &Registry{path: path, defaultThreshold: defaultThreshold, load: load}
+	lc, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	r.cur.Store(lc)
+	setClassifierInfoMetric(lc.info)
+	return r, nil
+}
+
+// sidecarPath returns the metadata file for a model file: the model's name
+// up to its first dot, plus ".json", so complexity_classifier.onnx and
+// complexity_classifier.gbt.json share complexity_classifier.json. Empty
+// if that would be the model file itself.
+func sidecarPath(modelPath string) string {
+	dir, file := filepath.Split(modelPath)
+	if i := strings.Index(file, "."); i > 0 {
+		file = file[:i]
+	}
+	if p := dir + file + ".json"; p != modelPath {
+		return p
+	}
+	return ""
+}
+
+// open loads the model at r.path with its sidecar metadata.
+func (r *Registry) open() (*loadedClassifier, error) {
+	st, err := os.Stat(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("loading classifier: %w", err)
+	}
+	modTime := st.ModTime()
+
+	info := ClassifierInfo{Path: r.path, Threshold: r.defaultThreshold, LoadedAt: time.Now()}
+	sidecar := sidecarPath(r.path)
+	if data, err := os.ReadFile(sidecar); err == nil {
+		if err := json.Unmarshal(data, &info.Metadata); err != nil {
+			return nil, fmt.Errorf("parsing classifier metadata %s: %w", sidecar, err)
+		}
+		if v, ok := info.Metadata["version"].(string); ok {
+			info.Version = v
+		}
+		if t, ok := info.Metadata["threshold"].(float64); ok {
+			info.Threshold = t
+		}
+		delete(info.Metadata, "version")
+		delete(info.Metadata, "threshold")
+		if st, err := os.Stat(sidecar); err == nil && st.ModTime().After(modTime) {
+			modTime = st.ModTime()
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading classifier metadata: %w", err)
+	}
+	if info.Version == "" {
+		if info.Version, err = fileVersion(r.path); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := r.load(r.path)
+	if err != nil {
+		return nil, err
+	}
+	return &loadedClassifier{classifier: c, info: info, modTime: modTime}, nil
+}
+
+// fileVersion names a model without a sidecar version: the first 12 hex
+// digits of its SHA-256.
+func fileVersion(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("hashing classifier: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing classifier: %w", err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+// Classify scores with the live classifier.
+func (r *Registry) Classify(f features.Features) (float64, error) {
+	return r.cur.Load().classifier.Classify(f)
+}
+
+// ClassifyWithThreshold scores with the live classifier and returns the
+// threshold that goes with it.
+func (r *Registry) ClassifyWithThreshold(f features.Features) (score, threshold float64, err error) {
+	lc := r.cur.Load()
+	score, err = lc.classifier.Classify(f)
+	return score, lc.info.Threshold, err
+}
+
+// Info returns the live classifier's description, and the rollback
+// target's (nil if there isn't one).
+func (r *Registry) Info() (current ClassifierInfo, previous *ClassifierInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current = r.cur.Load().info
+	if r.prev != nil {
+		p := r.prev.info
+		previous = &p
+	}
+	return current, previous
+}
+
+// Reload loads the model file again and swaps it in, keeping the current
+// classifier as the rollback target. On error the live classifier is left
+// untouched.
+func (r *Registry) Reload() (ClassifierInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lc, err := r.open()
+	if err != nil {
+		metrics.ClassifierReloads.WithLabelValues("error").Inc()
+		return ClassifierInfo{}, err
+	}
+	r.retire(r.prev)
+	r.prev = r.cur.Swap(lc)
+	setClassifierInfoMetric(lc.info)
+	metrics.ClassifierReloads.WithLabelValues("reloaded").Inc()
+	return lc.info, nil
+}
+
+// Rollback swaps the previous classifier back in; the one it replaces
+// becomes the new rollback target, so a second Rollback undoes the first.
+func (r *Registry) Rollback() (ClassifierInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.prev == nil {
+		return ClassifierInfo{}, fmt.Errorf("no previous classifier to roll back to")
+	}
+	r.prev = r.cur.Swap(r.prev)
+	info := r.cur.Load().info
+	setClassifierInfoMetric(info)
+	metrics.ClassifierReloads.WithLabelValues("rolled_back").Inc()
+	return info, nil
+}
+
+// Watch polls the model file and its sidecar every interval and reloads
+// when either changes, until ctx is done. Only changes trigger a reload,
+// so a Rollback sticks until a new model is written. A failed reload (say,
+// a file caught mid-write) is logged and retried every interval until it
+// succeeds, since the write that finishes the file may not move its mtime.
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := r.cur.Load().modTime
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime := r.modTime()
+		if !modTime.After(last) {
+			continue
+		}
+		info, err := r.Reload()
+		if err != nil {
+			log.Printf("classifier reload failed: %v", err)
+			continue
+		}
+		last = modTime
+		log.Printf("classifier reloaded: version %s, threshold %.3f", info.Version, info.Threshold)
+	}
+}
+
+// modTime returns the newest mtime of the model file and its sidecar.
+func (r *Registry) modTime() time.Time {
+	var newest time.Time
+	for _, p := range []string{r.path, sidecarPath(r.path)} {
+		if st, err := os.Stat(p); err == nil && st.ModTime().After(newest) {
+			newest = st.ModTime()
+		}
+	}
+	return newest
+}
+
+// Close releases the live and rollback classifiers, and any still waiting
+// out retireDelay, so none outlives the runtime it was loaded into.
+func (r *Registry) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for lc, timer := range r.retiring {
+		timer.Stop()
+		closeClassifier(lc)
+	}
+	r.retiring = nil
+	closeClassifier(r.cur.Load())
+	closeClassifier(r.prev)
+}
+
+// retire closes lc after retireDelay, unless Close gets to it first.
+// Callers hold r.mu.
+func (r *Registry) retire(lc *loadedClassifier) {
+	if lc == nil {
+		return
+	}
+	if r.retiring == nil {
+		r.retiring = make(map[*loadedClassifier]*time.Timer)
+	}
+	r.retiring[lc] = time.AfterFunc(retireDelay, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.retiring[lc]; ok {
+			delete(r.retiring, lc)
+			closeClassifier(lc)
+		}
+	})
+}
+
+func closeClassifier(lc *loadedClassifier) {
+	if lc == nil {
+		return
+	}
+	if c, ok := lc.classifier.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+// setClassifierInfoMetric points the classifier info metric at info.
+func setClassifierInfoMetric(info ClassifierInfo) {
+	metrics.ClassifierInfo.Reset()
+	metrics.ClassifierInfo.WithLabelValues(info.Version, fmt.Sprintf("%g", info.Threshold)).Set(1)
+}
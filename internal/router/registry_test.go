@@ -0,0 +1,235 @@
+package router
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/features"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fileScoreLoader loads a mockClassifier whose score is the model file's
+// contents, so tests can "retrain" by rewriting the file.
+func fileScoreLoader(path string) (Classifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	score, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil {
+		return nil, err
+	}
+	return &mockClassifier{score: score}, nil
+}
+
+// writeModel writes a fake model file scoring score and, if sidecar isn't
+// empty, its metadata sidecar.
+func writeModel(t *testing.T, path string, score float64, sidecar string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(strconv.FormatFloat(score, 'f', -1, 64)), 0o644))
+	if sidecar != "" {
+		require.NoError(t, os.WriteFile(sidecarPath(path), []byte(sidecar), 0o644))
+	}
+}
+
+func TestRegistry_SidecarMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "classifier.onnx")
+	writeModel(t, path, 0.5, `{"version": "gbt-1", "threshold": 0.4, "embedding_dim": 384}`)
+
+	reg, err := NewRegistry(path, 0.6, fileScoreLoader)
+	require.NoError(t, err)
+
+	score, threshold, err := reg.ClassifyWithThreshold(features.Features{Embedding: dummyEmbedding})
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, score)
+	assert.Equal(t, 0.4, threshold)
+
+	current, previous := reg.Info()
+	assert.Equal(t, "gbt-1", current.Version)
+	assert.Equal(t, map[string]any{"embedding_dim": float64(384)}, current.Metadata)
+	assert.Nil(t, previous)
+}
+
+func TestRegistry_NoSidecar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "classifier.onnx")
+	writeModel(t, path, 0.5, "")
+
+	reg, err := NewRegistry(path, 0.6, fileScoreLoader)
+	require.NoError(t, err)
+
+	current, _ := reg.Info()
+	assert.Equal(t, 0.6, current.Threshold, "configured threshold applies without a sidecar")
+	assert.True(t, strings.HasPrefix(current.Version, "sha256:"))
+}
+
+func TestRegistry_ReloadAndRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "classifier.onnx")
+	writeModel(t, path, 0.3, `{"version": "v1", "threshold": 0.5}`)
+	reg, err := NewRegistry(path, 0.6, fileScoreLoader)
+	require.NoError(t, err)
+
+	_, err = reg.Rollback()
+	assert.Error(t, err, "nothing to roll back to before the first reload")
+
+	writeModel(t, path, 0.7, `{"version": "v2", "threshold": 0.8}`)
+	info, err := reg.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, "v2", info.Version)
+
+	score, threshold, err := reg.ClassifyWithThreshold(features.Features{Embedding: dummyEmbedding})
+	require.NoError(t, err)
+	assert.Equal(t, 0.7, score)
+	assert.Equal(t, 0.8, threshold)
+
+	info, err = reg.Rollback()
+	require.NoError(t, err)
+	assert.Equal(t, "v1", info.Version)
+	score, threshold, err = reg.ClassifyWithThreshold(features.Features{Embedding: dummyEmbedding})
+	require.NoError(t, err)
+	assert.Equal(t, 0.3, score)
+	assert.Equal(t, 0.5, threshold)
+
+	// A second rollback undoes the first.
+	info, err = reg.Rollback()
+	require.NoError(t, err)
+	assert.Equal(t, "v2", info.Version)
+}
+
+// closingClassifier is a mockClassifier that counts its Close calls.
+type closingClassifier struct {
+	mockClassifier
+	closes *atomic.Int32
+}
+
+func (c *closingClassifier) Close() { c.closes.Add(1) }
+
+func TestRegistry_CloseAfterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "classifier.onnx")
+	writeModel(t, path, 0.3, "")
+	var loads, closes atomic.Int32
+	load := func(path string) (Classifier, error) {
+		loads.Add(1)
+		return &closingClassifier{closes: &closes}, nil
+	}
+	reg, err := NewRegistry(path, 0.6, load)
+	require.NoError(t, err)
+
+	// The second reload retires the first model: it's still waiting out
+	// retireDelay when the registry closes.
+	for range 2 {
+		_, err = reg.Reload()
+		require.NoError(t, err)
+	}
+	reg.Close()
+	assert.EqualValues(t, 3, loads.Load())
+	assert.EqualValues(t, 3, closes.Load(), "live, rollback, and retiring classifiers are all closed")
+}
+
+func TestRegistry_FailedReloadKeepsCurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "classifier.onnx")
+	writeModel(t, path, 0.3, `{"version": "v1"}`)
+	reg, err := NewRegistry(path, 0.6, fileScoreLoader)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("not a model"), 0o644))
+	_, err = reg.Reload()
+	require.Error(t, err)
+
+	current, previous := reg.Info()
+	assert.Equal(t, "v1", current.Version)
+	assert.Nil(t, previous)
+	score, err := reg.Classify(features.Features{Embedding: dummyEmbedding})
+	require.NoError(t, err)
+	assert.Equal(t, 0.3, score)
+}
+
+func TestRegistry_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "classifier.onnx")
+	writeModel(t, path, 0.3, `{"version": "v1"}`)
+	reg, err := NewRegistry(path, 0.6, fileScoreLoader)
+	require.NoError(t, err)
+
+	go reg.Watch(t.Context(), 10*time.Millisecond)
+
+	writeModel(t, path, 0.7, `{"version": "v2"}`)
+	// Make sure the change is visible even on filesystems with coarse mtimes.
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+
+	require.Eventually(t, func() bool {
+		current, _ := reg.Info()
+		return current.Version == "v2"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestRegistry_WatchRetriesFailedReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "classifier.onnx")
+	writeModel(t, path, 0.3, `{"version": "v1"}`)
+	reg, err := NewRegistry(path, 0.6, fileScoreLoader)
+	require.NoError(t, err)
+
+	go reg.Watch(t.Context(), 10*time.Millisecond)
+
+	// The watch sees the file half-written...
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.WriteFile(path, []byte("not a"), 0o644))
+	require.NoError(t, os.Chtimes(path, later, later))
+	time.Sleep(50 * time.Millisecond)
+
+	// ...and the rest of the write lands within the same mtime tick.
+	writeModel(t, path, 0.7, `{"version": "v2"}`)
+	require.NoError(t, os.Chtimes(path, later, later))
+	require.NoError(t, os.Chtimes(sidecarPath(path), later, later))
+
+	require.Eventually(t, func() bool {
+		current, _ := reg.Info()
+		return current.Version == "v2"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestDecide_AutoUsesRegistryThreshold(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "classifier.onnx")
+	writeModel(t, path, 0.5, `{"version": "v1", "threshold": 0.4}`)
+	reg, err := NewRegistry(path, 0.6, fileScoreLoader)
+	require.NoError(t, err)
+
+	// Config threshold is 0.6, but the model's own 0.4 wins: 0.5 is complex.
+	rt := New(testConfig(), nil, reg)
+	model, err := rt.Route(dummyEmbedding, "auto", "anthropic")
+	require.NoError(t, err)
+	assert.Equal(t, "claude-sonnet-4-5-20250929", model)
+
+	// A reload swaps threshold and model together.
+	writeModel(t, path, 0.5, `{"version": "v2", "threshold": 0.55}`)
+	_, err = reg.Reload()
+	require.NoError(t, err)
+	model, err = rt.Route(dummyEmbedding, "auto", "anthropic")
+	require.NoError(t, err)
+	assert.Equal(t, "claude-haiku-4-5-20251001", model)
+
+	status, ok := rt.ClassifierStatus().(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, "v2", status["current"].(ClassifierInfo).Version)
+}
+
+func TestRouter_ClassifierAdminWithoutRegistry(t *testing.T) {
+	rt := New(testConfig(), nil, &mockClassifier{score: 0.5})
+
+	assert.Nil(t, rt.ClassifierStatus())
+	_, err := rt.ReloadClassifier()
+	assert.Error(t, err)
+	_, err = rt.RollbackClassifier()
+	assert.Error(t, err)
+}
+
+func TestSidecarPath(t *testing.T) {
+	assert.Equal(t, "models/complexity_classifier.json", sidecarPath("models/complexity_classifier.onnx"))
+	assert.Equal(t, "models/complexity_classifier.json", sidecarPath("models/complexity_classifier.gbt.json"))
+	assert.Equal(t, "", sidecarPath("models/complexity_classifier.json"))
+}
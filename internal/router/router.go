@@ -1,39 +1,110 @@
 // Package router implements cost-aware model routing and complexity classification.
 //
 // When a request arrives with "model": "auto", the router selects a concrete
-// model based on the routing strategy (auto, cheapest, quality) and the
-// configured per-provider cheap/quality model pairs.
+// model based on the routing strategy (auto, cheapest, quality, latency) and
+// the configured per-provider model tiers, or — with the cost strategy — the
+// cheapest model across all providers expected to handle the prompt.
 package router
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
+	"time"
 
 	"github.com/howard-nolan/llmrouter/internal/config"
+	"github.com/howard-nolan/llmrouter/internal/features"
 	"github.com/howard-nolan/llmrouter/internal/metrics"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+	"github.com/howard-nolan/llmrouter/internal/tokens"
 )
 
-// Classifier scores prompt complexity from an embedding vector. Returns a
-// value between 0 (simple) and 1 (complex). Defined here at the consumer
-// rather than in a classifier package — same interface-at-consumer pattern
-// used by the server package's Embedder interface.
+// Classifier scores prompt complexity from a request's features: the
+// prompt embedding, plus request-level features for models trained with
+// them (see package features). Returns a value between 0 (simple) and 1
+// (complex). Defined here at the consumer rather than in a classifier
+// package — same interface-at-consumer pattern used by the server
+// package's Embedder interface.
 type Classifier interface {
-	Classify(embedding []float32) (float64, error)
+	Classify(f features.Features) (float64, error)
+}
+
+var errNoRegistry = errors.New("classifier hot reload is not enabled")
+
+// InputError is a routing error caused by the request rather than the
+// router: an unknown strategy or experiment, or a provider override with no
+// routing config. Anything else — a classifier failure, a rule naming a
+// provider that isn't configured — is the gateway's fault.
+type InputError struct {
+	msg string
+}
+
+func (e *InputError) Error() string { return e.msg }
+
+// InvalidInput marks e as the client's fault, so callers can recognize it
+// without importing this package.
+func (e *InputError) InvalidInput() {}
+
+// inputErrorf returns an *InputError with a formatted message.
+func inputErrorf(format string, args ...any) error {
+	return &InputError{msg: fmt.Sprintf(format, args...)}
 }
 
 // Router selects a concrete model for "auto" requests based on the routing
 // strategy and an optional complexity classifier.
 type Router struct {
 	cfg        config.RoutingConfig
+	costs      map[string]config.ModelCost
 	classifier Classifier
+	tiers      map[string][]config.RoutingTier // per provider, ascending MinScore
+	latency    *latencyTracker
+	rules      []*rule
+	tokens     *tokens.Counter // prompt size estimates; see SetTokenCounter
+
+	experiments    []*experiment
+	isArm          bool // an experiment's treatment arm: decisions aren't counted in RoutingDecisions
+	fixedThreshold bool // cfg.ComplexityThreshold wins over a ThresholdedClassifier's
 }
 
-// New creates a Router. classifier may be nil — cheapest and quality
-// strategies will still work, but auto will return an error.
-func New(cfg config.RoutingConfig, classifier Classifier) *Router {
-	return &Router{
+// New creates a Router. costs is the per-model price table, used by the
+// cost strategy. classifier may be nil — cheapest and quality strategies
+// will still work, but auto and cost will return an error. A
+// ThresholdedClassifier (such as a Registry) supplies the threshold for
+// cheap_model/quality_model pairs in place of cfg.ComplexityThreshold.
+func New(cfg config.RoutingConfig, costs map[string]config.ModelCost, classifier Classifier) *Router {
+	tiers := make(map[string][]config.RoutingTier, len(cfg.Providers))
+	for name, p := range cfg.Providers {
+		tiers[name] = tiersFor(p, cfg.ComplexityThreshold)
+	}
+	rt := &Router{
 		cfg:        cfg,
+		costs:      costs,
 		classifier: classifier,
+		tiers:      tiers,
+		latency:    newLatencyTracker(),
+		rules:      compileRules(cfg.Rules),
+		tokens:     tokens.NewCounter(nil),
 	}
+	for _, exp := range cfg.Experiments {
+		rt.experiments = append(rt.experiments, newExperiment(rt, exp))
+	}
+	return rt
+}
+
+// tiersFor returns a provider's tier list sorted by MinScore. A provider
+// configured with only cheap_model/quality_model becomes two tiers split at
+// threshold, which routes exactly as the binary pair always did.
+func tiersFor(p config.RoutingProviderConfig, threshold float64) []config.RoutingTier {
+	if len(p.Tiers) == 0 {
+		return []config.RoutingTier{
+			{Model: p.CheapModel},
+			{Model: p.QualityModel, MinScore: threshold},
+		}
+	}
+	tiers := append([]config.RoutingTier(nil), p.Tiers...)
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].MinScore < tiers[j].MinScore })
+	return tiers
 }
 
 // Route picks a concrete model name given the prompt embedding, the
@@ -43,63 +114,301 @@ func New(cfg config.RoutingConfig, classifier Classifier) *Router {
 // Returns the model name (e.g. "claude-haiku-4-5-20251001"). The caller
 // uses the existing model-to-provider map to resolve the Provider from this.
 func (rt *Router) Route(embedding []float32, strategy string, providerName string) (string, error) {
-	// Fall back to config defaults for empty overrides.
+	model, _, err := rt.Decide(embedding, nil, nil, strategy, providerName, 0, "")
+	return model, err
+}
+
+// Decide is Route with the reasoning attached: reason is a short
+// human-readable account of the choice, returned to clients in a debug
+// header. req and header are the client's request, matched against
+// routing rules and used by the cost strategy to estimate input size;
+// either may be nil. latencyBudget puts the latency strategy in SLO mode;
+// 0 means none. experimentName routes with that experiment's treatment arm
+// (see Assign) instead of the base config; empty means the base config.
+//
+// Decide doesn't hold the request to its cost cap: a cache hit can serve
+// it for free under the chosen model. On a miss, FitBudget does.
+func (rt *Router) Decide(embedding []float32, req *provider.ChatRequest, header http.Header, strategy string, providerName string, latencyBudget time.Duration, experimentName string) (model, reason string, err error) {
+	d, err := rt.decide(embedding, req, header, strategy, providerName, latencyBudget, experimentName, true)
+	if err != nil {
+		return "", "", err
+	}
+	return d.Model, d.Reason, nil
+}
+
+// decide makes a routing decision and moves it to a larger-context model
+// if the request needs one. record=false is a dry run (Explain, FitBudget):
+// the same decision, but nothing is counted in the routing metrics.
+func (rt *Router) decide(embedding []float32, req *provider.ChatRequest, header http.Header, strategy string, providerName string, latencyBudget time.Duration, experimentName string, record bool) (Explanation, error) {
+	d, err := rt.route(embedding, req, header, strategy, providerName, latencyBudget, experimentName, record)
+	if err != nil {
+		return Explanation{}, err
+	}
+	rt.fitContext(&d, req, record)
+	// Experiment decisions are counted by experiment and arm instead.
+	if experimentName == "" {
+		rt.recordDecision(record, d.Strategy, d.Model)
+	}
+	return d, nil
+}
+
+// route picks a model by rule, experiment, or strategy.
+func (rt *Router) route(embedding []float32, req *provider.ChatRequest, header http.Header, strategy string, providerName string, latencyBudget time.Duration, experimentName string, record bool) (Explanation, error) {
+	if experimentName != "" {
+		e := rt.experiment(experimentName)
+		if e == nil {
+			return Explanation{}, inputErrorf("unknown routing experiment: %q", experimentName)
+		}
+		if e.cfg.Model != "" {
+			return Explanation{
+				Model:      e.cfg.Model,
+				Reason:     fmt.Sprintf("experiment %s: treatment model", e.cfg.Name),
+				Strategy:   "experiment",
+				Experiment: e.cfg.Name,
+			}, nil
+		}
+		d, err := e.arm.route(embedding, req, header, strategy, providerName, latencyBudget, "", record)
+		if err != nil {
+			return Explanation{}, err
+		}
+		d.Reason = fmt.Sprintf("experiment %s: %s", e.cfg.Name, d.Reason)
+		d.Experiment = e.cfg.Name
+		return d, nil
+	}
+
+	// Fall back to config defaults for empty overrides. A provider the
+	// request named is the client's to get right; the default isn't.
 	if strategy == "" {
 		strategy = rt.cfg.DefaultStrategy
 	}
+	overridden := providerName != ""
 	if providerName == "" {
 		providerName = rt.cfg.DefaultProvider
 	}
+	d := Explanation{Strategy: strategy, Provider: providerName}
+
+	// Rules run before anything is classified. A rule either settles the
+	// model outright or narrows the provider for the strategy below.
+	var rulePrefix string
+	if rl := rt.matchRule(req, header); rl != nil {
+		if record {
+			metrics.RoutingRuleMatches.WithLabelValues(rl.cfg.Name).Inc()
+		}
+		rulePrefix = fmt.Sprintf("rule %s: ", rl.cfg.Name)
+		d.Rule = rl.cfg.Name
 
-	// Look up the cheap/quality pair for this provider.
-	providerCfg, ok := rt.cfg.Providers[providerName]
+		if rl.cfg.Model != "" {
+			d.Model, d.Reason, d.Strategy, d.Provider = rl.cfg.Model, rulePrefix+"forced model", "rule", ""
+			return d, nil
+		}
+		if rl.cfg.Provider != "" {
+			providerName = rl.cfg.Provider
+			d.Provider = providerName
+			overridden = false
+		}
+		if rl.cfg.Tier != 0 {
+			tiers, ok := rt.tiers[providerName]
+			if !ok {
+				return Explanation{}, fmt.Errorf("%sno routing config for provider %q", rulePrefix, providerName)
+			}
+			tier, ok := tierAt(tiers, rl.cfg.Tier)
+			if !ok {
+				return Explanation{}, fmt.Errorf("%stier %d out of range for provider %q", rulePrefix, rl.cfg.Tier, providerName)
+			}
+			d.Model, d.Strategy = tier.Model, "rule"
+			d.Reason = fmt.Sprintf("%sforced %s tier %d", rulePrefix, providerName, rl.cfg.Tier)
+			return d, nil
+		}
+	}
+
+	// The cost strategy picks across providers, so it doesn't need (or
+	// consult) a tier list.
+	if strategy == "cost" {
+		score, _, err := rt.classify(embedding, req, record)
+		if err != nil {
+			return Explanation{}, err
+		}
+		var messages []provider.Message
+		if req != nil {
+			messages = req.Messages
+		}
+		model, reason, err := rt.cheapestClearing(score, messages)
+		if err != nil {
+			return Explanation{}, err
+		}
+		d.Model, d.Reason, d.Provider, d.ComplexityScore = model, rulePrefix+reason, "", &score
+		return d, nil
+	}
+
+	// Look up the tier list for this provider.
+	tiers, ok := rt.tiers[providerName]
+	if !ok && overridden {
+		return Explanation{}, inputErrorf("no routing config for provider %q", providerName)
+	}
 	if !ok {
-		return "", fmt.Errorf("no routing config for provider %q", providerName)
+		return Explanation{}, fmt.Errorf("no routing config for provider %q", providerName)
 	}
 
-	var selected string
 	switch strategy {
 	case "cheapest":
-		selected = providerCfg.CheapModel
+		d.Model = tiers[0].Model
+		d.Reason = fmt.Sprintf("cheapest: lowest %s tier", providerName)
 
 	case "quality":
-		selected = providerCfg.QualityModel
+		d.Model = tiers[len(tiers)-1].Model
+		d.Reason = fmt.Sprintf("quality: top %s tier", providerName)
 
-	case "auto":
-		if rt.classifier == nil {
-			return "", fmt.Errorf("auto routing requires a classifier, but none is configured")
-		}
+	case "latency":
+		d.Model, d.Reason = rt.pickByLatency(tiers, providerName, latencyBudget)
 
-		score, err := rt.classifier.Classify(embedding)
+	case "auto":
+		score, threshold, err := rt.classify(embedding, req, record)
 		if err != nil {
-			return "", fmt.Errorf("classifying prompt complexity: %w", err)
+			return Explanation{}, err
 		}
-
-		if score < rt.cfg.ComplexityThreshold {
-			selected = providerCfg.CheapModel
-		} else {
-			selected = providerCfg.QualityModel
+		if p := rt.cfg.Providers[providerName]; len(p.Tiers) == 0 && threshold != rt.cfg.ComplexityThreshold {
+			tiers = tiersFor(p, threshold)
 		}
+		tier := tierForScore(tiers, score)
+		d.Model = tier.Model
+		d.Reason = fmt.Sprintf("auto: score=%.3f in %s tier from %.3f", score, providerName, tier.MinScore)
+		d.ComplexityScore, d.Threshold = &score, &threshold
 
 	default:
-		return "", fmt.Errorf("unknown routing strategy: %q", strategy)
+		return Explanation{}, inputErrorf("unknown routing strategy: %q", strategy)
+	}
+
+	d.Reason = rulePrefix + d.Reason
+	return d, nil
+}
+
+// recordDecision counts a routing decision, unless it's a dry run or rt is
+// an experiment arm — those are counted by experiment and arm instead, and
+// a shadow arm's decisions never serve traffic.
+func (rt *Router) recordDecision(record bool, strategy, model string) {
+	if record && !rt.isArm {
+		metrics.RoutingDecisions.WithLabelValues(strategy, model).Inc()
 	}
+}
+
+// classify scores the prompt's complexity, for strategies that need it,
+// and returns the threshold between a cheap/quality pair: the classifier's
+// own if it carries one, else the configured ComplexityThreshold. record
+// counts the score in the complexity score distribution.
+func (rt *Router) classify(embedding []float32, req *provider.ChatRequest, record bool) (score, threshold float64, err error) {
+	if rt.classifier == nil {
+		return 0, 0, fmt.Errorf("auto routing requires a classifier, but none is configured")
+	}
+	f := features.Extract(embedding, req)
+	threshold = rt.cfg.ComplexityThreshold
+	if tc, ok := rt.classifier.(ThresholdedClassifier); ok && !rt.fixedThreshold {
+		score, threshold, err = tc.ClassifyWithThreshold(f)
+	} else {
+		score, err = rt.classifier.Classify(f)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("classifying prompt complexity: %w", err)
+	}
+	if record {
+		metrics.ComplexityScore.Observe(score)
+	}
+	return score, threshold, nil
+}
+
+// Score returns the prompt's complexity score without routing on it, for
+// recording alongside the request (e.g. as training data). Unlike routing
+// decisions, it isn't counted in the complexity score distribution.
+func (rt *Router) Score(embedding []float32, req *provider.ChatRequest) (float64, error) {
+	if rt.classifier == nil {
+		return 0, fmt.Errorf("no complexity classifier is configured")
+	}
+	return rt.classifier.Classify(features.Extract(embedding, req))
+}
 
-	metrics.RoutingDecisions.WithLabelValues(strategy, selected).Inc()
-	return selected, nil
+// HasClassifier reports whether a complexity classifier is configured.
+// Without one, auto routing and the cost strategy fail, but pinned models
+// and the cheapest and quality strategies work.
+func (rt *Router) HasClassifier() bool {
+	return rt.classifier != nil
 }
 
-// CheapAndQualityFor returns the configured cheap and quality model names for
-// the given provider. Used by the handler to compute routing-cost-savings when
-// auto routing selects the cheap model. Returns ok=false if the provider isn't
-// in the routing config.
-func (rt *Router) CheapAndQualityFor(providerName string) (cheap, quality string, ok bool) {
+// tierForScore returns the highest tier whose MinScore is at or below
+// score, or the first tier if score is below all of them.
+func tierForScore(tiers []config.RoutingTier, score float64) config.RoutingTier {
+	selected := tiers[0]
+	for _, t := range tiers[1:] {
+		if score < t.MinScore {
+			break
+		}
+		selected = t
+	}
+	return selected
+}
+
+// ObserveTTFT feeds a streamed response's time to first token into the
+// model's rolling latency estimate.
+func (rt *Router) ObserveTTFT(model string, ttft time.Duration) {
+	rt.latency.observeTTFT(model, ttft)
+}
+
+// ObserveOutcome feeds a request's success or failure into the model's
+// rolling error rate.
+func (rt *Router) ObserveOutcome(model string, failed bool) {
+	rt.latency.observeOutcome(model, failed)
+}
+
+// registry returns the classifier as a Registry, or nil if it isn't one
+// (hot reload not configured).
+func (rt *Router) registry() *Registry {
+	reg, _ := rt.classifier.(*Registry)
+	return reg
+}
+
+// ClassifierStatus describes the live classifier and the rollback target,
+// for /health. Nil unless the classifier is a Registry.
+func (rt *Router) ClassifierStatus() any {
+	reg := rt.registry()
+	if reg == nil {
+		return nil
+	}
+	current, previous := reg.Info()
+	return map[string]any{"current": current, "previous": previous}
+}
+
+// ReloadClassifier reloads the classifier from disk (see Registry.Reload).
+func (rt *Router) ReloadClassifier() (any, error) {
+	reg := rt.registry()
+	if reg == nil {
+		return nil, errNoRegistry
+	}
+	return reg.Reload()
+}
+
+// RollbackClassifier restores the previous classifier (see
+// Registry.Rollback).
+func (rt *Router) RollbackClassifier() (any, error) {
+	reg := rt.registry()
+	if reg == nil {
+		return nil, errNoRegistry
+	}
+	return reg.Rollback()
+}
+
+// TiersFor returns the model names in the given provider's tier list,
+// cheapest first. Used by the handler to compute routing-cost-savings when
+// auto routing selects anything below the top tier. Returns ok=false if the
+// provider isn't in the routing config.
+func (rt *Router) TiersFor(providerName string) (models []string, ok bool) {
 	if providerName == "" {
 		providerName = rt.cfg.DefaultProvider
 	}
-	cfg, ok := rt.cfg.Providers[providerName]
+	tiers, ok := rt.tiers[providerName]
 	if !ok {
-		return "", "", false
+		return nil, false
+	}
+	models = make([]string, len(tiers))
+	for i, t := range tiers {
+		models[i] = t.Model
 	}
-	return cfg.CheapModel, cfg.QualityModel, true
+	return models, true
 }
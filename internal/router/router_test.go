@@ -1,20 +1,30 @@
 package router
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/howard-nolan/llmrouter/internal/config"
+	"github.com/howard-nolan/llmrouter/internal/features"
+	"github.com/howard-nolan/llmrouter/internal/provider"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-// mockClassifier implements Classifier with a fixed score.
+// mockClassifier implements Classifier with a fixed score, remembering
+// the features it was last given.
 type mockClassifier struct {
 	score float64
 	err   error
+	last  features.Features
 }
 
-func (m *mockClassifier) Classify(embedding []float32) (float64, error) {
+func (m *mockClassifier) Classify(f features.Features) (float64, error) {
+	m.last = f
 	return m.score, m.err
 }
 
@@ -39,8 +49,15 @@ func testConfig() config.RoutingConfig {
 
 var dummyEmbedding = []float32{0.1, 0.2, 0.3}
 
+// promptOf returns a one-message request with n characters of content.
+func promptOf(n int) *provider.ChatRequest {
+	return &provider.ChatRequest{Messages: []provider.Message{
+		{Role: "user", Content: strings.Repeat("x", n)},
+	}}
+}
+
 func TestRoute_CheapestStrategy(t *testing.T) {
-	rt := New(testConfig(), nil)
+	rt := New(testConfig(), nil, nil)
 
 	model, err := rt.Route(dummyEmbedding, "cheapest", "anthropic")
 	require.NoError(t, err)
@@ -48,7 +65,7 @@ func TestRoute_CheapestStrategy(t *testing.T) {
 }
 
 func TestRoute_QualityStrategy(t *testing.T) {
-	rt := New(testConfig(), nil)
+	rt := New(testConfig(), nil, nil)
 
 	model, err := rt.Route(dummyEmbedding, "quality", "google")
 	require.NoError(t, err)
@@ -57,7 +74,7 @@ func TestRoute_QualityStrategy(t *testing.T) {
 
 func TestRoute_AutoStrategy_BelowThreshold(t *testing.T) {
 	// Score 0.3 < threshold 0.6 → cheap model.
-	rt := New(testConfig(), &mockClassifier{score: 0.3})
+	rt := New(testConfig(), nil, &mockClassifier{score: 0.3})
 
 	model, err := rt.Route(dummyEmbedding, "auto", "anthropic")
 	require.NoError(t, err)
@@ -66,7 +83,7 @@ func TestRoute_AutoStrategy_BelowThreshold(t *testing.T) {
 
 func TestRoute_AutoStrategy_AboveThreshold(t *testing.T) {
 	// Score 0.8 >= threshold 0.6 → quality model.
-	rt := New(testConfig(), &mockClassifier{score: 0.8})
+	rt := New(testConfig(), nil, &mockClassifier{score: 0.8})
 
 	model, err := rt.Route(dummyEmbedding, "auto", "anthropic")
 	require.NoError(t, err)
@@ -75,7 +92,7 @@ func TestRoute_AutoStrategy_AboveThreshold(t *testing.T) {
 
 func TestRoute_AutoStrategy_ExactlyAtThreshold(t *testing.T) {
 	// Score 0.6 == threshold 0.6 → quality model (not strictly less than).
-	rt := New(testConfig(), &mockClassifier{score: 0.6})
+	rt := New(testConfig(), nil, &mockClassifier{score: 0.6})
 
 	model, err := rt.Route(dummyEmbedding, "auto", "anthropic")
 	require.NoError(t, err)
@@ -83,7 +100,7 @@ func TestRoute_AutoStrategy_ExactlyAtThreshold(t *testing.T) {
 }
 
 func TestRoute_AutoStrategy_NilClassifier(t *testing.T) {
-	rt := New(testConfig(), nil)
+	rt := New(testConfig(), nil, nil)
 
 	_, err := rt.Route(dummyEmbedding, "auto", "anthropic")
 	assert.Error(t, err)
@@ -93,7 +110,7 @@ func TestRoute_AutoStrategy_NilClassifier(t *testing.T) {
 func TestRoute_DefaultsFallback(t *testing.T) {
 	// Empty strategy and provider should use config defaults
 	// (auto strategy, anthropic provider).
-	rt := New(testConfig(), &mockClassifier{score: 0.3})
+	rt := New(testConfig(), nil, &mockClassifier{score: 0.3})
 
 	model, err := rt.Route(dummyEmbedding, "", "")
 	require.NoError(t, err)
@@ -102,7 +119,7 @@ func TestRoute_DefaultsFallback(t *testing.T) {
 
 func TestRoute_ProviderOverride(t *testing.T) {
 	// Default provider is anthropic, but override to google.
-	rt := New(testConfig(), nil)
+	rt := New(testConfig(), nil, nil)
 
 	model, err := rt.Route(dummyEmbedding, "cheapest", "google")
 	require.NoError(t, err)
@@ -110,25 +127,531 @@ func TestRoute_ProviderOverride(t *testing.T) {
 }
 
 func TestRoute_UnknownProvider(t *testing.T) {
-	rt := New(testConfig(), nil)
+	rt := New(testConfig(), nil, nil)
 
 	_, err := rt.Route(dummyEmbedding, "cheapest", "openai")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "openai")
+	var inputErr *InputError
+	assert.True(t, errors.As(err, &inputErr), "a bad override is the client's error")
+}
+
+func TestRoute_UnconfiguredDefaultProvider(t *testing.T) {
+	cfg := testConfig()
+	cfg.DefaultProvider = "openai"
+	rt := New(cfg, nil, nil)
+
+	_, err := rt.Route(dummyEmbedding, "cheapest", "")
+	assert.Error(t, err)
+	var inputErr *InputError
+	assert.False(t, errors.As(err, &inputErr), "a bad default is the gateway's error")
 }
 
 func TestRoute_UnknownStrategy(t *testing.T) {
-	rt := New(testConfig(), nil)
+	rt := New(testConfig(), nil, nil)
 
 	_, err := rt.Route(dummyEmbedding, "fastest", "anthropic")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "fastest")
+	var inputErr *InputError
+	assert.True(t, errors.As(err, &inputErr))
 }
 
 func TestRoute_ClassifierError(t *testing.T) {
-	rt := New(testConfig(), &mockClassifier{err: assert.AnError})
+	rt := New(testConfig(), nil, &mockClassifier{err: assert.AnError})
 
 	_, err := rt.Route(dummyEmbedding, "auto", "anthropic")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "classifying")
+	var inputErr *InputError
+	assert.False(t, errors.As(err, &inputErr))
+}
+
+// tieredConfig returns a RoutingConfig whose google provider has three
+// tiers, listed out of order to check that New sorts them.
+func tieredConfig() config.RoutingConfig {
+	cfg := testConfig()
+	cfg.DefaultProvider = "google"
+	cfg.Providers["google"] = config.RoutingProviderConfig{
+		Tiers: []config.RoutingTier{
+			{Model: "gemini-2.5-pro", MinScore: 0.7},
+			{Model: "gemini-2.0-flash-lite", MinScore: 0},
+			{Model: "gemini-2.0-flash", MinScore: 0.3},
+		},
+	}
+	return cfg
+}
+
+func TestRoute_AutoStrategy_Tiers(t *testing.T) {
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{0.0, "gemini-2.0-flash-lite"},
+		{0.29, "gemini-2.0-flash-lite"},
+		{0.3, "gemini-2.0-flash"},
+		{0.69, "gemini-2.0-flash"},
+		{0.7, "gemini-2.5-pro"},
+		{1.0, "gemini-2.5-pro"},
+	}
+	for _, tt := range tests {
+		rt := New(tieredConfig(), nil, &mockClassifier{score: tt.score})
+
+		model, err := rt.Route(dummyEmbedding, "auto", "google")
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, model, "score %.2f", tt.score)
+	}
+}
+
+func TestRoute_Tiers_CheapestAndQuality(t *testing.T) {
+	rt := New(tieredConfig(), nil, nil)
+
+	model, err := rt.Route(dummyEmbedding, "cheapest", "google")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.0-flash-lite", model)
+
+	model, err = rt.Route(dummyEmbedding, "quality", "google")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.5-pro", model)
+}
+
+func TestRoute_Tiers_ScoreBelowFirstTier(t *testing.T) {
+	// The first tier takes scores below its own MinScore.
+	cfg := testConfig()
+	cfg.Providers["google"] = config.RoutingProviderConfig{
+		Tiers: []config.RoutingTier{
+			{Model: "gemini-2.0-flash", MinScore: 0.2},
+			{Model: "gemini-2.5-pro", MinScore: 0.7},
+		},
+	}
+	rt := New(cfg, nil, &mockClassifier{score: 0.1})
+
+	model, err := rt.Route(dummyEmbedding, "auto", "google")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.0-flash", model)
+}
+
+func TestTiersFor(t *testing.T) {
+	rt := New(tieredConfig(), nil, nil)
+
+	// Tiered provider, cheapest first.
+	models, ok := rt.TiersFor("google")
+	require.True(t, ok)
+	assert.Equal(t, []string{"gemini-2.0-flash-lite", "gemini-2.0-flash", "gemini-2.5-pro"}, models)
+
+	// Legacy cheap/quality pair becomes two tiers.
+	models, ok = rt.TiersFor("anthropic")
+	require.True(t, ok)
+	assert.Equal(t, []string{"claude-haiku-4-5-20251001", "claude-sonnet-4-5-20250929"}, models)
+
+	// Empty provider falls back to the default.
+	models, ok = rt.TiersFor("")
+	require.True(t, ok)
+	assert.Equal(t, "gemini-2.5-pro", models[len(models)-1])
+
+	_, ok = rt.TiersFor("openai")
+	assert.False(t, ok)
+}
+
+// costConfig returns a RoutingConfig with quality scores for models across
+// both providers, and a matching price table.
+func costConfig() (config.RoutingConfig, map[string]config.ModelCost) {
+	cfg := testConfig()
+	cfg.EstimatedOutputTokens = 1000
+	cfg.QualityScores = map[string]float64{
+		"gemini-2.0-flash":           0.4,
+		"claude-haiku-4-5-20251001":  0.6,
+		"gemini-2.5-pro":             0.9,
+		"claude-sonnet-4-5-20250929": 0.95,
+		"unpriced-model":             1.0, // no cost entry: never a candidate
+	}
+	costs := map[string]config.ModelCost{
+		"gemini-2.0-flash":           {InputPerMillion: 0.10, OutputPerMillion: 0.40},
+		"claude-haiku-4-5-20251001":  {InputPerMillion: 1.00, OutputPerMillion: 5.00},
+		"gemini-2.5-pro":             {InputPerMillion: 1.25, OutputPerMillion: 10.00},
+		"claude-sonnet-4-5-20250929": {InputPerMillion: 3.00, OutputPerMillion: 15.00},
+	}
+	return cfg, costs
+}
+
+func TestDecide_CostStrategy(t *testing.T) {
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{0.2, "gemini-2.0-flash"},          // cheapest clears the bar
+		{0.5, "claude-haiku-4-5-20251001"}, // crosses providers
+		{0.9, "gemini-2.5-pro"},            // cheaper than sonnet at equal bar
+		{0.92, "claude-sonnet-4-5-20250929"},
+		{0.99, "claude-sonnet-4-5-20250929"}, // nothing clears: highest quality
+	}
+	for _, tt := range tests {
+		cfg, costs := costConfig()
+		rt := New(cfg, costs, &mockClassifier{score: tt.score})
+
+		// X-Provider is ignored: candidates come from every provider.
+		model, reason, err := rt.Decide(dummyEmbedding, promptOf(800), nil, "cost", "google", 0, "")
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, model, "score %.2f", tt.score)
+		assert.Contains(t, reason, "cost: score=")
+		assert.Contains(t, reason, tt.want)
+	}
+}
+
+func TestDecide_CostStrategy_ReasonListsSkipped(t *testing.T) {
+	cfg, costs := costConfig()
+	rt := New(cfg, costs, &mockClassifier{score: 0.5})
+
+	_, reason, err := rt.Decide(dummyEmbedding, promptOf(800), nil, "cost", "", 0, "")
+	require.NoError(t, err)
+	// 800 characters → 200 estimated input tokens; 1000 tokens scaled by (0.5 + 0.5) → 1000 estimated output tokens.
+	assert.Contains(t, reason, "est_tokens=200+1000")
+	assert.Contains(t, reason, "cheaper below bar: gemini-2.0-flash(q=0.40)")
+}
+
+func TestDecide_CostStrategy_NoCandidates(t *testing.T) {
+	rt := New(testConfig(), nil, &mockClassifier{score: 0.5})
+
+	_, _, err := rt.Decide(dummyEmbedding, nil, nil, "cost", "", 0, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "quality score")
+}
+
+func TestDecide_CostStrategy_NilClassifier(t *testing.T) {
+	cfg, costs := costConfig()
+	rt := New(cfg, costs, nil)
+
+	_, _, err := rt.Decide(dummyEmbedding, nil, nil, "cost", "", 0, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "classifier")
+}
+
+func TestDecide_ReasonForTierStrategies(t *testing.T) {
+	rt := New(testConfig(), nil, &mockClassifier{score: 0.8})
+
+	_, reason, err := rt.Decide(dummyEmbedding, nil, nil, "auto", "anthropic", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "auto: score=0.800 in anthropic tier from 0.600", reason)
+
+	_, reason, err = rt.Decide(dummyEmbedding, nil, nil, "cheapest", "google", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "cheapest: lowest google tier", reason)
+}
+
+func TestDecide_ClassifierSeesRequestFeatures(t *testing.T) {
+	clf := &mockClassifier{score: 0.2}
+	rt := New(testConfig(), nil, clf)
+
+	req := &provider.ChatRequest{
+		MaxTokens: 1024,
+		Messages: []provider.Message{
+			{Role: "system", Content: "You are a reviewer."},
+			{Role: "user", Content: "```go\nfunc f() {}\n```"},
+			{Role: "assistant", Content: "Looks fine."},
+			{Role: "user", Content: "Any races?"},
+		},
+	}
+	_, _, err := rt.Decide(dummyEmbedding, req, nil, "auto", "anthropic", 0, "")
+	require.NoError(t, err)
+
+	assert.Equal(t, features.Extract(dummyEmbedding, req), clf.last)
+	assert.Equal(t, 4, clf.last.MessageCount)
+	assert.Equal(t, 2, clf.last.UserTurns)
+	assert.True(t, clf.last.HasCodeFence)
+}
+
+// observeTTFTs feeds n identical TTFT samples for model.
+func observeTTFTs(rt *Router, model string, ttft time.Duration, n int) {
+	for range n {
+		rt.ObserveTTFT(model, ttft)
+	}
+}
+
+func TestLatencyTracker_EWMA(t *testing.T) {
+	lt := newLatencyTracker()
+
+	_, trusted := lt.get("m")
+	assert.False(t, trusted, "no samples yet")
+
+	for range latencyMinSamples {
+		lt.observeTTFT("m", 200*time.Millisecond)
+	}
+	s, trusted := lt.get("m")
+	require.True(t, trusted)
+	assert.InDelta(t, 0.2, s.mean, 1e-9)
+	assert.Equal(t, 200*time.Millisecond, s.p90(), "constant samples have no spread")
+
+	// A slow outlier moves the mean by alpha of the difference and widens p90.
+	lt.observeTTFT("m", 1200*time.Millisecond)
+	s, _ = lt.get("m")
+	assert.InDelta(t, 0.3, s.mean, 1e-9)
+	assert.Greater(t, s.p90(), 300*time.Millisecond)
+
+	// Failures raise the error rate, which inflates effective latency.
+	lt.observeOutcome("m", true)
+	s, _ = lt.get("m")
+	assert.InDelta(t, latencyAlpha, s.errorRate, 1e-9)
+	assert.Greater(t, s.effective(), s.p90())
+}
+
+func TestDecide_LatencyStrategy_Fastest(t *testing.T) {
+	rt := New(tieredConfig(), nil, nil)
+	observeTTFTs(rt, "gemini-2.0-flash-lite", 900*time.Millisecond, 10)
+	observeTTFTs(rt, "gemini-2.0-flash", 300*time.Millisecond, 10)
+
+	// The pro tier is unmeasured, so it's tried first to get measured.
+	model, reason, err := rt.Decide(dummyEmbedding, nil, nil, "latency", "google", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.5-pro", model)
+	assert.Contains(t, reason, "unmeasured")
+
+	observeTTFTs(rt, "gemini-2.5-pro", 2*time.Second, 10)
+	model, reason, err = rt.Decide(dummyEmbedding, nil, nil, "latency", "google", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.0-flash", model)
+	assert.Contains(t, reason, "p90=300ms")
+}
+
+func TestDecide_LatencyStrategy_Budget(t *testing.T) {
+	rt := New(tieredConfig(), nil, nil)
+	observeTTFTs(rt, "gemini-2.0-flash-lite", 900*time.Millisecond, 10)
+	observeTTFTs(rt, "gemini-2.0-flash", 300*time.Millisecond, 10)
+	observeTTFTs(rt, "gemini-2.5-pro", 2*time.Second, 10)
+
+	// Cheapest tier that fits the budget.
+	model, _, err := rt.Decide(dummyEmbedding, nil, nil, "latency", "google", time.Second, "")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.0-flash-lite", model)
+
+	model, _, err = rt.Decide(dummyEmbedding, nil, nil, "latency", "google", 500*time.Millisecond, "")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.0-flash", model)
+
+	// Nothing fits: fall back to the fastest.
+	model, reason, err := rt.Decide(dummyEmbedding, nil, nil, "latency", "google", 100*time.Millisecond, "")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.0-flash", model)
+	assert.Contains(t, reason, "no google tier within it")
+
+	// A failing model no longer fits a budget its raw TTFT would meet.
+	for range 10 {
+		rt.ObserveOutcome("gemini-2.0-flash-lite", true)
+	}
+	model, _, err = rt.Decide(dummyEmbedding, nil, nil, "latency", "google", time.Second, "")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.0-flash", model)
+}
+
+func experimentConfig(exps ...config.ExperimentConfig) config.RoutingConfig {
+	cfg := testConfig()
+	cfg.Experiments = exps
+	return cfg
+}
+
+// bearer returns headers carrying key as an OpenAI-style bearer token.
+func bearer(key string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + key}}
+}
+
+func TestAssign_NoExperiments(t *testing.T) {
+	rt := New(testConfig(), nil, nil)
+
+	exp, arm, shadow := rt.Assign(bearer("key"), "req")
+	assert.Empty(t, exp)
+	assert.Empty(t, arm)
+	assert.False(t, shadow)
+}
+
+func TestAssign_PercentAndStickiness(t *testing.T) {
+	rt := New(experimentConfig(config.ExperimentConfig{Name: "tenth", Percent: 10}), nil, nil)
+
+	counts := map[string]int{}
+	for i := range 1000 {
+		key := fmt.Sprintf("key-%d", i)
+		exp, arm, _ := rt.Assign(bearer(key), "req")
+		counts[exp+"/"+arm]++
+
+		// Sticky by API key: a different request from the same key lands
+		// in the same arm.
+		again, againArm, _ := rt.Assign(bearer(key), "other-req")
+		assert.Equal(t, exp, again)
+		assert.Equal(t, arm, againArm)
+	}
+	assert.Len(t, counts, 3)
+	assert.InDelta(t, 100, counts["tenth/"+ArmTreatment], 40, "roughly a tenth of the keys are treated")
+	assert.InDelta(t, 100, counts["tenth/"+ArmControl], 40, "and as many held out as control")
+	assert.InDelta(t, 800, counts[NoExperiment+"/"+ArmControl], 60)
+}
+
+func TestAssign_ControlShareFitsUnder100(t *testing.T) {
+	rt := New(experimentConfig(config.ExperimentConfig{Name: "most", Percent: 80}), nil, nil)
+
+	treated := 0
+	for i := range 1000 {
+		exp, arm, _ := rt.Assign(bearer(fmt.Sprintf("key-%d", i)), "req")
+		assert.Equal(t, "most", exp, "the rest of the traffic is control")
+		if arm == ArmTreatment {
+			treated++
+		}
+	}
+	assert.InDelta(t, 800, treated, 60)
+}
+
+func TestAssign_StickyByRequest(t *testing.T) {
+	rt := New(experimentConfig(config.ExperimentConfig{Name: "e", Percent: 50, StickyBy: "request"}), nil, nil)
+
+	// The API key is ignored: the request key alone decides.
+	for i := range 100 {
+		req := fmt.Sprintf("req-%d", i)
+		_, a1, _ := rt.Assign(bearer("key-a"), req)
+		_, a2, _ := rt.Assign(bearer("key-b"), req)
+		assert.Equal(t, a1, a2)
+	}
+}
+
+func TestAssign_Shadow(t *testing.T) {
+	rt := New(experimentConfig(config.ExperimentConfig{Name: "s", Percent: 100, Shadow: true}), nil, nil)
+
+	exp, arm, shadow := rt.Assign(nil, "req")
+	assert.Equal(t, "s", exp)
+	assert.Equal(t, ArmControl, arm, "shadowed requests are served by control")
+	assert.True(t, shadow)
+}
+
+func TestDecide_ExperimentArm(t *testing.T) {
+	rt := New(experimentConfig(
+		config.ExperimentConfig{Name: "lower-threshold", Percent: 10, ComplexityThreshold: 0.2},
+		config.ExperimentConfig{Name: "forced", Percent: 10, Model: "gemini-2.5-pro"},
+	), nil, &mockClassifier{score: 0.4})
+
+	// Control: 0.4 < 0.6 → cheap.
+	model, _, err := rt.Decide(dummyEmbedding, nil, nil, "auto", "anthropic", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "claude-haiku-4-5-20251001", model)
+
+	// Treatment: 0.4 >= 0.2 → quality.
+	model, reason, err := rt.Decide(dummyEmbedding, nil, nil, "auto", "anthropic", 0, "lower-threshold")
+	require.NoError(t, err)
+	assert.Equal(t, "claude-sonnet-4-5-20250929", model)
+	assert.Contains(t, reason, "experiment lower-threshold: auto:")
+
+	model, _, err = rt.Decide(dummyEmbedding, nil, nil, "auto", "anthropic", 0, "forced")
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.5-pro", model)
+
+	_, _, err = rt.Decide(dummyEmbedding, nil, nil, "auto", "anthropic", 0, "missing")
+	assert.Error(t, err)
+}
+
+// rulesConfig returns testConfig with the given rules.
+func rulesConfig(rules ...config.RoutingRule) config.RoutingConfig {
+	cfg := testConfig()
+	cfg.Rules = rules
+	return cfg
+}
+
+func TestDecide_Rules(t *testing.T) {
+	cfg := rulesConfig(
+		config.RoutingRule{Name: "code", PromptRegex: "```", Tier: -1},
+		config.RoutingRule{Name: "legal", Tenants: []string{"legal"}, Model: "gemini-2.5-pro"},
+		config.RoutingRule{Name: "vip", APIKeys: []string{"sk-vip"}, Provider: "google", Tier: 1},
+		config.RoutingRule{Name: "long", MinTokens: 1000, Tier: -1},
+		config.RoutingRule{Name: "chatty", MinMessages: 3, Headers: map[string]string{"X-Client": "ide"}, Provider: "google"},
+	)
+	// Without a rule, the classifier would send everything to haiku.
+	rt := New(cfg, nil, &mockClassifier{score: 0.1})
+
+	tests := []struct {
+		name       string
+		req        *provider.ChatRequest
+		header     http.Header
+		wantModel  string
+		wantReason string
+	}{
+		{
+			name:       "no rule",
+			req:        promptOf(10),
+			wantModel:  "claude-haiku-4-5-20251001",
+			wantReason: "auto:",
+		},
+		{
+			name: "prompt regex forces top tier",
+			req: &provider.ChatRequest{Messages: []provider.Message{
+				{Role: "user", Content: "fix this:\n```go\nfunc main() {}\n```"},
+			}},
+			wantModel:  "claude-sonnet-4-5-20250929",
+			wantReason: "rule code: forced anthropic tier -1",
+		},
+		{
+			name:       "tenant forces model",
+			req:        promptOf(10),
+			header:     http.Header{"X-Tenant": {"legal"}},
+			wantModel:  "gemini-2.5-pro",
+			wantReason: "rule legal: forced model",
+		},
+		{
+			name:       "api key forces provider and tier",
+			req:        promptOf(10),
+			header:     http.Header{"Authorization": {"Bearer sk-vip"}},
+			wantModel:  "gemini-2.0-flash",
+			wantReason: "rule vip: forced google tier 1",
+		},
+		{
+			name:       "estimated tokens",
+			req:        promptOf(4000),
+			wantModel:  "claude-sonnet-4-5-20250929",
+			wantReason: "rule long:",
+		},
+		{
+			name: "provider only, strategy still runs",
+			req: &provider.ChatRequest{Messages: []provider.Message{
+				{Role: "user", Content: "a"}, {Role: "assistant", Content: "b"}, {Role: "user", Content: "c"},
+			}},
+			header:     http.Header{"X-Client": {"ide"}},
+			wantModel:  "gemini-2.0-flash",
+			wantReason: "rule chatty: auto: score=0.100 in google tier",
+		},
+		{
+			name: "header mismatch",
+			req: &provider.ChatRequest{Messages: []provider.Message{
+				{Role: "user", Content: "a"}, {Role: "assistant", Content: "b"}, {Role: "user", Content: "c"},
+			}},
+			header:     http.Header{"X-Client": {"web"}},
+			wantModel:  "claude-haiku-4-5-20251001",
+			wantReason: "auto:",
+		},
+	}
+	for _, tt := range tests {
+		model, reason, err := rt.Decide(dummyEmbedding, tt.req, tt.header, "auto", "", 0, "")
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.wantModel, model, tt.name)
+		assert.True(t, strings.HasPrefix(reason, tt.wantReason), "%s: reason %q", tt.name, reason)
+	}
+}
+
+func TestDecide_RuleTierOutOfRange(t *testing.T) {
+	rt := New(rulesConfig(config.RoutingRule{Name: "bad-tier", Tier: 5}), nil, nil)
+
+	_, _, err := rt.Decide(dummyEmbedding, promptOf(10), nil, "auto", "anthropic", 0, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bad-tier")
+}
+
+func TestValidateRules(t *testing.T) {
+	assert.NoError(t, ValidateRules([]config.RoutingRule{{Name: "ok", PromptRegex: "```", Tier: -1}}))
+
+	for _, bad := range []config.RoutingRule{
+		{PromptRegex: "x", Tier: 1},                    // no name
+		{Name: "no-action", PromptRegex: "x"},          // nothing to force
+		{Name: "bad-regex", PromptRegex: "(", Tier: 1}, // doesn't compile
+		{Name: "both", Model: "m", Provider: "google"}, // model plus provider
+	} {
+		assert.Error(t, ValidateRules([]config.RoutingRule{bad}), bad.Name)
+	}
+
+	// Invalid rules are dropped rather than failing every request.
+	rt := New(rulesConfig(config.RoutingRule{Name: "bad-regex", PromptRegex: "(", Tier: 1}), nil, &mockClassifier{score: 0.1})
+	model, _, err := rt.Decide(dummyEmbedding, promptOf(10), nil, "auto", "", 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, "claude-haiku-4-5-20251001", model)
 }
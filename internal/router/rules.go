@@ -0,0 +1,142 @@
+package router
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/howard-nolan/llmrouter/internal/config"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// tenantHeader carries the caller's tenant for RoutingRule.Tenants.
+const tenantHeader = "X-Tenant"
+
+// rule is a compiled config.RoutingRule.
+type rule struct {
+	cfg    config.RoutingRule
+	prompt *regexp.Regexp // nil if the rule doesn't match on prompt text
+}
+
+// ValidateRules reports the first invalid routing rule: a bad prompt
+// regex, no action, or a model combined with a provider or tier.
+func ValidateRules(rules []config.RoutingRule) error {
+	for _, r := range rules {
+		if _, err := compileRule(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compileRule(r config.RoutingRule) (*rule, error) {
+	if r.Name == "" {
+		return nil, fmt.Errorf("routing rule needs a name")
+	}
+	if r.Model == "" && r.Provider == "" && r.Tier == 0 {
+		return nil, fmt.Errorf("routing rule %q: needs a model, provider, or tier to force", r.Name)
+	}
+	if r.Model != "" && (r.Provider != "" || r.Tier != 0) {
+		return nil, fmt.Errorf("routing rule %q: model can't be combined with provider or tier", r.Name)
+	}
+	compiled := &rule{cfg: r}
+	if r.PromptRegex != "" {
+		re, err := regexp.Compile(r.PromptRegex)
+		if err != nil {
+			return nil, fmt.Errorf("routing rule %q: prompt_regex: %w", r.Name, err)
+		}
+		compiled.prompt = re
+	}
+	return compiled, nil
+}
+
+// compileRules compiles the rules that are valid and logs the rest — call
+// ValidateRules first to refuse them instead.
+func compileRules(rules []config.RoutingRule) []*rule {
+	var out []*rule
+	for _, r := range rules {
+		compiled, err := compileRule(r)
+		if err != nil {
+			log.Printf("ignoring %v", err)
+			continue
+		}
+		out = append(out, compiled)
+	}
+	return out
+}
+
+// matches reports whether every condition the rule sets holds for req,
+// whose input is estimated at promptTokens. A nil req (Route's callers)
+// matches only rules with no request-based conditions.
+func (r *rule) matches(req *provider.ChatRequest, header http.Header, promptTokens int) bool {
+	c := r.cfg
+	var messages []provider.Message
+	if req != nil {
+		messages = req.Messages
+	}
+
+	if r.prompt != nil && !slices.ContainsFunc(messages, func(m provider.Message) bool {
+		return r.prompt.MatchString(m.Content)
+	}) {
+		return false
+	}
+	if c.MinMessages > 0 && len(messages) < c.MinMessages {
+		return false
+	}
+	if c.MinTokens > 0 && promptTokens < c.MinTokens {
+		return false
+	}
+	if len(c.APIKeys) > 0 && !slices.Contains(c.APIKeys, apiKey(header)) {
+		return false
+	}
+	if len(c.Tenants) > 0 && !slices.Contains(c.Tenants, header.Get(tenantHeader)) {
+		return false
+	}
+	for name, value := range c.Headers {
+		if header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRule returns the first rule that matches, or nil.
+func (rt *Router) matchRule(req *provider.ChatRequest, header http.Header) *rule {
+	var promptTokens int
+	if req != nil {
+		// Rules match before a model is chosen, so the estimate is
+		// model-independent.
+		promptTokens = rt.promptTokens("", req.Messages)
+	}
+	for _, r := range rt.rules {
+		if r.matches(req, header, promptTokens) {
+			return r
+		}
+	}
+	return nil
+}
+
+// tierAt returns the tier at a rule's 1-based index, counting from the
+// cheapest; negative indexes count from the top (-1 is the top tier).
+func tierAt(tiers []config.RoutingTier, index int) (config.RoutingTier, bool) {
+	i := index - 1
+	if index < 0 {
+		i = len(tiers) + index
+	}
+	if index == 0 || i < 0 || i >= len(tiers) {
+		return config.RoutingTier{}, false
+	}
+	return tiers[i], true
+}
+
+// apiKey returns the caller's API key: the bearer token (OpenAI style) or
+// x-api-key (Anthropic style). Empty if neither is set.
+func apiKey(header http.Header) string {
+	if auth := header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return header.Get("X-Api-Key")
+}
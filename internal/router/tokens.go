@@ -0,0 +1,39 @@
+package router
+
+import (
+	"context"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+	"github.com/howard-nolan/llmrouter/internal/tokens"
+)
+
+// SetTokenCounter replaces the Router's token counter, which estimates
+// prompt sizes for routing rules, the cost strategy, and cost caps. New
+// starts with an uncalibrated counter that can't reach any provider's
+// counting API; main.go installs one that can, shared with the server
+// through CountTokens and ObservePromptTokens.
+func (rt *Router) SetTokenCounter(c *tokens.Counter) {
+	rt.tokens = c
+	for _, e := range rt.experiments {
+		e.arm.tokens = c
+	}
+}
+
+// promptTokens estimates messages' input tokens on model. An empty model
+// (routing rules, which match before a model is chosen) gets the
+// model-independent estimate.
+func (rt *Router) promptTokens(model string, messages []provider.Message) int {
+	return rt.tokens.Estimate(model, messages)
+}
+
+// CountTokens returns req's input tokens on req.Model, from the provider's
+// counting API if it has one, otherwise the local estimate.
+func (rt *Router) CountTokens(ctx context.Context, req *provider.ChatRequest) tokens.Count {
+	return rt.tokens.Count(ctx, req)
+}
+
+// ObservePromptTokens calibrates the local estimate for model against the
+// prompt token count a provider reported for messages.
+func (rt *Router) ObservePromptTokens(model string, messages []provider.Message, promptTokens int) {
+	rt.tokens.Observe(model, messages, promptTokens)
+}
@@ -0,0 +1,35 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/howard-nolan/llmrouter/internal/config"
+	"github.com/howard-nolan/llmrouter/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObservePromptTokens_CalibratesEstimates(t *testing.T) {
+	cfg, costs := costConfig()
+	cfg.Experiments = []config.ExperimentConfig{{Name: "exp", Percent: 50, DefaultStrategy: "cost"}}
+	rt := New(cfg, costs, nil)
+	rt.SetTokenCounter(tokens.NewCounter(nil))
+	req := promptOf(4000)
+	req.MaxTokens = 1000
+
+	// Sonnet's tokenizer turns out to use 2 characters per token, so the
+	// 4,000-character prompt is ~2,000 tokens there.
+	for range 200 {
+		rt.ObservePromptTokens("claude-sonnet-4-5-20250929", req.Messages, 2000)
+	}
+
+	est, ok := rt.EstimateCost("claude-sonnet-4-5-20250929", req)
+	require.True(t, ok)
+	assert.InDelta(t, 0.021, est, 0.0001)
+	est, ok = rt.EstimateCost("claude-haiku-4-5-20251001", req)
+	require.True(t, ok)
+	assert.InDelta(t, 0.006, est, 1e-12, "other models keep the default")
+
+	// Experiment arms share the calibration.
+	assert.Same(t, rt.tokens, rt.experiments[0].arm.tokens)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+	"github.com/howard-nolan/llmrouter/internal/stream"
+)
+
+// anthropicFormat is the Anthropic Messages API format served on
+// /v1/messages. Errors use Anthropic's {"type":"error","error":{...}}
+// envelope so the Anthropic SDKs raise their usual typed exceptions.
+type anthropicFormat struct{}
+
+// writeCodedError drops code and param: Anthropic's error object has only
+// a type and message.
+func (f anthropicFormat) writeCodedError(w http.ResponseWriter, status int, message, _, _ string) {
+	f.writeError(w, status, message)
+}
+
+func (anthropicFormat) writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := provider.EncodeAnthropicError(w, status, message); err != nil {
+		log.Printf("writing anthropic error: %v", err)
+	}
+}
+
+func (anthropicFormat) writeResponse(w http.ResponseWriter, resp *provider.ChatResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := provider.EncodeAnthropicResponse(w, resp); err != nil {
+		log.Printf("writing anthropic response: %v", err)
+	}
+}
+
+func (anthropicFormat) writeStream(w http.ResponseWriter, chunks <-chan provider.StreamChunk, opts stream.WriteOptions) error {
+	return stream.WriteAnthropic(w, chunks, opts)
+}
+
+// handleMessages handles POST /v1/messages — the Anthropic Messages API
+// ingress. The body is translated into a unified ChatRequest, so these
+// requests get the same caching and routing as /v1/chat/completions
+// (including "model": "auto" and routing to Gemini); only the response
+// shape differs.
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	req, err := provider.DecodeAnthropicRequest(r.Body)
+	if err != nil {
+		anthropicFormat{}.writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	s.serveChat(w, r, req, anthropicFormat{}, start)
+}
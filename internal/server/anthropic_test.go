@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// doMessagesRequest sends a JSON body to POST /v1/messages.
+func doMessagesRequest(t *testing.T, srv *Server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	return w
+}
+
+func TestMessages_NonStreaming(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+
+	body := `{"model":"test-model","max_tokens":100,"messages":[{"role":"user","content":"hello"}]}`
+
+	w := doMessagesRequest(t, srv, body)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "MISS", w.Header().Get("X-LLMRouter-Cache"))
+
+	var resp map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "message", resp["type"])
+	assert.Equal(t, "assistant", resp["role"])
+	assert.Equal(t, []any{map[string]any{"type": "text", "text": "This is a test response."}}, resp["content"])
+
+	// The second request shares the cache with /v1/chat/completions.
+	w2 := doMessagesRequest(t, srv, body)
+	assert.Equal(t, "HIT", w2.Header().Get("X-LLMRouter-Cache"))
+}
+
+func TestMessages_StreamingReplaysCachedChatCompletion(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+
+	// Seed the cache through the OpenAI endpoint.
+	w1 := doRequest(t, srv, map[string]interface{}{
+		"model":    "test-model",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+	})
+	require.Equal(t, "MISS", w1.Header().Get("X-LLMRouter-Cache"))
+
+	w2 := doMessagesRequest(t, srv,
+		`{"model":"test-model","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hello"}]}`)
+	assert.Equal(t, "HIT", w2.Header().Get("X-LLMRouter-Cache"))
+	assert.Equal(t, "text/event-stream", w2.Header().Get("Content-Type"))
+
+	out := w2.Body.String()
+	assert.Contains(t, out, "event: message_start\n")
+	assert.Contains(t, out, `"text":"This is a test response."`)
+	assert.True(t, strings.HasSuffix(out, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"))
+}
+
+func TestMessages_ErrorsUseAnthropicEnvelope(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+
+	w := doMessagesRequest(t, srv,
+		`{"model":"no-such-model","max_tokens":100,"messages":[{"role":"user","content":"hello"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var resp struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "error", resp.Type)
+	assert.Equal(t, "invalid_request_error", resp.Error.Type)
+	assert.Contains(t, resp.Error.Message, "no-such-model")
+}
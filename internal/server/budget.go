@@ -0,0 +1,112 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// budgetGuard is implemented by routers that enforce per-request cost caps
+// (router.Router). Caps apply only on a cache miss. FitBudget holds an
+// "auto" request's routing decision to its cap, downgrading it if need be;
+// CheckBudget does the same for pinned models, which can't be downgraded.
+// maxCost is the request's X-Max-Cost, 0 if unset.
+type budgetGuard interface {
+	FitBudget(embedding []float32, req *provider.ChatRequest, header http.Header, strategy string, providerName string, latencyBudget time.Duration, experiment string, maxCost float64) (model, reason string, err error)
+	CheckBudget(model string, req *provider.ChatRequest, header http.Header, maxCost float64) error
+	EstimateCost(model string, req *provider.ChatRequest) (float64, bool)
+}
+
+// overBudgetError is the error routing returns when a request's estimated
+// cost exceeds its cap on every model it could use (router.BudgetError).
+type overBudgetError interface {
+	error
+	OverBudget() (estimate, maxCost float64)
+}
+
+// isOverBudget reports whether err is an overBudgetError.
+func isOverBudget(err error) bool {
+	var ob overBudgetError
+	return errors.As(err, &ob)
+}
+
+// fitBudget holds an "auto" request, already routed under routeUnder, to
+// its cost cap, switching req.Model to the model FitBudget settles on. It
+// writes a 402 if nothing fits. Returns false if the request was rejected.
+func (s *Server) fitBudget(w http.ResponseWriter, r *http.Request, f wireFormat, req *provider.ChatRequest, embedding []float32, opts routeOptions, routeUnder string) bool {
+	guard, ok := s.modelRouter.(budgetGuard)
+	if !ok {
+		return true
+	}
+	model, reason, err := guard.FitBudget(embedding, req, r.Header, opts.route, opts.provider, opts.latencyBudget, routeUnder, opts.maxCost)
+	if err != nil {
+		writeRoutingError(w, f, err)
+		return false
+	}
+	if model != "" {
+		w.Header().Set("X-LLMRouter-Route-Reason", reason)
+		req.Model = model
+	}
+	return true
+}
+
+// fitShadowBudget holds a shadow copy of an "auto" request, routed to
+// model under experiment's treatment arm, to the request's cost cap as
+// fitBudget does the request. Returns the model to send the copy to, or ""
+// if nothing fits and it isn't worth sending.
+func (s *Server) fitShadowBudget(r *http.Request, req *provider.ChatRequest, embedding []float32, opts routeOptions, experiment, model string) string {
+	guard, ok := s.modelRouter.(budgetGuard)
+	if !ok {
+		return model
+	}
+	fitted, _, err := guard.FitBudget(embedding, req, r.Header, opts.route, opts.provider, opts.latencyBudget, experiment, opts.maxCost)
+	if err != nil {
+		log.Printf("shadow %s: %v", experiment, err)
+		return ""
+	}
+	if fitted != "" {
+		return fitted
+	}
+	return model
+}
+
+// checkBudget holds a request for a pinned model to its cost cap, maxCost
+// being its X-Max-Cost, writing a 402 if it's over. Returns false if the
+// request was rejected.
+func (s *Server) checkBudget(w http.ResponseWriter, r *http.Request, f wireFormat, req *provider.ChatRequest, maxCost float64) bool {
+	guard, ok := s.modelRouter.(budgetGuard)
+	if !ok {
+		return true
+	}
+	if err := guard.CheckBudget(req.Model, req, r.Header, maxCost); err != nil {
+		f.writeCodedError(w, http.StatusPaymentRequired, err.Error(), codeMaxCostExceeded, "")
+		return false
+	}
+	return true
+}
+
+// estimateCost returns the pre-flight cost estimate for req on model, if
+// the router can make one.
+func (s *Server) estimateCost(model string, req *provider.ChatRequest) (float64, bool) {
+	guard, ok := s.modelRouter.(budgetGuard)
+	if !ok {
+		return 0, false
+	}
+	return guard.EstimateCost(model, req)
+}
+
+// observeCostEstimate records a provider call's pre-flight estimate next to
+// its actual cost. No-op without an estimate.
+func observeCostEstimate(providerName, model string, estimate float64, ok bool, actual float64) {
+	if !ok {
+		return
+	}
+	metrics.EstimatedCostUSD.WithLabelValues(providerName, model).Add(estimate)
+	if estimate > 0 {
+		metrics.CostEstimateRatio.WithLabelValues(model).Observe(actual / estimate)
+	}
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/cache"
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+	"github.com/howard-nolan/llmrouter/internal/stream"
+)
+
+// Coalescing modes for CacheConfig.Coalesce.
+const (
+	CoalesceOff      = "off"      // every miss calls the provider (the default)
+	CoalesceExact    = "exact"    // share calls between prompts with identical embeddings
+	CoalesceSemantic = "semantic" // ...or embeddings at least SimilarityThreshold apart, as a cache hit would be
+)
+
+// defaultCoalesceWait is CacheConfig.CoalesceMaxWait's default.
+const defaultCoalesceWait = 30 * time.Second
+
+// ValidCoalesce reports whether s names a coalescing mode. Empty means
+// CoalesceOff.
+func ValidCoalesce(s string) bool {
+	return s == "" || s == CoalesceOff || s == CoalesceExact || s == CoalesceSemantic
+}
+
+// coalescer tracks the provider calls cache misses are waiting on, so a
+// miss that matches one already in flight — the same model, and a prompt
+// the cache would match once the first response is stored — can wait for
+// that response instead of paying for its own. The first request is the
+// leader; the rest are followers.
+type coalescer struct {
+	semantic  bool
+	threshold float64
+	maxWait   time.Duration
+
+	mu      sync.Mutex
+	flights map[string][]*flight // by model
+}
+
+// newCoalescer returns a coalescer for cfg, or nil if coalescing is off.
+func newCoalescer(cfg cache.CacheConfig) *coalescer {
+	if cfg.Coalesce == "" || cfg.Coalesce == CoalesceOff {
+		return nil
+	}
+	maxWait := cfg.CoalesceMaxWait
+	if maxWait <= 0 {
+		maxWait = defaultCoalesceWait
+	}
+	return &coalescer{
+		semantic:  cfg.Coalesce == CoalesceSemantic,
+		threshold: cfg.SimilarityThreshold,
+		maxWait:   maxWait,
+		flights:   make(map[string][]*flight),
+	}
+}
+
+// join returns the call in flight for model that embedding matches, or
+// registers a new one and reports that the caller leads it. A leader must
+// call leave once its response is stored (or the call has failed).
+func (c *coalescer) join(model string, embedding []float32, stream bool) (fl *flight, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, fl := range c.flights[model] {
+		if !fl.failed() && c.matches(fl.embedding, embedding) {
+			return fl, false
+		}
+	}
+	fl = &flight{model: model, embedding: embedding, stream: stream, updated: make(chan struct{})}
+	c.flights[model] = append(c.flights[model], fl)
+	return fl, true
+}
+
+// matches reports whether a request embedded as b can be served a's
+// response.
+func (c *coalescer) matches(a, b []float32) bool {
+	if !c.semantic {
+		return slices.Equal(a, b)
+	}
+	if len(a) != len(b) {
+		return false
+	}
+	// Dot product = cosine similarity because embeddings are L2-normalized.
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot >= c.threshold
+}
+
+// leave ends fl — as failed, if the leader never finished it — and stops
+// new requests joining it. Nil-safe, and a no-op after the first call.
+func (c *coalescer) leave(fl *flight) {
+	if c == nil || fl == nil {
+		return
+	}
+	fl.finish(nil)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flights[fl.model] = slices.DeleteFunc(c.flights[fl.model], func(other *flight) bool { return other == fl })
+	if len(c.flights[fl.model]) == 0 {
+		delete(c.flights, fl.model)
+	}
+}
+
+// flight is one leader's provider call. A streaming leader publishes its
+// chunks as they arrive (teeAndCache), so streaming followers can replay
+// them from the first and then follow live; every leader finishes with
+// the complete response, or nil if the call failed.
+type flight struct {
+	model     string
+	embedding []float32
+	stream    bool
+
+	mu       sync.Mutex
+	chunks   []provider.StreamChunk
+	resp     *provider.ChatResponse
+	finished bool
+	updated  chan struct{} // closed, and replaced, on every publish; closed for good by finish
+}
+
+// publish appends chunk to the stream followers see. Nil-safe.
+func (fl *flight) publish(chunk provider.StreamChunk) {
+	if fl == nil {
+		return
+	}
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.finished {
+		return
+	}
+	fl.chunks = append(fl.chunks, chunk)
+	close(fl.updated)
+	fl.updated = make(chan struct{})
+}
+
+// finish records the leader's response — nil if the call failed — and
+// wakes every follower. Nil-safe; only the first call counts.
+func (fl *flight) finish(resp *provider.ChatResponse) {
+	if fl == nil {
+		return
+	}
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.finished {
+		return
+	}
+	fl.resp = resp
+	fl.finished = true
+	close(fl.updated)
+}
+
+// failed reports whether fl finished without a response.
+func (fl *flight) failed() bool {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return fl.finished && fl.resp == nil
+}
+
+// await blocks until ready — called with fl.mu held — reports true or fl
+// finishes, and reports whether that happened before deadline fired or
+// ctx was done. A nil deadline never fires.
+func (fl *flight) await(ctx context.Context, deadline <-chan time.Time, ready func() bool) bool {
+	for {
+		fl.mu.Lock()
+		if fl.finished || ready() {
+			fl.mu.Unlock()
+			return true
+		}
+		updated := fl.updated
+		fl.mu.Unlock()
+
+		select {
+		case <-updated:
+		case <-deadline:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// subscribe returns the leader's chunks from the first: those already
+// published, then the rest as they arrive. The channel closes when the
+// flight finishes or ctx is done.
+func (fl *flight) subscribe(ctx context.Context) <-chan provider.StreamChunk {
+	out := make(chan provider.StreamChunk, 1)
+	go func() {
+		defer close(out)
+		for i := 0; ; i++ {
+			if !fl.await(ctx, nil, func() bool { return i < len(fl.chunks) }) {
+				return
+			}
+			fl.mu.Lock()
+			if i >= len(fl.chunks) {
+				fl.mu.Unlock()
+				return // finished
+			}
+			chunk := fl.chunks[i]
+			fl.mu.Unlock()
+
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// follow serves req from fl, the call a matching request is already
+// making, and reports whether it did. A streaming follower of a streaming
+// leader waits for the leader's first chunk and then streams along with
+// it; any other follower waits for the complete response. If that takes
+// longer than the coalescer's maxWait, or the leader fails before it has
+// sent anything, follow writes nothing and returns false: the caller calls
+// the provider itself. Once a follower is streaming it shares the
+// leader's fate, including an error mid-stream.
+func (s *Server) follow(w http.ResponseWriter, r *http.Request, f wireFormat, fl *flight, req *provider.ChatRequest, served servedResponse, providerName string, start time.Time) bool {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	timer := time.NewTimer(s.flights.maxWait)
+	defer timer.Stop()
+
+	live := req.Stream && fl.stream
+	var ready func() bool
+	if live {
+		ready = func() bool { return len(fl.chunks) > 0 }
+	} else {
+		ready = func() bool { return false } // only finish will do
+	}
+	if !fl.await(ctx, timer.C, ready) {
+		metrics.Coalesced.WithLabelValues(metrics.CoalesceTimeout).Inc()
+		return false
+	}
+
+	fl.mu.Lock()
+	resp := fl.resp
+	failed := fl.finished && resp == nil
+	if live && len(fl.chunks) > 0 {
+		failed = fl.chunks[0].Error != nil
+	}
+	fl.mu.Unlock()
+	if failed {
+		metrics.Coalesced.WithLabelValues(metrics.CoalesceLeaderFailed).Inc()
+		return false
+	}
+
+	metrics.Coalesced.WithLabelValues(metrics.CoalesceJoined).Inc()
+	w.Header().Set("X-LLMRouter-Coalesced", "true")
+
+	if !req.Stream {
+		metrics.CostSavedByCoalescing.WithLabelValues(providerName, req.Model).Add(resp.CostUSD)
+		f.writeResponse(w, resp)
+		s.trackResponse(served, resp.ID, req.Model)
+		return true
+	}
+
+	// A leader that finished before we looked has nothing left to stream
+	// live; replay its response like a cache hit.
+	var chunks <-chan provider.StreamChunk
+	if live && resp == nil {
+		chunks = fl.subscribe(ctx)
+	} else {
+		chunks = replayChunks(resp)
+	}
+	chunks = s.trackStream(ctx, chunks, served, req.Model)
+
+	if err := f.writeStream(w, chunks, stream.WriteOptions{
+		Provider:     providerName,
+		Model:        req.Model,
+		RequestStart: start,
+		CostFn:       costFnForModel(req.Model, s.cfg.Costs),
+		OnDone: func(_ provider.Usage, cost float64) {
+			metrics.CostSavedByCoalescing.WithLabelValues(providerName, req.Model).Add(cost)
+		},
+	}); err != nil {
+		log.Printf("stream write error: %v", err)
+	}
+	return true
+}
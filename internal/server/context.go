@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// contextFitter is implemented by routers that know their models' context
+// windows (router.Router). "auto" requests were already moved to a model
+// with room where one exists; FitContext truncates or rejects whatever
+// still doesn't fit, returning the request to send and how many messages
+// it dropped.
+type contextFitter interface {
+	FitContext(model string, req *provider.ChatRequest) (*provider.ChatRequest, int, error)
+}
+
+// fitContext makes req fit its model's context window before it's sent,
+// writing a 400 if it can't. Returns the request to send and false if the
+// request was rejected.
+func (s *Server) fitContext(w http.ResponseWriter, f wireFormat, req *provider.ChatRequest) (*provider.ChatRequest, bool) {
+	fitter, ok := s.modelRouter.(contextFitter)
+	if !ok {
+		return req, true
+	}
+	fitted, dropped, err := fitter.FitContext(req.Model, req)
+	if err != nil {
+		f.writeCodedError(w, http.StatusBadRequest, err.Error(), codeContextLengthExceeded, "messages")
+		return nil, false
+	}
+	if dropped > 0 {
+		w.Header().Set("X-LLMRouter-Dropped-Messages", strconv.Itoa(dropped))
+	}
+	return fitted, true
+}
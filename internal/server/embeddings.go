@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+)
+
+// maxEmbeddingInputs caps how many strings one /v1/embeddings request may
+// carry. Matches OpenAI's limit; the whole batch becomes one ONNX call, so
+// this also bounds the size of the input tensors.
+const maxEmbeddingInputs = 2048
+
+// embeddingsRequest is the OpenAI-compatible POST /v1/embeddings body.
+// Input is raw JSON because OpenAI accepts either a single string or an
+// array of strings — we decide which after decoding.
+type embeddingsRequest struct {
+	Input          json.RawMessage `json:"input"`
+	Model          string          `json:"model"`
+	EncodingFormat string          `json:"encoding_format"` // "float" (default) or "base64"
+}
+
+// embeddingsResponse mirrors OpenAI's embeddings response envelope.
+type embeddingsResponse struct {
+	Object string          `json:"object"` // always "list"
+	Data   []embeddingData `json:"data"`
+	Model  string          `json:"model"`
+	Usage  embeddingsUsage `json:"usage"`
+}
+
+// embeddingData is one vector in the response. Embedding is either a
+// []float32 or a base64 string, depending on the requested encoding_format.
+type embeddingData struct {
+	Object    string `json:"object"` // always "embedding"
+	Index     int    `json:"index"`
+	Embedding any    `json:"embedding"`
+}
+
+// embeddingsUsage is always zero: the model runs in-process, so there are
+// no billed tokens to report. The fields exist because the OpenAI SDKs
+// expect them.
+type embeddingsUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// parseEmbeddingsInput accepts the two input shapes OpenAI allows for text:
+// a single string, or a non-empty array of strings.
+func parseEmbeddingsInput(raw json.RawMessage) ([]string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("input is required")
+	}
+
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil, fmt.Errorf("input must be a string or an array of strings")
+	}
+	if len(many) == 0 {
+		return nil, fmt.Errorf("input must not be an empty array")
+	}
+	if len(many) > maxEmbeddingInputs {
+		return nil, fmt.Errorf("input has %d items; the maximum is %d", len(many), maxEmbeddingInputs)
+	}
+	return many, nil
+}
+
+// encodeEmbeddingBase64 packs a vector as little-endian float32 bytes and
+// base64-encodes it — the format OpenAI uses for encoding_format=base64
+// (and which the OpenAI Python SDK requests by default).
+func encodeEmbeddingBase64(vec []float32) string {
+	buf := make([]byte, len(vec)*4)
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(v))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// handleEmbeddings handles POST /v1/embeddings — an OpenAI-compatible
+// passthrough to the gateway's in-process embedding model. Every input in
+// the request is embedded in a single EmbedBatch call (one ONNX run with
+// batch dimension len(input)), so RAG services can use the same vectors
+// the semantic cache uses without running their own embedding service.
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	f := openAIFormat{}
+
+	if s.embedder == nil {
+		f.writeError(w, http.StatusServiceUnavailable, "embeddings are not enabled")
+		return
+	}
+
+	var req embeddingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	modelName := s.cfg.Embedding.ModelName
+	if req.Model != "" && modelName != "" && req.Model != modelName {
+		f.writeCodedError(w, http.StatusBadRequest, fmt.Sprintf("unknown embedding model %q (this gateway serves %q)", req.Model, modelName), codeModelNotFound, "model")
+		return
+	}
+	if modelName == "" {
+		modelName = req.Model
+	}
+
+	if req.EncodingFormat != "" && req.EncodingFormat != "float" && req.EncodingFormat != "base64" {
+		f.writeCodedError(w, http.StatusBadRequest, fmt.Sprintf("unsupported encoding_format %q (must be \"float\" or \"base64\")", req.EncodingFormat), "", "encoding_format")
+		return
+	}
+
+	inputs, err := parseEmbeddingsInput(req.Input)
+	if err != nil {
+		f.writeCodedError(w, http.StatusBadRequest, err.Error(), "", "input")
+		return
+	}
+
+	embedStart := time.Now()
+	vecs, err := s.embedder.EmbedBatch(inputs)
+	metrics.EmbeddingDuration.Observe(time.Since(embedStart).Seconds())
+	if err != nil {
+		log.Printf("embedding error: %v", err)
+		f.writeError(w, http.StatusInternalServerError, "failed to compute embeddings")
+		return
+	}
+
+	resp := embeddingsResponse{
+		Object: "list",
+		Data:   make([]embeddingData, len(vecs)),
+		Model:  modelName,
+	}
+	for i, vec := range vecs {
+		var payload any = vec
+		if req.EncodingFormat == "base64" {
+			payload = encodeEmbeddingBase64(vec)
+		}
+		resp.Data[i] = embeddingData{Object: "embedding", Index: i, Embedding: payload}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
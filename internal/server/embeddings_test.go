@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// doEmbeddingsRequest sends a raw JSON body to POST /v1/embeddings.
+func doEmbeddingsRequest(t *testing.T, srv *Server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/embeddings", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	return w
+}
+
+func TestEmbeddings_SingleString(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+
+	w := doEmbeddingsRequest(t, srv, `{"input":"hello","model":"any"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Object string `json:"object"`
+		Data   []struct {
+			Object    string    `json:"object"`
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "list", resp.Object)
+	require.Len(t, resp.Data, 1)
+	assert.Equal(t, "embedding", resp.Data[0].Object)
+	assert.Equal(t, normalizedVec(0), resp.Data[0].Embedding)
+}
+
+func TestEmbeddings_ArrayIsOneBatch(t *testing.T) {
+	vecs := map[string][]float32{"a": normalizedVec(0), "b": normalizedVec(1), "c": normalizedVec(2)}
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return vecs[text], nil
+	})
+
+	w := doEmbeddingsRequest(t, srv, `{"input":["a","b","c"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// All three inputs must go through a single EmbedBatch call.
+	assert.Equal(t, []int{3}, srv.embedder.(*mockEmbedder).BatchSizes)
+
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Data, 3)
+	for i, key := range []string{"a", "b", "c"} {
+		assert.Equal(t, i, resp.Data[i].Index)
+		assert.Equal(t, vecs[key], resp.Data[i].Embedding)
+	}
+}
+
+func TestEmbeddings_Base64Encoding(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(5), nil
+	})
+
+	w := doEmbeddingsRequest(t, srv, `{"input":"hello","encoding_format":"base64"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Data []struct {
+			Embedding string `json:"embedding"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+	raw, err := base64.StdEncoding.DecodeString(resp.Data[0].Embedding)
+	require.NoError(t, err)
+	require.Len(t, raw, 384*4)
+	assert.Equal(t, float32(1.0), math.Float32frombits(binary.LittleEndian.Uint32(raw[5*4:])))
+}
+
+func TestEmbeddings_InvalidRequests(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	srv.cfg.Embedding.ModelName = "all-MiniLM-L6-v2"
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"missing_input", `{}`, "input is required"},
+		{"empty_array", `{"input":[]}`, "empty"},
+		{"token_ids", `{"input":[1,2,3]}`, "string"},
+		{"wrong_model", `{"input":"x","model":"text-embedding-3-small"}`, "text-embedding-3-small"},
+		{"bad_encoding", `{"input":"x","encoding_format":"int8"}`, "int8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doEmbeddingsRequest(t, srv, tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			var errResp errorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+			assert.Contains(t, errResp.Error.Message, tt.wantErr)
+			assert.Equal(t, "invalid_request_error", errResp.Error.Type)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// OpenAI error codes the gateway sets on its own errors.
+const (
+	codeModelNotFound         = "model_not_found"
+	codeContextLengthExceeded = "context_length_exceeded"
+	codeMaxCostExceeded       = "max_cost_exceeded"
+	codeRateLimitExceeded     = "rate_limit_exceeded"
+	codeCacheMiss             = "cache_miss"
+)
+
+// errorResponse is an error response body in OpenAI's format, used by
+// every endpoint except /v1/messages (which answers in Anthropic's).
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// apiError is OpenAI's error object. Code and Param are null when unset,
+// as OpenAI sends them; the SDKs read Type and Code to raise typed errors.
+type apiError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Code    *string `json:"code"`
+	Param   *string `json:"param"`
+}
+
+// newAPIError builds the error object for a response with the given
+// status. Empty code or param become null.
+func newAPIError(status int, message, code, param string) apiError {
+	e := apiError{Message: message, Type: openAIErrorType(status)}
+	if code != "" {
+		e.Code = &code
+	}
+	if param != "" {
+		e.Param = &param
+	}
+	return e
+}
+
+// openAIErrorType maps an HTTP status to the error "type" OpenAI uses for
+// it.
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+// writeProviderError writes the response for a failed provider call, in
+// the caller's wire format. The full error is logged; the client only
+// sees what it can act on:
+//
+//   - the provider rejected the request's content (400, 413, 422): a 400
+//     with the provider's explanation, which is about the client's input,
+//     if its body was a parseable error; otherwise just its status
+//   - the provider rate limited us: a 429, with its Retry-After
+//   - a timeout: a 504
+//   - anything else — bad gateway credentials, provider outages, network
+//     failures: a 502 naming the provider and its status, but none of its
+//     response, which can carry internals of either side
+func writeProviderError(w http.ResponseWriter, f wireFormat, err error) {
+	log.Printf("provider error: %v", err)
+
+	var provErr *provider.ProviderError
+	switch {
+	case errors.As(err, &provErr) && provErr.ClientError() && provErr.Structured:
+		f.writeError(w, http.StatusBadRequest, fmt.Sprintf("%s rejected the request: %s", provErr.Provider, provErr.Message))
+	case errors.As(err, &provErr) && provErr.ClientError():
+		f.writeError(w, http.StatusBadRequest, fmt.Sprintf("%s rejected the request (status %d)", provErr.Provider, provErr.StatusCode))
+	case errors.As(err, &provErr) && provErr.StatusCode == http.StatusTooManyRequests:
+		if provErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(provErr.RetryAfter.Seconds()))))
+		}
+		f.writeCodedError(w, http.StatusTooManyRequests, provErr.Provider+" rate limit exceeded; retry later", codeRateLimitExceeded, "")
+	case errors.As(err, &provErr):
+		f.writeError(w, http.StatusBadGateway, fmt.Sprintf("%s returned an error (status %d)", provErr.Provider, provErr.StatusCode))
+	case errors.Is(err, context.DeadlineExceeded):
+		f.writeError(w, http.StatusGatewayTimeout, "upstream provider timed out")
+	default:
+		f.writeError(w, http.StatusBadGateway, "upstream provider request failed")
+	}
+}
+
+// invalidInputError is a routing error caused by the request itself — an
+// unknown strategy or experiment, or a bad provider override
+// (router.InputError).
+type invalidInputError interface {
+	error
+	InvalidInput()
+}
+
+// writeRoutingError writes the response for a failed routing decision, in
+// the caller's wire format: a 402 if the request is over its cost cap, a
+// 400 with the router's explanation if the request is at fault, and
+// otherwise — a classifier failure, routing config the gateway can't use —
+// a 500 that leaves the detail to the log.
+func writeRoutingError(w http.ResponseWriter, f wireFormat, err error) {
+	var invalid invalidInputError
+	switch {
+	case isOverBudget(err):
+		f.writeCodedError(w, http.StatusPaymentRequired, err.Error(), codeMaxCostExceeded, "")
+	case errors.As(err, &invalid):
+		f.writeError(w, http.StatusBadRequest, "routing error: "+err.Error())
+	default:
+		log.Printf("routing error: %v", err)
+		f.writeError(w, http.StatusInternalServerError, "routing failed")
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// armTreatment is the arm name a ModelRouter's Assign returns for requests
+// routed by an experiment's treatment config (router.ArmTreatment).
+const armTreatment = "treatment"
+
+// shadowTimeout bounds a shadow copy's provider call. Shadows run detached
+// from the client request, so nothing but this and shutdown (Drain) would
+// stop a hung one.
+const shadowTimeout = 2 * time.Minute
+
+// requestKey identifies a request body for assignment by request: the hash
+// of its messages, so retries and repeats land in the same arm.
+func requestKey(req *provider.ChatRequest) string {
+	data, err := json.Marshal(req.Messages)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// observeExperimentCost records a provider call's cost against its
+// experiment arm. No-op outside experiments.
+func observeExperimentCost(experiment, arm string, cost float64) {
+	if experiment != "" {
+		metrics.ExperimentCostUSD.WithLabelValues(experiment, arm).Add(cost)
+	}
+}
+
+// shadow sends a copy of req to model in the background, as the treatment
+// arm of a shadow experiment. The client never sees the result: it's
+// recorded in the experiment metrics and thrown away. Shadows don't read
+// or write the cache, stream, or retry. req is the request as the client
+// sent it, not fitted to the serving model's context window: the copy is
+// fitted to model's, and dropped (counted as an error) if it can't be.
+// Once the server is draining, shadows aren't sent, and those in flight
+// are cancelled without counting against the model or the experiment.
+func (s *Server) shadow(experiment, model string, req *provider.ChatRequest) {
+	if s.shadowCtx.Err() != nil {
+		return
+	}
+	p, err := s.resolveProvider(model)
+	if err != nil {
+		log.Printf("shadow %s: %v", experiment, err)
+		return
+	}
+
+	shadowReq := *req
+	shadowReq.Model = model
+	shadowReq.Stream = false
+	if fitter, ok := s.modelRouter.(contextFitter); ok {
+		fitted, _, err := fitter.FitContext(model, &shadowReq)
+		if err != nil {
+			metrics.ExperimentErrors.WithLabelValues(experiment, armTreatment).Inc()
+			log.Printf("shadow %s: %s: %v", experiment, model, err)
+			return
+		}
+		shadowReq = *fitted
+	}
+
+	s.goBackground(func() {
+		ctx, cancel := context.WithTimeout(s.shadowCtx, shadowTimeout)
+		defer cancel()
+
+		start := time.Now()
+		resp, err := p.ChatCompletion(ctx, &shadowReq)
+		if err != nil && s.shadowCtx.Err() != nil {
+			return // cut short by shutdown, which says nothing about model
+		}
+		s.observeOutcome(model, err)
+		if err != nil {
+			metrics.ExperimentErrors.WithLabelValues(experiment, armTreatment).Inc()
+			log.Printf("shadow %s: %s: %v", experiment, model, err)
+			return
+		}
+
+		metrics.ExperimentRequests.WithLabelValues(experiment, armTreatment, model).Inc()
+		metrics.ExperimentRequestDuration.WithLabelValues(experiment, armTreatment).Observe(time.Since(start).Seconds())
+		observeExperimentCost(experiment, armTreatment, computeCost(model, resp.Usage, s.cfg.Costs))
+	})
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/cache"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// routeExplainer is implemented by routers that can make a routing
+// decision without recording it (router.Router). model is the decision
+// the cache is searched under; explanation is a JSON-ready account of it,
+// including what the request's cost cap (maxCost, its X-Max-Cost) would
+// change on a miss.
+type routeExplainer interface {
+	Explain(embedding []float32, req *provider.ChatRequest, header http.Header, strategy string, providerName string, latencyBudget time.Duration, experiment string, maxCost float64) (model string, explanation any, err error)
+}
+
+// embedPeeker is implemented by embedders with an LRU in front of the
+// model (embedder.CachingEmbedder). Peek embeds without touching the LRU
+// or its lookup counters.
+type embedPeeker interface {
+	Peek(text string) ([]float32, error)
+}
+
+// explainEmbedding describes the prompt embedding in a route explanation.
+type explainEmbedding struct {
+	Dimension int     `json:"dimension"`
+	Norm      float64 `json:"norm"`
+	Truncated bool    `json:"truncated"`
+}
+
+// explainResponse is the body of POST /v1/route/explain.
+type explainResponse struct {
+	Model      string                `json:"model"`
+	Embedding  *explainEmbedding     `json:"embedding,omitempty"`
+	Experiment string                `json:"experiment,omitempty"`
+	Arm        string                `json:"arm,omitempty"`
+	Shadow     bool                  `json:"shadow,omitempty"`
+	Routing    any                   `json:"routing"` // null for pinned models
+	Cache      *cache.CacheCandidate `json:"cache"`   // null if the cache is off or empty for the model
+}
+
+// handleRouteExplain handles POST /v1/route/explain: a chat completions
+// request body and headers, run through embedding, routing, and cache
+// lookup as if it were being served — but without calling a provider,
+// writing to the cache, or counting anything in request metrics. The
+// prompt is embedded through embedPeeker where the embedder has an LRU, so
+// that's left alone too; only a model call made on an LRU miss shows up,
+// in the embedding model's own inference metrics. The response says where
+// the request would go and why.
+func (s *Server) handleRouteExplain(w http.ResponseWriter, r *http.Request) {
+	f := openAIFormat{}
+
+	var req provider.ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	opts, err := readRouteOptions(r, req.Model)
+	if err != nil {
+		f.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := explainResponse{Model: req.Model}
+	ctx := r.Context()
+
+	var embedding []float32
+	if s.embedder != nil {
+		userMsg, err := lastUserMessage(req.Messages)
+		if err != nil {
+			f.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if ep, ok := s.embedder.(embedPeeker); ok {
+			embedding, err = ep.Peek(userMsg)
+		} else {
+			embedding, err = s.embedder.Embed(userMsg)
+		}
+		if err != nil {
+			log.Printf("embedding error: %v", err)
+			f.writeError(w, http.StatusInternalServerError, "failed to compute embedding")
+			return
+		}
+		resp.Embedding = &explainEmbedding{Dimension: len(embedding), Norm: l2Norm(embedding)}
+		if tr, ok := s.embedder.(truncationReporter); ok && tr.Truncated(userMsg) {
+			resp.Embedding.Truncated = true
+			ctx = cache.WithTruncatedPrompt(ctx)
+		}
+	}
+
+	if req.Model == "auto" {
+		explainer, ok := s.modelRouter.(routeExplainer)
+		if !ok {
+			f.writeError(w, http.StatusBadRequest, "auto routing is not configured")
+			return
+		}
+
+		resp.Experiment, resp.Arm, resp.Shadow = s.modelRouter.Assign(r.Header, requestKey(&req))
+		routeUnder := ""
+		if resp.Arm == armTreatment {
+			routeUnder = resp.Experiment
+		}
+		resp.Model, resp.Routing, err = explainer.Explain(embedding, &req, r.Header, opts.route, opts.provider, opts.latencyBudget, routeUnder, opts.maxCost)
+		if err != nil {
+			writeRoutingError(w, f, err)
+			return
+		}
+	} else if _, err := s.resolveProvider(req.Model); err != nil {
+		f.writeCodedError(w, http.StatusBadRequest, err.Error(), codeModelNotFound, "model")
+		return
+	}
+
+	if s.cache != nil && embedding != nil && opts.cache != "skip" && s.cacheAvailable() {
+		resp.Cache, err = s.cache.Nearest(ctx, embedding, resp.Model)
+		if err != nil {
+			log.Printf("cache nearest error: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// l2Norm returns the Euclidean length of v. Embeddings are normalized, so
+// anything far from 1 points at an embedder problem.
+func l2Norm(v []float32) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/howard-nolan/llmrouter/internal/features"
+	"github.com/howard-nolan/llmrouter/internal/feedback"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// complexityScorer is implemented by routers that can score a prompt's
+// complexity without routing on it (router.Router). The score is recorded
+// with each served response as training data.
+type complexityScorer interface {
+	Score(embedding []float32, req *provider.ChatRequest) (float64, error)
+}
+
+// feedbackRequest is the body of POST /v1/feedback.
+type feedbackRequest struct {
+	ResponseID string `json:"response_id"`
+	feedback.Feedback
+}
+
+// handleFeedback handles POST /v1/feedback: a client's rating or score for
+// a response the gateway served, identified by the response's "id".
+func (s *Server) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	f := openAIFormat{}
+
+	if s.feedback == nil {
+		f.writeError(w, http.StatusServiceUnavailable, "feedback collection is not enabled")
+		return
+	}
+
+	var req feedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.ResponseID == "" {
+		f.writeError(w, http.StatusBadRequest, "response_id is required")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		f.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.feedback.Submit(r.Context(), req.ResponseID, req.Feedback); err != nil {
+		if errors.Is(err, feedback.ErrUnknownResponse) {
+			f.writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		log.Printf("feedback error: %v", err)
+		f.writeError(w, http.StatusInternalServerError, "failed to record feedback")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "recorded",
+	})
+}
+
+// servedResponse describes a request the gateway answered, for the
+// feedback collector. strategy is the routing strategy, empty if the
+// client pinned the model.
+type servedResponse struct {
+	req       *provider.ChatRequest
+	prompt    string
+	embedding []float32
+	strategy  string
+	cacheHit  bool
+}
+
+// trackResponse remembers a served response so feedback can be attached
+// to it later. No-op unless feedback collection is enabled.
+func (s *Server) trackResponse(sr servedResponse, responseID, model string) {
+	if s.feedback == nil || responseID == "" {
+		return
+	}
+
+	prompt := sr.prompt
+	if prompt == "" {
+		prompt, _ = lastUserMessage(sr.req.Messages)
+	}
+	feats := features.Extract(sr.embedding, sr.req)
+	rec := feedback.Record{
+		Prompt:               prompt,
+		ResponseID:           responseID,
+		Model:                model,
+		Strategy:             sr.strategy,
+		CacheHit:             sr.cacheHit,
+		Embedding:            sr.embedding,
+		EmbeddingFingerprint: s.cfg.Cache.Fingerprint,
+		Features:             &feats,
+	}
+	if scorer, ok := s.modelRouter.(complexityScorer); ok && sr.embedding != nil {
+		if score, err := scorer.Score(sr.embedding, sr.req); err == nil {
+			rec.ComplexityScore = &score
+		}
+	}
+	s.feedback.Track(rec)
+}
+
+// trackStream forwards chunks unchanged and tracks the response once the
+// stream completes, under the ID its chunks carry. Returns chunks as-is
+// unless feedback collection is enabled. It gives up when ctx — the
+// request's — is done, since the writer reading from it has gone too.
+func (s *Server) trackStream(ctx context.Context, chunks <-chan provider.StreamChunk, sr servedResponse, model string) <-chan provider.StreamChunk {
+	if s.feedback == nil {
+		return chunks
+	}
+
+	out := make(chan provider.StreamChunk, 1)
+	go func() {
+		defer close(out)
+		for chunk := range chunks {
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				return
+			}
+			if chunk.Error != nil {
+				return
+			}
+			if chunk.Done {
+				s.trackResponse(sr, chunk.ID, model)
+			}
+		}
+	}()
+	return out
+}
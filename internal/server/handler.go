@@ -6,10 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/howard-nolan/llmrouter/internal/cache"
 	"github.com/howard-nolan/llmrouter/internal/config"
 	"github.com/howard-nolan/llmrouter/internal/metrics"
 	"github.com/howard-nolan/llmrouter/internal/provider"
@@ -37,32 +41,60 @@ func classifyProviderError(err error) string {
 }
 
 // observeRoutingSavings records metrics.CostSavedByRouting when the chosen
-// model is the provider's cheap model. The savings estimator approximates the
-// quality model's cost using this request's actual token counts: input tokens
+// model is below the provider's top tier. The savings estimator approximates
+// the top tier's cost using this request's actual token counts: input tokens
 // are exact (same prompt either way), output tokens approximated using the
-// cheap model's completion count. No-op if the router isn't configured, the
-// chosen model isn't the cheap model, or cost table entries are missing.
+// chosen model's completion count. No-op if the router isn't configured, the
+// chosen model isn't a lower tier, or cost table entries are missing.
 func (s *Server) observeRoutingSavings(providerName, xProvider, chosenModel string, usage provider.Usage, actualCost float64) {
 	if s.modelRouter == nil {
 		return
 	}
-	cheap, quality, ok := s.modelRouter.CheapAndQualityFor(xProvider)
-	if !ok || chosenModel != cheap {
+	tiers, ok := s.modelRouter.TiersFor(xProvider)
+	if !ok || !slices.Contains(tiers[:len(tiers)-1], chosenModel) {
 		return
 	}
-	qc, ok := s.cfg.Costs[quality]
+	tc, ok := s.cfg.Costs[tiers[len(tiers)-1]]
 	if !ok {
 		return
 	}
-	qualityEstimate := (float64(usage.PromptTokens)*qc.InputPerMillion +
-		float64(usage.CompletionTokens)*qc.OutputPerMillion) / 1_000_000
-	savings := qualityEstimate - actualCost
+	topEstimate := (float64(usage.PromptTokens)*tc.InputPerMillion +
+		float64(usage.CompletionTokens)*tc.OutputPerMillion) / 1_000_000
+	savings := topEstimate - actualCost
 	if savings <= 0 {
 		return
 	}
 	metrics.CostSavedByRouting.WithLabelValues(providerName).Add(savings)
 }
 
+// observeTTFT feeds a streamed response's time to first token to the
+// router's latency estimates, if it keeps them.
+func (s *Server) observeTTFT(model string, ttft time.Duration) {
+	if lo, ok := s.modelRouter.(latencyObserver); ok {
+		lo.ObserveTTFT(model, ttft)
+	}
+}
+
+// observeOutcome feeds a provider call's result to the router's error-rate
+// estimates, if it keeps them. Client cancellations and errors caused by
+// the request itself (400, 413, ...) say nothing about the model's health
+// and aren't counted; auth failures, rate limits, timeouts, and 5xx are.
+func (s *Server) observeOutcome(model string, err error) {
+	lo, ok := s.modelRouter.(latencyObserver)
+	if !ok || errors.Is(err, context.Canceled) {
+		return
+	}
+	var pe *provider.ProviderError
+	if errors.As(err, &pe) && pe.StatusCode >= 400 && pe.StatusCode < 500 {
+		switch pe.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		default:
+			return
+		}
+	}
+	lo.ObserveOutcome(model, err != nil)
+}
+
 // computeCost calculates the USD cost of a request from token usage and the
 // per-model cost table. Returns 0 if the model isn't in the table.
 func computeCost(model string, usage provider.Usage, costs map[string]config.ModelCost) float64 {
@@ -87,34 +119,46 @@ func costFnForModel(model string, costs map[string]config.ModelCost) func(provid
 	}
 }
 
-// writeProviderError writes a JSON error response with an HTTP status code
-// derived from the error type. Maps ProviderError status codes to appropriate
-// gateway responses; falls back to 502 for unrecognized errors.
-func writeProviderError(w http.ResponseWriter, err error) {
-	log.Printf("provider error: %v", err)
+// wireFormat renders gateway output in the shape a particular ingress API
+// expects. Every chat endpoint shares one pipeline (serveChat); they differ
+// only in how they decode the request body and which wireFormat they pass.
+type wireFormat interface {
+	// writeError writes a complete error response with the given status.
+	writeError(w http.ResponseWriter, status int, message string)
 
-	status := http.StatusBadGateway // default for unknown errors
+	// writeCodedError is writeError with a machine-readable error code and
+	// the request parameter at fault, for formats that carry them. Either
+	// may be empty.
+	writeCodedError(w http.ResponseWriter, status int, message, code, param string)
 
-	var provErr *provider.ProviderError
-	if errors.As(err, &provErr) {
-		switch {
-		case provErr.StatusCode == http.StatusTooManyRequests:
-			status = http.StatusTooManyRequests
-		case provErr.StatusCode >= 500:
-			status = http.StatusBadGateway
-		default:
-			// 401, 403, 400 from provider = our upstream config is wrong
-			status = http.StatusBadGateway
-		}
-	} else if errors.Is(err, context.DeadlineExceeded) {
-		status = http.StatusGatewayTimeout
-	}
+	// writeResponse writes a complete non-streaming response.
+	writeResponse(w http.ResponseWriter, resp *provider.ChatResponse)
+
+	// writeStream drains chunks to the client as server-sent events.
+	writeStream(w http.ResponseWriter, chunks <-chan provider.StreamChunk, opts stream.WriteOptions) error
+}
+
+// openAIFormat is the OpenAI-compatible format served on
+// /v1/chat/completions.
+type openAIFormat struct{}
 
+func (f openAIFormat) writeError(w http.ResponseWriter, status int, message string) {
+	f.writeCodedError(w, status, message, "", "")
+}
+
+func (openAIFormat) writeCodedError(w http.ResponseWriter, status int, message, code, param string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{
-		"error": err.Error(),
-	})
+	json.NewEncoder(w).Encode(errorResponse{Error: newAPIError(status, message, code, param)})
+}
+
+func (openAIFormat) writeResponse(w http.ResponseWriter, resp *provider.ChatResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (openAIFormat) writeStream(w http.ResponseWriter, chunks <-chan provider.StreamChunk, opts stream.WriteOptions) error {
+	return stream.Write(w, chunks, opts)
 }
 
 // resolveProvider looks up the Provider for a given model name using the
@@ -172,18 +216,95 @@ func lastUserMessage(messages []provider.Message) (string, error) {
 	return "", fmt.Errorf("no user message found")
 }
 
+// routeOptions are the client's routing and caching control headers.
+type routeOptions struct {
+	cache         string        // X-Cache: "auto", "skip", "only"
+	route         string        // X-Route: "auto", "cheapest", "quality", "cost", "latency"
+	provider      string        // X-Provider: "google", "anthropic"
+	latencyBudget time.Duration // X-Latency-Budget, e.g. "800ms"; 0 if unset
+	maxCost       float64       // X-Max-Cost in USD, e.g. "0.01"; 0 if unset
+}
+
+// readRouteOptions reads the control headers for a request for model and
+// rejects inconsistent ones.
+func readRouteOptions(r *http.Request, model string) (routeOptions, error) {
+	opts := routeOptions{
+		cache:    r.Header.Get("X-Cache"),
+		route:    r.Header.Get("X-Route"),
+		provider: r.Header.Get("X-Provider"),
+	}
+	xBudget := r.Header.Get("X-Latency-Budget")
+
+	// Reject routing controls on pinned models. X-Route, X-Provider, and
+	// X-Latency-Budget only have meaning when model="auto"; silently
+	// ignoring them on a pinned model hides client misconfiguration.
+	if model != "auto" && model != "" {
+		for _, h := range []struct{ name, value string }{
+			{"X-Route", opts.route},
+			{"X-Provider", opts.provider},
+			{"X-Latency-Budget", xBudget},
+		} {
+			if h.value != "" {
+				return opts, fmt.Errorf("%s header has no effect when model is pinned (%q); set model to \"auto\" to enable routing", h.name, model)
+			}
+		}
+	}
+
+	// A latency budget puts the latency strategy in SLO mode: the cheapest
+	// tier whose p90 TTFT fits. It implies X-Route: latency.
+	if xBudget != "" {
+		budget, err := time.ParseDuration(xBudget)
+		if err != nil || budget <= 0 {
+			return opts, fmt.Errorf("invalid X-Latency-Budget %q: want a positive duration such as \"800ms\"", xBudget)
+		}
+		if opts.route == "" {
+			opts.route = "latency"
+		} else if opts.route != "latency" {
+			return opts, fmt.Errorf("X-Latency-Budget requires X-Route: latency, got %q", opts.route)
+		}
+		opts.latencyBudget = budget
+	}
+
+	// X-Max-Cost caps the request's estimated cost in USD. It applies to
+	// pinned models too: they're rejected rather than downgraded.
+	if xMaxCost := r.Header.Get("X-Max-Cost"); xMaxCost != "" {
+		limit, err := strconv.ParseFloat(xMaxCost, 64)
+		if err != nil || limit <= 0 || math.IsInf(limit, 0) {
+			return opts, fmt.Errorf("invalid X-Max-Cost %q: want a positive USD amount such as \"0.01\"", xMaxCost)
+		}
+		opts.maxCost = limit
+	}
+	return opts, nil
+}
+
 // teeAndCache inserts a pipeline stage between the provider's chunk channel
 // and the SSE writer. It reads each chunk from the input channel, forwards
 // it to a new output channel (which stream.Write consumes), and buffers
 // all the delta text. After the stream completes, it reconstructs a full
 // ChatResponse and stores it in the cache.
 //
+// The output channel closes as soon as the stream ends, so the client gets
+// its final event without waiting on Redis; the store finishes in the
+// background, on a context detached from the request's (see detached),
+// unless shutdown is already waiting on background work (goBackground).
+//
+// If ctx is done before the stream ends — the client hung up, and
+// stream.Write has stopped reading — the tee stops forwarding but keeps
+// draining chunks until the provider closes the channel.
+//
+// fl is the coalescing flight the request leads, or nil. Every chunk is
+// published to it for streaming followers, and it's finished with the
+// reconstructed response (nil if the stream failed) and left once the
+// store is done — or when the goroutine exits any other way.
+//
 // The goroutine inside is the "tee" — one copy goes to the client (via
 // the returned channel), the other accumulates for caching. This is like
 // piping a Node.js readable stream through a Transform that also collects
 // the data into a buffer.
 func (s *Server) teeAndCache(
 	chunks <-chan provider.StreamChunk,
+	fl *flight,
+	prompt string,
 	embedding []float32,
 	model string,
 	ctx context.Context,
@@ -193,25 +314,40 @@ func (s *Server) teeAndCache(
 	// blocking on every single chunk.
 	out := make(chan provider.StreamChunk, 1)
 
-	go func() {
-		// Close the output channel when the goroutine exits. This signals
-		// to stream.Write that the stream is done (its range loop will end).
-		defer close(out)
+	// store is false once shutdown is waiting on background work: the
+	// client still gets its stream, but nothing is left for the cache.
+	tee := func(store bool) {
+		// Runs last: a flight must never outlive its leader, or every
+		// later match would join it and wait out the coalescer's
+		// maxWait.
+		defer s.flights.leave(fl)
 
 		// strings.Builder efficiently concatenates all the delta text
 		// fragments into one string. Each WriteString appends to an
 		// internal byte buffer — no new string allocation per chunk.
 		var buf strings.Builder
 		var lastChunk provider.StreamChunk
+		failed := false
+		clientGone := false
 
 		for chunk := range chunks {
-			// Forward every chunk to the output channel so stream.Write
-			// can send it to the client immediately.
-			out <- chunk
+			// Fan the chunk out to coalesced followers (a no-op without
+			// any), then forward it to the output channel so stream.Write
+			// can send it to the client immediately — unless the client
+			// has gone, when nothing is reading out any more.
+			fl.publish(chunk)
+			if !clientGone {
+				select {
+				case out <- chunk:
+				case <-ctx.Done():
+					clientGone = true
+				}
+			}
 
-			// Skip error chunks — don't cache failed streams.
+			// Stop at error chunks — don't cache failed streams.
 			if chunk.Error != nil {
-				return
+				failed = true
+				break
 			}
 
 			// Accumulate the text delta for cache reconstruction.
@@ -224,10 +360,15 @@ func (s *Server) teeAndCache(
 			}
 		}
 
+		// Close the output channel. This signals to stream.Write that the
+		// stream is done (its range loop will end).
+		close(out)
+
 		// Only cache if we got a complete stream (saw a Done chunk)
 		// and actually have content to store.
-		if lastChunk.Done && buf.Len() > 0 {
-			resp := &provider.ChatResponse{
+		var resp *provider.ChatResponse
+		if !failed && lastChunk.Done && buf.Len() > 0 {
+			resp = &provider.ChatResponse{
 				ID:      lastChunk.ID,
 				Model:   lastChunk.Model,
 				Content: buf.String(),
@@ -236,22 +377,36 @@ func (s *Server) teeAndCache(
 				resp.Usage = *lastChunk.Usage
 			}
 			resp.CostUSD = computeCost(model, resp.Usage, s.cfg.Costs)
+		}
 
-			if err := s.cache.Store(ctx, embedding, model, resp); err != nil {
+		// Followers waiting for the whole response get it now; the
+		// flight stays open until the Store lands, so a match arriving
+		// in between joins it rather than missing the cache.
+		fl.finish(resp)
+
+		if resp != nil && store {
+			storeCtx, cancel := detached(ctx)
+			defer cancel()
+			if err := s.cache.Store(storeCtx, prompt, embedding, model, resp); err != nil {
 				log.Printf("cache store error (streaming): %v", err)
 			}
 		}
-	}()
+	}
+	if !s.goBackground(func() { tee(true) }) {
+		go tee(false)
+	}
 
 	return out
 }
 
 // handleHealth responds with a simple JSON status indicating the server
-// is alive. Later we'll expand this to check provider connectivity, Redis,
-// etc. — but for now it's a basic liveness probe.
+// is alive, plus the classifier versions. It doesn't check dependencies —
+// /health/ready does (see ready.go); /health/live is the bare liveness
+// probe.
 //
 // In Express terms, this is like:
-//   app.get('/health', (req, res) => res.json({ status: 'ok' }))
+//
+//	app.get('/health', (req, res) => res.json({ status: 'ok' }))
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	// Set the Content-Type header BEFORE calling WriteHeader or Write.
 	// In Go, headers must be set before the first write — once you start
@@ -269,19 +424,26 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	// The `json:"status"` part is a "struct tag" — it tells the JSON
 	// encoder to use "status" as the key name (lowercase) instead of
 	// the Go field name "Status" (uppercase).
-	json.NewEncoder(w).Encode(map[string]string{
+	body := map[string]any{
 		"status": "ok",
-	})
+	}
+	if s.draining.Load() {
+		// Shutting down: tell load balancers to stop sending traffic.
+		w.WriteHeader(http.StatusServiceUnavailable)
+		body["status"] = "draining"
+	}
+	if admin, ok := s.modelRouter.(classifierAdmin); ok {
+		if status := admin.ClassifierStatus(); status != nil {
+			body["classifier"] = status
+		}
+	}
+	json.NewEncoder(w).Encode(body)
 }
 
 // handleCacheStats returns cache performance metrics as JSON.
 func (s *Server) handleCacheStats(w http.ResponseWriter, r *http.Request) {
 	if s.cache == nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "cache is not enabled",
-		})
+		openAIFormat{}.writeError(w, http.StatusServiceUnavailable, "cache is not enabled")
 		return
 	}
 
@@ -292,20 +454,18 @@ func (s *Server) handleCacheStats(w http.ResponseWriter, r *http.Request) {
 // handleCacheFlush deletes all cached entries and resets stats.
 func (s *Server) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
 	if s.cache == nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "cache is not enabled",
-		})
+		openAIFormat{}.writeError(w, http.StatusServiceUnavailable, "cache is not enabled")
 		return
 	}
 
-	if err := s.cache.Flush(r.Context()); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "flush failed: " + err.Error(),
-		})
+	err := s.cache.Flush(r.Context())
+	if errors.Is(err, cache.ErrUnavailable) {
+		openAIFormat{}.writeError(w, http.StatusServiceUnavailable, "cache is unavailable")
+		return
+	}
+	if err != nil {
+		log.Printf("cache flush error: %v", err)
+		openAIFormat{}.writeError(w, http.StatusInternalServerError, "cache flush failed")
 		return
 	}
 
@@ -315,19 +475,74 @@ func (s *Server) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// handleChatCompletions handles POST /v1/chat/completions.
-// It decodes the request, resolves the provider from the model name,
-// and dispatches to either the streaming or non-streaming path.
+// handleClassifierReload reloads the complexity classifier from disk and
+// returns the new live version. The previous one is kept for rollback.
+func (s *Server) handleClassifierReload(w http.ResponseWriter, r *http.Request) {
+	s.swapClassifier(w, "reload", http.StatusInternalServerError, "reload failed; see the gateway log", classifierAdmin.ReloadClassifier)
+}
+
+// handleClassifierRollback swaps the previous complexity classifier back
+// in and returns its version.
+func (s *Server) handleClassifierRollback(w http.ResponseWriter, r *http.Request) {
+	s.swapClassifier(w, "rollback", http.StatusConflict, "no previous classifier to roll back to", classifierAdmin.RollbackClassifier)
+}
+
+// swapClassifier runs a classifier admin action and renders the result.
+// A failed action is answered with failStatus and failMessage; its error,
+// which can name model files and ONNX Runtime internals, is only logged.
+func (s *Server) swapClassifier(w http.ResponseWriter, action string, failStatus int, failMessage string, swap func(classifierAdmin) (any, error)) {
+	f := openAIFormat{}
+
+	admin, ok := s.modelRouter.(classifierAdmin)
+	if !ok || admin.ClassifierStatus() == nil {
+		f.writeError(w, http.StatusServiceUnavailable, "classifier hot reload is not enabled")
+		return
+	}
+
+	info, err := swap(admin)
+	if err != nil {
+		log.Printf("classifier %s failed: %v", action, err)
+		f.writeError(w, failStatus, failMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":     "ok",
+		"classifier": info,
+	})
+}
+
+// handleChatCompletions handles POST /v1/chat/completions. It decodes the
+// OpenAI-format body and hands off to serveChat, which runs the shared
+// embed → route → cache → provider pipeline.
 func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
+	var req provider.ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		openAIFormat{}.writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	s.serveChat(w, r, &req, openAIFormat{}, start)
+}
+
+// serveChat runs the request pipeline shared by every ingress endpoint:
+// embed the prompt, resolve "auto" to a concrete model, check the cache,
+// and dispatch to either the streaming or non-streaming provider path.
+// f controls how responses and errors are rendered, so the same pipeline
+// can answer in OpenAI or Anthropic format. start is the handler-entry
+// timestamp used for request duration and TTFT.
+func (s *Server) serveChat(w http.ResponseWriter, r *http.Request, req *provider.ChatRequest, f wireFormat, start time.Time) {
 	// Captured by the deferred metrics recorder. Filled in as the request
 	// progresses — provider/model are known after routing, cacheStatus is
 	// updated on hit/skip/only-miss paths.
+	// experiment/arm are set when routing experiments are configured.
 	var (
 		metricProvider    string
 		metricModel       string
 		metricCacheStatus = metrics.CacheMiss
+		experiment, arm   string
 	)
 	defer func() {
 		if metricProvider == "" {
@@ -335,45 +550,18 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		}
 		metrics.Requests.WithLabelValues(metricProvider, metricModel, metricCacheStatus).Inc()
 		metrics.RequestDuration.WithLabelValues(metricProvider, metricModel).Observe(time.Since(start).Seconds())
+		if experiment != "" {
+			metrics.ExperimentRequests.WithLabelValues(experiment, arm, metricModel).Inc()
+			metrics.ExperimentRequestDuration.WithLabelValues(experiment, arm).Observe(time.Since(start).Seconds())
+		}
 	}()
 
-	// Step 1: Decode the incoming JSON body into our unified ChatRequest.
-	var req provider.ChatRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "invalid request body: " + err.Error(),
-		})
+	opts, err := readRouteOptions(r, req.Model)
+	if err != nil {
+		f.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	// Read routing/caching control headers.
-	xCache := r.Header.Get("X-Cache")       // "auto", "skip", "only"
-	xRoute := r.Header.Get("X-Route")       // "auto", "cheapest", "quality"
-	xProvider := r.Header.Get("X-Provider") // "google", "anthropic"
-
-	// Reject routing controls on pinned models. X-Route and X-Provider
-	// only have meaning when model="auto"; silently ignoring them on a
-	// pinned model hides client misconfiguration.
-	if req.Model != "auto" && req.Model != "" {
-		if xRoute != "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": fmt.Sprintf("X-Route header has no effect when model is pinned (%q); set model to \"auto\" to enable routing", req.Model),
-			})
-			return
-		}
-		if xProvider != "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": fmt.Sprintf("X-Provider header has no effect when model is pinned (%q); set model to \"auto\" to enable routing", req.Model),
-			})
-			return
-		}
-	}
+	xCache, xRoute, xProvider, latencyBudget := opts.cache, opts.route, opts.provider, opts.latencyBudget
 
 	// Step 2: Compute embedding.
 	// The embedding is needed for both cache lookup and auto-routing, so
@@ -382,20 +570,32 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	needsRouting := req.Model == "auto"
 	cacheEnabled := s.embedder != nil && s.cache != nil && xCache != "skip"
 
+	// Redis is down (the cache's breaker is open): skip the cache rather
+	// than have every request wait on a dead connection.
+	cacheUnavailable := cacheEnabled && !s.cacheAvailable()
+	if cacheUnavailable {
+		cacheEnabled = false
+		metricCacheStatus = metrics.CacheUnavailable
+	}
+
 	var embedding []float32
+	var userMsg string
+	exactRepeat := false
+	truncated := false
 	if s.embedder != nil && (cacheEnabled || needsRouting) {
-		userMsg, err := lastUserMessage(req.Messages)
+		var err error
+		userMsg, err = lastUserMessage(req.Messages)
 		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": err.Error(),
-			})
+			f.writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		embedStart := time.Now()
-		embedding, err = s.embedder.Embed(userMsg)
+		if ce, ok := s.embedder.(cachingEmbedder); ok {
+			embedding, exactRepeat, err = ce.EmbedCached(userMsg)
+		} else {
+			embedding, err = s.embedder.Embed(userMsg)
+		}
 		metrics.EmbeddingDuration.Observe(time.Since(embedStart).Seconds())
 		if err != nil {
 			log.Printf("embedding error: %v", err)
@@ -403,43 +603,76 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 			// failures are fatal — we can't pick a model without it.
 			cacheEnabled = false
 			if needsRouting {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(map[string]string{
-					"error": "failed to compute embedding for routing: " + err.Error(),
-				})
+				f.writeError(w, http.StatusInternalServerError, "failed to compute embedding for routing")
 				return
 			}
 		}
+
+		// Long prompts can lose tokens to the embedding model's window.
+		// Flag the request so the cache can apply its truncated-prompt
+		// policy — every cache call below takes r.Context().
+		if tr, ok := s.embedder.(truncationReporter); ok && cacheEnabled && tr.Truncated(userMsg) {
+			w.Header().Set("X-LLMRouter-Truncated", "true")
+			r = r.WithContext(cache.WithTruncatedPrompt(r.Context()))
+			truncated = true
+		}
 	}
 
 	// Resolve "auto" to a concrete model before cache lookup. Cache
 	// entries are partitioned by model name, so looking up under "auto"
 	// would miss every entry stored under the routed model.
+	var shadowModel, routeUnder string
+	served := servedResponse{req: req, prompt: userMsg, embedding: embedding}
 	if req.Model == "auto" {
 		if s.modelRouter == nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "auto routing is not configured",
-			})
+			f.writeError(w, http.StatusBadRequest, "auto routing is not configured")
 			return
 		}
 
-		routed, err := s.modelRouter.Route(embedding, xRoute, xProvider)
+		// Experiments: a treatment-arm request is routed by the
+		// experiment's config; a shadowed one is routed normally and the
+		// treatment's choice is worked out now, then called on a miss.
+		var isShadow bool
+		experiment, arm, isShadow = s.modelRouter.Assign(r.Header, requestKey(req))
+		if arm == armTreatment {
+			routeUnder = experiment
+		}
+
+		// The cost cap waits for a cache miss: a hit is free, whatever
+		// model it was stored under.
+		routed, reason, err := s.modelRouter.Decide(embedding, req, r.Header, xRoute, xProvider, latencyBudget, routeUnder)
 		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "routing error: " + err.Error(),
-			})
+			writeRoutingError(w, f, err)
 			return
 		}
+		w.Header().Set("X-LLMRouter-Route-Reason", reason)
 		req.Model = routed
+		served.strategy = xRoute
+		if served.strategy == "" {
+			served.strategy = s.cfg.Routing.DefaultStrategy
+		}
+
+		if isShadow {
+			shadowModel, _, err = s.modelRouter.Decide(embedding, req, r.Header, xRoute, xProvider, latencyBudget, experiment)
+			if err != nil {
+				log.Printf("shadow %s: routing error: %v", experiment, err)
+			}
+		}
 	}
 
 	if cacheEnabled {
-		result, err := s.cache.Lookup(r.Context(), embedding, req.Model)
+		// Exact repeats carry the same embedding as the original request,
+		// so the stored entry can be read by key — no similarity scan.
+		// Fall through to the scan if it has expired or was stored under
+		// a different model.
+		var result *cache.CacheResult
+		var err error
+		if exactRepeat {
+			result, err = s.cache.LookupExact(r.Context(), embedding, req.Model)
+		}
+		if err == nil && result == nil {
+			result, err = s.cache.Lookup(r.Context(), embedding, req.Model)
+		}
 		if err != nil {
 			log.Printf("cache lookup error (skipping cache): %v", err)
 		} else if result != nil {
@@ -458,12 +691,14 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 			if result.Response.CostUSD > 0 && metricProvider != "" {
 				metrics.CostSavedByCache.WithLabelValues(metricProvider, metricModel).Add(result.Response.CostUSD)
 			}
+			served.cacheHit = true
+			defer s.trackResponse(served, result.Response.ID, metricModel)
 
 			if req.Stream {
 				// Replay as a fast SSE burst — stream.Write doesn't
 				// know (or care) that these chunks came from cache.
 				chunks := replayChunks(result.Response)
-				if err := stream.Write(w, chunks, stream.WriteOptions{
+				if err := f.writeStream(w, chunks, stream.WriteOptions{
 					Provider:     metricProvider,
 					Model:        metricModel,
 					RequestStart: start,
@@ -475,8 +710,7 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// Non-streaming: return as JSON.
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(result.Response)
+			f.writeResponse(w, result.Response)
 			return
 		}
 	}
@@ -484,13 +718,13 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	// X-Cache: "only" returns 404 on a cache miss instead of calling
 	// the provider. Useful for testing cache without spending tokens.
 	if xCache == "only" {
-		metricCacheStatus = metrics.CacheOnlyMiss
 		w.Header().Set("X-LLMRouter-Cache", "MISS")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "cache miss (x-cache: only)",
-		})
+		if cacheUnavailable {
+			f.writeError(w, http.StatusServiceUnavailable, "cache is unavailable (x-cache: only)")
+			return
+		}
+		metricCacheStatus = metrics.CacheOnlyMiss
+		f.writeCodedError(w, http.StatusNotFound, "cache miss (x-cache: only)", codeCacheMiss, "")
 		return
 	}
 
@@ -500,22 +734,66 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		metricCacheStatus = metrics.CacheSkip
 	}
 
+	// Now the request will cost something, hold it to its cap: an "auto"
+	// request may be downgraded to a cheaper model that fits, a pinned
+	// one is checked below, after fitting its context.
+	if needsRouting && !s.fitBudget(w, r, f, req, embedding, opts, routeUnder) {
+		return
+	}
+	if shadowModel != "" {
+		shadowModel = s.fitShadowBudget(r, req, embedding, opts, experiment, shadowModel)
+	}
+
 	// Resolve the provider from the model name.
 	p, err := s.resolveProvider(req.Model)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": err.Error(),
-		})
+		f.writeCodedError(w, http.StatusBadRequest, err.Error(), codeModelNotFound, "model")
 		return
 	}
 
+	// Too large for the model's context window: truncate per config, or
+	// reject here rather than let the provider's 400 become a 502. The
+	// shadow copy is fitted to its own model's window, from the original.
+	unfitted := req
+	req, ok := s.fitContext(w, f, req)
+	if !ok {
+		return
+	}
+
+	if !needsRouting && !s.checkBudget(w, r, f, req, opts.maxCost) {
+		return
+	}
+	estimate, hasEstimate := s.estimateCost(req.Model, req)
+
 	w.Header().Set("X-LLMRouter-Provider", p.Name())
 	w.Header().Set("X-LLMRouter-Model", req.Model)
 	metricProvider = p.Name()
 	metricModel = req.Model
 
+	// A shadow copy is only worth sending when it would exercise a
+	// different model than the one serving the request.
+	if shadowModel != "" && shadowModel != req.Model {
+		s.shadow(experiment, shadowModel, unfitted)
+	}
+
+	// A miss matching one already waiting on the provider shares that
+	// call instead of paying for its own (see coalesce.go). Truncated
+	// prompts never do: their embeddings don't tell them apart.
+	var fl *flight
+	if cacheEnabled && s.flights != nil && !truncated {
+		var leader bool
+		fl, leader = s.flights.join(req.Model, embedding, req.Stream)
+		if !leader {
+			if s.follow(w, r, f, fl, req, served, p.Name(), start) {
+				metricCacheStatus = metrics.CacheCoalesced
+				return
+			}
+			fl = nil
+		} else if !req.Stream {
+			defer s.flights.leave(fl)
+		}
+	}
+
 	// Step 4: Branch on streaming vs non-streaming.
 	const maxRetries = 3
 
@@ -523,37 +801,52 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		var chunks <-chan provider.StreamChunk
 		err := provider.Retry(r.Context(), maxRetries, func() error {
 			var callErr error
-			chunks, callErr = p.ChatCompletionStream(r.Context(), &req)
+			chunks, callErr = p.ChatCompletionStream(r.Context(), req)
 			return callErr
 		})
 		if err != nil {
 			metrics.ProviderErrors.WithLabelValues(p.Name(), classifyProviderError(err)).Inc()
-			writeProviderError(w, err)
+			s.observeOutcome(req.Model, err)
+			if experiment != "" {
+				metrics.ExperimentErrors.WithLabelValues(experiment, arm).Inc()
+			}
+			s.flights.leave(fl)
+			writeProviderError(w, f, err)
 			return
 		}
 
 		// If caching is enabled, insert the tee stage between the
 		// provider channel and the SSE writer. stream.Write reads
 		// from the tee's output channel — it doesn't know or care
-		// that there's a goroutine buffering for cache storage.
+		// that there's a goroutine buffering for cache storage. It
+		// also fans the chunks out to coalesced followers, and leaves fl
+		// once the response is stored.
 		if cacheEnabled {
-			chunks = s.teeAndCache(chunks, embedding, req.Model, r.Context())
+			chunks = s.teeAndCache(chunks, fl, userMsg, embedding, req.Model, r.Context())
 		}
+		chunks = s.trackStream(r.Context(), chunks, served, req.Model)
 
 		providerName := p.Name()
 		model := req.Model
-		if err := stream.Write(w, chunks, stream.WriteOptions{
+		if err := f.writeStream(w, chunks, stream.WriteOptions{
 			Provider:     providerName,
 			Model:        model,
 			RequestStart: start,
 			CostFn:       costFnForModel(model, s.cfg.Costs),
+			OnFirstToken: func(ttft time.Duration) {
+				s.observeTTFT(model, ttft)
+			},
 			OnDone: func(usage provider.Usage, cost float64) {
+				s.observeOutcome(model, nil)
 				metrics.Tokens.WithLabelValues(providerName, model, metrics.DirInput).Add(float64(usage.PromptTokens))
 				metrics.Tokens.WithLabelValues(providerName, model, metrics.DirOutput).Add(float64(usage.CompletionTokens))
 				metrics.PromptTokens.Observe(float64(usage.PromptTokens))
 				metrics.CostUSD.WithLabelValues(providerName, model).Add(cost)
 				metrics.CostPerRequest.WithLabelValues(providerName, model).Observe(cost)
 				s.observeRoutingSavings(providerName, xProvider, model, usage, cost)
+				observeExperimentCost(experiment, arm, cost)
+				observeCostEstimate(providerName, model, estimate, hasEstimate, cost)
+				s.observePromptTokens(model, req.Messages, usage.PromptTokens)
 			},
 		}); err != nil {
 			log.Printf("stream write error: %v", err)
@@ -565,11 +858,15 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	var resp *provider.ChatResponse
 	err = provider.Retry(r.Context(), maxRetries, func() error {
 		var callErr error
-		resp, callErr = p.ChatCompletion(r.Context(), &req)
+		resp, callErr = p.ChatCompletion(r.Context(), req)
 		return callErr
 	})
+	s.observeOutcome(req.Model, err)
 	if err != nil {
-		writeProviderError(w, err)
+		if experiment != "" {
+			metrics.ExperimentErrors.WithLabelValues(experiment, arm).Inc()
+		}
+		writeProviderError(w, f, err)
 		return
 	}
 
@@ -582,14 +879,21 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	metrics.CostUSD.WithLabelValues(p.Name(), req.Model).Add(resp.CostUSD)
 	metrics.CostPerRequest.WithLabelValues(p.Name(), req.Model).Observe(resp.CostUSD)
 	s.observeRoutingSavings(p.Name(), xProvider, req.Model, resp.Usage, resp.CostUSD)
+	observeExperimentCost(experiment, arm, resp.CostUSD)
+	observeCostEstimate(p.Name(), req.Model, estimate, hasEstimate, resp.CostUSD)
+	s.observePromptTokens(req.Model, req.Messages, resp.Usage.PromptTokens)
+	fl.finish(resp)
 
 	// Store the response in cache for future hits.
 	if cacheEnabled {
-		if err := s.cache.Store(r.Context(), embedding, req.Model, resp); err != nil {
+		storeCtx, cancel := detached(r.Context())
+		err := s.cache.Store(storeCtx, userMsg, embedding, req.Model, resp)
+		cancel()
+		if err != nil {
 			log.Printf("cache store error: %v", err)
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	f.writeResponse(w, resp)
+	s.trackResponse(served, resp.ID, req.Model)
 }
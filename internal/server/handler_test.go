@@ -4,10 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,7 +24,9 @@ import (
 
 	"github.com/howard-nolan/llmrouter/internal/cache"
 	"github.com/howard-nolan/llmrouter/internal/config"
+	"github.com/howard-nolan/llmrouter/internal/feedback"
 	"github.com/howard-nolan/llmrouter/internal/provider"
+	"github.com/howard-nolan/llmrouter/internal/tokens"
 )
 
 // ---------------------------------------------------------------------------
@@ -27,13 +36,29 @@ import (
 // mockEmbedder implements the Embedder interface. Each test provides an
 // EmbedFunc that returns deterministic embeddings for known inputs.
 type mockEmbedder struct {
-	EmbedFunc func(text string) ([]float32, error)
+	EmbedFunc  func(text string) ([]float32, error)
+	BatchSizes []int
 }
 
 func (m *mockEmbedder) Embed(text string) ([]float32, error) {
 	return m.EmbedFunc(text)
 }
 
+// EmbedBatch records the batch size of each call and embeds texts one at
+// a time with EmbedFunc.
+func (m *mockEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	m.BatchSizes = append(m.BatchSizes, len(texts))
+	out := make([][]float32, len(texts))
+	for i, text := range texts {
+		vec, err := m.EmbedFunc(text)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = vec
+	}
+	return out, nil
+}
+
 // mockProvider implements provider.Provider with canned responses.
 type mockProvider struct {
 	name     string
@@ -128,7 +153,7 @@ func setupTestServer(t *testing.T, embedFunc func(string) ([]float32, error)) *S
 
 	cfg := &config.Config{}
 
-	return New(cfg, models, emb, rc, nil)
+	return New(cfg, models, emb, rc, nil, nil)
 }
 
 // doRequest sends a JSON request to the server and returns the recorder.
@@ -304,9 +329,11 @@ func TestXCache_OnlyReturns404OnMiss(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, w.Code)
 	assert.Equal(t, "MISS", w.Header().Get("X-LLMRouter-Cache"))
 
-	var errResp map[string]string
+	var errResp errorResponse
 	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
-	assert.Contains(t, errResp["error"], "x-cache: only")
+	assert.Contains(t, errResp.Error.Message, "x-cache: only")
+	require.NotNil(t, errResp.Error.Code)
+	assert.Equal(t, codeCacheMiss, *errResp.Error.Code)
 }
 
 func TestXCache_OnlyReturnsCachedResponse(t *testing.T) {
@@ -333,3 +360,1222 @@ func TestXCache_OnlyReturnsCachedResponse(t *testing.T) {
 	require.NoError(t, json.Unmarshal(w2.Body.Bytes(), &resp))
 	assert.Equal(t, "This is a test response.", resp.Content)
 }
+
+// cachingMockEmbedder adds EmbedCached to mockEmbedder, reporting a hit for
+// any text it has seen before — a stand-in for embedder.CachingEmbedder.
+type cachingMockEmbedder struct {
+	mockEmbedder
+	seen  map[string]bool
+	calls int
+}
+
+func (m *cachingMockEmbedder) EmbedCached(text string) ([]float32, bool, error) {
+	m.calls++
+	vec, err := m.EmbedFunc(text)
+	hit := m.seen[text]
+	m.seen[text] = true
+	return vec, hit, err
+}
+
+func TestCacheHit_ExactRepeatFastPath(t *testing.T) {
+	srv := setupTestServer(t, nil)
+	emb := &cachingMockEmbedder{
+		mockEmbedder: mockEmbedder{EmbedFunc: func(string) ([]float32, error) {
+			return normalizedVec(0), nil
+		}},
+		seen: map[string]bool{},
+	}
+	srv.embedder = emb
+
+	body := map[string]interface{}{
+		"model":    "test-model",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+		"stream":   false,
+	}
+
+	w1 := doRequest(t, srv, body)
+	require.Equal(t, "MISS", w1.Header().Get("X-LLMRouter-Cache"))
+
+	w2 := doRequest(t, srv, body)
+	assert.Equal(t, http.StatusOK, w2.Code)
+	assert.Equal(t, "HIT", w2.Header().Get("X-LLMRouter-Cache"))
+	assert.Equal(t, "1.0000", w2.Header().Get("X-LLMRouter-Similarity"))
+	assert.Equal(t, 2, emb.calls, "both requests should go through EmbedCached")
+
+	var resp provider.ChatResponse
+	require.NoError(t, json.Unmarshal(w2.Body.Bytes(), &resp))
+	assert.Equal(t, "This is a test response.", resp.Content)
+}
+
+// recordingRouter is a ModelRouter that always routes to test-model and
+// records what the handler passed it and fed back. Outcomes also arrive
+// from shadow calls in the background, so they're guarded by mu.
+type recordingRouter struct {
+	strategy string
+	budget   time.Duration
+
+	mu       sync.Mutex
+	outcomes []bool
+}
+
+func (m *recordingRouter) Decide(_ []float32, _ *provider.ChatRequest, _ http.Header, strategy, _ string, budget time.Duration, _ string) (string, string, error) {
+	m.strategy, m.budget = strategy, budget
+	return "test-model", "test: always test-model", nil
+}
+
+func (m *recordingRouter) TiersFor(string) ([]string, bool) { return nil, false }
+
+func (m *recordingRouter) Assign(http.Header, string) (string, string, bool) { return "", "", false }
+
+func (m *recordingRouter) ObserveTTFT(string, time.Duration) {}
+
+func (m *recordingRouter) ObserveOutcome(_ string, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outcomes = append(m.outcomes, failed)
+}
+
+// observed returns the outcomes fed back so far.
+func (m *recordingRouter) observed() []bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.outcomes)
+}
+
+func TestLatencyBudget_ImpliesLatencyStrategy(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	mr := &recordingRouter{}
+	srv.modelRouter = mr
+
+	body := map[string]interface{}{
+		"model":    "auto",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+	}
+	w := doRequest(t, srv, body, http.Header{"X-Latency-Budget": {"800ms"}})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, "latency", mr.strategy)
+	assert.Equal(t, 800*time.Millisecond, mr.budget)
+	assert.Equal(t, "test: always test-model", w.Header().Get("X-LLMRouter-Route-Reason"))
+	assert.Equal(t, []bool{false}, mr.observed(), "successful call fed back to the router")
+}
+
+func TestLatencyBudget_Rejected(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	srv.modelRouter = &recordingRouter{}
+
+	tests := []struct {
+		name   string
+		model  string
+		header http.Header
+	}{
+		{"pinned model", "test-model", http.Header{"X-Latency-Budget": {"800ms"}}},
+		{"not a duration", "auto", http.Header{"X-Latency-Budget": {"fast"}}},
+		{"other strategy", "auto", http.Header{"X-Latency-Budget": {"800ms"}, "X-Route": {"cheapest"}}},
+	}
+	for _, tt := range tests {
+		body := map[string]interface{}{
+			"model":    tt.model,
+			"messages": []map[string]string{{"role": "user", "content": "hello"}},
+		}
+		w := doRequest(t, srv, body, tt.header)
+		assert.Equal(t, http.StatusBadRequest, w.Code, tt.name)
+	}
+}
+
+// shadowRouter assigns every request to a shadow experiment whose
+// treatment arm picks shadow-model.
+type shadowRouter struct{ recordingRouter }
+
+func (m *shadowRouter) Assign(http.Header, string) (string, string, bool) {
+	return "exp", "control", true
+}
+
+func (m *shadowRouter) Decide(_ []float32, _ *provider.ChatRequest, _ http.Header, _, _ string, _ time.Duration, experiment string) (string, string, error) {
+	if experiment == "exp" {
+		return "shadow-model", "treatment", nil
+	}
+	return "test-model", "control", nil
+}
+
+// signalingProvider is a provider that reports each ChatCompletion call.
+type signalingProvider struct {
+	mockProvider
+	calls chan *provider.ChatRequest
+}
+
+func (m *signalingProvider) ChatCompletion(ctx context.Context, req *provider.ChatRequest) (*provider.ChatResponse, error) {
+	m.calls <- req
+	return m.mockProvider.ChatCompletion(ctx, req)
+}
+
+func TestShadowExperiment_CopiesRequestToTreatmentModel(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	srv.modelRouter = &shadowRouter{}
+	shadowProvider := &signalingProvider{
+		mockProvider: mockProvider{name: "shadow-provider", response: &provider.ChatResponse{
+			ID: "shadow-resp", Model: "shadow-model", Content: "shadow answer",
+		}},
+		calls: make(chan *provider.ChatRequest, 1),
+	}
+	srv.models["shadow-model"] = shadowProvider
+
+	body := map[string]interface{}{
+		"model":    "auto",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+		"stream":   true,
+	}
+	w := doRequest(t, srv, body)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The client is served by the control arm...
+	assert.Equal(t, "test-model", w.Header().Get("X-LLMRouter-Model"))
+	assert.NotContains(t, w.Body.String(), "shadow answer")
+
+	// ...while the treatment model gets a non-streaming copy.
+	select {
+	case req := <-shadowProvider.calls:
+		assert.Equal(t, "shadow-model", req.Model)
+		assert.False(t, req.Stream)
+		assert.Equal(t, "hello", req.Messages[0].Content)
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow model was not called")
+	}
+}
+
+// hangingProvider is a signalingProvider whose calls never finish on
+// their own: they return when ctx is done.
+type hangingProvider struct{ signalingProvider }
+
+func (m *hangingProvider) ChatCompletion(ctx context.Context, req *provider.ChatRequest) (*provider.ChatResponse, error) {
+	m.calls <- req
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestShadowExperiment_DrainCancelsShadows(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	srv.modelRouter = &shadowRouter{}
+	shadowProvider := &hangingProvider{signalingProvider{
+		mockProvider: mockProvider{name: "shadow-provider"},
+		calls:        make(chan *provider.ChatRequest, 1),
+	}}
+	srv.models["shadow-model"] = shadowProvider
+
+	body := map[string]interface{}{
+		"model":    "auto",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+	}
+	require.Equal(t, http.StatusOK, doRequest(t, srv, body).Code)
+	select {
+	case <-shadowProvider.calls:
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow model was not called")
+	}
+
+	// Shutdown doesn't wait out the shadow's timeout...
+	srv.Drain()
+	waitCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, srv.Wait(waitCtx))
+
+	// ...and sends no new ones.
+	body["messages"] = []map[string]string{{"role": "user", "content": "hello again"}}
+	require.Equal(t, http.StatusOK, doRequest(t, srv, body).Code)
+	require.NoError(t, srv.Wait(waitCtx))
+	assert.Empty(t, shadowProvider.calls)
+}
+
+// windowedShadowRouter is a shadowRouter whose control model only has
+// room for the latest message, while the treatment model takes anything.
+type windowedShadowRouter struct{ shadowRouter }
+
+func (m *windowedShadowRouter) FitContext(model string, req *provider.ChatRequest) (*provider.ChatRequest, int, error) {
+	if model != "test-model" || len(req.Messages) == 1 {
+		return req, 0, nil
+	}
+	fitted := *req
+	fitted.Messages = req.Messages[len(req.Messages)-1:]
+	return &fitted, len(req.Messages) - 1, nil
+}
+
+func TestShadowExperiment_FitsCopyToTreatmentWindow(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	srv.modelRouter = &windowedShadowRouter{}
+	shadowProvider := &signalingProvider{
+		mockProvider: mockProvider{name: "shadow-provider", response: &provider.ChatResponse{
+			ID: "shadow-resp", Model: "shadow-model", Content: "shadow answer",
+		}},
+		calls: make(chan *provider.ChatRequest, 1),
+	}
+	srv.models["shadow-model"] = shadowProvider
+
+	body := map[string]interface{}{
+		"model": "auto",
+		"messages": []map[string]string{
+			{"role": "user", "content": "hi"},
+			{"role": "assistant", "content": "hello"},
+			{"role": "user", "content": "how are you?"},
+		},
+	}
+	w := doRequest(t, srv, body)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-LLMRouter-Dropped-Messages"))
+
+	// The treatment model has room for the whole conversation.
+	select {
+	case req := <-shadowProvider.calls:
+		assert.Len(t, req.Messages, 3)
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow model was not called")
+	}
+}
+
+// adminRouter is a ModelRouter whose classifier can be reloaded and rolled
+// back, flipping between two versions.
+type adminRouter struct {
+	recordingRouter
+	versions []string // live first
+	failNext bool
+}
+
+func (m *adminRouter) ClassifierStatus() any {
+	return map[string]string{"version": m.versions[0]}
+}
+
+func (m *adminRouter) ReloadClassifier() (any, error) {
+	if m.failNext {
+		return nil, errors.New("bad model file")
+	}
+	m.versions = []string{"v2", m.versions[0]}
+	return m.ClassifierStatus(), nil
+}
+
+func (m *adminRouter) RollbackClassifier() (any, error) {
+	if len(m.versions) < 2 {
+		return nil, errors.New("no previous classifier")
+	}
+	m.versions[0], m.versions[1] = m.versions[1], m.versions[0]
+	return m.ClassifierStatus(), nil
+}
+
+func TestClassifierAdmin(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	mr := &adminRouter{versions: []string{"v1"}}
+	srv.modelRouter = mr
+	srv.cfg.Server.AdminToken = "admin-secret"
+
+	postAs := func(path, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	post := func(path string) *httptest.ResponseRecorder {
+		return postAs(path, "admin-secret")
+	}
+	classifierVersion := func(w *httptest.ResponseRecorder) string {
+		var body struct {
+			Classifier struct {
+				Version string `json:"version"`
+			} `json:"classifier"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body.Classifier.Version
+	}
+
+	assert.Equal(t, http.StatusConflict, post("/classifier/rollback").Code)
+
+	// Without the admin token, nothing is swapped.
+	assert.Equal(t, http.StatusUnauthorized, postAs("/classifier/reload", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, postAs("/classifier/reload", "client-key").Code)
+	assert.Equal(t, []string{"v1"}, mr.versions)
+
+	w := post("/classifier/reload")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "v2", classifierVersion(w))
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "v2", classifierVersion(w), "/health reports the live classifier")
+
+	w = post("/classifier/rollback")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "v1", classifierVersion(w))
+
+	mr.failNext = true
+	w = post("/classifier/reload")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "bad model file", "load errors are only logged")
+}
+
+func TestClassifierAdmin_NotEnabled(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	srv.modelRouter = &recordingRouter{}
+
+	post := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/classifier/reload", nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	// Without a configured token, the admin endpoints are off.
+	assert.Equal(t, http.StatusForbidden, post().Code)
+
+	srv.cfg.Server.AdminToken = "admin-secret"
+	assert.Equal(t, http.StatusServiceUnavailable, post().Code)
+}
+
+// scoringRouter is a recordingRouter that also scores prompt complexity.
+type scoringRouter struct{ recordingRouter }
+
+func (m *scoringRouter) Score([]float32, *provider.ChatRequest) (float64, error) {
+	return 0.42, nil
+}
+
+func TestFeedback_RecordsServedResponse(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	srv.modelRouter = &scoringRouter{}
+	path := filepath.Join(t.TempDir(), "feedback.jsonl")
+	sink, err := feedback.NewJSONLSink(path)
+	require.NoError(t, err)
+	srv.feedback = feedback.NewCollector(feedback.FeedbackConfig{}, sink)
+
+	postFeedback := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/feedback", strings.NewReader(body)))
+		return w
+	}
+
+	// Nothing served yet.
+	assert.Equal(t, http.StatusNotFound, postFeedback(`{"response_id": "resp-123", "rating": "up"}`).Code)
+
+	body := map[string]interface{}{
+		"model":    "auto",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+	}
+	w := doRequest(t, srv, body)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusBadRequest, postFeedback(`{"response_id": "resp-123", "rating": "meh"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, postFeedback(`{"rating": "up"}`).Code)
+	require.Equal(t, http.StatusOK, postFeedback(`{"response_id": "resp-123", "rating": "down", "score": 0.2}`).Code)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var rec feedback.Record
+	require.NoError(t, json.Unmarshal(bytes.TrimSpace(data), &rec))
+	assert.Equal(t, "hello", rec.Prompt)
+	assert.Equal(t, "feedback", rec.Source)
+	assert.Equal(t, "test-model", rec.Model)
+	assert.Equal(t, 0.42, *rec.ComplexityScore)
+	assert.Equal(t, normalizedVec(0), rec.Embedding)
+	require.NotNil(t, rec.Features)
+	assert.Equal(t, 1, rec.Features.UserTurns)
+	assert.Equal(t, "down", rec.Feedback.Rating)
+	assert.Equal(t, 0.2, *rec.Feedback.Score)
+}
+
+func TestFeedback_NotEnabled(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/feedback", strings.NewReader(`{"response_id": "x", "rating": "up"}`)))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// explainRouter is a recordingRouter that can explain its decisions.
+type explainRouter struct{ recordingRouter }
+
+func (m *explainRouter) Explain(_ []float32, _ *provider.ChatRequest, _ http.Header, strategy, _ string, _ time.Duration, _ string, _ float64) (string, any, error) {
+	return "test-model", map[string]string{"strategy": strategy}, nil
+}
+
+func TestRouteExplain(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	srv.modelRouter = &explainRouter{}
+
+	body := map[string]interface{}{
+		"model":    "auto",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+	}
+	// Serve once so the cache has an entry to find.
+	require.Equal(t, http.StatusOK, doRequest(t, srv, body).Code)
+	statsBefore := srv.cache.Stats()
+
+	explain := func(headers http.Header) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/v1/route/explain", bytes.NewReader(data))
+		for k, v := range headers {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := explain(http.Header{"X-Route": {"cheapest"}})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Model     string `json:"model"`
+		Embedding struct {
+			Dimension int     `json:"dimension"`
+			Norm      float64 `json:"norm"`
+		} `json:"embedding"`
+		Routing map[string]string     `json:"routing"`
+		Cache   *cache.CacheCandidate `json:"cache"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "test-model", resp.Model)
+	assert.Equal(t, 384, resp.Embedding.Dimension)
+	assert.InDelta(t, 1.0, resp.Embedding.Norm, 1e-6)
+	assert.Equal(t, "cheapest", resp.Routing["strategy"])
+	require.NotNil(t, resp.Cache)
+	assert.True(t, resp.Cache.Hit)
+	assert.Equal(t, "hello", resp.Cache.Prompt)
+
+	// Dry run: the cache's counters didn't move.
+	assert.Equal(t, statsBefore, srv.cache.Stats())
+
+	// Same header validation as chat completions.
+	assert.Equal(t, http.StatusBadRequest, explain(http.Header{"X-Latency-Budget": {"fast"}}).Code)
+}
+
+// invalidInput is a routing error the request caused, like
+// router.InputError.
+type invalidInput struct{}
+
+func (invalidInput) Error() string { return `unknown routing strategy: "fastest"` }
+func (invalidInput) InvalidInput() {}
+
+// failingRouter fails every decision with err.
+type failingRouter struct {
+	recordingRouter
+	err error
+}
+
+func (m *failingRouter) Decide(_ []float32, _ *provider.ChatRequest, _ http.Header, _, _ string, _ time.Duration, _ string) (string, string, error) {
+	return "", "", m.err
+}
+
+func TestRoutingErrors(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	body := map[string]interface{}{
+		"model":    "auto",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+	}
+
+	t.Run("client input", func(t *testing.T) {
+		srv.modelRouter = &failingRouter{err: invalidInput{}}
+		w := doRequest(t, srv, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "fastest")
+	})
+
+	t.Run("classifier failure", func(t *testing.T) {
+		srv.modelRouter = &failingRouter{err: fmt.Errorf("classifying prompt complexity: %w", errors.New("onnxruntime: session closed"))}
+		w := doRequest(t, srv, body)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.NotContains(t, w.Body.String(), "onnxruntime", "the detail is only logged")
+	})
+}
+
+// overBudget is a routing error for a request whose estimate exceeds its
+// cost cap, like router.BudgetError.
+type overBudget struct{}
+
+func (overBudget) Error() string                         { return "estimated cost $0.010000 exceeds the cap" }
+func (overBudget) OverBudget() (estimate, limit float64) { return 0.01, 0.001 }
+
+// budgetRouter estimates every request at $0.01 and rejects it if its
+// X-Max-Cost is lower.
+type budgetRouter struct{ recordingRouter }
+
+func (m *budgetRouter) FitBudget(_ []float32, _ *provider.ChatRequest, header http.Header, _, _ string, _ time.Duration, _ string, maxCost float64) (string, string, error) {
+	if err := m.CheckBudget("test-model", nil, header, maxCost); err != nil {
+		return "", "", err
+	}
+	return "test-model", "test: always test-model", nil
+}
+
+func (m *budgetRouter) CheckBudget(_ string, _ *provider.ChatRequest, _ http.Header, maxCost float64) error {
+	if maxCost > 0 && maxCost < 0.01 {
+		return overBudget{}
+	}
+	return nil
+}
+
+func (m *budgetRouter) EstimateCost(string, *provider.ChatRequest) (float64, bool) { return 0.01, true }
+
+func TestMaxCost(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	srv.modelRouter = &budgetRouter{}
+
+	tests := []struct {
+		name   string
+		model  string
+		header string
+		want   int
+	}{
+		{"auto within cap", "auto", "0.02", http.StatusOK},
+		{"auto over cap", "auto", "0.001", http.StatusPaymentRequired},
+		{"pinned over cap", "test-model", "0.001", http.StatusPaymentRequired},
+		{"not a number", "auto", "cheap", http.StatusBadRequest},
+		{"not positive", "test-model", "0", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		body := map[string]interface{}{
+			"model":    tt.model,
+			"messages": []map[string]string{{"role": "user", "content": "hello"}},
+		}
+		// Skip the cache: a hit costs nothing, so it's served regardless.
+		w := doRequest(t, srv, body, http.Header{"X-Max-Cost": {tt.header}, "X-Cache": {"skip"}})
+		assert.Equal(t, tt.want, w.Code, tt.name)
+		if tt.want == http.StatusPaymentRequired {
+			assert.Contains(t, w.Body.String(), "exceeds the cap", tt.name)
+		}
+	}
+
+	// A cached response is served whatever the cap, auto or pinned.
+	for _, model := range []string{"auto", "test-model"} {
+		body := map[string]interface{}{
+			"model":    model,
+			"messages": []map[string]string{{"role": "user", "content": "hello"}},
+		}
+		require.Equal(t, http.StatusOK, doRequest(t, srv, body).Code)
+		w := doRequest(t, srv, body, http.Header{"X-Max-Cost": {"0.001"}})
+		assert.Equal(t, http.StatusOK, w.Code, model)
+		assert.Equal(t, "HIT", w.Header().Get("X-LLMRouter-Cache"), model)
+	}
+}
+
+// tokenRouter counts every prompt at 42 tokens and records the prompt
+// sizes providers report.
+type tokenRouter struct {
+	recordingRouter
+	observed []int
+}
+
+func (m *tokenRouter) CountTokens(_ context.Context, req *provider.ChatRequest) tokens.Count {
+	return tokens.Count{Model: req.Model, Tokens: 42, Source: tokens.SourceProvider}
+}
+
+func (m *tokenRouter) ObservePromptTokens(_ string, _ []provider.Message, promptTokens int) {
+	m.observed = append(m.observed, promptTokens)
+}
+
+func TestTokenize(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	tr := &tokenRouter{}
+	srv.modelRouter = tr
+
+	tokenize := func(model string) *httptest.ResponseRecorder {
+		data, err := json.Marshal(map[string]interface{}{
+			"model":    model,
+			"messages": []map[string]string{{"role": "user", "content": "hello"}},
+		})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/tokenize", bytes.NewReader(data)))
+		return w
+	}
+
+	w := tokenize("test-model")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"model": "test-model", "prompt_tokens": 42, "source": "provider"}`, w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, tokenize("auto").Code)
+	assert.Equal(t, http.StatusBadRequest, tokenize("no-such-model").Code)
+
+	// Served completions calibrate the estimate with the provider's count.
+	body := map[string]interface{}{
+		"model":    "test-model",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+	}
+	require.Equal(t, http.StatusOK, doRequest(t, srv, body, http.Header{"X-Cache": {"skip"}}).Code)
+	assert.Equal(t, []int{10}, tr.observed)
+
+	srv.modelRouter = &recordingRouter{}
+	assert.Equal(t, http.StatusNotFound, tokenize("test-model").Code)
+}
+
+// contextRouter has room for two messages: longer conversations are cut
+// to their last two, and a single message over 100 characters is too big.
+type contextRouter struct{ recordingRouter }
+
+func (m *contextRouter) FitContext(_ string, req *provider.ChatRequest) (*provider.ChatRequest, int, error) {
+	last := req.Messages[len(req.Messages)-1]
+	if len(last.Content) > 100 {
+		return nil, 0, errors.New("request is over the context window")
+	}
+	if len(req.Messages) <= 2 {
+		return req, 0, nil
+	}
+	fitted := *req
+	fitted.Messages = req.Messages[len(req.Messages)-2:]
+	return &fitted, len(req.Messages) - 2, nil
+}
+
+func TestContextWindow(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	srv.modelRouter = &contextRouter{}
+
+	send := func(messages ...string) *httptest.ResponseRecorder {
+		var msgs []map[string]string
+		for _, m := range messages {
+			msgs = append(msgs, map[string]string{"role": "user", "content": m})
+		}
+		body := map[string]interface{}{"model": "test-model", "messages": msgs}
+		return doRequest(t, srv, body, http.Header{"X-Cache": {"skip"}})
+	}
+
+	w := send("hello")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-LLMRouter-Dropped-Messages"))
+
+	w = send("one", "two", "three", "four")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-LLMRouter-Dropped-Messages"))
+
+	w = send(strings.Repeat("x", 200))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var errResp errorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Contains(t, errResp.Error.Message, "context window")
+	require.NotNil(t, errResp.Error.Code)
+	assert.Equal(t, codeContextLengthExceeded, *errResp.Error.Code)
+	require.NotNil(t, errResp.Error.Param)
+	assert.Equal(t, "messages", *errResp.Error.Param)
+}
+
+// failingProvider is a provider whose calls fail with err.
+type failingProvider struct {
+	mockProvider
+	err error
+}
+
+func (m *failingProvider) ChatCompletion(context.Context, *provider.ChatRequest) (*provider.ChatResponse, error) {
+	return nil, m.err
+}
+
+func (m *failingProvider) ChatCompletionStream(context.Context, *provider.ChatRequest) (<-chan provider.StreamChunk, error) {
+	return nil, m.err
+}
+
+func TestProviderErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantType   string
+		wantMsg    string
+	}{
+		{
+			name:       "client input rejected",
+			err:        &provider.ProviderError{Provider: "google", StatusCode: 400, Message: "Invalid value at 'contents'", Structured: true},
+			wantStatus: http.StatusBadRequest,
+			wantType:   "invalid_request_error",
+			wantMsg:    "google rejected the request: Invalid value at 'contents'",
+		},
+		{
+			name:       "client input rejected by a proxy",
+			err:        &provider.ProviderError{Provider: "google", StatusCode: 413, Message: "<html>nginx at 10.0.0.7</html>"},
+			wantStatus: http.StatusBadRequest,
+			wantType:   "invalid_request_error",
+			wantMsg:    "google rejected the request (status 413)",
+		},
+		{
+			name:       "rate limited",
+			err:        &provider.ProviderError{Provider: "anthropic", StatusCode: 429, Message: "slow down", RetryAfter: 1500 * time.Millisecond},
+			wantStatus: http.StatusTooManyRequests,
+			wantType:   "rate_limit_error",
+			wantMsg:    "anthropic rate limit exceeded",
+		},
+		{
+			name:       "gateway credentials",
+			err:        &provider.ProviderError{Provider: "anthropic", StatusCode: 401, Message: "invalid x-api-key sk-ant-123"},
+			wantStatus: http.StatusBadGateway,
+			wantType:   "server_error",
+			wantMsg:    "anthropic returned an error (status 401)",
+		},
+		{
+			name:       "provider outage",
+			err:        &provider.ProviderError{Provider: "google", StatusCode: 500, Message: "backend shard us-east-7 failed"},
+			wantStatus: http.StatusBadGateway,
+			wantType:   "server_error",
+			wantMsg:    "google returned an error (status 500)",
+		},
+		{
+			name:       "timeout",
+			err:        fmt.Errorf("calling provider: %w", context.DeadlineExceeded),
+			wantStatus: http.StatusGatewayTimeout,
+			wantType:   "server_error",
+			wantMsg:    "timed out",
+		},
+		{
+			name:       "network failure",
+			err:        errors.New("dial tcp 10.0.0.7:443: connection refused"),
+			wantStatus: http.StatusBadGateway,
+			wantType:   "server_error",
+			wantMsg:    "upstream provider request failed",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/stream=%v", tt.name, stream), func(t *testing.T) {
+				srv := setupTestServer(t, func(text string) ([]float32, error) {
+					return normalizedVec(0), nil
+				})
+				srv.models["test-model"] = &failingProvider{mockProvider: mockProvider{name: "test-provider"}, err: tt.err}
+
+				body := map[string]interface{}{
+					"model":    "test-model",
+					"messages": []map[string]string{{"role": "user", "content": "hello"}},
+					"stream":   stream,
+				}
+				w := doRequest(t, srv, body, http.Header{"X-Cache": {"skip"}})
+				assert.Equal(t, tt.wantStatus, w.Code)
+
+				var errResp errorResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+				assert.Equal(t, tt.wantType, errResp.Error.Type)
+				assert.Contains(t, errResp.Error.Message, tt.wantMsg)
+				assert.NotContains(t, w.Body.String(), "sk-ant-123")
+				assert.NotContains(t, w.Body.String(), "us-east-7")
+				assert.NotContains(t, w.Body.String(), "10.0.0.7")
+				if tt.wantStatus == http.StatusTooManyRequests {
+					assert.Equal(t, "2", w.Header().Get("Retry-After"))
+				}
+			})
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		if text == "late" {
+			return normalizedVec(1), nil
+		}
+		return normalizedVec(0), nil
+	})
+
+	health := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+		return w
+	}
+	require.Equal(t, http.StatusOK, health().Code)
+
+	// A streamed miss whose client has already gone away by the time the
+	// stream ends: its cache write still lands, and Wait waits for it.
+	body := map[string]interface{}{
+		"model":    "test-model",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+		"stream":   true,
+	}
+	jsonBody, err := json.Marshal(body)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(jsonBody)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	cancel()
+	require.Equal(t, "MISS", w.Header().Get("X-LLMRouter-Cache"))
+
+	srv.Drain()
+	w = health()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "draining")
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	require.NoError(t, srv.Wait(waitCtx))
+
+	body["stream"] = false
+	w = doRequest(t, srv, body)
+	assert.Equal(t, "HIT", w.Header().Get("X-LLMRouter-Cache"))
+
+	// A stream that outlived Shutdown's deadline is still served once Wait
+	// has begun, but leaves no background work behind: it isn't cached.
+	body["messages"] = []map[string]string{{"role": "user", "content": "late"}}
+	body["stream"] = true
+	w = doRequest(t, srv, body)
+	assert.Equal(t, "MISS", w.Header().Get("X-LLMRouter-Cache"))
+	assert.Contains(t, w.Body.String(), "[DONE]")
+	require.NoError(t, srv.Wait(waitCtx))
+	body["stream"] = false
+	assert.Equal(t, "MISS", doRequest(t, srv, body).Header().Get("X-LLMRouter-Cache"))
+}
+
+// classifyingRouter is a router whose complexity classifier gives every
+// prompt the same score, or fails with err.
+type classifyingRouter struct {
+	recordingRouter
+	score float64
+	err   error
+}
+
+func (m *classifyingRouter) HasClassifier() bool { return true }
+
+func (m *classifyingRouter) Score([]float32, *provider.ChatRequest) (float64, error) {
+	return m.score, m.err
+}
+
+func TestHealthReady(t *testing.T) {
+	embedErr := error(nil)
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), embedErr
+	})
+	srv.cfg.Providers = map[string]config.ProviderConfig{
+		"test-provider": {APIKey: "key"},
+		"unused":        {APIKey: "key"},
+	}
+
+	check := func() (int, readiness) {
+		t.Helper()
+		srv.lastReady = nil // skip the result cache
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+		var report readiness
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		return w.Code, report
+	}
+
+	code, report := check()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", report.Status)
+	assert.Equal(t, componentOK, report.Components["redis"].Status)
+	assert.Equal(t, componentOK, report.Components["embedder"].Status)
+	assert.Equal(t, componentDisabled, report.Components["classifier"].Status)
+	assert.Equal(t, componentOK, report.Components["providers"].Status)
+	assert.Contains(t, report.Components["providers"].Detail, "unused")
+
+	srv.modelRouter = &classifyingRouter{score: 0.3}
+	_, report = check()
+	assert.Equal(t, componentOK, report.Components["classifier"].Status)
+
+	srv.modelRouter = &classifyingRouter{score: math.NaN()}
+	code, report = check()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, componentFailed, report.Components["classifier"].Status)
+
+	srv.modelRouter = nil
+	embedErr = errors.New("onnx session closed")
+	code, report = check()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not_ready", report.Status)
+	assert.Contains(t, report.Components["embedder"].Error, "onnx session closed")
+	embedErr = nil
+
+	srv.cfg.Providers = map[string]config.ProviderConfig{"test-provider": {APIKey: ""}}
+	code, report = check()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, componentFailed, report.Components["providers"].Status)
+	srv.cfg.Providers = map[string]config.ProviderConfig{"test-provider": {APIKey: "key"}}
+
+	require.NoError(t, srv.cache.Close())
+	code, report = check()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, componentFailed, report.Components["redis"].Status)
+}
+
+func TestHealthReady_CachedAndDraining(t *testing.T) {
+	calls := 0
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		calls++
+		return normalizedVec(0), nil
+	})
+	srv.cfg.Providers = map[string]config.ProviderConfig{"test-provider": {APIKey: "key"}}
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, get("/health/ready"))
+	assert.Equal(t, http.StatusOK, get("/health/ready"))
+	assert.Equal(t, 1, calls, "the second probe reuses the first check")
+
+	// Draining takes effect at once, cache or not; liveness is unaffected.
+	srv.Drain()
+	assert.Equal(t, http.StatusServiceUnavailable, get("/health/ready"))
+	assert.Equal(t, http.StatusOK, get("/health/live"))
+}
+
+func TestCacheUnavailable(t *testing.T) {
+	srv := setupTestServer(t, func(text string) ([]float32, error) {
+		return normalizedVec(0), nil
+	})
+	srv.cfg.Providers = map[string]config.ProviderConfig{"test-provider": {APIKey: "key"}}
+
+	// Redis is down from the start: the breaker opens at once.
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+	cfg := cache.CacheConfig{RedisURL: "redis://" + addr + "?max_retries=-1", SimilarityThreshold: 0.92}
+	rc, err := cache.OpenRedisCache(cfg)
+	require.NoError(t, err)
+	b := cache.NewBreaker(rc, cfg)
+	t.Cleanup(func() { b.Close() })
+	require.False(t, b.Available())
+	srv.cache = b
+
+	body := map[string]interface{}{
+		"model":    "test-model",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+	}
+	w := doRequest(t, srv, body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "MISS", w.Header().Get("X-LLMRouter-Cache"))
+
+	w = doRequest(t, srv, body, http.Header{"X-Cache": {"only"}})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// Serving without the cache is degraded, not unready...
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report readiness
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, "ready", report.Status)
+	assert.Equal(t, componentDegraded, report.Components["redis"].Status)
+	assert.NotEmpty(t, report.Components["redis"].Error)
+
+	// ...but the same outage without the breaker fails readiness.
+	srv.cache = rc
+	srv.lastReady = nil
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, componentFailed, report.Components["redis"].Status)
+}
+
+// gatedProvider holds its responses until release is closed; a streamed
+// response sends its first chunk at once and the rest after release. The
+// first call fails with err, if set.
+type gatedProvider struct {
+	mockProvider
+	release chan struct{}
+	calls   atomic.Int32
+	err     error
+}
+
+func (m *gatedProvider) ChatCompletion(context.Context, *provider.ChatRequest) (*provider.ChatResponse, error) {
+	n := m.calls.Add(1)
+	<-m.release
+	if n == 1 && m.err != nil {
+		return nil, m.err
+	}
+	resp := *m.response // each caller sets its own CostUSD
+	return &resp, nil
+}
+
+func (m *gatedProvider) ChatCompletionStream(context.Context, *provider.ChatRequest) (<-chan provider.StreamChunk, error) {
+	m.calls.Add(1)
+	resp := m.response
+	ch := make(chan provider.StreamChunk, 1)
+	go func() {
+		defer close(ch)
+		ch <- provider.StreamChunk{ID: resp.ID, Model: resp.Model, Delta: "This is "}
+		<-m.release
+		ch <- provider.StreamChunk{ID: resp.ID, Model: resp.Model, Delta: "a test response."}
+		ch <- provider.StreamChunk{ID: resp.ID, Model: resp.Model, Done: true, Usage: &resp.Usage}
+	}()
+	return ch, nil
+}
+
+// hungUpWriter is a response writer whose client has disconnected: every
+// write fails.
+type hungUpWriter struct{ *httptest.ResponseRecorder }
+
+func (w *hungUpWriter) Write([]byte) (int, error) { return 0, errors.New("write: broken pipe") }
+
+func TestCoalescing(t *testing.T) {
+	// setup returns a server coalescing in mode whose provider is gated.
+	// "hello" and "hello there" embed 0.96 apart.
+	setup := func(t *testing.T, mode string, maxWait time.Duration) (*Server, *gatedProvider) {
+		srv := setupTestServer(t, func(text string) ([]float32, error) {
+			if text == "hello there" {
+				return similarVec(0.3), nil
+			}
+			return normalizedVec(0), nil
+		})
+		srv.flights = newCoalescer(cache.CacheConfig{Coalesce: mode, CoalesceMaxWait: maxWait, SimilarityThreshold: 0.92})
+		gp := &gatedProvider{mockProvider: *srv.models["test-model"].(*mockProvider), release: make(chan struct{})}
+		srv.models["test-model"] = gp
+		return srv, gp
+	}
+	// send starts a request and returns its recorder once it completes.
+	send := func(srv *Server, prompt string, stream bool) <-chan *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"model":    "test-model",
+			"messages": []map[string]string{{"role": "user", "content": prompt}},
+			"stream":   stream,
+		})
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body)))
+			done <- w
+		}()
+		return done
+	}
+	// joined gives followers time to find the leader's flight.
+	joined := func() { time.Sleep(100 * time.Millisecond) }
+
+	t.Run("followers share the leader's call", func(t *testing.T) {
+		srv, gp := setup(t, CoalesceSemantic, time.Minute)
+		leader := send(srv, "hello", false)
+		require.Eventually(t, func() bool { return gp.calls.Load() == 1 }, time.Second, time.Millisecond)
+		followers := []<-chan *httptest.ResponseRecorder{send(srv, "hello", false), send(srv, "hello there", false)}
+		joined()
+		close(gp.release)
+
+		w := <-leader
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-LLMRouter-Coalesced"))
+		for _, follower := range followers {
+			w := <-follower
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "MISS", w.Header().Get("X-LLMRouter-Cache"))
+			assert.Equal(t, "true", w.Header().Get("X-LLMRouter-Coalesced"))
+			assert.Contains(t, w.Body.String(), "This is a test response.")
+		}
+		assert.EqualValues(t, 1, gp.calls.Load())
+	})
+
+	t.Run("streaming followers subscribe to the leader's stream", func(t *testing.T) {
+		srv, gp := setup(t, CoalesceExact, time.Minute)
+		leader := send(srv, "hello", true)
+		require.Eventually(t, func() bool { return gp.calls.Load() == 1 }, time.Second, time.Millisecond)
+		streaming, buffered := send(srv, "hello", true), send(srv, "hello", false)
+		joined()
+		close(gp.release)
+
+		w := <-leader
+		assert.Contains(t, w.Body.String(), "a test response.")
+		w = <-streaming
+		assert.Equal(t, "true", w.Header().Get("X-LLMRouter-Coalesced"))
+		assert.Contains(t, w.Body.String(), `"content":"This is "`)
+		assert.Contains(t, w.Body.String(), `"content":"a test response."`)
+		assert.Contains(t, w.Body.String(), "[DONE]")
+		w = <-buffered
+		assert.Equal(t, "true", w.Header().Get("X-LLMRouter-Coalesced"))
+		assert.Contains(t, w.Body.String(), "This is a test response.")
+		assert.EqualValues(t, 1, gp.calls.Load())
+
+		// The leader's flight closes once its response is stored.
+		require.NoError(t, srv.Wait(context.Background()))
+		assert.Empty(t, srv.flights.flights)
+
+		// "hello there" is similar enough for the cache, but not an
+		// exact match.
+		fl, leads := srv.flights.join("test-model", normalizedVec(0), false)
+		assert.True(t, leads)
+		_, leads = srv.flights.join("test-model", similarVec(0.3), false)
+		assert.True(t, leads)
+		srv.flights.leave(fl)
+	})
+
+	t.Run("leader's client hangs up", func(t *testing.T) {
+		srv, gp := setup(t, CoalesceExact, time.Minute)
+
+		// The leader's client is gone by the time its first chunk is
+		// written; net/http cancels the request's context when the
+		// handler returns.
+		body, err := json.Marshal(map[string]interface{}{
+			"model":    "test-model",
+			"messages": []map[string]string{{"role": "user", "content": "hello"}},
+			"stream":   true,
+		})
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body)).WithContext(ctx)
+		srv.ServeHTTP(&hungUpWriter{httptest.NewRecorder()}, req)
+		cancel()
+
+		// The provider's stream carries on: a follower still gets all
+		// of it, and the flight closes once it's stored.
+		follower := send(srv, "hello", true)
+		joined()
+		close(gp.release)
+		w := <-follower
+		assert.Equal(t, "true", w.Header().Get("X-LLMRouter-Coalesced"))
+		assert.Contains(t, w.Body.String(), `"content":"a test response."`)
+
+		waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer waitCancel()
+		require.NoError(t, srv.Wait(waitCtx))
+		assert.Empty(t, srv.flights.flights)
+		assert.Equal(t, "HIT", (<-send(srv, "hello", false)).Header().Get("X-LLMRouter-Cache"))
+		assert.EqualValues(t, 1, gp.calls.Load())
+	})
+
+	t.Run("followers stop waiting at max wait", func(t *testing.T) {
+		srv, gp := setup(t, CoalesceExact, 20*time.Millisecond)
+		leader := send(srv, "hello", false)
+		require.Eventually(t, func() bool { return gp.calls.Load() == 1 }, time.Second, time.Millisecond)
+		follower := send(srv, "hello", false)
+		require.Eventually(t, func() bool { return gp.calls.Load() == 2 }, time.Second, time.Millisecond)
+		close(gp.release)
+
+		assert.Equal(t, http.StatusOK, (<-leader).Code)
+		w := <-follower
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-LLMRouter-Coalesced"))
+	})
+
+	t.Run("followers call the provider when the leader fails", func(t *testing.T) {
+		srv, gp := setup(t, CoalesceExact, time.Minute)
+		gp.err = &provider.ProviderError{Provider: "test-provider", StatusCode: 400, Message: "bad request"}
+		leader := send(srv, "hello", false)
+		require.Eventually(t, func() bool { return gp.calls.Load() == 1 }, time.Second, time.Millisecond)
+		follower := send(srv, "hello", false)
+		joined()
+		close(gp.release)
+
+		assert.Equal(t, http.StatusBadRequest, (<-leader).Code)
+		w := <-follower
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-LLMRouter-Coalesced"))
+		assert.EqualValues(t, 2, gp.calls.Load())
+	})
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// readyCacheTTL is how long a readiness result is reused. Kubernetes
+// probes every few seconds from every kubelet that cares, and a check runs
+// the embedding model and round-trips to Redis.
+const readyCacheTTL = 2 * time.Second
+
+// readyCheckTimeout bounds each dependency check. A Redis that takes
+// longer than this to answer PING is as good as down.
+const readyCheckTimeout = 2 * time.Second
+
+// readyProbeText is the prompt the readiness check embeds and classifies.
+const readyProbeText = "llmrouter readiness check"
+
+// Component states in a readiness report.
+const (
+	componentOK       = "ok"
+	componentFailed   = "failed"
+	componentDisabled = "disabled" // not configured; doesn't affect readiness
+	componentDegraded = "degraded" // failing, but requests are served without it
+)
+
+// embedProber is implemented by embedders that wrap the model in a cache
+// or batcher (embedder.Prober). Probe embeds a fixed text through the
+// model itself; a cached vector would prove nothing.
+type embedProber interface {
+	Probe() ([]float32, error)
+}
+
+// classifierChecker is implemented by routers that can say whether they
+// have a complexity classifier (router.Router); readiness test-runs it
+// through complexityScorer.
+type classifierChecker interface {
+	complexityScorer
+	HasClassifier() bool
+}
+
+// componentStatus is one dependency's entry in a readiness report.
+type componentStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// readiness is the body of GET /health/ready.
+type readiness struct {
+	Status     string                     `json:"status"` // "ready", "not_ready", or "draining"
+	CheckedAt  time.Time                  `json:"checked_at"`
+	Components map[string]componentStatus `json:"components"`
+}
+
+// ready reports whether every configured component is ok.
+func (rd *readiness) ready() bool {
+	for _, c := range rd.Components {
+		if c.Status == componentFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// handleLive handles GET /health/live: the process is up and serving HTTP.
+// It stays 200 while draining — a liveness failure gets the pod restarted,
+// which is never what a dependency outage or a shutdown needs.
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReady handles GET /health/ready: 200 if the gateway can serve
+// requests, 503 with the failing components if not, or while draining for
+// shutdown. The gateway can serve without Redis when its cache is behind
+// the breaker — as it always is in cmd/llmrouter — so a Redis outage alone
+// doesn't fail the probe (see checkRedis); llmrouter_cache_available is the
+// signal for it. Results are cached for readyCacheTTL.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	report := s.readiness(r.Context())
+	status := http.StatusOK
+	switch {
+	case s.draining.Load():
+		report.Status = "draining"
+		status = http.StatusServiceUnavailable
+	case !report.ready():
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// readiness returns the latest readiness report, re-checking dependencies
+// if it's older than readyCacheTTL. Concurrent callers wait for one check
+// rather than each running their own.
+func (s *Server) readiness(ctx context.Context) readiness {
+	s.readyMu.Lock()
+	defer s.readyMu.Unlock()
+
+	if s.lastReady != nil && time.Since(s.lastReady.CheckedAt) < readyCacheTTL {
+		return *s.lastReady
+	}
+
+	report := readiness{CheckedAt: time.Now(), Components: make(map[string]componentStatus)}
+	report.Components["redis"] = s.checkRedis(ctx)
+	embedding, embedStatus := s.checkEmbedder()
+	report.Components["embedder"] = embedStatus
+	report.Components["classifier"] = s.checkClassifier(embedding)
+	report.Components["providers"] = s.checkProviders()
+
+	report.Status = "ready"
+	if !report.ready() {
+		report.Status = "not_ready"
+	}
+	s.lastReady = &report
+	return report
+}
+
+// checkRedis pings the cache's Redis. A cache behind a breaker
+// (cacheAvailability) is only degraded when Redis is down: requests skip
+// it until it's back, and pulling every pod sharing that Redis out of
+// rotation would turn a cache outage into a full one. Without a breaker,
+// every request would wait on a dead Redis, so a failed ping fails
+// readiness.
+func (s *Server) checkRedis(ctx context.Context) componentStatus {
+	if s.cache == nil {
+		return componentStatus{Status: componentDisabled}
+	}
+	ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	status := timedStatus(start, s.cache.Ping(ctx))
+	if _, ok := s.cache.(cacheAvailability); ok && status.Status == componentFailed {
+		status.Status = componentDegraded
+	}
+	return status
+}
+
+// checkEmbedder embeds the probe text through the embedding model, and
+// returns the vector for checkClassifier.
+func (s *Server) checkEmbedder() ([]float32, componentStatus) {
+	if s.embedder == nil {
+		return nil, componentStatus{Status: componentDisabled}
+	}
+
+	start := time.Now()
+	var vec []float32
+	var err error
+	if p, ok := s.embedder.(embedProber); ok {
+		vec, err = p.Probe()
+	} else {
+		vec, err = s.embedder.Embed(readyProbeText)
+	}
+	if err == nil && len(vec) == 0 {
+		err = errors.New("empty embedding")
+	}
+	if err == nil && s.cfg.Embedding.Dimension > 0 && len(vec) != s.cfg.Embedding.Dimension {
+		err = fmt.Errorf("embedding has %d dimensions, want %d", len(vec), s.cfg.Embedding.Dimension)
+	}
+	status := timedStatus(start, err)
+	if err != nil {
+		return nil, status
+	}
+	return vec, status
+}
+
+// checkClassifier scores the probe embedding with the complexity
+// classifier and checks the score is a probability.
+func (s *Server) checkClassifier(embedding []float32) componentStatus {
+	cc, ok := s.modelRouter.(classifierChecker)
+	if !ok || !cc.HasClassifier() {
+		return componentStatus{Status: componentDisabled}
+	}
+	if embedding == nil {
+		return componentStatus{Status: componentFailed, Error: "no embedding to classify"}
+	}
+
+	req := &provider.ChatRequest{Messages: []provider.Message{{Role: "user", Content: readyProbeText}}}
+	start := time.Now()
+	score, err := cc.Score(embedding, req)
+	if err == nil && (math.IsNaN(score) || score < 0 || score > 1) {
+		err = fmt.Errorf("score %v is outside [0, 1]", score)
+	}
+	return timedStatus(start, err)
+}
+
+// checkProviders confirms at least one configured provider has an API key
+// and serves a registered model. It doesn't call them: that would cost
+// money and rate limit on every probe, and a provider outage is something
+// fallback and retries handle, not a reason to pull every pod.
+func (s *Server) checkProviders() componentStatus {
+	served := make(map[string]bool)
+	for _, p := range s.models {
+		served[p.Name()] = true
+	}
+
+	var usable, missing []string
+	for name, pc := range s.cfg.Providers {
+		if pc.APIKey != "" && served[name] {
+			usable = append(usable, name)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(usable)
+	sort.Strings(missing)
+
+	status := componentStatus{Status: componentOK, Detail: fmt.Sprintf("usable: %v", usable)}
+	if len(missing) > 0 {
+		status.Detail += fmt.Sprintf("; no API key or models: %v", missing)
+	}
+	if len(usable) == 0 {
+		status.Status = componentFailed
+		status.Error = "no provider has an API key and a registered model"
+	}
+	return status
+}
+
+// timedStatus is the status of a check that started at start and returned
+// err.
+func timedStatus(start time.Time, err error) componentStatus {
+	status := componentStatus{
+		Status:    componentOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = componentFailed
+		status.Error = err.Error()
+	}
+	return status
+}
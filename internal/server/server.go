@@ -2,12 +2,19 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/howard-nolan/llmrouter/internal/cache"
 	"github.com/howard-nolan/llmrouter/internal/config"
+	"github.com/howard-nolan/llmrouter/internal/feedback"
 	"github.com/howard-nolan/llmrouter/internal/provider"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -18,14 +25,55 @@ import (
 // implementation (*embedder.ONNXEmbedder) satisfies this implicitly.
 type Embedder interface {
 	Embed(text string) ([]float32, error)
+	EmbedBatch(texts []string) ([][]float32, error)
+}
+
+// cachingEmbedder is implemented by embedders that remember prompts they've
+// already embedded (embedder.CachingEmbedder). hit=true tells the handler
+// the prompt is an exact repeat with a bit-identical embedding, so it can
+// try the cache's exact-match fast path before the similarity scan.
+type cachingEmbedder interface {
+	EmbedCached(text string) (vec []float32, hit bool, err error)
+}
+
+// truncationReporter is implemented by embedders that can tell whether a
+// prompt's embedding is lossy (embedder.TruncationReporter).
+type truncationReporter interface {
+	Truncated(text string) bool
+}
+
+// cacheAvailability is implemented by caches behind a circuit breaker
+// (cache.Breaker). Available is false while Redis is down.
+type cacheAvailability interface {
+	Available() bool
+}
+
+// latencyObserver is implemented by routers that track live per-model
+// latency and error rates (router.Router). The handler feeds it streamed
+// TTFT and request outcomes so the latency strategy routes on current
+// conditions.
+type latencyObserver interface {
+	ObserveTTFT(model string, ttft time.Duration)
+	ObserveOutcome(model string, failed bool)
+}
+
+// classifierAdmin is implemented by routers whose complexity classifier
+// can be swapped at runtime (router.Router over a router.Registry). Status
+// is JSON-ready and nil when hot reload isn't configured; Reload and
+// Rollback return the now-live classifier's description.
+type classifierAdmin interface {
+	ClassifierStatus() any
+	ReloadClassifier() (any, error)
+	RollbackClassifier() (any, error)
 }
 
 // ModelRouter selects a concrete model name for "auto" routing requests.
 // Defined here at the consumer to decouple the server package from the
 // router package (same pattern as Embedder above).
 type ModelRouter interface {
-	Route(embedding []float32, strategy string, providerName string) (string, error)
-	CheapAndQualityFor(providerName string) (cheap, quality string, ok bool)
+	Decide(embedding []float32, req *provider.ChatRequest, header http.Header, strategy string, providerName string, latencyBudget time.Duration, experiment string) (model, reason string, err error)
+	Assign(header http.Header, requestKey string) (experiment, arm string, shadow bool)
+	TiersFor(providerName string) (models []string, ok bool)
 }
 
 // Server holds the HTTP router and all dependencies that handlers need.
@@ -36,21 +84,53 @@ type Server struct {
 	embedder    Embedder
 	cache       cache.Cache
 	modelRouter ModelRouter
+	feedback    *feedback.Collector
+
+	// flights tracks provider calls that matching cache misses can share;
+	// nil when coalescing is off.
+	flights *coalescer
+
+	// draining is set by Drain when shutdown begins; background tracks
+	// goroutines requests leave running, for Wait, and backgroundClosed
+	// (under backgroundMu) stops goBackground adding to it once Wait has
+	// begun. shadowCtx is the parent of every shadow call, cancelled by
+	// Drain.
+	draining         atomic.Bool
+	background       sync.WaitGroup
+	backgroundMu     sync.Mutex
+	backgroundClosed bool
+	shadowCtx        context.Context
+	cancelShadows    context.CancelFunc
+
+	// readyMu guards lastReady, the cached /health/ready report.
+	readyMu   sync.Mutex
+	lastReady *readiness
 }
 
-// New creates a Server with all dependencies wired in.
-func New(cfg *config.Config, models map[string]provider.Provider, emb Embedder, c cache.Cache, mr ModelRouter) *Server {
+// New creates a Server with all dependencies wired in. fb may be nil,
+// which disables POST /v1/feedback.
+func New(cfg *config.Config, models map[string]provider.Provider, emb Embedder, c cache.Cache, mr ModelRouter, fb *feedback.Collector) *Server {
 	s := &Server{
 		cfg:         cfg,
 		models:      models,
 		embedder:    emb,
 		cache:       c,
 		modelRouter: mr,
+		feedback:    fb,
+		flights:     newCoalescer(cfg.Cache),
 	}
+	s.shadowCtx, s.cancelShadows = context.WithCancel(context.Background())
 	s.routes()
 	return s
 }
 
+// cacheAvailable reports whether the cache can be used: true unless it's
+// behind a breaker that has opened.
+func (s *Server) cacheAvailable() bool {
+	ca, ok := s.cache.(cacheAvailability)
+	return !ok || ca.Available()
+}
+
 // routes builds the chi router with all middleware and route definitions.
 // This is conceptually like your Express app.use() / app.get() / app.post()
 // setup, but gathered in one method so the routing table is easy to scan.
@@ -69,14 +149,47 @@ func (s *Server) routes() {
 
 	// --- Routes ---
 	r.Get("/health", s.handleHealth)
+	r.Get("/health/live", s.handleLive)
+	r.Get("/health/ready", s.handleReady)
 	r.Handle("/metrics", promhttp.Handler())
 	r.Get("/cache/stats", s.handleCacheStats)
 	r.Post("/cache/flush", s.handleCacheFlush)
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireAdmin)
+		r.Post("/classifier/reload", s.handleClassifierReload)
+		r.Post("/classifier/rollback", s.handleClassifierRollback)
+	})
 	r.Post("/v1/chat/completions", s.handleChatCompletions)
+	r.Post("/v1/messages", s.handleMessages)
+	r.Post("/v1/embeddings", s.handleEmbeddings)
+	r.Post("/v1/feedback", s.handleFeedback)
+	r.Post("/v1/route/explain", s.handleRouteExplain)
+	r.Post("/v1/tokenize", s.handleTokenize)
 
 	s.router = r
 }
 
+// requireAdmin lets a request through to an admin endpoint only if it
+// carries server.admin_token as a bearer token. Without a configured token
+// the admin endpoints are disabled.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := openAIFormat{}
+		token := s.cfg.Server.AdminToken
+		if token == "" {
+			f.writeError(w, http.StatusForbidden, "admin endpoints are disabled; set server.admin_token to enable them")
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			f.writeError(w, http.StatusUnauthorized, "missing or invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // ServeHTTP makes Server satisfy the http.Handler interface. Every incoming
 // request flows through this method, and we just delegate to chi's router.
 //
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// storeTimeout bounds a cache write that outlives its request. Stores run
+// on a context detached from the client's, so a client hanging up the
+// moment its last token arrives doesn't cancel the write, and shutdown
+// waits for them (Wait).
+const storeTimeout = 10 * time.Second
+
+// Drain marks the server as shutting down: /health starts answering 503 so
+// load balancers stop sending new requests, while requests already in
+// flight — and new ones, until http.Server.Shutdown closes the listener —
+// are served as usual. Shadow experiment calls are cancelled, and no new
+// ones start: their results are thrown away, so shutdown needn't wait.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+	s.cancelShadows()
+}
+
+// Wait blocks until the work requests left running in the background —
+// streamed responses being written to the cache, shadow experiment calls
+// (which Drain cancels) — has finished, or ctx is done. Call it after
+// http.Server.Shutdown has returned. Handlers that outlived Shutdown's
+// deadline may still be running, so from here on goBackground turns their
+// work away rather than add to a WaitGroup that's being waited on.
+func (s *Server) Wait(ctx context.Context) error {
+	s.backgroundMu.Lock()
+	s.backgroundClosed = true
+	s.backgroundMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// goBackground runs f in a goroutine Wait waits for, and reports whether
+// it did. Once Wait has begun it doesn't run f at all.
+func (s *Server) goBackground(f func()) bool {
+	s.backgroundMu.Lock()
+	defer s.backgroundMu.Unlock()
+	if s.backgroundClosed {
+		return false
+	}
+	s.background.Go(f)
+	return true
+}
+
+// detached returns a context for work that must finish even if the
+// request that started it is cancelled. It keeps ctx's values (the cache
+// reads the truncated-prompt flag from them) but not its cancellation,
+// and gives up after storeTimeout.
+func detached(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), storeTimeout)
+}
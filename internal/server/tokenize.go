@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+	"github.com/howard-nolan/llmrouter/internal/tokens"
+)
+
+// tokenCounter is implemented by routers with a token counter
+// (router.Router). CountTokens serves POST /v1/tokenize;
+// ObservePromptTokens is fed every provider-reported prompt token count
+// so the local estimate behind routing and cost caps stays calibrated.
+type tokenCounter interface {
+	CountTokens(ctx context.Context, req *provider.ChatRequest) tokens.Count
+	ObservePromptTokens(model string, messages []provider.Message, promptTokens int)
+}
+
+// handleTokenize handles POST /v1/tokenize: a chat completions request
+// body, answered with its input token count on the requested model —
+// exact where the provider can count tokens, otherwise the calibrated
+// local estimate. The model must be concrete; "auto" hasn't picked a
+// tokenizer yet.
+func (s *Server) handleTokenize(w http.ResponseWriter, r *http.Request) {
+	f := openAIFormat{}
+
+	var req provider.ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		f.writeCodedError(w, http.StatusBadRequest, "messages is required", "", "messages")
+		return
+	}
+	if req.Model == "auto" {
+		f.writeError(w, http.StatusBadRequest, "tokenize needs a concrete model, not auto")
+		return
+	}
+	if _, err := s.resolveProvider(req.Model); err != nil {
+		f.writeCodedError(w, http.StatusBadRequest, err.Error(), codeModelNotFound, "model")
+		return
+	}
+
+	tc, ok := s.modelRouter.(tokenCounter)
+	if !ok {
+		f.writeError(w, http.StatusNotFound, "token counting is not configured")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tc.CountTokens(r.Context(), &req))
+}
+
+// observePromptTokens calibrates the router's token estimate for model
+// against a provider's reported prompt size. No-op for routers without a
+// token counter.
+func (s *Server) observePromptTokens(model string, messages []provider.Message, promptTokens int) {
+	if tc, ok := s.modelRouter.(tokenCounter); ok {
+		tc.ObservePromptTokens(model, messages, promptTokens)
+	}
+}
@@ -0,0 +1,81 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// WriteAnthropic is the Anthropic Messages API counterpart to Write. It
+// reads the same StreamChunks but emits Anthropic's NAMED events
+// (message_start, content_block_delta, message_stop, ...) instead of
+// OpenAI's anonymous data: lines, so Anthropic SDK clients can consume the
+// stream from POST /v1/messages.
+//
+// Each SSE event carries two fields:
+//
+//	event: content_block_delta
+//	data: {"type":"content_block_delta","index":0,"delta":{...}}
+//
+// The provider package decides which events a chunk becomes
+// (AnthropicStreamEncoder); this function only handles framing, flushing,
+// timing metrics, and cost. There's no [DONE] sentinel — message_stop
+// is the terminal event in Anthropic's protocol.
+func WriteAnthropic(w http.ResponseWriter, chunks <-chan provider.StreamChunk, opts WriteOptions) error {
+	lat := newLatencyTracker(opts)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("response writer does not support flushing (http.Flusher)")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	var enc provider.AnthropicStreamEncoder
+
+	for chunk := range chunks {
+		lat.observe()
+
+		if chunk.Error != nil {
+			log.Printf("stream error: %v", chunk.Error)
+			// Headers are already sent, so the status can't change.
+			// Anthropic's protocol has an in-band "error" event for
+			// exactly this case — the SDKs raise it as an exception.
+			if err := writeNamedEvent(w, provider.AnthropicErrorEvent()); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return chunk.Error
+		}
+
+		if chunk.Done && chunk.Usage != nil {
+			opts.finish(*chunk.Usage)
+		}
+
+		for _, event := range enc.Encode(chunk) {
+			if err := writeNamedEvent(w, event); err != nil {
+				return err
+			}
+		}
+		flusher.Flush()
+	}
+
+	return nil
+}
+
+// writeNamedEvent writes one "event: <name>\ndata: <json>\n\n" SSE event.
+func writeNamedEvent(w http.ResponseWriter, event provider.AnthropicEvent) error {
+	jsonBytes, err := json.Marshal(event.Data)
+	if err != nil {
+		return fmt.Errorf("marshaling SSE event: %w", err)
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, jsonBytes); err != nil {
+		return fmt.Errorf("writing SSE event: %w", err)
+	}
+	return nil
+}
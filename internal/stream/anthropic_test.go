@@ -0,0 +1,87 @@
+package stream
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// parseEventNames returns the "event:" names from Anthropic-style SSE output.
+func parseEventNames(body string) []string {
+	var names []string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "event: ") {
+			names = append(names, strings.TrimPrefix(line, "event: "))
+		}
+	}
+	return names
+}
+
+func TestWriteAnthropic_NamedEvents(t *testing.T) {
+	ch := sendChunks(
+		provider.StreamChunk{ID: "msg_1", Model: "test-model", Delta: "Hello"},
+		provider.StreamChunk{ID: "msg_1", Model: "test-model", Done: true, Usage: &provider.Usage{
+			PromptTokens: 5, CompletionTokens: 1, TotalTokens: 6,
+		}},
+	)
+
+	var doneUsage provider.Usage
+	var doneCost float64
+	w := httptest.NewRecorder()
+	err := WriteAnthropic(w, ch, WriteOptions{
+		CostFn: func(u provider.Usage) float64 { return 0.5 },
+		OnDone: func(u provider.Usage, cost float64) {
+			doneUsage = u
+			doneCost = cost
+		},
+	})
+	if err != nil {
+		t.Fatalf("WriteAnthropic returned error: %v", err)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want %q", ct, "text/event-stream")
+	}
+
+	want := []string{
+		"message_start", "content_block_start", "content_block_delta",
+		"content_block_stop", "message_delta", "message_stop",
+	}
+	got := parseEventNames(w.Body.String())
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	// Anthropic's protocol has no [DONE] sentinel.
+	if strings.Contains(w.Body.String(), "[DONE]") {
+		t.Error("anthropic stream should not contain [DONE]")
+	}
+
+	if doneUsage.TotalTokens != 6 || doneCost != 0.5 {
+		t.Errorf("OnDone got usage=%+v cost=%v, want total=6 cost=0.5", doneUsage, doneCost)
+	}
+}
+
+func TestWriteAnthropic_MidStreamError(t *testing.T) {
+	ch := sendChunks(
+		provider.StreamChunk{Model: "test-model", Delta: "partial"},
+		provider.StreamChunk{Done: true, Error: fmt.Errorf("connection reset")},
+	)
+
+	w := httptest.NewRecorder()
+	err := WriteAnthropic(w, ch, WriteOptions{})
+	if err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Fatalf("error = %v, want it to contain %q", err, "connection reset")
+	}
+
+	names := parseEventNames(w.Body.String())
+	if len(names) == 0 || names[len(names)-1] != "error" {
+		t.Errorf("last event = %v, want error", names)
+	}
+	if strings.Contains(w.Body.String(), "message_stop") {
+		t.Error("errored stream should not contain message_stop")
+	}
+}
@@ -4,6 +4,7 @@ package stream
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -29,6 +30,11 @@ type WriteOptions struct {
 	// cost from the response body.
 	CostFn func(provider.Usage) float64
 
+	// OnFirstToken is called with the time to first token when the first
+	// chunk arrives, if TTFT is being observed (see RequestStart). Lets the
+	// handler feed live latency back into routing. Nil is a no-op.
+	OnFirstToken func(ttft time.Duration)
+
 	// OnDone is called after the final chunk is processed with the chunk's
 	// usage and the computed cost (or 0 if CostFn was nil). Lets the handler
 	// record Tokens/CostUSD/etc. without stream importing the metrics package
@@ -36,6 +42,61 @@ type WriteOptions struct {
 	OnDone func(usage provider.Usage, costUSD float64)
 }
 
+// finish computes the request cost from the final chunk's usage (0 if CostFn
+// is nil) and fires OnDone. Shared by Write and WriteAnthropic.
+func (opts WriteOptions) finish(usage provider.Usage) float64 {
+	var cost float64
+	if opts.CostFn != nil {
+		cost = opts.CostFn(usage)
+	}
+	if opts.OnDone != nil {
+		opts.OnDone(usage, cost)
+	}
+	return cost
+}
+
+// latencyTracker records TimeToFirstToken on the first chunk and
+// InterTokenLatency on every chunk after it. Shared by Write and
+// WriteAnthropic so both wire formats report identical timing metrics.
+type latencyTracker struct {
+	provider, model string
+	requestStart    time.Time
+	onFirstToken    func(time.Duration)
+	recordTTFT      bool
+	recordInter     bool
+	seen            bool
+	last            time.Time
+}
+
+func newLatencyTracker(opts WriteOptions) *latencyTracker {
+	return &latencyTracker{
+		provider:     opts.Provider,
+		model:        opts.Model,
+		requestStart: opts.RequestStart,
+		onFirstToken: opts.OnFirstToken,
+		recordTTFT:   !opts.RequestStart.IsZero() && opts.Provider != "" && opts.Model != "",
+		recordInter:  opts.Provider != "" && opts.Model != "",
+	}
+}
+
+// observe is called once per chunk, as soon as it's read off the channel.
+func (lt *latencyTracker) observe() {
+	now := time.Now()
+	if !lt.seen {
+		lt.seen = true
+		if lt.recordTTFT {
+			ttft := now.Sub(lt.requestStart)
+			metrics.TimeToFirstToken.WithLabelValues(lt.provider, lt.model).Observe(ttft.Seconds())
+			if lt.onFirstToken != nil {
+				lt.onFirstToken(ttft)
+			}
+		}
+	} else if lt.recordInter {
+		metrics.InterTokenLatency.WithLabelValues(lt.provider, lt.model).Observe(now.Sub(lt.last).Seconds())
+	}
+	lt.last = now
+}
+
 // ---------------------------------------------------------------------------
 // OpenAI-compatible SSE response types
 // ---------------------------------------------------------------------------
@@ -120,11 +181,7 @@ type sseUsage struct {
 // the cost table). The handler creates a closure that captures the cost
 // table and model name, keeping the stream package decoupled from config.
 func Write(w http.ResponseWriter, chunks <-chan provider.StreamChunk, opts WriteOptions) error {
-	costFn := opts.CostFn
-	recordTTFT := !opts.RequestStart.IsZero() && opts.Provider != "" && opts.Model != ""
-	recordInter := opts.Provider != "" && opts.Model != ""
-	firstChunkSeen := false
-	var lastChunkTime time.Time
+	lat := newLatencyTracker(opts)
 	// --- Step 1: Assert that the ResponseWriter supports flushing ---
 	//
 	// http.ResponseWriter is an interface with three methods: Header(),
@@ -181,25 +238,19 @@ func Write(w http.ResponseWriter, chunks <-chan provider.StreamChunk, opts Write
 	// This is the consumer end of the kitchen/waiter pattern from
 	// google.go — we're the waiter picking dishes off the serving window.
 	for chunk := range chunks {
-		now := time.Now()
-		if !firstChunkSeen {
-			firstChunkSeen = true
-			if recordTTFT {
-				metrics.TimeToFirstToken.WithLabelValues(opts.Provider, opts.Model).Observe(now.Sub(opts.RequestStart).Seconds())
-			}
-		} else if recordInter {
-			metrics.InterTokenLatency.WithLabelValues(opts.Provider, opts.Model).Observe(now.Sub(lastChunkTime).Seconds())
-		}
-		lastChunkTime = now
+		lat.observe()
 
 		// Check for mid-stream errors from the provider goroutine.
 		if chunk.Error != nil {
 			log.Printf("stream error: %v", chunk.Error)
 			// We've already started writing the response (headers sent),
-			// so we can't change the status code to 500. The best we can
-			// do in SSE is stop sending events. The client will see the
-			// stream end unexpectedly — they can detect this because they
-			// won't get the "data: [DONE]" sentinel.
+			// so we can't change the status code to 500. Instead we send
+			// an OpenAI-style error event, which the SDKs raise as an
+			// APIError, and stop without the "data: [DONE]" sentinel. The
+			// message is generic: the provider's error can carry its
+			// internals.
+			writeErrorEvent(w)
+			flusher.Flush()
 			return chunk.Error
 		}
 
@@ -244,14 +295,10 @@ func Write(w http.ResponseWriter, chunks <-chan provider.StreamChunk, opts Write
 					CompletionTokens: chunk.Usage.CompletionTokens,
 					TotalTokens:      chunk.Usage.TotalTokens,
 				}
-				var cost float64
-				if costFn != nil {
-					cost = costFn(*chunk.Usage)
+				cost := opts.finish(*chunk.Usage)
+				if opts.CostFn != nil {
 					event.CostUSD = &cost
 				}
-				if opts.OnDone != nil {
-					opts.OnDone(*chunk.Usage, cost)
-				}
 			}
 		}
 
@@ -299,3 +346,24 @@ func Write(w http.ResponseWriter, chunks <-chan provider.StreamChunk, opts Write
 
 	return nil
 }
+
+// streamError is the body of the error event sent when a stream fails
+// after headers are on the wire — OpenAI's error object.
+type streamError struct {
+	Error struct {
+		Message string  `json:"message"`
+		Type    string  `json:"type"`
+		Code    *string `json:"code"`
+		Param   *string `json:"param"`
+	} `json:"error"`
+}
+
+// writeErrorEvent sends the error event for a failed stream. Best effort:
+// the stream is ending either way.
+func writeErrorEvent(w io.Writer) {
+	var event streamError
+	event.Error.Message = "upstream provider stream failed"
+	event.Error.Type = "server_error"
+	jsonBytes, _ := json.Marshal(event)
+	fmt.Fprintf(w, "data: %s\n\n", jsonBytes)
+}
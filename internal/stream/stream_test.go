@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/howard-nolan/llmrouter/internal/provider"
 )
@@ -223,3 +224,31 @@ func TestWrite_SSEFormat(t *testing.T) {
 		t.Errorf("got %d SSE events, want 3 (content + finish + DONE)", nonEmpty)
 	}
 }
+
+func TestWrite_OnFirstTokenCalledOnce(t *testing.T) {
+	ch := sendChunks(
+		provider.StreamChunk{Model: "m", Delta: "a"},
+		provider.StreamChunk{Model: "m", Delta: "b"},
+		provider.StreamChunk{Model: "m", Done: true},
+	)
+
+	start := time.Now().Add(-50 * time.Millisecond)
+	var calls []time.Duration
+	w := httptest.NewRecorder()
+	err := Write(w, ch, WriteOptions{
+		Provider:     "p",
+		Model:        "m",
+		RequestStart: start,
+		OnFirstToken: func(ttft time.Duration) { calls = append(calls, ttft) },
+	})
+	if err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	if len(calls) != 1 {
+		t.Fatalf("OnFirstToken called %d times, want 1", len(calls))
+	}
+	if calls[0] < 50*time.Millisecond {
+		t.Errorf("ttft = %v, want at least 50ms (measured from RequestStart)", calls[0])
+	}
+}
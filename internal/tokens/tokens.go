@@ -0,0 +1,146 @@
+// Package tokens counts a request's input tokens before it is sent.
+//
+// Exact counts come from providers whose API can count tokens
+// (provider.TokenCounter: Anthropic's count_tokens, Gemini's countTokens).
+// Everything else — including every routing and budget decision, which
+// can't wait on a network call — uses a local estimate: the prompt's
+// length divided by a per-model characters-per-token ratio. The ratio
+// starts at four, the usual rule of thumb for English, and is calibrated
+// against the prompt token counts providers report on real traffic, so
+// the estimate tracks each model's tokenizer and the gateway's actual mix
+// of prompts.
+package tokens
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+
+	"github.com/howard-nolan/llmrouter/internal/metrics"
+	"github.com/howard-nolan/llmrouter/internal/provider"
+)
+
+// Sources of a Count.
+const (
+	SourceProvider = "provider" // the provider's counting API
+	SourceEstimate = "estimate" // the local calibrated estimate
+)
+
+const (
+	// defaultCharsPerToken is the ratio before a model has been calibrated,
+	// and for estimates that aren't for a particular model.
+	defaultCharsPerToken = 4.0
+
+	// calibrationWeight is how much each observation moves a model's ratio
+	// (an exponentially weighted moving average), so a few odd prompts
+	// don't swing it.
+	calibrationWeight = 0.05
+
+	// minCalibrationTokens skips observations of very short prompts, whose
+	// ratio is dominated by the provider's per-message framing.
+	minCalibrationTokens = 50
+
+	// Observations outside these ratios are more likely a miscount than a
+	// tokenizer, and are ignored.
+	minCharsPerToken = 1.0
+	maxCharsPerToken = 10.0
+)
+
+// Count is a request's input token count.
+type Count struct {
+	Model  string `json:"model"`
+	Tokens int    `json:"prompt_tokens"`
+	Source string `json:"source"` // SourceProvider or SourceEstimate
+}
+
+// Counter counts and estimates input tokens per model. It's safe for
+// concurrent use.
+type Counter struct {
+	models map[string]provider.Provider
+
+	mu     sync.RWMutex
+	ratios map[string]float64 // calibrated characters per token, by model
+}
+
+// NewCounter creates a Counter. models maps model names to the provider
+// serving them, for Count; it may be nil, in which case Count always
+// estimates.
+func NewCounter(models map[string]provider.Provider) *Counter {
+	return &Counter{
+		models: models,
+		ratios: make(map[string]float64),
+	}
+}
+
+// Estimate returns the local estimate of messages' input tokens on model.
+// It does no I/O. An empty or uncalibrated model uses four characters per
+// token.
+func (c *Counter) Estimate(model string, messages []provider.Message) int {
+	chars := promptChars(messages)
+	if chars == 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(chars) / c.charsPerToken(model)))
+}
+
+// Count returns req's input tokens on req.Model, from the provider's
+// counting API when it has one. If it doesn't, or the call fails, the
+// local estimate is returned instead; a provider count also calibrates
+// the estimate.
+func (c *Counter) Count(ctx context.Context, req *provider.ChatRequest) Count {
+	if tc, ok := c.models[req.Model].(provider.TokenCounter); ok {
+		n, err := tc.CountTokens(ctx, req)
+		if err == nil {
+			metrics.TokenCounts.WithLabelValues(req.Model, SourceProvider).Inc()
+			c.Observe(req.Model, req.Messages, n)
+			return Count{Model: req.Model, Tokens: n, Source: SourceProvider}
+		}
+		log.Printf("token count for %s failed, estimating: %v", req.Model, err)
+	}
+	metrics.TokenCounts.WithLabelValues(req.Model, SourceEstimate).Inc()
+	return Count{Model: req.Model, Tokens: c.Estimate(req.Model, req.Messages), Source: SourceEstimate}
+}
+
+// Observe calibrates model's estimate against promptTokens, the input
+// token count the provider reported (or counted) for messages.
+func (c *Counter) Observe(model string, messages []provider.Message, promptTokens int) {
+	if model == "" || promptTokens < minCalibrationTokens {
+		return
+	}
+	ratio := float64(promptChars(messages)) / float64(promptTokens)
+	if ratio < minCharsPerToken || ratio > maxCharsPerToken {
+		return
+	}
+
+	c.mu.Lock()
+	current, ok := c.ratios[model]
+	if !ok {
+		current = defaultCharsPerToken
+	}
+	current += calibrationWeight * (ratio - current)
+	c.ratios[model] = current
+	c.mu.Unlock()
+
+	metrics.CharsPerToken.WithLabelValues(model).Set(current)
+}
+
+// charsPerToken returns model's calibrated ratio, or the default.
+func (c *Counter) charsPerToken(model string) float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if ratio, ok := c.ratios[model]; ok {
+		return ratio
+	}
+	return defaultCharsPerToken
+}
+
+// promptChars is the length of messages' content in bytes, the unit the
+// ratio is calibrated in.
+func promptChars(messages []provider.Message) int {
+	chars := 0
+	for _, m := range messages {
+		chars += len(m.Content)
+	}
+	return chars
+}
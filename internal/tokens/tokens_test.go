@@ -0,0 +1,85 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/howard-nolan/llmrouter/internal/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingProvider is a provider with a token counting API.
+type countingProvider struct {
+	provider.Provider
+	tokens int
+	err    error
+}
+
+func (p *countingProvider) CountTokens(context.Context, *provider.ChatRequest) (int, error) {
+	return p.tokens, p.err
+}
+
+// plainProvider is a provider without one.
+type plainProvider struct{ provider.Provider }
+
+func prompt(chars int) []provider.Message {
+	return []provider.Message{{Role: "user", Content: strings.Repeat("x", chars)}}
+}
+
+func TestEstimate_Default(t *testing.T) {
+	c := NewCounter(nil)
+	assert.Equal(t, 0, c.Estimate("m", nil))
+	assert.Equal(t, 1, c.Estimate("m", prompt(1)))
+	assert.Equal(t, 1000, c.Estimate("m", prompt(4000)))
+	assert.Equal(t, 1001, c.Estimate("", prompt(4001)), "rounds up")
+}
+
+func TestObserve_Calibrates(t *testing.T) {
+	c := NewCounter(nil)
+
+	// A tokenizer that packs 2 characters per token pulls the estimate
+	// toward it, for that model only.
+	for range 200 {
+		c.Observe("dense", prompt(4000), 2000)
+	}
+	assert.InDelta(t, 2000, c.Estimate("dense", prompt(4000)), 5)
+	assert.Equal(t, 1000, c.Estimate("other", prompt(4000)))
+	assert.Equal(t, 1000, c.Estimate("", prompt(4000)))
+
+	// One observation only nudges it: 6 characters per token moves the
+	// ratio from 4 to 4.1.
+	c.Observe("sparse", prompt(6000), 1000)
+	assert.Equal(t, 976, c.Estimate("sparse", prompt(4000)))
+}
+
+func TestObserve_IgnoresNoise(t *testing.T) {
+	c := NewCounter(nil)
+	c.Observe("m", prompt(40), 10)      // too short to calibrate on
+	c.Observe("m", prompt(100), 1000)   // 0.1 chars per token
+	c.Observe("m", prompt(40000), 1000) // 40 chars per token
+	c.Observe("", prompt(4000), 2000)   // no model
+	assert.Equal(t, 1000, c.Estimate("m", prompt(4000)))
+	assert.Equal(t, 1000, c.Estimate("", prompt(4000)))
+}
+
+func TestCount(t *testing.T) {
+	c := NewCounter(map[string]provider.Provider{
+		"counted": &countingProvider{tokens: 1500},
+		"failing": &countingProvider{err: errors.New("503")},
+		"plain":   &plainProvider{},
+	})
+	req := func(model string) *provider.ChatRequest {
+		return &provider.ChatRequest{Model: model, Messages: prompt(4000)}
+	}
+
+	assert.Equal(t, Count{Model: "counted", Tokens: 1500, Source: SourceProvider}, c.Count(context.Background(), req("counted")))
+	assert.Equal(t, Count{Model: "failing", Tokens: 1000, Source: SourceEstimate}, c.Count(context.Background(), req("failing")))
+	assert.Equal(t, Count{Model: "plain", Tokens: 1000, Source: SourceEstimate}, c.Count(context.Background(), req("plain")))
+	assert.Equal(t, Count{Model: "unknown", Tokens: 1000, Source: SourceEstimate}, c.Count(context.Background(), req("unknown")))
+
+	// The provider's count calibrated the estimate.
+	assert.Less(t, c.Estimate("counted", prompt(4000)), 1500)
+	assert.Greater(t, c.Estimate("counted", prompt(4000)), 1000)
+}
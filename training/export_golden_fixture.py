@@ -0,0 +1,84 @@
+"""
+export_golden_fixture.py — Regenerate the Go classifier's golden test fixture.
+
+Trains a small, seeded GradientBoostingClassifier on synthetic inputs laid
+out as the gateway builds them (an embedding, then the request features in
+feature_schema.json order), converts it to ONNX exactly as export_onnx.py
+does, and writes two files under internal/router/testdata:
+
+    gbt_golden.trees.json   the JSON tree export (gbt_json.py)
+    gbt_golden.json         sample model inputs and their ONNX Runtime scores
+
+TestGBTClassifier_Golden checks the pure-Go evaluation of the first against
+the second, so the Go evaluator is pinned to ONNX Runtime without needing
+the real checkpoint. Rerun after changing gbt_json.py or the feature schema.
+Without ONNX Runtime, golden_reference.py writes a stand-in.
+
+Usage:
+    cd training && uv run python export_golden_fixture.py
+"""
+
+import json
+from pathlib import Path
+
+import numpy as np
+import onnxruntime as ort
+from sklearn.ensemble import GradientBoostingClassifier
+from skl2onnx import convert_sklearn
+from skl2onnx.common.data_types import FloatTensorType
+
+import gbt_json
+from features import REQUEST_FEATURE_NAMES
+
+TESTDATA = Path(__file__).parent.parent / "internal" / "router" / "testdata"
+GBT_JSON_PATH = TESTDATA / "gbt_golden.trees.json"
+GOLDEN_PATH = TESTDATA / "gbt_golden.json"
+
+# Small enough to commit and review, deep enough that every sample takes a
+# different path through most trees.
+EMBEDDING_DIM = 8
+N_TRAIN = 400
+N_GOLDEN = 10
+
+input_dim = EMBEDDING_DIM + len(REQUEST_FEATURE_NAMES)
+rng = np.random.RandomState(42)
+
+# A label that depends on both parts of the input, so the trees split on
+# embedding dimensions and request features alike.
+X = rng.randn(N_TRAIN, input_dim).astype(np.float32)
+y = ((X[:, 0] + 0.5 * X[:, 3] - X[:, EMBEDDING_DIM] + 0.3 * rng.randn(N_TRAIN)) > 0).astype(int)
+
+model = GradientBoostingClassifier(n_estimators=20, max_depth=3, learning_rate=0.1, random_state=42)
+model.fit(X, y)
+
+onnx_model = convert_sklearn(
+    model,
+    initial_types=[("float_input", FloatTensorType([1, input_dim]))],
+    options={id(model): {"zipmap": False}},
+    target_opset=15,
+)
+session = ort.InferenceSession(onnx_model.SerializeToString())
+input_name = session.get_inputs()[0].name
+
+samples = rng.randn(N_GOLDEN, input_dim).astype(np.float32)
+onnx_scores = [float(session.run(None, {input_name: samples[i : i + 1]})[1][0, 1])
+               for i in range(N_GOLDEN)]
+
+gbt_export = gbt_json.to_json(model, input_dim, samples)
+json_diff = max(abs(gbt_json.evaluate(gbt_export, samples[i]) - onnx_scores[i])
+                for i in range(N_GOLDEN))
+print(f"Max JSON-vs-ONNX difference across {N_GOLDEN} samples: {json_diff:.2e}")
+if json_diff > 1e-5:
+    raise SystemExit("JSON tree export doesn't match ONNX — not writing the fixture")
+
+TESTDATA.mkdir(parents=True, exist_ok=True)
+with open(GBT_JSON_PATH, "w") as f:
+    json.dump(gbt_export, f)
+with open(GOLDEN_PATH, "w") as f:
+    json.dump({
+        "embedding_dim": EMBEDDING_DIM,
+        "scored_by": f"onnxruntime {ort.__version__}",
+        "inputs": samples.tolist(),
+        "scores": onnx_scores,
+    }, f)
+print(f"Saved {GBT_JSON_PATH} and {GOLDEN_PATH}")
@@ -3,13 +3,23 @@ export_onnx.py — Export the trained GBT classifier to ONNX format.
 
 Loads the scikit-learn GradientBoostingClassifier from the joblib checkpoint,
 converts it to ONNX via skl2onnx, verifies the output matches scikit-learn's
-predict_proba, and saves to models/complexity_classifier.onnx.
+predict_proba, and saves to models/complexity_classifier.onnx with a metadata
+sidecar (models/complexity_classifier.json) carrying its version and tuned
+threshold. A running gateway with routing.classifier_watch_interval set picks
+up both without a restart.
+
+The same tree ensemble is also written as JSON (models/complexity_classifier
+.gbt.json) for the gateway's pure-Go classifier backend, with a golden file
+of sample model inputs and their ONNX Runtime scores. The Go tests check the
+JSON evaluation against a small committed export instead (see
+export_golden_fixture.py).
 
 Usage:
     cd training && uv run python export_onnx.py
 """
 
 import json
+from datetime import datetime, timezone
 from pathlib import Path
 
 import joblib
@@ -18,6 +28,10 @@ import onnxruntime as ort
 from skl2onnx import convert_sklearn
 from skl2onnx.common.data_types import FloatTensorType
 
+import gbt_json
+from features import REQUEST_FEATURE_NAMES
+from features import SCHEMA as FEATURE_SCHEMA
+
 # ---------------------------------------------------------------------------
 # Paths
 # ---------------------------------------------------------------------------
@@ -25,6 +39,9 @@ from skl2onnx.common.data_types import FloatTensorType
 GBT_CHECKPOINT = Path(__file__).parent / "complexity_classifier_gbt.joblib"
 LABELED_FILE = Path(__file__).parent / "labeled_dataset.jsonl"
 OUTPUT_PATH = Path(__file__).parent.parent / "models" / "complexity_classifier.onnx"
+METADATA_PATH = OUTPUT_PATH.with_suffix(".json")
+GBT_JSON_PATH = OUTPUT_PATH.with_suffix(".gbt.json")
+GOLDEN_PATH = OUTPUT_PATH.with_suffix(".golden.json")
 
 # ---------------------------------------------------------------------------
 # Step 1: Load the checkpoint
@@ -38,7 +55,18 @@ model = checkpoint["model"]
 embedding_dim = checkpoint["embedding_dim"]
 best_threshold = checkpoint["best_threshold"]
 
+# Models trained with request features take them after the embedding, in
+# feature_schema.json order — the layout the gateway builds (see
+# features.py).
+request_features = checkpoint.get("request_features", [])
+if request_features and request_features != REQUEST_FEATURE_NAMES:
+    raise SystemExit(f"checkpoint request features {request_features} don't match feature_schema.json")
+input_dim = int(model.n_features_in_)
+if input_dim != embedding_dim + len(request_features):
+    raise SystemExit(f"model takes {input_dim} inputs, expected {embedding_dim} + {len(request_features)}")
+
 print(f"  Embedding dim: {embedding_dim}")
+print(f"  Request features: {len(request_features)}")
 print(f"  Best threshold: {best_threshold}")
 print(f"  Config: {checkpoint['config']}")
 
@@ -63,7 +91,7 @@ print(f"  Config: {checkpoint['config']}")
 
 print("\nConverting to ONNX...")
 
-initial_types = [("float_input", FloatTensorType([1, embedding_dim]))]
+initial_types = [("float_input", FloatTensorType([1, input_dim]))]
 
 onnx_model = convert_sklearn(
     model,
@@ -95,7 +123,7 @@ print("\nVerifying ONNX output matches scikit-learn...")
 # model (slow), we use random vectors — the point is to verify the ONNX
 # conversion is numerically identical, not that the predictions are good.
 rng = np.random.RandomState(42)
-test_samples = rng.randn(10, embedding_dim).astype(np.float32)
+test_samples = rng.randn(10, input_dim).astype(np.float32)
 
 # Scikit-learn predictions.
 sklearn_probs = model.predict_proba(test_samples)[:, 1]
@@ -115,7 +143,7 @@ print(f"  ONNX input name: {input_name}")
 # match how the Go classifier will call it.
 max_diff = 0.0
 for i in range(len(test_samples)):
-    sample = test_samples[i : i + 1]  # shape [1, 384]
+    sample = test_samples[i : i + 1]  # shape [1, input_dim]
     onnx_out = session.run(None, {input_name: sample})
 
     # onnx_out[0] = labels (int64), onnx_out[1] = probabilities (float32)
@@ -135,16 +163,70 @@ else:
     print("  PASS: outputs match within floating-point tolerance")
 
 # ---------------------------------------------------------------------------
-# Step 4: Done
+# Step 4: Export the tree ensemble as JSON
+# ---------------------------------------------------------------------------
+# The pure-Go classifier (internal/router/gbt.go) evaluates this directly,
+# without ONNX Runtime (see gbt_json.py). The export is checked against ONNX
+# Runtime before anything is saved.
+
+print("\nExporting tree ensemble to JSON...")
+
+gbt_export = gbt_json.to_json(model, input_dim, test_samples)
+
+onnx_scores = [float(session.run(None, {input_name: test_samples[i : i + 1]})[1][0, 1])
+               for i in range(len(test_samples))]
+json_diff = max(abs(gbt_json.evaluate(gbt_export, test_samples[i]) - onnx_scores[i])
+                for i in range(len(test_samples)))
+print(f"  Max JSON-vs-ONNX difference across {len(test_samples)} samples: {json_diff:.2e}")
+if json_diff > 1e-5:
+    raise SystemExit("JSON tree export doesn't match ONNX — not writing it")
+
+with open(GBT_JSON_PATH, "w") as f:
+    json.dump(gbt_export, f)
+with open(GOLDEN_PATH, "w") as f:
+    json.dump({
+        "embedding_dim": int(embedding_dim),
+        "inputs": test_samples.tolist(),
+        "scores": onnx_scores,
+    }, f)
+print(f"  Saved {GBT_JSON_PATH} ({GBT_JSON_PATH.stat().st_size / 1024:.1f} KB) and {GOLDEN_PATH}")
+
+# ---------------------------------------------------------------------------
+# Step 5: Write the metadata sidecar
+# ---------------------------------------------------------------------------
+# The gateway reads "version" and "threshold" from this file when it loads
+# the model (the threshold overrides routing.complexity_threshold), and
+# reports the rest in /health. Written to a temp file and renamed so a
+# watching gateway never reads it half-written.
+
+exported_at = datetime.now(timezone.utc)
+metadata = {
+    "version": exported_at.strftime("gbt-%Y%m%dT%H%M%SZ"),
+    "threshold": float(best_threshold),
+    "embedding_dim": int(embedding_dim),
+    "features": "embedding+request" if request_features else "embedding",
+    "feature_schema_version": FEATURE_SCHEMA["version"],
+    "config": checkpoint["config"],
+    "exported_at": exported_at.isoformat(),
+    "onnx_max_diff": max_diff,
+}
+tmp_path = METADATA_PATH.with_suffix(".json.tmp")
+with open(tmp_path, "w") as f:
+    json.dump(metadata, f, indent=2, default=str)
+tmp_path.replace(METADATA_PATH)
+
+# ---------------------------------------------------------------------------
+# Step 6: Done
 # ---------------------------------------------------------------------------
 
 print(f"\nONNX model saved to {OUTPUT_PATH}")
 print(f"  File size: {OUTPUT_PATH.stat().st_size / 1024:.1f} KB")
+print(f"Metadata saved to {METADATA_PATH} (version {metadata['version']})")
 
 # Print a summary that's useful for the Go integration.
 print(f"\n--- Go integration notes ---")
 print(f"  Input name:  {input_name}")
-print(f"  Input shape: [1, {embedding_dim}]  (float32)")
+print(f"  Input shape: [1, {input_dim}]  (float32: embedding{' + request features' if request_features else ''})")
 print(f"  Output[0]:   output_label  (int64, predicted class)")
 print(f"  Output[1]:   output_probability  (float32, shape [1, 2])")
 print(f"  Use output_probability[:, 1] as the complexity score (0–1)")
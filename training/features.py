@@ -0,0 +1,70 @@
+"""
+features.py — complexity classifier inputs, shared with the gateway.
+
+The gateway's router scores each request from the embedding of its last
+user message and, for models trained with them, a few request-level
+features (internal/features in the Go tree). This module extracts the same
+features for training. Both follow feature_schema.json, which fixes the
+feature names, their order after the embedding, and their transforms.
+
+Raw values are the ones the gateway records with feedback (the "features"
+object in each feedback record), so those records can be turned into
+model inputs with request_vector_from_raw.
+"""
+
+import json
+import math
+from pathlib import Path
+
+SCHEMA_PATH = Path(__file__).parent / "feature_schema.json"
+SCHEMA = json.loads(SCHEMA_PATH.read_text())
+
+REQUEST_FEATURE_NAMES = [f["name"] for f in SCHEMA["request_features"]]
+
+_TRANSFORMS = {
+    "log1p": lambda v: math.log1p(max(float(v), 0.0)),
+    "bool": lambda v: 1.0 if v else 0.0,
+}
+
+
+def raw_request_features(messages: list[dict], max_tokens: int | None = None) -> dict:
+    """Raw request features for a conversation of {"role", "content"} messages."""
+    raw = {
+        "message_count": len(messages),
+        "user_turns": 0,
+        "system_prompt_chars": 0,
+        "last_user_chars": 0,
+        "conversation_chars": 0,
+        "max_tokens": max_tokens or 0,
+        "has_code_fence": False,
+    }
+    for m in messages:
+        n = len(m.get("content", ""))
+        raw["conversation_chars"] += n
+        if m.get("role") == "system":
+            raw["system_prompt_chars"] += n
+        elif m.get("role") == "user":
+            raw["user_turns"] += 1
+            raw["last_user_chars"] = n
+        if "```" in m.get("content", ""):
+            raw["has_code_fence"] = True
+    return raw
+
+
+def request_vector_from_raw(raw: dict) -> list[float]:
+    """Transformed request features, in schema order."""
+    return [
+        _TRANSFORMS[f["transform"]](raw.get(f["name"], 0))
+        for f in SCHEMA["request_features"]
+    ]
+
+
+def request_vector(messages: list[dict], max_tokens: int | None = None) -> list[float]:
+    """Transformed request features for a conversation, in schema order."""
+    return request_vector_from_raw(raw_request_features(messages, max_tokens))
+
+
+# Fail at import if the extractor and the schema disagree.
+assert set(raw_request_features([])) == set(REQUEST_FEATURE_NAMES), (
+    "features.py is out of step with feature_schema.json"
+)
@@ -0,0 +1,52 @@
+"""
+gbt_json.py — the JSON tree export read by the gateway's pure-Go classifier.
+
+internal/router/gbt.go evaluates this directly, without ONNX Runtime.
+Binary GradientBoostingClassifier scores are sigmoid(init_score +
+learning_rate * sum of tree outputs), where each tree sends a sample left
+when x[feature] <= threshold. ONNX stores the thresholds as float32, and so
+does the Go evaluator; evaluate mirrors it, so an export can be checked
+against ONNX Runtime before it's saved.
+"""
+
+import numpy as np
+
+
+def to_json(model, input_dim: int, sample: np.ndarray) -> dict:
+    """The JSON export of a fitted binary GradientBoostingClassifier.
+
+    sample is any one model input; it's only used to read the prior.
+    """
+    trees = []
+    for estimator in model.estimators_[:, 0]:
+        t = estimator.tree_
+        trees.append({
+            "feature": t.feature.tolist(),
+            "threshold": t.threshold.tolist(),
+            "left": t.children_left.tolist(),
+            "right": t.children_right.tolist(),
+            "value": t.value[:, 0, 0].tolist(),
+        })
+    return {
+        "format": "sklearn-gbt",
+        "n_features": input_dim,
+        "learning_rate": float(model.learning_rate),
+        # Raw (log-odds) score before any tree — the prior from model.init_.
+        "init_score": float(model._raw_predict_init(sample[:1])[0, 0]),
+        "trees": trees,
+    }
+
+
+def evaluate(export: dict, x: np.ndarray) -> float:
+    """Score one sample the way the Go GBTClassifier does."""
+    raw = export["init_score"]
+    for tree in export["trees"]:
+        node = 0
+        while tree["left"][node] >= 0:
+            threshold = np.float32(tree["threshold"][node])
+            if x[tree["feature"][node]] <= threshold:
+                node = tree["left"][node]
+            else:
+                node = tree["right"][node]
+        raw += export["learning_rate"] * tree["value"][node]
+    return 1.0 / (1.0 + np.exp(-raw))
@@ -0,0 +1,103 @@
+"""
+golden_reference.py — Stand-in golden fixture for machines without ONNX Runtime.
+
+Writes the same two files as export_golden_fixture.py (see there), using
+only the standard library: a small seeded tree ensemble in the JSON export
+layout, and sample inputs scored the way ONNX's TreeEnsembleClassifier
+specifies for the graph skl2onnx builds from a binary
+GradientBoostingClassifier — float32 thresholds, leaf weights pre-scaled by
+the learning rate and summed in float32 onto the prior, then LOGISTIC.
+
+The trees are random rather than trained: the Go evaluator doesn't care how
+they were fitted, only how they're laid out. export_golden_fixture.py
+overwrites the fixture with a trained model and real ONNX Runtime scores,
+and is the one to run when its dependencies are installed.
+
+Usage:
+    cd training && python golden_reference.py
+"""
+
+import json
+import math
+import random
+import struct
+from pathlib import Path
+
+from features import REQUEST_FEATURE_NAMES
+
+TESTDATA = Path(__file__).parent.parent / "internal" / "router" / "testdata"
+GBT_JSON_PATH = TESTDATA / "gbt_golden.trees.json"
+GOLDEN_PATH = TESTDATA / "gbt_golden.json"
+
+EMBEDDING_DIM = 8
+N_TREES = 20
+DEPTH = 3
+N_GOLDEN = 10
+LEARNING_RATE = 0.1
+
+input_dim = EMBEDDING_DIM + len(REQUEST_FEATURE_NAMES)
+rng = random.Random(42)
+
+
+def f32(v: float) -> float:
+    """Round v to float32."""
+    return struct.unpack("f", struct.pack("f", v))[0]
+
+
+def random_tree() -> dict:
+    """A complete tree of DEPTH splits, numbered in sklearn's preorder."""
+    tree = {"feature": [], "threshold": [], "left": [], "right": [], "value": []}
+
+    def grow(depth: int) -> int:
+        node = len(tree["feature"])
+        for key in tree:
+            tree[key].append(None)
+        tree["value"][node] = rng.gauss(0, 1)
+        if depth == DEPTH:
+            tree["feature"][node], tree["threshold"][node] = -2, -2.0
+            tree["left"][node], tree["right"][node] = -1, -1
+            return node
+        tree["feature"][node] = rng.randrange(input_dim)
+        tree["threshold"][node] = rng.gauss(0, 0.5)
+        tree["left"][node] = grow(depth + 1)
+        tree["right"][node] = grow(depth + 1)
+        return node
+
+    grow(0)
+    return tree
+
+
+def onnx_score(export: dict, x: list[float]) -> float:
+    """TreeEnsembleClassifier (BRANCH_LEQ, SUM, LOGISTIC) in float32."""
+    raw = f32(export["init_score"])
+    for tree in export["trees"]:
+        node = 0
+        while tree["left"][node] >= 0:
+            if x[tree["feature"][node]] <= f32(tree["threshold"][node]):
+                node = tree["left"][node]
+            else:
+                node = tree["right"][node]
+        raw = f32(raw + f32(export["learning_rate"] * tree["value"][node]))
+    return f32(1.0 / (1.0 + math.exp(-raw)))
+
+
+export = {
+    "format": "sklearn-gbt",
+    "n_features": input_dim,
+    "learning_rate": LEARNING_RATE,
+    "init_score": -0.2,
+    "trees": [random_tree() for _ in range(N_TREES)],
+}
+samples = [[f32(rng.gauss(0, 1)) for _ in range(input_dim)] for _ in range(N_GOLDEN)]
+
+TESTDATA.mkdir(parents=True, exist_ok=True)
+with open(GBT_JSON_PATH, "w") as f:
+    json.dump(export, f)
+with open(GOLDEN_PATH, "w") as f:
+    json.dump({
+        "embedding_dim": EMBEDDING_DIM,
+        "scored_by": "golden_reference.py (ONNX TreeEnsembleClassifier semantics, float32)",
+        "inputs": samples,
+        "scores": [onnx_score(export, x) for x in samples],
+    }, f)
+print(f"Saved {GBT_JSON_PATH} and {GOLDEN_PATH}")
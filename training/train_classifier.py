@@ -12,6 +12,13 @@ handcrafted complexity features (sub-task count, constraint count, reasoning
 keywords, etc.) that target task difficulty rather than topic. Each model
 gets a threshold sweep to find the best F1 operating point.
 
+With USE_REQUEST_FEATURES, the GBT also gets the request-level features the
+gateway extracts (conversation length, system prompt size, max_tokens, code
+fences — see features.py and feature_schema.json), appended to the
+embedding in schema order. Entries carrying a "features" object (feedback
+records from the gateway) or a "messages" list use those; plain prompts
+count as a one-message conversation.
+
 Usage:
     uv run python train_classifier.py
 
@@ -34,6 +41,8 @@ from sentence_transformers import SentenceTransformer
 from sklearn.ensemble import GradientBoostingClassifier
 from sklearn.metrics import accuracy_score, classification_report, confusion_matrix
 
+from features import REQUEST_FEATURE_NAMES, request_vector, request_vector_from_raw
+
 # ---------------------------------------------------------------------------
 # Config
 # ---------------------------------------------------------------------------
@@ -46,6 +55,7 @@ EMBEDDING_MODEL = "all-MiniLM-L6-v2"
 EMBEDDING_DIM = 384
 
 NUM_COMPLEXITY_FEATURES = 6
+USE_REQUEST_FEATURES = False  # append the gateway's request features to the GBT input
 MLP_INPUT_DIM = EMBEDDING_DIM  # embeddings only (complexity features extracted but not used — see notes below)
 MLP_HIDDEN1 = 64
 MLP_HIDDEN2 = 32
@@ -210,10 +220,11 @@ def extract_all_complexity_features(prompts: list[str]) -> np.ndarray:
 # ---------------------------------------------------------------------------
 
 
-def load_dataset() -> tuple[list[str], list[int]]:
-    """Read labeled JSONL, return (prompts, labels)."""
+def load_dataset() -> tuple[list[str], list[int], list[dict]]:
+    """Read labeled JSONL, return (prompts, labels, entries)."""
     prompts = []
     labels = []
+    entries = []
     with open(LABELED_FILE) as f:
         for line in f:
             line = line.strip()
@@ -221,11 +232,25 @@ def load_dataset() -> tuple[list[str], list[int]]:
                 entry = json.loads(line)
                 prompts.append(entry["prompt"])
                 labels.append(entry["label"])
+                entries.append(entry)
 
     print(f"Loaded {len(prompts)} labeled entries")
     print(f"  label=0 (adequate):       {labels.count(0)}")
     print(f"  label=1 (needs expensive): {labels.count(1)}")
-    return prompts, labels
+    return prompts, labels, entries
+
+
+def extract_request_features(entries: list[dict]) -> np.ndarray:
+    """Request features for each entry, in feature_schema.json order. Returns (N, 7) array."""
+    rows = []
+    for entry in entries:
+        if "features" in entry:
+            rows.append(request_vector_from_raw(entry["features"]))
+        elif "messages" in entry:
+            rows.append(request_vector(entry["messages"], entry.get("max_tokens")))
+        else:
+            rows.append(request_vector([{"role": "user", "content": entry["prompt"]}]))
+    return np.array(rows, dtype=np.float32)
 
 
 def compute_embeddings(prompts: list[str]) -> np.ndarray:
@@ -540,7 +565,7 @@ def main():
         print(f"Error: {LABELED_FILE} not found. Run label_quality.py first.")
         return
 
-    prompts, labels = load_dataset()
+    prompts, labels, entries = load_dataset()
     embeddings = compute_embeddings(prompts)
     complexity_features = extract_all_complexity_features(prompts)
 
@@ -561,6 +586,15 @@ def main():
     X_val = embeddings[val_idx]
     print(f"\nFeature mode: embeddings only ({X_train.shape[1]} dims)")
 
+    # The GBT can additionally take the request features the gateway
+    # extracts. The MLP stays on embeddings only.
+    X_train_gbt, X_val_gbt = X_train, X_val
+    if USE_REQUEST_FEATURES:
+        request_features = extract_request_features(entries)
+        X_train_gbt = np.hstack([X_train, request_features[train_idx]])
+        X_val_gbt = np.hstack([X_val, request_features[val_idx]])
+        print(f"GBT feature mode: embeddings + request features ({X_train_gbt.shape[1]} dims)")
+
     X_train_t = torch.tensor(X_train, dtype=torch.float32)
     y_train_t = torch.tensor(y_train_np, dtype=torch.float32).unsqueeze(1)
     X_val_t = torch.tensor(X_val, dtype=torch.float32)
@@ -577,7 +611,7 @@ def main():
 
     # ===== MODEL 2: GBT (grid search) =====
     print("\n" + "=" * 60)
-    print("MODEL 2: GBT grid search (embeddings only)")
+    print(f"MODEL 2: GBT grid search ({'embeddings + request features' if USE_REQUEST_FEATURES else 'embeddings only'})")
     print("=" * 60)
 
     gbt_configs = [
@@ -594,8 +628,8 @@ def main():
 
     for i, cfg in enumerate(gbt_configs):
         print(f"\n--- GBT config {i+1}/{len(gbt_configs)} ---")
-        model = train_gbt(X_train, y_train_np, X_val, y_val_np, **cfg)
-        threshold, fb = sweep_thresholds(model, X_val, y_val_np)
+        model = train_gbt(X_train_gbt, y_train_np, X_val_gbt, y_val_np, **cfg)
+        threshold, fb = sweep_thresholds(model, X_val_gbt, y_val_np)
 
         if fb > best_gbt_fb:
             best_gbt_fb = fb
@@ -609,7 +643,7 @@ def main():
     print(f"{'=' * 60}")
 
     # ===== COMPARISON =====
-    gbt_acc = accuracy_score(y_val_np, best_gbt_model.predict(X_val))
+    gbt_acc = accuracy_score(y_val_np, best_gbt_model.predict(X_val_gbt))
     print(f"\nCOMPARISON:  MLP val_acc={mlp_result['val_acc']:.3f}  |  GBT val_acc={gbt_acc:.3f}")
     print(f"  Majority-class baseline: {(y_val_np == 0).sum() / len(y_val_np):.3f}")
 
@@ -640,6 +674,7 @@ def main():
             "embedding_model": EMBEDDING_MODEL,
             "embedding_dim": EMBEDDING_DIM,
             "complexity_feature_names": COMPLEXITY_FEATURE_NAMES,
+            "request_features": REQUEST_FEATURE_NAMES if USE_REQUEST_FEATURES else [],
         },
         GBT_CHECKPOINT,
     )